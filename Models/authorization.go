@@ -0,0 +1,121 @@
+package models
+
+// AuthorizationRequest asks whether a principal holds a permission. The
+// principal is the user Email unless PrincipalType and PrincipalID name a
+// service account or group instead. Context holds the attributes, such as
+// amount or ip, that conditional bindings are evaluated against.
+type AuthorizationRequest struct {
+	Email         string                 `json:"email"`
+	PrincipalType string                 `json:"principal_type,omitempty"`
+	PrincipalID   string                 `json:"principal_id,omitempty"`
+	Permission    string                 `json:"permission"`
+	Resource      string                 `json:"resource,omitempty"`
+	Context       map[string]interface{} `json:"context,omitempty"`
+}
+
+type MatchedRole struct {
+	UserRoleID   int      `json:"user_role_id"`
+	RoleID       int      `json:"role_id"`
+	RoleKey      string   `json:"role_key"`
+	ResourceType string   `json:"resource_type"`
+	ResourceID   string   `json:"resource_id"`
+	Path         []string `json:"path"`
+	// Conditions are those of the user role and role permission the match
+	// relied on, which the request context satisfied.
+	Conditions []string `json:"conditions,omitempty"`
+}
+
+type AuthorizationDecision struct {
+	Email         string        `json:"email"`
+	PrincipalType string        `json:"principal_type,omitempty"`
+	PrincipalID   string        `json:"principal_id,omitempty"`
+	Permission    string        `json:"permission"`
+	Resource      string        `json:"resource,omitempty"`
+	Allowed       bool          `json:"allowed"`
+	Decision      string        `json:"decision"`
+	MatchedRoles  []MatchedRole `json:"matched_roles"`
+	// DeniedBy names the deny rules that overrode any matched roles.
+	DeniedBy []DenyRule `json:"denied_by,omitempty"`
+}
+
+// DenyRule is a deny binding that fired for a check: a "user_role" denying
+// the principal a role, or a "role_permission" denying the permission to a
+// role the principal holds. RoleID and RoleKey name the role the binding is
+// on. Path leads from the role of UserRoleID to the role the permission is
+// bound to.
+type DenyRule struct {
+	Source           string   `json:"source"`
+	UserRoleID       int      `json:"user_role_id"`
+	RolePermissionID int      `json:"role_permission_id,omitempty"`
+	RoleID           int      `json:"role_id"`
+	RoleKey          string   `json:"role_key"`
+	Permission       string   `json:"permission"`
+	Path             []string `json:"path"`
+	Condition        string   `json:"condition,omitempty"`
+}
+
+type BatchAuthorizationRequest struct {
+	Checks []AuthorizationRequest `json:"checks"`
+}
+
+type BatchAuthorizationResponse struct {
+	Results []AuthorizationDecision `json:"results"`
+}
+
+// AuthorizationExplanation traces how a check was decided: the roles the
+// principal holds, every binding considered and what became of it, and the
+// rule that decided the result.
+type AuthorizationExplanation struct {
+	Result    AuthorizationDecision `json:"result"`
+	Principal Principal             `json:"principal"`
+	Roles     []HeldRole            `json:"roles"`
+	Bindings  []EvaluatedBinding    `json:"bindings"`
+	DecidedBy DecidingRule          `json:"decided_by"`
+}
+
+// HeldRole is a role the principal holds through a live allow grant, either
+// the granted role itself or, when Inherited, one it inherits from along
+// Path. GroupID is set when the grant is to a group.
+type HeldRole struct {
+	UserRoleID   int      `json:"user_role_id"`
+	GroupID      *int     `json:"group_id,omitempty"`
+	RoleID       int      `json:"role_id"`
+	RoleKey      string   `json:"role_key"`
+	Inherited    bool     `json:"inherited"`
+	Path         []string `json:"path"`
+	ResourceType string   `json:"resource_type"`
+	ResourceID   string   `json:"resource_id"`
+}
+
+// EvaluatedBinding is a "user_role" or "role_permission" binding a check
+// considered. Outcome is "matched", "denied", "skipped" or "no_match"; a
+// skipped binding has a Reason, and Detail says why a condition could not
+// be evaluated. A user role lists the role permissions for the checked
+// permission that it reached, in RolePermissions.
+type EvaluatedBinding struct {
+	Kind             string             `json:"kind"`
+	UserRoleID       int                `json:"user_role_id"`
+	RolePermissionID int                `json:"role_permission_id,omitempty"`
+	GroupID          *int               `json:"group_id,omitempty"`
+	RoleID           int                `json:"role_id"`
+	RoleKey          string             `json:"role_key"`
+	Path             []string           `json:"path,omitempty"`
+	Effect           string             `json:"effect"`
+	ResourceType     string             `json:"resource_type,omitempty"`
+	ResourceID       string             `json:"resource_id,omitempty"`
+	Condition        string             `json:"condition,omitempty"`
+	Outcome          string             `json:"outcome"`
+	Reason           string             `json:"reason,omitempty"`
+	Detail           string             `json:"detail,omitempty"`
+	RolePermissions  []EvaluatedBinding `json:"role_permissions,omitempty"`
+}
+
+// DecidingRule is the rule that decided a check: the first "deny_rule" that
+// fired, else the first "allow_rule" that matched, else "default_deny".
+type DecidingRule struct {
+	Effect      string       `json:"effect"`
+	Rule        string       `json:"rule"`
+	Summary     string       `json:"summary"`
+	DenyRule    *DenyRule    `json:"deny_rule,omitempty"`
+	MatchedRole *MatchedRole `json:"matched_role,omitempty"`
+}
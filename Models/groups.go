@@ -0,0 +1,30 @@
+package models
+
+import "time"
+
+// Group holds roles on behalf of its members, which are emails and other
+// groups.
+type Group struct {
+	ID          int        `json:"id"`
+	TenantID    string     `json:"tenant_id"`
+	GroupKey    string     `json:"group_key"`
+	Description string     `json:"description"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+	DeletedAt   *time.Time `json:"deleted_at"`
+	Version     int        `json:"version"`
+}
+
+type GroupPage struct {
+	Groups     []Group `json:"groups"`
+	NextCursor string  `json:"next_cursor,omitempty"`
+}
+
+// GroupMember puts an email, or a nested group, in a group.
+type GroupMember struct {
+	ID            int       `json:"id"`
+	GroupID       int       `json:"group_id"`
+	Email         string    `json:"email,omitempty"`
+	MemberGroupID *int      `json:"member_group_id,omitempty"`
+	CreatedAt     time.Time `json:"created_at"`
+}
@@ -0,0 +1,18 @@
+package models
+
+import "time"
+
+type Permission struct {
+	ID            int        `json:"id"`
+	TenantID      string     `json:"tenant_id"`
+	PermissionKey string     `json:"permission_key"`
+	Description   string     `json:"description"`
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+	DeletedAt     *time.Time `json:"deleted_at"`
+}
+
+type PermissionPage struct {
+	Permissions []Permission `json:"permissions"`
+	NextCursor  string       `json:"next_cursor,omitempty"`
+}
@@ -0,0 +1,63 @@
+package models
+
+import "time"
+
+// Principal types. A user is identified by email, a service account or a
+// group by its id.
+const (
+	PrincipalUser    = "user"
+	PrincipalService = "service"
+	PrincipalGroup   = "group"
+)
+
+// Principal is anything roles can be granted to.
+type Principal struct {
+	Type        string `json:"type"`
+	ID          string `json:"id"`
+	DisplayName string `json:"display_name,omitempty"`
+}
+
+// PrincipalRoles lists one page of the user roles a principal holds.
+type PrincipalRoles struct {
+	Principal  Principal  `json:"principal"`
+	UserRoles  []UserRole `json:"user_roles"`
+	NextCursor string     `json:"next_cursor,omitempty"`
+}
+
+// ServiceAccount is a non-human principal, such as a CI bot or a service,
+// that authenticates with API keys.
+type ServiceAccount struct {
+	ID          int        `json:"id"`
+	TenantID    string     `json:"tenant_id"`
+	Name        string     `json:"name"`
+	DisplayName string     `json:"display_name"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+	DeletedAt   *time.Time `json:"deleted_at"`
+	Version     int        `json:"version"`
+}
+
+type ServiceAccountPage struct {
+	ServiceAccounts []ServiceAccount `json:"service_accounts"`
+	NextCursor      string           `json:"next_cursor,omitempty"`
+}
+
+// ServiceAccountCredential is an API key of a service account. Only its
+// prefix and a digest are stored; Key is set once, in the response that
+// creates it.
+type ServiceAccountCredential struct {
+	ID               int        `json:"id"`
+	ServiceAccountID int        `json:"service_account_id"`
+	KeyPrefix        string     `json:"key_prefix"`
+	KeyHash          string     `json:"-"`
+	Key              string     `json:"key,omitempty"`
+	CreatedAt        time.Time  `json:"created_at"`
+	ExpiresAt        *time.Time `json:"expires_at"`
+	RevokedAt        *time.Time `json:"revoked_at"`
+}
+
+// CredentialRotation issues a new API key and retires the service account's
+// other keys once GracePeriodSeconds have passed, immediately by default.
+type CredentialRotation struct {
+	GracePeriodSeconds int `json:"grace_period_seconds"`
+}
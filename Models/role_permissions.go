@@ -0,0 +1,23 @@
+package models
+
+import "time"
+
+// Effects of a role permission or user role. A deny binding always wins
+// over an allow binding for the same permission.
+const (
+	EffectAllow = "allow"
+	EffectDeny  = "deny"
+)
+
+// RolePermission binds a permission to a role. With a Condition, the role
+// only grants the permission to checks whose context satisfies it. A deny
+// binding instead revokes the permission from everyone holding the role.
+type RolePermission struct {
+	ID            int       `json:"id"`
+	RoleID        int       `json:"role_id"`
+	PermissionID  int       `json:"permission_id"`
+	PermissionKey string    `json:"permission_key"`
+	Condition     string    `json:"condition"`
+	Effect        string    `json:"effect"`
+	CreatedAt     time.Time `json:"created_at"`
+}
@@ -2,9 +2,32 @@ package models
 
 type Role struct {
 	ID          int     `json:"id"`
+	TenantID    string  `json:"tenant_id"`
 	RoleKey     string  `json:"role_key"`
 	Description string  `json:"description"`
+	ParentIDs   []int   `json:"parent_ids"`
 	CreatedAt   string  `json:"created_at"`
 	UpdatedAt   string  `json:"updated_at"`
 	DeletedAt   *string `json:"deleted_at"`
-}
\ No newline at end of file
+	Version     int     `json:"version"`
+}
+
+type RolePage struct {
+	Roles      []Role `json:"roles"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// RoleMembers is the full list of emails that should hold a role.
+type RoleMembers struct {
+	Emails []string `json:"emails"`
+}
+
+// RoleMembersDiff reports how a members sync changed, or in a dry run would
+// change, who holds a role.
+type RoleMembersDiff struct {
+	RoleID    int      `json:"role_id"`
+	DryRun    bool     `json:"dry_run"`
+	Added     []string `json:"added"`
+	Removed   []string `json:"removed"`
+	Unchanged []string `json:"unchanged"`
+}
@@ -2,12 +2,64 @@ package models
 
 import "time"
 
+// UserRole grants a role to an email, a service account, or every member of
+// a group. Principal names the grantee whichever it is, and Source says
+// whether the grant is "direct" or through a "group". With a Condition, the
+// grant only counts for checks whose context satisfies it. A deny grant
+// revokes every permission of the role from the principal instead.
 type UserRole struct {
-	ID        int        `json:"id"`
-	Email     string     `json:"email"`
-	RoleID    int        `json:"role_id"`
-	RoleKey   string     `json:"role_key"`
-	CreatedAt time.Time  `json:"created_at"`
-	UpdatedAt time.Time  `json:"updated_at"`
-	DeletedAt *time.Time `json:"deleted_at"`
+	ID               int        `json:"id"`
+	TenantID         string     `json:"tenant_id"`
+	Email            string     `json:"email"`
+	GroupID          *int       `json:"group_id"`
+	ServiceAccountID *int       `json:"service_account_id"`
+	Principal        Principal  `json:"principal"`
+	Source           string     `json:"source"`
+	RoleID           int        `json:"role_id"`
+	RoleKey          string     `json:"role_key"`
+	ResourceType     string     `json:"resource_type"`
+	ResourceID       string     `json:"resource_id"`
+	ValidFrom        *time.Time `json:"valid_from"`
+	ValidUntil       *time.Time `json:"valid_until"`
+	Condition        string     `json:"condition"`
+	Effect           string     `json:"effect"`
+	CreatedAt        time.Time  `json:"created_at"`
+	UpdatedAt        time.Time  `json:"updated_at"`
+	DeletedAt        *time.Time `json:"deleted_at"`
+	DeletedReason    *string    `json:"deleted_reason"`
+	Version          int        `json:"version"`
+}
+
+type UserRolePage struct {
+	UserRoles  []UserRole `json:"user_roles"`
+	NextCursor string     `json:"next_cursor,omitempty"`
+}
+
+// UserRoleBatch grants and revokes several user roles in one request. An
+// atomic batch applies every item or none.
+type UserRoleBatch struct {
+	Atomic      bool                 `json:"atomic"`
+	Grants      []UserRole           `json:"grants"`
+	Revocations []UserRoleRevocation `json:"revocations"`
+}
+
+// UserRoleRevocation names a user role to revoke. A non-zero version must be
+// its current version.
+type UserRoleRevocation struct {
+	ID      int `json:"id"`
+	Version int `json:"version,omitempty"`
+}
+
+// UserRoleBatchResult is the outcome of one grant or revocation: the user
+// role as granted or revoked, or the error that item failed with.
+type UserRoleBatchResult struct {
+	Op       string         `json:"op"`
+	Index    int            `json:"index"`
+	Status   int            `json:"status"`
+	UserRole *UserRole      `json:"user_role,omitempty"`
+	Error    *ErrorResponse `json:"error,omitempty"`
+}
+
+type UserRoleBatchResponse struct {
+	Results []UserRoleBatchResult `json:"results"`
 }
@@ -1,17 +1,30 @@
 package app
 
 import (
-	"database/sql"
 	"log"
+	"main/store"
 	"main/utils"
 	"net/http"
 
 	"github.com/gorilla/mux"
 )
 
-func InitializeRoute(db *sql.DB) {
+func InitializeRoute(s store.Store) {
+	authenticators, err := authenticatorsFromEnv(s)
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	r := mux.NewRouter()
-	RoleRoutes(db,r)
-	UserRoleRoutes(db,r)
-	log.Fatal(http.ListenAndServe(":8000", utils.JsonContentTypeMiddleware(r)))
+	if !authDisabled() {
+		r.Use(authorizeRoutes(s, superAdminsFromEnv()))
+	}
+	RoleRoutes(s,r)
+	UserRoleRoutes(s,r)
+	GroupRoutes(s, r)
+	ServiceAccountRoutes(s, r)
+	PermissionRoutes(s, r)
+	AuthorizeRoutes(s, r)
+	AuditRoutes(s, r)
+	log.Fatal(http.ListenAndServe(":8000", utils.RequestIDMiddleware(utils.JsonContentTypeMiddleware(utils.AuthMiddleware(authenticators...)(utils.TenantMiddleware(r))))))
 }
\ No newline at end of file
@@ -0,0 +1,14 @@
+package app
+
+import (
+	"main/controllers"
+	"main/store"
+
+	"github.com/gorilla/mux"
+)
+
+func AuditRoutes(events store.AuditStore, r *mux.Router) {
+
+	r.HandleFunc("/audit", controllers.GetAuditEvents(events)).Methods("GET")
+
+}
@@ -0,0 +1,99 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"main/store"
+	"main/utils"
+	"main/validation"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// authenticatorsFromEnv configures caller authentication:
+//
+//	API_KEYS      comma-separated name=key pairs; each name is the caller's
+//	              email address, which its grants are held by, unless the
+//	              name is listed in SUPER_ADMINS
+//	JWKS_FILE     path to a JWKS document with HS256 (oct) and RS256 (RSA) keys
+//	JWT_ISSUER    expected iss claim, optional
+//	JWT_AUDIENCE  expected aud claim, optional
+//
+// Service accounts can also authenticate with their API keys, but creating
+// the first one takes one of the above. Starting without any credentials
+// configured is refused unless AUTH_DISABLED=true, in which case every
+// request is anonymous and route policies are not enforced.
+func authenticatorsFromEnv(accounts store.ServiceAccountStore) ([]utils.Authenticator, error) {
+	var authenticators []utils.Authenticator
+
+	if v := os.Getenv("API_KEYS"); v != "" {
+		superAdmins := superAdminsFromEnv()
+		keys := map[string]string{}
+		for _, pair := range strings.Split(v, ",") {
+			name, key, ok := strings.Cut(strings.TrimSpace(pair), "=")
+			if !ok || name == "" || key == "" {
+				return nil, fmt.Errorf("API_KEYS: expected name=key, got %q", pair)
+			}
+			if _, err := validation.NormalizeEmail(name); err != nil && !superAdmins[name] {
+				return nil, fmt.Errorf("API_KEYS: name %q cannot hold grants: it must be an email address or be listed in SUPER_ADMINS", name)
+			}
+			keys[name] = key
+		}
+		authenticators = append(authenticators, utils.NewAPIKeyAuthenticator(keys))
+	}
+
+	if path := os.Getenv("JWKS_FILE"); path != "" {
+		keys, err := utils.LoadKeySet(path)
+		if err != nil {
+			return nil, fmt.Errorf("JWKS_FILE: %w", err)
+		}
+		authenticators = append(authenticators, utils.NewJWTAuthenticator(keys, os.Getenv("JWT_ISSUER"), os.Getenv("JWT_AUDIENCE")))
+	}
+
+	if len(authenticators) == 0 {
+		if !authDisabled() {
+			return nil, errors.New("no authentication configured: set API_KEYS or JWKS_FILE, or AUTH_DISABLED=true")
+		}
+		return append(authenticators, anonymousAuthenticator{}), nil
+	}
+	return append(authenticators, serviceAccountAuthenticator{accounts: accounts}), nil
+}
+
+// authDisabled reports whether the API runs without authentication or route
+// policies, for local development only.
+func authDisabled() bool {
+	return os.Getenv("AUTH_DISABLED") == "true"
+}
+
+// serviceAccountAuthenticator accepts the active API keys of live service
+// accounts. The caller is bound to the service account's tenant.
+type serviceAccountAuthenticator struct {
+	accounts store.ServiceAccountStore
+}
+
+func (a serviceAccountAuthenticator) Authenticate(r *http.Request) (*utils.Identity, error) {
+	key := utils.APIKeyFromRequest(r)
+	if !strings.HasPrefix(key, utils.ServiceAccountKeyPrefix) {
+		return nil, nil
+	}
+	account, err := a.accounts.AuthenticateCredential(r.Context(), utils.HashAPIKey(key))
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, utils.ErrInvalidCredentials
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &utils.Identity{
+		Subject:          "service:" + account.Name,
+		Method:           utils.AuthMethodServiceAccount,
+		Tenant:           account.TenantID,
+		ServiceAccountID: account.ID,
+	}, nil
+}
+
+type anonymousAuthenticator struct{}
+
+func (anonymousAuthenticator) Authenticate(*http.Request) (*utils.Identity, error) {
+	return &utils.Identity{Subject: utils.AnonymousActor}, nil
+}
@@ -0,0 +1,16 @@
+package app
+
+import (
+	"main/controllers"
+	"main/store"
+
+	"github.com/gorilla/mux"
+)
+
+func AuthorizeRoutes(authorizer store.Authorizer, r *mux.Router) {
+
+	r.HandleFunc("/authorize", controllers.Authorize(authorizer)).Methods("POST")
+	r.HandleFunc("/authorize/batch", controllers.AuthorizeBatch(authorizer)).Methods("POST")
+	r.HandleFunc("/authorize/explain", controllers.ExplainAuthorization(authorizer)).Methods("POST")
+
+}
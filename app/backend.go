@@ -0,0 +1,38 @@
+package app
+
+import (
+	"database/sql"
+	"fmt"
+	"main/store"
+	"os"
+)
+
+// Store backends, chosen with STORE_BACKEND.
+const (
+	// BackendPostgres keeps state in the DATABASE_URL database. It is the
+	// default.
+	BackendPostgres = "postgres"
+	// BackendMemory keeps state in process memory, lost on restart, for
+	// local development and tests only.
+	BackendMemory = "memory"
+)
+
+// BackendFromEnv reads STORE_BACKEND, postgres when unset.
+func BackendFromEnv() (string, error) {
+	switch backend := os.Getenv("STORE_BACKEND"); backend {
+	case "", BackendPostgres:
+		return BackendPostgres, nil
+	case BackendMemory:
+		return BackendMemory, nil
+	default:
+		return "", fmt.Errorf("STORE_BACKEND: expected %s or %s, got %q", BackendPostgres, BackendMemory, backend)
+	}
+}
+
+// NewStore returns the store of backend, kept in db for BackendPostgres.
+func NewStore(backend string, db *sql.DB) store.Store {
+	if backend == BackendMemory {
+		return store.NewMemory()
+	}
+	return store.NewPostgres(db)
+}
@@ -0,0 +1,20 @@
+package app
+
+import (
+	"main/controllers"
+	"main/store"
+
+	"github.com/gorilla/mux"
+)
+
+func GroupRoutes(groups store.GroupStore, r *mux.Router) {
+	r.HandleFunc("/groups", controllers.GetGroups(groups)).Methods("GET")
+	r.HandleFunc("/groups/{id}", controllers.GetGroup(groups)).Methods("GET")
+	r.HandleFunc("/groups", controllers.CreateGroup(groups)).Methods("POST")
+	r.HandleFunc("/groups/{id}", controllers.UpdateGroup(groups)).Methods("PUT")
+	r.HandleFunc("/groups/{id}", controllers.DeleteGroup(groups)).Methods("DELETE")
+
+	r.HandleFunc("/groups/{id}/members", controllers.GetGroupMembers(groups)).Methods("GET")
+	r.HandleFunc("/groups/{id}/members", controllers.AddGroupMember(groups)).Methods("POST")
+	r.HandleFunc("/groups/{id}/members/{member_id}", controllers.RemoveGroupMember(groups)).Methods("DELETE")
+}
@@ -0,0 +1,18 @@
+package app
+
+import (
+	"main/controllers"
+	"main/store"
+
+	"github.com/gorilla/mux"
+)
+
+func PermissionRoutes(permissions store.PermissionStore, r *mux.Router) {
+
+	r.HandleFunc("/permissions", controllers.GetPermissions(permissions)).Methods("GET")
+	r.HandleFunc("/permissions/{id}", controllers.GetPermission(permissions)).Methods("GET")
+	r.HandleFunc("/permissions", controllers.CreatePermission(permissions)).Methods("POST")
+	r.HandleFunc("/permissions/{id}", controllers.UpdatePermission(permissions)).Methods("PUT")
+	r.HandleFunc("/permissions/{id}", controllers.DeletePermission(permissions)).Methods("DELETE")
+
+}
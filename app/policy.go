@@ -0,0 +1,330 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	models "main/Models"
+	"main/store"
+	"main/utils"
+	"main/validation"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// Permissions that guard the service's own endpoints. They are ordinary
+// permission keys: grant them by binding them to roles in the tenant.
+const (
+	PermissionsRead      = "permissions:read"
+	PermissionsAdmin     = "permissions:admin"
+	UserRolesRead        = "user-roles:read"
+	UserRolesWrite       = "user-roles:write"
+	GroupsRead           = "groups:read"
+	GroupsWrite          = "groups:write"
+	ServiceAccountsRead  = "service-accounts:read"
+	ServiceAccountsWrite = "service-accounts:write"
+	AuthorizeCheck       = "authorize:check"
+	AuthorizeExplain     = "authorize:explain"
+	AuditRead            = "audit:read"
+	roleResourcePrefix   = "role:"
+)
+
+// routePolicy is the permission a caller needs to use a route. When
+// resources is set, the caller needs the permission on every resource it
+// returns, so grants can be scoped, e.g. user-roles:write on role:viewer.
+// When passesOn is set, it returns the role:<key> resources of the roles
+// the route hands to or takes from a principal, and the caller also needs
+// user-roles:write on each, as if granting them directly.
+type routePolicy struct {
+	permission string
+	resources  func(policies store.PolicyStore, r *http.Request) ([]string, error)
+	passesOn   func(policies store.PolicyStore, r *http.Request) ([]string, error)
+}
+
+// routePolicies is keyed by method and mux path template. Routes without an
+// entry are refused, so a new route is closed until it is given a policy.
+var routePolicies = map[string]routePolicy{
+	"GET /roles":                                     {permission: PermissionsRead},
+	"GET /roles/{id}":                                {permission: PermissionsRead},
+	"POST /roles":                                    {permission: PermissionsAdmin},
+	"PUT /roles/{id}":                                {permission: PermissionsAdmin},
+	"PATCH /roles/{id}":                              {permission: PermissionsAdmin},
+	"DELETE /roles/{id}":                             {permission: PermissionsAdmin},
+	"POST /roles/{id}/restore":                       {permission: PermissionsAdmin},
+	"GET /roles/{id}/ancestors":                      {permission: PermissionsRead},
+	"GET /roles/{id}/descendants":                    {permission: PermissionsRead},
+	"PUT /roles/{id}/members":                        {permission: UserRolesWrite, resources: roleInPath},
+	"GET /roles/{id}/permissions":                    {permission: PermissionsRead},
+	"POST /roles/{id}/permissions":                   {permission: PermissionsAdmin},
+	"DELETE /roles/{id}/permissions/{permission_id}": {permission: PermissionsAdmin},
+
+	"GET /permissions":         {permission: PermissionsRead},
+	"GET /permissions/{id}":    {permission: PermissionsRead},
+	"POST /permissions":        {permission: PermissionsAdmin},
+	"PUT /permissions/{id}":    {permission: PermissionsAdmin},
+	"DELETE /permissions/{id}": {permission: PermissionsAdmin},
+
+	"GET /user-roles":               {permission: UserRolesRead},
+	"GET /user-roles/{id}":          {permission: UserRolesRead},
+	"POST /user-roles":              {permission: UserRolesWrite, resources: grantedRole},
+	"POST /user-roles:batch":        {permission: UserRolesWrite, resources: batchGrantedRoles},
+	"PUT /user-roles/{id}":          {permission: UserRolesWrite, resources: grantedRole},
+	"PATCH /user-roles/{id}":        {permission: UserRolesWrite, resources: grantedRole},
+	"DELETE /user-roles/{id}":       {permission: UserRolesWrite, resources: grantedRole},
+	"POST /user-roles/{id}/restore": {permission: UserRolesWrite, resources: grantedRole},
+
+	// Group membership passes on the group's roles, so managing members
+	// needs groups:write as well as user-roles:write on each of those roles.
+	"GET /groups":                             {permission: GroupsRead},
+	"GET /groups/{id}":                        {permission: GroupsRead},
+	"POST /groups":                            {permission: GroupsWrite},
+	"PUT /groups/{id}":                        {permission: GroupsWrite},
+	"DELETE /groups/{id}":                     {permission: GroupsWrite},
+	"GET /groups/{id}/members":                {permission: GroupsRead},
+	"POST /groups/{id}/members":               {permission: GroupsWrite, passesOn: groupRoles},
+	"DELETE /groups/{id}/members/{member_id}": {permission: GroupsWrite, passesOn: groupRoles},
+
+	// A service account's credentials are its identity, so issuing them
+	// needs service-accounts:write as well as user-roles:write on each role
+	// the account holds.
+	"GET /service-accounts":                                     {permission: ServiceAccountsRead},
+	"GET /service-accounts/{id}":                                {permission: ServiceAccountsRead},
+	"POST /service-accounts":                                    {permission: ServiceAccountsWrite},
+	"PUT /service-accounts/{id}":                                {permission: ServiceAccountsWrite},
+	"DELETE /service-accounts/{id}":                             {permission: ServiceAccountsWrite},
+	"GET /service-accounts/{id}/credentials":                    {permission: ServiceAccountsRead},
+	"POST /service-accounts/{id}/credentials":                   {permission: ServiceAccountsWrite, passesOn: serviceAccountRoles},
+	"POST /service-accounts/{id}/credentials:rotate":            {permission: ServiceAccountsWrite, passesOn: serviceAccountRoles},
+	"DELETE /service-accounts/{id}/credentials/{credential_id}": {permission: ServiceAccountsWrite},
+
+	"GET /principals/{type}/{id}/roles": {permission: UserRolesRead},
+
+	"POST /authorize":       {permission: AuthorizeCheck},
+	"POST /authorize/batch": {permission: AuthorizeCheck},
+	// The trace lists the principal's grants, so it needs more than a check.
+	"POST /authorize/explain": {permission: AuthorizeExplain},
+
+	"GET /audit": {permission: AuditRead},
+}
+
+// superAdminsFromEnv reads SUPER_ADMINS, a comma-separated list of subjects
+// that pass every route policy in every tenant. They bootstrap a fresh
+// deployment by granting the first roles. Service accounts never match an
+// entry: their subjects are derived from names any account admin can pick.
+func superAdminsFromEnv() map[string]bool {
+	superAdmins := map[string]bool{}
+	for _, subject := range strings.Split(os.Getenv("SUPER_ADMINS"), ",") {
+		if subject = strings.TrimSpace(subject); subject != "" {
+			superAdmins[subject] = true
+		}
+	}
+	return superAdmins
+}
+
+// authorizeRoutes enforces routePolicies against the caller's own roles in
+// the request's tenant. Callers other than super admins must map to a
+// principal that can hold grants, see callerPrincipal.
+func authorizeRoutes(policies store.PolicyStore, superAdmins map[string]bool) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			identity := utils.IdentityFromContext(r.Context())
+			if identity == nil {
+				utils.WriteError(w, r, http.StatusUnauthorized, utils.CodeUnauthorized, "missing or invalid credentials", nil)
+				return
+			}
+			if identity.ServiceAccountID == 0 && superAdmins[identity.Subject] {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			template, _ := mux.CurrentRoute(r).GetPathTemplate()
+			policy, ok := routePolicies[r.Method+" "+template]
+			if !ok {
+				utils.WriteError(w, r, http.StatusForbidden, utils.CodeForbidden, "route has no access policy", nil)
+				return
+			}
+			caller, err := callerPrincipal(identity)
+			if err != nil {
+				utils.WriteError(w, r, http.StatusForbidden, utils.CodeForbidden, "caller "+identity.Subject+" cannot hold grants: subject "+err.Error(), nil)
+				return
+			}
+
+			resources := []string{""}
+			if policy.resources != nil {
+				var err error
+				if resources, err = policy.resources(policies, r); err != nil {
+					log.Println(err)
+					utils.WriteError(w, r, http.StatusInternalServerError, utils.CodeInternal, "internal server error", nil)
+					return
+				}
+			}
+			required := []models.AuthorizationRequest{}
+			for _, resource := range resources {
+				required = append(required, models.AuthorizationRequest{Permission: policy.permission, Resource: resource})
+			}
+			if policy.passesOn != nil {
+				roles, err := policy.passesOn(policies, r)
+				if err != nil {
+					log.Println(err)
+					utils.WriteError(w, r, http.StatusInternalServerError, utils.CodeInternal, "internal server error", nil)
+					return
+				}
+				for _, role := range roles {
+					required = append(required, models.AuthorizationRequest{Permission: UserRolesWrite, Resource: role})
+				}
+			}
+
+			tenant := utils.TenantFromContext(r.Context())
+			for _, req := range required {
+				caller.Permission = req.Permission
+				caller.Resource = req.Resource
+				decision, err := policies.Check(r.Context(), tenant, caller)
+				if err != nil {
+					log.Println(err)
+					utils.WriteError(w, r, http.StatusInternalServerError, utils.CodeInternal, "internal server error", nil)
+					return
+				}
+				if !decision.Allowed {
+					utils.WriteError(w, r, http.StatusForbidden, utils.CodeForbidden, "caller lacks "+req.Permission, nil)
+					return
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// callerPrincipal maps an identity to the principal its grants are held by.
+// Service accounts hold grants by id, every other caller by email address.
+// An API key name or JWT subject that is not an email address can hold no
+// grant, so it is refused here rather than checked under a name nothing can
+// be granted to. API_KEYS names are also checked when the service starts,
+// see authenticatorsFromEnv.
+func callerPrincipal(identity *utils.Identity) (models.AuthorizationRequest, error) {
+	if identity.ServiceAccountID != 0 {
+		return models.AuthorizationRequest{PrincipalType: models.PrincipalService, PrincipalID: strconv.Itoa(identity.ServiceAccountID)}, nil
+	}
+	email, err := validation.NormalizeEmail(identity.Subject)
+	if err != nil {
+		return models.AuthorizationRequest{}, err
+	}
+	return models.AuthorizationRequest{Email: email}, nil
+}
+
+// grantedRole returns the role:<key> resources a user role write touches:
+// the role currently granted by the user role being changed, the role named
+// in the request body, and every role either inherits from. A role that does
+// not exist yields no resource and is left for the handler to reject.
+func grantedRole(policies store.PolicyStore, r *http.Request) ([]string, error) {
+	var roleIDs, userRoleIDs []int
+	if id, err := strconv.Atoi(mux.Vars(r)["id"]); err == nil {
+		userRoleIDs = append(userRoleIDs, id)
+	}
+	if r.Method == http.MethodPost || r.Method == http.MethodPut || r.Method == http.MethodPatch {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			return nil, err
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		var userRole struct {
+			RoleID int `json:"role_id"`
+		}
+		if json.Unmarshal(body, &userRole) == nil && userRole.RoleID != 0 {
+			roleIDs = append(roleIDs, userRole.RoleID)
+		}
+	}
+	return roleResources(policies, r, roleIDs, userRoleIDs)
+}
+
+// roleInPath returns the role:<key> resources of the role a route addresses
+// and of every role it inherits from, so a grant of user-roles:write scoped
+// to a role covers syncing its members only when it covers what they
+// inherit too. A role that does not exist is left for the handler to reject.
+func roleInPath(policies store.PolicyStore, r *http.Request) ([]string, error) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		return []string{""}, nil
+	}
+	return roleResources(policies, r, []int{id}, nil)
+}
+
+// batchGrantedRoles returns the role:<key> resources a user role batch
+// touches: every role it grants, every role granted by the user roles it
+// revokes, and every role those inherit from. Like grantedRole, roles that
+// do not exist are left to the handler.
+func batchGrantedRoles(policies store.PolicyStore, r *http.Request) ([]string, error) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	var batch models.UserRoleBatch
+	if json.Unmarshal(body, &batch) != nil {
+		return []string{""}, nil
+	}
+	roleIDs := []int{}
+	for _, grant := range batch.Grants {
+		roleIDs = append(roleIDs, grant.RoleID)
+	}
+	userRoleIDs := []int{}
+	for _, revocation := range batch.Revocations {
+		userRoleIDs = append(userRoleIDs, revocation.ID)
+	}
+	return roleResources(policies, r, roleIDs, userRoleIDs)
+}
+
+// roleResources returns the role:<key> resources of roleIDs, of the roles
+// granted by userRoleIDs and of every role they inherit from, or the
+// tenant-wide resource when there are none.
+func roleResources(policies store.PolicyStore, r *http.Request, roleIDs, userRoleIDs []int) ([]string, error) {
+	roleKeys, err := policies.RoleKeys(r.Context(), utils.TenantFromContext(r.Context()), roleIDs, userRoleIDs)
+	if err != nil {
+		return nil, err
+	}
+	if len(roleKeys) == 0 {
+		return []string{""}, nil
+	}
+	return withRolePrefix(roleKeys), nil
+}
+
+// heldRoles returns the role:<key> resources of the roles held by the
+// principal of principalType whose id the route addresses, and of every
+// role they inherit from.
+func heldRoles(policies store.PolicyStore, r *http.Request, principalType string) ([]string, error) {
+	principal := models.Principal{Type: principalType, ID: mux.Vars(r)["id"]}
+	roleKeys, err := policies.HeldRoleKeys(r.Context(), utils.TenantFromContext(r.Context()), principal)
+	if err != nil {
+		return nil, err
+	}
+	return withRolePrefix(roleKeys), nil
+}
+
+func withRolePrefix(roleKeys []string) []string {
+	resources := make([]string, 0, len(roleKeys))
+	for _, roleKey := range roleKeys {
+		resources = append(resources, roleResourcePrefix+roleKey)
+	}
+	return resources
+}
+
+// groupRoles returns the role:<key> resources of the roles a member of the
+// group a route addresses holds through it: those granted to the group or
+// to any group containing it, and every role they inherit from. A group
+// that does not exist is left for the handler to reject.
+func groupRoles(policies store.PolicyStore, r *http.Request) ([]string, error) {
+	return heldRoles(policies, r, models.PrincipalGroup)
+}
+
+// serviceAccountRoles returns the role:<key> resources of the roles granted
+// to the service account a route addresses, and every role they inherit
+// from. Whoever holds its credentials acts with all of them. A service
+// account that does not exist is left for the handler to reject.
+func serviceAccountRoles(policies store.PolicyStore, r *http.Request) ([]string, error) {
+	return heldRoles(policies, r, models.PrincipalService)
+}
@@ -0,0 +1,379 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"io"
+	models "main/Models"
+	"main/store"
+	"main/utils"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuthorizeRoutes(t *testing.T) {
+	assignmentColumns := []string{"id", "group_id", "id", "role_key", "resource_type", "resource_id", "condition", "effect", "inactive"}
+
+	testCases := []struct {
+		name             string
+		subject          string
+		serviceAccountID int
+		method           string
+		path             string
+		body             string
+		mockSetup        func(mock sqlmock.Sqlmock)
+		expectedCode     int
+	}{
+		{
+			name:         "unauthenticated",
+			method:       "POST",
+			path:         "/roles",
+			mockSetup:    func(mock sqlmock.Sqlmock) {},
+			expectedCode: http.StatusUnauthorized,
+		},
+		{
+			name:         "super admin bypasses policies",
+			subject:      "root@example.com",
+			method:       "POST",
+			path:         "/roles",
+			mockSetup:    func(mock sqlmock.Sqlmock) {},
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:             "service account never matches a super admin",
+			subject:          "service:deploy",
+			serviceAccountID: 4,
+			method:           "POST",
+			path:             "/roles",
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(`FROM user_roles`).
+					WillReturnRows(sqlmock.NewRows(assignmentColumns))
+			},
+			expectedCode: http.StatusForbidden,
+		},
+		{
+			name:         "route without policy is closed",
+			subject:      "alice@example.com",
+			method:       "GET",
+			path:         "/unguarded",
+			mockSetup:    func(mock sqlmock.Sqlmock) {},
+			expectedCode: http.StatusForbidden,
+		},
+		{
+			name:    "caller holds the route permission",
+			subject: "alice@example.com",
+			method:  "POST",
+			path:    "/roles",
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(`FROM user_roles`).
+					WithArgs("default", "alice@example.com").
+					WillReturnRows(sqlmock.NewRows(assignmentColumns).AddRow(1, nil, 10, "admin", "", "", "", "allow", ""))
+				mock.ExpectQuery(`WITH RECURSIVE edges`).
+					WillReturnRows(sqlmock.NewRows([]string{"role_id", "parent_role_id", "role_key"}))
+				mock.ExpectQuery(`FROM role_permissions`).
+					WillReturnRows(sqlmock.NewRows([]string{"id", "role_id", "permission_key", "condition", "effect"}).AddRow(1, 10, PermissionsAdmin, "", "allow"))
+			},
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "subject that cannot hold grants is refused",
+			subject:      "ci-bot",
+			method:       "POST",
+			path:         "/roles",
+			mockSetup:    func(mock sqlmock.Sqlmock) {},
+			expectedCode: http.StatusForbidden,
+		},
+		{
+			name:    "email subject is normalized like grants are",
+			subject: "Alice@Example.com",
+			method:  "POST",
+			path:    "/roles",
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(`FROM user_roles`).
+					WithArgs("default", "alice@example.com").
+					WillReturnRows(sqlmock.NewRows(assignmentColumns).AddRow(1, nil, 10, "admin", "", "", "", "allow", ""))
+				mock.ExpectQuery(`WITH RECURSIVE edges`).
+					WillReturnRows(sqlmock.NewRows([]string{"role_id", "parent_role_id", "role_key"}))
+				mock.ExpectQuery(`FROM role_permissions`).
+					WillReturnRows(sqlmock.NewRows([]string{"id", "role_id", "permission_key", "condition", "effect"}).AddRow(1, 10, PermissionsAdmin, "", "allow"))
+			},
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:    "caller lacks the route permission",
+			subject: "alice@example.com",
+			method:  "POST",
+			path:    "/roles",
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(`FROM user_roles`).
+					WithArgs("default", "alice@example.com").
+					WillReturnRows(sqlmock.NewRows(assignmentColumns))
+			},
+			expectedCode: http.StatusForbidden,
+		},
+		{
+			name:    "scoped grant covers the granted role",
+			subject: "alice@example.com",
+			method:  "POST",
+			path:    "/user-roles",
+			body:    `{"email":"bob@example.com","role_id":5}`,
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(`WITH RECURSIVE touched`).
+					WithArgs("default", sqlmock.AnyArg(), sqlmock.AnyArg()).
+					WillReturnRows(sqlmock.NewRows([]string{"role_key"}).AddRow("viewer"))
+				mock.ExpectQuery(`FROM user_roles`).
+					WithArgs("default", "alice@example.com").
+					WillReturnRows(sqlmock.NewRows(assignmentColumns).AddRow(1, nil, 10, "team-lead", "role", "viewer", "", "allow", ""))
+				mock.ExpectQuery(`WITH RECURSIVE edges`).
+					WillReturnRows(sqlmock.NewRows([]string{"role_id", "parent_role_id", "role_key"}))
+				mock.ExpectQuery(`FROM role_permissions`).
+					WillReturnRows(sqlmock.NewRows([]string{"id", "role_id", "permission_key", "condition", "effect"}).AddRow(2, 10, UserRolesWrite, "", "allow"))
+			},
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:    "scoped grant does not cover other roles",
+			subject: "alice@example.com",
+			method:  "POST",
+			path:    "/user-roles",
+			body:    `{"email":"bob@example.com","role_id":6}`,
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(`WITH RECURSIVE touched`).
+					WithArgs("default", sqlmock.AnyArg(), sqlmock.AnyArg()).
+					WillReturnRows(sqlmock.NewRows([]string{"role_key"}).AddRow("admin"))
+				mock.ExpectQuery(`FROM user_roles`).
+					WithArgs("default", "alice@example.com").
+					WillReturnRows(sqlmock.NewRows(assignmentColumns).AddRow(1, nil, 10, "team-lead", "role", "viewer", "", "allow", ""))
+				mock.ExpectQuery(`WITH RECURSIVE edges`).
+					WillReturnRows(sqlmock.NewRows([]string{"role_id", "parent_role_id", "role_key"}))
+				mock.ExpectQuery(`FROM role_permissions`).
+					WillReturnRows(sqlmock.NewRows([]string{"id", "role_id", "permission_key", "condition", "effect"}).AddRow(3, 10, UserRolesWrite, "", "allow"))
+			},
+			expectedCode: http.StatusForbidden,
+		},
+		{
+			name:    "batch needs every role it touches",
+			subject: "alice@example.com",
+			method:  "POST",
+			path:    "/user-roles:batch",
+			body:    `{"grants":[{"email":"bob@example.com","role_id":5}],"revocations":[{"id":7}]}`,
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(`WITH RECURSIVE touched`).
+					WithArgs("default", sqlmock.AnyArg(), sqlmock.AnyArg()).
+					WillReturnRows(sqlmock.NewRows([]string{"role_key"}).AddRow("admin").AddRow("viewer"))
+				mock.ExpectQuery(`FROM user_roles`).
+					WithArgs("default", "alice@example.com").
+					WillReturnRows(sqlmock.NewRows(assignmentColumns).AddRow(1, nil, 10, "team-lead", "role", "viewer", "", "allow", ""))
+				mock.ExpectQuery(`WITH RECURSIVE edges`).
+					WillReturnRows(sqlmock.NewRows([]string{"role_id", "parent_role_id", "role_key"}))
+				mock.ExpectQuery(`FROM role_permissions`).
+					WillReturnRows(sqlmock.NewRows([]string{"id", "role_id", "permission_key", "condition", "effect"}).AddRow(4, 10, UserRolesWrite, "", "allow"))
+			},
+			expectedCode: http.StatusForbidden,
+		},
+		{
+			name:    "group member may be given the group's roles",
+			subject: "alice@example.com",
+			method:  "POST",
+			path:    "/groups/3/members",
+			body:    `{"email":"bob@example.com"}`,
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(`WITH RECURSIVE held`).
+					WithArgs("default", 3).
+					WillReturnRows(sqlmock.NewRows([]string{"role_key"}).AddRow("viewer"))
+				for i := 0; i < 2; i++ {
+					mock.ExpectQuery(`FROM user_roles`).
+						WithArgs("default", "alice@example.com").
+						WillReturnRows(sqlmock.NewRows(assignmentColumns).
+							AddRow(1, nil, 10, "group-admin", "", "", "", "allow", "").
+							AddRow(2, nil, 11, "team-lead", "role", "viewer", "", "allow", ""))
+					mock.ExpectQuery(`WITH RECURSIVE edges`).
+						WillReturnRows(sqlmock.NewRows([]string{"role_id", "parent_role_id", "role_key"}))
+					mock.ExpectQuery(`FROM role_permissions`).
+						WillReturnRows(sqlmock.NewRows([]string{"id", "role_id", "permission_key", "condition", "effect"}).
+							AddRow(5, 10, GroupsWrite, "", "allow").
+							AddRow(6, 11, UserRolesWrite, "", "allow"))
+				}
+			},
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:    "group member needs every role the group holds",
+			subject: "alice@example.com",
+			method:  "POST",
+			path:    "/groups/3/members",
+			body:    `{"email":"bob@example.com"}`,
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(`WITH RECURSIVE held`).
+					WithArgs("default", 3).
+					WillReturnRows(sqlmock.NewRows([]string{"role_key"}).AddRow("admin"))
+				for i := 0; i < 2; i++ {
+					mock.ExpectQuery(`FROM user_roles`).
+						WithArgs("default", "alice@example.com").
+						WillReturnRows(sqlmock.NewRows(assignmentColumns).
+							AddRow(1, nil, 10, "group-admin", "", "", "", "allow", "").
+							AddRow(2, nil, 11, "team-lead", "role", "viewer", "", "allow", ""))
+					mock.ExpectQuery(`WITH RECURSIVE edges`).
+						WillReturnRows(sqlmock.NewRows([]string{"role_id", "parent_role_id", "role_key"}))
+					mock.ExpectQuery(`FROM role_permissions`).
+						WillReturnRows(sqlmock.NewRows([]string{"id", "role_id", "permission_key", "condition", "effect"}).
+							AddRow(5, 10, GroupsWrite, "", "allow").
+							AddRow(6, 11, UserRolesWrite, "", "allow"))
+				}
+			},
+			expectedCode: http.StatusForbidden,
+		},
+		{
+			name:    "credentials need every role the service account holds",
+			subject: "alice@example.com",
+			method:  "POST",
+			path:    "/service-accounts/4/credentials",
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(`WITH RECURSIVE held`).
+					WithArgs("default", 4).
+					WillReturnRows(sqlmock.NewRows([]string{"role_key"}).AddRow("admin"))
+				for i := 0; i < 2; i++ {
+					mock.ExpectQuery(`FROM user_roles`).
+						WithArgs("default", "alice@example.com").
+						WillReturnRows(sqlmock.NewRows(assignmentColumns).
+							AddRow(1, nil, 10, "sa-admin", "", "", "", "allow", "").
+							AddRow(2, nil, 11, "team-lead", "role", "viewer", "", "allow", ""))
+					mock.ExpectQuery(`WITH RECURSIVE edges`).
+						WillReturnRows(sqlmock.NewRows([]string{"role_id", "parent_role_id", "role_key"}))
+					mock.ExpectQuery(`FROM role_permissions`).
+						WillReturnRows(sqlmock.NewRows([]string{"id", "role_id", "permission_key", "condition", "effect"}).
+							AddRow(5, 10, ServiceAccountsWrite, "", "allow").
+							AddRow(6, 11, UserRolesWrite, "", "allow"))
+				}
+			},
+			expectedCode: http.StatusForbidden,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			assert.NoError(t, err)
+			defer db.Close()
+			tc.mockSetup(mock)
+
+			var body []byte
+			ok := func(w http.ResponseWriter, r *http.Request) {
+				body, _ = io.ReadAll(r.Body)
+			}
+
+			r := mux.NewRouter()
+			r.Use(authorizeRoutes(store.NewPostgres(db), map[string]bool{"root@example.com": true, "service:deploy": true}))
+			r.HandleFunc("/roles", ok).Methods("POST")
+			r.HandleFunc("/user-roles", ok).Methods("POST")
+			r.HandleFunc("/user-roles:batch", ok).Methods("POST")
+			r.HandleFunc("/groups/{id}/members", ok).Methods("POST")
+			r.HandleFunc("/service-accounts/{id}/credentials", ok).Methods("POST")
+			r.HandleFunc("/unguarded", ok).Methods("GET")
+
+			req := httptest.NewRequest(tc.method, tc.path, strings.NewReader(tc.body))
+			if tc.subject != "" {
+				req = req.WithContext(utils.WithIdentity(req.Context(), &utils.Identity{Subject: tc.subject, ServiceAccountID: tc.serviceAccountID}))
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			assert.Equal(t, tc.expectedCode, w.Code)
+			if tc.expectedCode == http.StatusOK {
+				assert.Equal(t, tc.body, string(body), "the handler still sees the request body")
+			}
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestAuthorizeRoutesInheritedRoles(t *testing.T) {
+	s := store.NewMemory()
+	ctx := context.Background()
+
+	admin, err := s.CreateRole(ctx, models.Role{TenantID: "default", RoleKey: "admin"})
+	require.NoError(t, err)
+	support, err := s.CreateRole(ctx, models.Role{TenantID: "default", RoleKey: "support", ParentIDs: []int{admin.ID}})
+	require.NoError(t, err)
+	viewer, err := s.CreateRole(ctx, models.Role{TenantID: "default", RoleKey: "viewer"})
+	require.NoError(t, err)
+	lead, err := s.CreateRole(ctx, models.Role{TenantID: "default", RoleKey: "team-lead"})
+	require.NoError(t, err)
+	write, err := s.CreatePermission(ctx, models.Permission{TenantID: "default", PermissionKey: UserRolesWrite})
+	require.NoError(t, err)
+	_, err = s.AddRolePermission(ctx, "default", models.RolePermission{RoleID: lead.ID, PermissionID: write.ID, Effect: models.EffectAllow})
+	require.NoError(t, err)
+	// alice may write user roles for support and viewer, but not for admin,
+	// which support inherits from.
+	for _, key := range []string{"support", "viewer"} {
+		_, err = s.CreateUserRole(ctx, models.UserRole{TenantID: "default", Email: "alice@example.com", RoleID: lead.ID, ResourceType: "role", ResourceID: key})
+		require.NoError(t, err)
+	}
+
+	testCases := []struct {
+		name         string
+		method       string
+		path         string
+		body         string
+		expectedCode int
+	}{
+		{name: "grant of a role without parents", method: "POST", path: "/user-roles", body: fmt.Sprintf(`{"email":"bob@example.com","role_id":%d}`, viewer.ID), expectedCode: http.StatusOK},
+		{name: "grant of a role inheriting an unwritable role", method: "POST", path: "/user-roles", body: fmt.Sprintf(`{"email":"bob@example.com","role_id":%d}`, support.ID), expectedCode: http.StatusForbidden},
+		{name: "batch grant of a role inheriting an unwritable role", method: "POST", path: "/user-roles:batch", body: fmt.Sprintf(`{"grants":[{"email":"bob@example.com","role_id":%d}]}`, support.ID), expectedCode: http.StatusForbidden},
+		{name: "members sync of a role inheriting an unwritable role", method: "PUT", path: fmt.Sprintf("/roles/%d/members", support.ID), body: `{"emails":["bob@example.com"]}`, expectedCode: http.StatusForbidden},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ok := func(w http.ResponseWriter, r *http.Request) {}
+
+			r := mux.NewRouter()
+			r.Use(authorizeRoutes(s, map[string]bool{}))
+			r.HandleFunc("/user-roles", ok).Methods("POST")
+			r.HandleFunc("/user-roles:batch", ok).Methods("POST")
+			r.HandleFunc("/roles/{id}/members", ok).Methods("PUT")
+
+			req := httptest.NewRequest(tc.method, tc.path, strings.NewReader(tc.body))
+			req = req.WithContext(utils.WithIdentity(req.Context(), &utils.Identity{Subject: "alice@example.com"}))
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			assert.Equal(t, tc.expectedCode, w.Code)
+		})
+	}
+}
+
+func TestAuthenticatorsFromEnvAPIKeyNames(t *testing.T) {
+	testCases := []struct {
+		name        string
+		apiKeys     string
+		superAdmins string
+		expectedErr bool
+	}{
+		{name: "email name", apiKeys: "ops@example.com=secret"},
+		{name: "non-email super admin", apiKeys: "bootstrap=secret", superAdmins: "bootstrap"},
+		{name: "non-email name", apiKeys: "ci=secret", expectedErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv("API_KEYS", tc.apiKeys)
+			t.Setenv("SUPER_ADMINS", tc.superAdmins)
+			t.Setenv("JWKS_FILE", "")
+
+			_, err := authenticatorsFromEnv(store.NewMemory())
+			if tc.expectedErr {
+				assert.ErrorContains(t, err, "SUPER_ADMINS")
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
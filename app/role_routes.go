@@ -1,21 +1,30 @@
 package app
 
 import (
-	"database/sql"
 	// "log"
 	"main/controllers"
+	"main/store"
 	// "main/utils"
 	// "net/http"
 
 	"github.com/gorilla/mux"
 )
 
-func RoleRoutes(db *sql.DB,r *mux.Router) {
+func RoleRoutes(roles store.Store,r *mux.Router) {
+	r.HandleFunc("/roles", controllers.GetRoles(roles)).Methods("GET")
+	r.HandleFunc("/roles/{id}", controllers.GetRole(roles)).Methods("GET")
+	r.HandleFunc("/roles", controllers.CreateRole(roles)).Methods("POST")
+	r.HandleFunc("/roles/{id}", controllers.UpdateRole(roles)).Methods("PUT")
+	r.HandleFunc("/roles/{id}", controllers.PatchRole(roles)).Methods("PATCH")
+	r.HandleFunc("/roles/{id}", controllers.DeleteRole(roles)).Methods("DELETE")
+	r.HandleFunc("/roles/{id}/restore", controllers.RestoreRole(roles)).Methods("POST")
 
-	r.HandleFunc("/roles", controllers.GetRoles(db)).Methods("GET")
-	r.HandleFunc("/roles/{id}", controllers.GetRole(db)).Methods("GET")
-	r.HandleFunc("/roles", controllers.CreateRole(db)).Methods("POST")
-	r.HandleFunc("/roles/{id}", controllers.UpdateRole(db)).Methods("PUT")
-	r.HandleFunc("/roles/{id}", controllers.DeleteRole(db)).Methods("DELETE")
+	r.HandleFunc("/roles/{id}/ancestors", controllers.GetRoleAncestors(roles)).Methods("GET")
+	r.HandleFunc("/roles/{id}/descendants", controllers.GetRoleDescendants(roles)).Methods("GET")
+	r.HandleFunc("/roles/{id}/members", controllers.SyncRoleMembers(roles)).Methods("PUT")
+
+	r.HandleFunc("/roles/{id}/permissions", controllers.GetRolePermissions(roles)).Methods("GET")
+	r.HandleFunc("/roles/{id}/permissions", controllers.AddRolePermission(roles)).Methods("POST")
+	r.HandleFunc("/roles/{id}/permissions/{permission_id}", controllers.RemoveRolePermission(roles)).Methods("DELETE")
 
 }
@@ -0,0 +1,23 @@
+package app
+
+import (
+	"main/controllers"
+	"main/store"
+
+	"github.com/gorilla/mux"
+)
+
+func ServiceAccountRoutes(accounts store.PrincipalStore, r *mux.Router) {
+	r.HandleFunc("/service-accounts", controllers.GetServiceAccounts(accounts)).Methods("GET")
+	r.HandleFunc("/service-accounts/{id}", controllers.GetServiceAccount(accounts)).Methods("GET")
+	r.HandleFunc("/service-accounts", controllers.CreateServiceAccount(accounts)).Methods("POST")
+	r.HandleFunc("/service-accounts/{id}", controllers.UpdateServiceAccount(accounts)).Methods("PUT")
+	r.HandleFunc("/service-accounts/{id}", controllers.DeleteServiceAccount(accounts)).Methods("DELETE")
+
+	r.HandleFunc("/service-accounts/{id}/credentials", controllers.GetCredentials(accounts)).Methods("GET")
+	r.HandleFunc("/service-accounts/{id}/credentials", controllers.CreateCredential(accounts)).Methods("POST")
+	r.HandleFunc("/service-accounts/{id}/credentials:rotate", controllers.RotateCredentials(accounts)).Methods("POST")
+	r.HandleFunc("/service-accounts/{id}/credentials/{credential_id}", controllers.RevokeCredential(accounts)).Methods("DELETE")
+
+	r.HandleFunc("/principals/{type}/{id}/roles", controllers.GetPrincipalRoles(accounts)).Methods("GET")
+}
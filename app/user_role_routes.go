@@ -1,18 +1,20 @@
 package app
 
 import (
-	"database/sql"
 	"main/controllers"
+	"main/store"
 	"github.com/gorilla/mux"
 )
 
-func UserRoleRoutes(db *sql.DB,r *mux.Router) {
-
-	r.HandleFunc("/user-roles", controllers.GetUserRoles(db)).Methods("GET")
-	r.HandleFunc("/user-roles/{id}", controllers.GetUserRole(db)).Methods("GET")
-	r.HandleFunc("/user-roles", controllers.CreateUserRole(db)).Methods("POST")
-	r.HandleFunc("/user-roles/{id}", controllers.UpdateUserRole(db)).Methods("PUT")
-	r.HandleFunc("/user-roles/{id}", controllers.DeleteUserRole(db)).Methods("DELETE")
+func UserRoleRoutes(userRoles store.UserRoleStore,r *mux.Router) {
+	r.HandleFunc("/user-roles", controllers.GetUserRoles(userRoles)).Methods("GET")
+	r.HandleFunc("/user-roles/{id}", controllers.GetUserRole(userRoles)).Methods("GET")
+	r.HandleFunc("/user-roles", controllers.CreateUserRole(userRoles)).Methods("POST")
+	r.HandleFunc("/user-roles:batch", controllers.BatchUserRoles(userRoles)).Methods("POST")
+	r.HandleFunc("/user-roles/{id}", controllers.UpdateUserRole(userRoles)).Methods("PUT")
+	r.HandleFunc("/user-roles/{id}", controllers.PatchUserRole(userRoles)).Methods("PATCH")
+	r.HandleFunc("/user-roles/{id}", controllers.DeleteUserRole(userRoles)).Methods("DELETE")
+	r.HandleFunc("/user-roles/{id}/restore", controllers.RestoreUserRole(userRoles)).Methods("POST")
 
 }
 
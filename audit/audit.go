@@ -0,0 +1,86 @@
+// Package audit writes the append-only trail of changes to access data. Events
+// are written with the same transaction as the change they describe, so a
+// change is never committed without its record.
+package audit
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"main/utils"
+)
+
+const (
+	ActionCreate  = "create"
+	ActionUpdate  = "update"
+	ActionDelete  = "delete"
+	ActionExpire  = "expire"
+	ActionRestore = "restore"
+	ActionPurge   = "purge"
+)
+
+const (
+	EntityRole           = "role"
+	EntityUserRole       = "user_role"
+	EntityPermission     = "permission"
+	EntityRolePermission = "role_permission"
+	EntityGroup          = "group"
+	EntityGroupMember    = "group_member"
+	EntityServiceAccount = "service_account"
+	EntityCredential     = "service_account_credential"
+)
+
+type Event struct {
+	TenantID   string
+	Actor      string
+	Action     string
+	EntityType string
+	EntityID   int
+	Before     interface{}
+	After      interface{}
+	RequestID  string
+}
+
+// NewEvent builds an event attributed to the tenant, actor and request in ctx.
+func NewEvent(ctx context.Context, action, entityType string, entityID int, before, after interface{}) Event {
+	return Event{
+		TenantID:   utils.TenantFromContext(ctx),
+		Actor:      utils.ActorFromContext(ctx),
+		Action:     action,
+		EntityType: entityType,
+		EntityID:   entityID,
+		Before:     before,
+		After:      after,
+		RequestID:  utils.RequestIDFromContext(ctx),
+	}
+}
+
+// Record appends event within tx.
+func Record(ctx context.Context, tx *sql.Tx, event Event) error {
+	before, err := marshal(event.Before)
+	if err != nil {
+		return err
+	}
+	after, err := marshal(event.After)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO audit_events (tenant_id, actor, action, entity_type, entity_id, before, after, request_id)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+		event.TenantID, event.Actor, event.Action, event.EntityType, event.EntityID, before, after, event.RequestID)
+	return err
+}
+
+// marshal encodes state as a JSON string, or nil for a missing state so it
+// is stored as NULL.
+func marshal(state interface{}) (interface{}, error) {
+	if state == nil {
+		return nil, nil
+	}
+	b, err := json.Marshal(state)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
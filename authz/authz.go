@@ -0,0 +1,359 @@
+// Package authz answers "may this principal use this permission?" by
+// resolving the principal's live user roles, and every role they inherit
+// from, through the permissions bound to each role. A principal is an email,
+// a service account or a group. A user role or role permission with a
+// condition only counts when the request context satisfies it. A user role
+// only counts while it is inside its valid_from/valid_until window. A deny
+// user role or role permission overrides every allow: a principal is denied
+// a permission when it holds a deny grant of a role that carries the
+// permission, or holds a role whose closure denies it.
+package authz
+
+import (
+	"context"
+	"errors"
+	models "main/Models"
+	"main/condition"
+	"strconv"
+	"strings"
+)
+
+const (
+	Allow = "allow"
+	Deny  = "deny"
+)
+
+// Assignment is a live user role together with the role it grants and the
+// resource scope it is limited to. Inactive is the reason it does not count
+// now, if any.
+type Assignment struct {
+	UserRoleID   int
+	GroupID      *int
+	RoleID       int
+	RoleKey      string
+	ResourceType string
+	ResourceID   string
+	Condition    string
+	Effect       string
+	Inactive     string
+}
+
+// Binding is a live permission bound to a role.
+type Binding struct {
+	RolePermissionID int
+	RoleID           int
+	PermissionKey    string
+	Condition        string
+	Effect           string
+}
+
+// RoleParent is an edge of the role hierarchy: RoleID inherits from
+// ParentRoleID, whose key is ParentKey.
+type RoleParent struct {
+	RoleID       int
+	ParentRoleID int
+	ParentKey    string
+}
+
+// Source loads what a check evaluates. NewPostgres reads it from the
+// database; the store package keeps another in memory.
+type Source interface {
+	// Assignments returns the live grants of principal that count now or,
+	// with inactive, also those that are expired, not yet valid or of a
+	// deleted role, with Inactive saying which, ordered by user role id.
+	// Inactive grants include those already soft-deleted as expired or along
+	// with their role, until they are purged. A principal of an unknown type
+	// has none.
+	Assignments(ctx context.Context, tenant string, principal models.Principal, inactive bool) ([]Assignment, error)
+	// RoleParents returns the parent edges reachable from roleIDs, following
+	// live roles only, ordered by role and parent id.
+	RoleParents(ctx context.Context, tenant string, roleIDs []int) ([]RoleParent, error)
+	// RolePermissions returns the bindings of live permissions to roleIDs.
+	RolePermissions(ctx context.Context, tenant string, roleIDs []int) ([]Binding, error)
+}
+
+// Check evaluates a single authorization request within tenant.
+func Check(ctx context.Context, src Source, tenant string, req models.AuthorizationRequest) (models.AuthorizationDecision, error) {
+	decisions, err := CheckBatch(ctx, src, tenant, []models.AuthorizationRequest{req})
+	if err != nil {
+		return models.AuthorizationDecision{}, err
+	}
+	return decisions[0], nil
+}
+
+// ErrInvalidPrincipal is returned by ValidatePrincipal.
+var ErrInvalidPrincipal = errors.New(`email, or principal_type "user", "service" or "group" with a principal_id, is required; service and group ids are numeric`)
+
+// ValidatePrincipal checks that req names exactly one principal: an email, or
+// a principal type with an id.
+func ValidatePrincipal(req models.AuthorizationRequest) error {
+	principal := PrincipalOf(req)
+	if principal.ID == "" || (req.PrincipalType != "" && req.Email != "") {
+		return ErrInvalidPrincipal
+	}
+	switch principal.Type {
+	case models.PrincipalUser:
+		return nil
+	case models.PrincipalService, models.PrincipalGroup:
+		if _, err := strconv.Atoi(principal.ID); err != nil {
+			return ErrInvalidPrincipal
+		}
+		return nil
+	}
+	return ErrInvalidPrincipal
+}
+
+// PrincipalOf returns the principal req is about: the user Email unless
+// PrincipalType is set.
+func PrincipalOf(req models.AuthorizationRequest) models.Principal {
+	if req.PrincipalType == "" || req.PrincipalType == models.PrincipalUser {
+		id := req.PrincipalID
+		if id == "" {
+			id = req.Email
+		}
+		return models.Principal{Type: models.PrincipalUser, ID: strings.ToLower(id)}
+	}
+	return models.Principal{Type: req.PrincipalType, ID: req.PrincipalID}
+}
+
+// CheckBatch evaluates several requests within tenant, loading each distinct
+// principal's assignments and role permissions only once.
+func CheckBatch(ctx context.Context, src Source, tenant string, reqs []models.AuthorizationRequest) ([]models.AuthorizationDecision, error) {
+	assignmentsByPrincipal := map[models.Principal][]Assignment{}
+	all := []Assignment{}
+	for _, req := range reqs {
+		principal := PrincipalOf(req)
+		if _, ok := assignmentsByPrincipal[principal]; ok {
+			continue
+		}
+		assignments, err := src.Assignments(ctx, tenant, principal, false)
+		if err != nil {
+			return nil, err
+		}
+		assignmentsByPrincipal[principal] = assignments
+		all = append(all, assignments...)
+	}
+	graph, permissionsByRole, err := loadRoles(ctx, src, tenant, all)
+	if err != nil {
+		return nil, err
+	}
+
+	conditions := conditions{}
+	decisions := make([]models.AuthorizationDecision, 0, len(reqs))
+	for _, req := range reqs {
+		decisions = append(decisions, evaluate(req, assignmentsByPrincipal[PrincipalOf(req)], graph, permissionsByRole, conditions, nil))
+	}
+	return decisions, nil
+}
+
+// loadRoles loads the roles the active assignments grant, every role they
+// inherit from, and the permissions bound to all of them.
+func loadRoles(ctx context.Context, src Source, tenant string, assignments []Assignment) (roleGraph, map[int]map[string]Binding, error) {
+	graph := roleGraph{parents: map[int][]int{}, keys: map[int]string{}}
+	permissionsByRole := map[int]map[string]Binding{}
+	roleIDs := []int{}
+	seen := map[int]bool{}
+	for _, a := range assignments {
+		if a.Inactive == "" && !seen[a.RoleID] {
+			seen[a.RoleID] = true
+			roleIDs = append(roleIDs, a.RoleID)
+		}
+	}
+	if len(roleIDs) == 0 {
+		return graph, permissionsByRole, nil
+	}
+
+	edges, err := src.RoleParents(ctx, tenant, roleIDs)
+	if err != nil {
+		return graph, nil, err
+	}
+	for _, edge := range edges {
+		graph.parents[edge.RoleID] = append(graph.parents[edge.RoleID], edge.ParentRoleID)
+		graph.keys[edge.ParentRoleID] = edge.ParentKey
+		if !seen[edge.ParentRoleID] {
+			seen[edge.ParentRoleID] = true
+			roleIDs = append(roleIDs, edge.ParentRoleID)
+		}
+	}
+
+	bindings, err := src.RolePermissions(ctx, tenant, roleIDs)
+	if err != nil {
+		return graph, nil, err
+	}
+	for _, b := range bindings {
+		if permissionsByRole[b.RoleID] == nil {
+			permissionsByRole[b.RoleID] = map[string]Binding{}
+		}
+		permissionsByRole[b.RoleID][b.PermissionKey] = b
+	}
+	return graph, permissionsByRole, nil
+}
+
+// conditions caches parsed binding conditions by source, so a batch parses
+// each only once.
+type conditions map[string]*condition.Expr
+
+// errUnparsable is returned by conditions.eval for a condition stored before
+// it would have passed validation.
+var errUnparsable = errors.New("condition does not parse")
+
+// eval evaluates source in vars. An empty condition holds.
+func (c conditions) eval(source string, vars map[string]interface{}) (bool, error) {
+	if source == "" {
+		return true, nil
+	}
+	expr, ok := c[source]
+	if !ok {
+		expr, _ = condition.Parse(source)
+		c[source] = expr
+	}
+	if expr == nil {
+		return false, errUnparsable
+	}
+	return expr.Eval(vars)
+}
+
+// test reports whether the condition source of a binding counts in vars and,
+// when it could not be evaluated, e.g. because vars lack an attribute it
+// reads, why not. Such a condition does not hold for an allow binding, but
+// does for a deny binding, so that denies fail closed.
+func (c conditions) test(source string, vars map[string]interface{}, effect string) (bool, string) {
+	result, err := c.eval(source, vars)
+	if err != nil {
+		return effect == models.EffectDeny, err.Error()
+	}
+	return result, ""
+}
+
+// appendCondition appends source to sources unless it is empty.
+func appendCondition(sources []string, source string) []string {
+	if source == "" {
+		return sources
+	}
+	return append(sources, source)
+}
+
+// evaluate decides req from the principal's assignments. Any deny rule that
+// fires wins; the allows it overrode are still reported in MatchedRoles.
+// Each assignment considered is recorded in trace, which may be nil.
+func evaluate(req models.AuthorizationRequest, assignments []Assignment, graph roleGraph, permissionsByRole map[int]map[string]Binding, conditions conditions, trace *trace) models.AuthorizationDecision {
+	decision := models.AuthorizationDecision{
+		Email:         req.Email,
+		PrincipalType: req.PrincipalType,
+		PrincipalID:   req.PrincipalID,
+		Permission:    req.Permission,
+		Resource:      req.Resource,
+		Decision:      Deny,
+		MatchedRoles:  []models.MatchedRole{},
+	}
+	for _, a := range assignments {
+		grant := a.evaluated()
+		switch holds, detail := conditions.test(a.Condition, req.Context, a.Effect); {
+		case a.Inactive != "":
+			skip(&grant, a.Inactive, "")
+		case !ScopeMatches(a.ResourceType, a.ResourceID, req.Resource):
+			skip(&grant, SkipScopeMismatch, "")
+		case !holds:
+			skip(&grant, SkipConditionFailed, detail)
+		case a.Effect == models.EffectDeny:
+			grant.Detail = detail
+			if rule, ok := denyGrant(req, a, graph, permissionsByRole); ok {
+				decision.DeniedBy = append(decision.DeniedBy, rule)
+				grant.Outcome, grant.Path = OutcomeDenied, rule.Path
+			}
+		default:
+			grant.Outcome = allowGrant(req, a, graph, permissionsByRole, conditions, &decision, &grant)
+		}
+		trace.record(grant)
+	}
+	switch {
+	case len(decision.DeniedBy) > 0:
+	case len(decision.MatchedRoles) > 0:
+		decision.Allowed = true
+		decision.Decision = Allow
+	}
+	return decision
+}
+
+// allowGrant walks the closure of an allow assignment that applies to req,
+// adding the deny rules it reaches to decision, and the first allow binding
+// that holds to its matched roles. The role permissions for req.Permission
+// are recorded on grant. It returns the outcome for the assignment: denied
+// if a deny rule fired, else matched if an allow binding held.
+func allowGrant(req models.AuthorizationRequest, a Assignment, graph roleGraph, permissionsByRole map[int]map[string]Binding, conditions conditions, decision *models.AuthorizationDecision, grant *models.EvaluatedBinding) string {
+	matched, denied := false, false
+	for _, inherited := range graph.closure(a.RoleID, a.RoleKey) {
+		b, bound := permissionsByRole[inherited.RoleID][req.Permission]
+		if !bound {
+			continue
+		}
+		step := b.evaluated(a, inherited)
+		holds, detail := conditions.test(b.Condition, req.Context, b.Effect)
+		switch {
+		case !holds:
+			skip(&step, SkipConditionFailed, detail)
+		case b.Effect == models.EffectDeny:
+			denied = true
+			step.Outcome, step.Detail = OutcomeDenied, detail
+			decision.DeniedBy = append(decision.DeniedBy, models.DenyRule{
+				Source:           DenySourceRolePermission,
+				UserRoleID:       a.UserRoleID,
+				RolePermissionID: b.RolePermissionID,
+				RoleID:           inherited.RoleID,
+				RoleKey:          step.RoleKey,
+				Permission:       req.Permission,
+				Path:             inherited.Path,
+				Condition:        b.Condition,
+			})
+		default:
+			step.Outcome = OutcomeMatched
+			if !matched {
+				matched = true
+				decision.MatchedRoles = append(decision.MatchedRoles, models.MatchedRole{
+					UserRoleID:   a.UserRoleID,
+					RoleID:       a.RoleID,
+					RoleKey:      a.RoleKey,
+					ResourceType: a.ResourceType,
+					ResourceID:   a.ResourceID,
+					Path:         inherited.Path,
+					Conditions:   appendCondition(appendCondition(nil, a.Condition), b.Condition),
+				})
+			}
+		}
+		grant.RolePermissions = append(grant.RolePermissions, step)
+	}
+	switch {
+	case denied:
+		return OutcomeDenied
+	case matched:
+		return OutcomeMatched
+	}
+	return OutcomeNoMatch
+}
+
+// Kinds of binding: the sources of a models.DenyRule and the kinds of a
+// models.EvaluatedBinding.
+const (
+	DenySourceUserRole       = "user_role"
+	DenySourceRolePermission = "role_permission"
+)
+
+// denyGrant returns the rule a deny assignment that applies to req fires
+// when its role, or a role it inherits from, allows req.Permission. The
+// permission's own condition is not consulted.
+func denyGrant(req models.AuthorizationRequest, a Assignment, graph roleGraph, permissionsByRole map[int]map[string]Binding) (models.DenyRule, bool) {
+	for _, inherited := range graph.closure(a.RoleID, a.RoleKey) {
+		if b, bound := permissionsByRole[inherited.RoleID][req.Permission]; bound && b.Effect != models.EffectDeny {
+			return models.DenyRule{
+				Source:     DenySourceUserRole,
+				UserRoleID: a.UserRoleID,
+				RoleID:     a.RoleID,
+				RoleKey:    a.RoleKey,
+				Permission: req.Permission,
+				Path:       inherited.Path,
+				Condition:  a.Condition,
+			}, true
+		}
+	}
+	return models.DenyRule{}, false
+}
@@ -0,0 +1,317 @@
+package authz
+
+import (
+	"context"
+	models "main/Models"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckBatch(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	assignmentColumns := []string{"id", "group_id", "id", "role_key", "resource_type", "resource_id", "condition", "effect", "inactive"}
+	mock.ExpectQuery(`SELECT user_roles.id, user_roles.group_id, roles.id, .* FROM user_roles`).
+		WithArgs("default", "alice@example.com").
+		WillReturnRows(sqlmock.NewRows(assignmentColumns).
+			AddRow(1, nil, 10, "approver", "", "", "", "allow", "").
+			AddRow(2, nil, 11, "viewer", "", "", "", "allow", ""))
+	mock.ExpectQuery(`SELECT user_roles.id, user_roles.group_id, roles.id, .* FROM user_roles`).
+		WithArgs("default", "bob@example.com").
+		WillReturnRows(sqlmock.NewRows(assignmentColumns).
+			AddRow(3, nil, 11, "viewer", "project", "team-a/*", "", "allow", ""))
+	mock.ExpectQuery(`WITH RECURSIVE edges`).
+		WillReturnRows(sqlmock.NewRows([]string{"role_id", "parent_role_id", "role_key"}).
+			AddRow(10, 12, "clerk"))
+	mock.ExpectQuery(`SELECT role_permissions.id, role_permissions.role_id, permissions.permission_key, role_permissions.condition, role_permissions.effect FROM role_permissions`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "role_id", "permission_key", "condition", "effect"}).
+			AddRow(1, 10, "invoice:approve", "", "allow").
+			AddRow(2, 10, "invoice:read", "", "allow").
+			AddRow(3, 11, "invoice:read", "", "allow").
+			AddRow(4, 12, "invoice:print", "", "allow"))
+
+	decisions, err := CheckBatch(context.Background(), NewPostgres(db), "default", []models.AuthorizationRequest{
+		{Email: "alice@example.com", Permission: "invoice:approve"},
+		{Email: "alice@example.com", Permission: "invoice:read"},
+		{Email: "alice@example.com", Permission: "invoice:delete"},
+		{Email: "alice@example.com", Permission: "invoice:print"},
+		{Email: "bob@example.com", Permission: "invoice:read"},
+		{Email: "bob@example.com", Permission: "invoice:read", Resource: "project:team-a/42"},
+		{Email: "bob@example.com", Permission: "invoice:read", Resource: "project:team-b/42"},
+	})
+	assert.NoError(t, err)
+	assert.Len(t, decisions, 7)
+
+	assert.True(t, decisions[0].Allowed)
+	assert.Equal(t, Allow, decisions[0].Decision)
+	assert.Equal(t, []models.MatchedRole{{UserRoleID: 1, RoleID: 10, RoleKey: "approver", Path: []string{"approver"}}}, decisions[0].MatchedRoles)
+
+	assert.True(t, decisions[1].Allowed)
+	assert.Len(t, decisions[1].MatchedRoles, 2)
+
+	assert.False(t, decisions[2].Allowed)
+	assert.Equal(t, Deny, decisions[2].Decision)
+	assert.Empty(t, decisions[2].MatchedRoles)
+
+	assert.True(t, decisions[3].Allowed)
+	assert.Equal(t, []string{"approver", "clerk"}, decisions[3].MatchedRoles[0].Path)
+
+	assert.False(t, decisions[4].Allowed, "scoped assignments do not grant global access")
+	assert.True(t, decisions[5].Allowed)
+	assert.Equal(t, "team-a/*", decisions[5].MatchedRoles[0].ResourceID)
+	assert.False(t, decisions[6].Allowed)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCheckServiceAccount(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectQuery(`FROM user_roles .* user_roles.service_account_id = \$2::int`).
+		WithArgs("default", "7").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "group_id", "id", "role_key", "resource_type", "resource_id", "condition", "effect", "inactive"}).
+			AddRow(4, nil, 10, "deployer", "", "", "", "allow", ""))
+	mock.ExpectQuery(`WITH RECURSIVE edges`).
+		WillReturnRows(sqlmock.NewRows([]string{"role_id", "parent_role_id", "role_key"}))
+	mock.ExpectQuery(`SELECT role_permissions.id, role_permissions.role_id, permissions.permission_key, role_permissions.condition, role_permissions.effect FROM role_permissions`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "role_id", "permission_key", "condition", "effect"}).AddRow(5, 10, "deploy:run", "", "allow"))
+
+	decision, err := Check(context.Background(), NewPostgres(db), "default", models.AuthorizationRequest{PrincipalType: models.PrincipalService, PrincipalID: "7", Permission: "deploy:run"})
+	assert.NoError(t, err)
+	assert.True(t, decision.Allowed)
+	assert.Equal(t, "7", decision.PrincipalID)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCheckConditions(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectQuery(`FROM user_roles`).
+		WithArgs("default", "alice@example.com").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "group_id", "id", "role_key", "resource_type", "resource_id", "condition", "effect", "inactive"}).
+			AddRow(1, nil, 10, "refunder", "", "", `in_cidr(ip, "10.0.0.0/8")`, "allow", ""))
+	mock.ExpectQuery(`WITH RECURSIVE edges`).
+		WillReturnRows(sqlmock.NewRows([]string{"role_id", "parent_role_id", "role_key"}))
+	mock.ExpectQuery(`FROM role_permissions`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "role_id", "permission_key", "condition", "effect"}).
+			AddRow(6, 10, "refund:approve", "amount < 1000", "allow").
+			AddRow(7, 10, "refund:read", "", "allow"))
+
+	check := func(permission string, context map[string]interface{}) models.AuthorizationRequest {
+		return models.AuthorizationRequest{Email: "alice@example.com", Permission: permission, Context: context}
+	}
+	decisions, err := CheckBatch(context.Background(), NewPostgres(db), "default", []models.AuthorizationRequest{
+		check("refund:approve", map[string]interface{}{"amount": 250.0, "ip": "10.1.2.3"}),
+		check("refund:approve", map[string]interface{}{"amount": 5000.0, "ip": "10.1.2.3"}),
+		check("refund:read", map[string]interface{}{"ip": "192.168.1.1"}),
+		check("refund:read", map[string]interface{}{"ip": "10.1.2.3"}),
+		check("refund:approve", nil),
+	})
+	assert.NoError(t, err)
+	assert.True(t, decisions[0].Allowed)
+	assert.Equal(t, []string{`in_cidr(ip, "10.0.0.0/8")`, "amount < 1000"}, decisions[0].MatchedRoles[0].Conditions)
+	assert.False(t, decisions[1].Allowed, "the role permission's condition fails")
+	assert.False(t, decisions[2].Allowed, "the user role's condition fails")
+	assert.True(t, decisions[3].Allowed)
+	assert.Equal(t, []string{`in_cidr(ip, "10.0.0.0/8")`}, decisions[3].MatchedRoles[0].Conditions)
+	assert.False(t, decisions[4].Allowed, "conditions fail closed without context")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCheckDeny(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	// Alice is a billing admin, but also holds contractor, which inherits a
+	// deny of billing:export from restricted. Bob's admin grant is offset by
+	// a deny grant of the same role while he is off the VPN.
+	mock.ExpectQuery(`FROM user_roles`).
+		WithArgs("default", "alice@example.com").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "group_id", "id", "role_key", "resource_type", "resource_id", "condition", "effect", "inactive"}).
+			AddRow(1, nil, 10, "billing-admin", "", "", "", "allow", "").
+			AddRow(2, nil, 11, "contractor", "", "", "", "allow", ""))
+	mock.ExpectQuery(`FROM user_roles`).
+		WithArgs("default", "bob@example.com").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "group_id", "id", "role_key", "resource_type", "resource_id", "condition", "effect", "inactive"}).
+			AddRow(3, nil, 10, "billing-admin", "", "", "", "allow", "").
+			AddRow(4, nil, 10, "billing-admin", "", "", `!in_cidr(ip, "10.0.0.0/8")`, "deny", ""))
+	mock.ExpectQuery(`WITH RECURSIVE edges`).
+		WillReturnRows(sqlmock.NewRows([]string{"role_id", "parent_role_id", "role_key"}).
+			AddRow(11, 12, "restricted"))
+	mock.ExpectQuery(`FROM role_permissions`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "role_id", "permission_key", "condition", "effect"}).
+			AddRow(1, 10, "billing:export", "", "allow").
+			AddRow(2, 10, "billing:read", "", "allow").
+			AddRow(3, 11, "billing:read", "", "allow").
+			AddRow(4, 12, "billing:export", "", "deny"))
+
+	vpn := map[string]interface{}{"ip": "10.1.2.3"}
+	decisions, err := CheckBatch(context.Background(), NewPostgres(db), "default", []models.AuthorizationRequest{
+		{Email: "alice@example.com", Permission: "billing:export"},
+		{Email: "alice@example.com", Permission: "billing:read"},
+		{Email: "bob@example.com", Permission: "billing:read", Context: vpn},
+		{Email: "bob@example.com", Permission: "billing:read", Context: map[string]interface{}{"ip": "192.168.1.1"}},
+		{Email: "bob@example.com", Permission: "billing:read"},
+	})
+	assert.NoError(t, err)
+
+	assert.False(t, decisions[0].Allowed)
+	assert.Equal(t, Deny, decisions[0].Decision)
+	assert.Len(t, decisions[0].MatchedRoles, 1, "the overridden allow is still reported")
+	assert.Equal(t, []models.DenyRule{{
+		Source: DenySourceRolePermission, UserRoleID: 2, RolePermissionID: 4, RoleID: 12, RoleKey: "restricted",
+		Permission: "billing:export", Path: []string{"contractor", "restricted"},
+	}}, decisions[0].DeniedBy)
+	assert.True(t, decisions[1].Allowed, "the deny only covers billing:export")
+	assert.Empty(t, decisions[1].DeniedBy)
+
+	assert.True(t, decisions[2].Allowed)
+	assert.False(t, decisions[3].Allowed)
+	assert.Equal(t, []models.DenyRule{{
+		Source: DenySourceUserRole, UserRoleID: 4, RoleID: 10, RoleKey: "billing-admin",
+		Permission: "billing:read", Path: []string{"billing-admin"}, Condition: `!in_cidr(ip, "10.0.0.0/8")`,
+	}}, decisions[3].DeniedBy)
+	assert.False(t, decisions[4].Allowed, "deny conditions fail closed without context")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestExplain(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	groupID := 7
+	mock.ExpectQuery(`FROM user_roles .* AND \(user_roles.deleted_at IS NULL OR user_roles.deleted_reason IN \('expired', 'role_deleted'\)\) ORDER BY user_roles.id`).
+		WithArgs("default", "alice@example.com").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "group_id", "id", "role_key", "resource_type", "resource_id", "condition", "effect", "inactive"}).
+			AddRow(1, nil, 10, "billing-admin", "", "", "", "allow", "").
+			AddRow(2, nil, 15, "auditor", "", "", "", "allow", "expired").
+			AddRow(3, nil, 16, "legacy", "", "", "", "allow", "role_deleted").
+			AddRow(4, nil, 13, "viewer", "project", "team-a/*", "", "allow", "").
+			AddRow(5, nil, 14, "approver", "", "", "amount < 100", "allow", "").
+			AddRow(6, groupID, 11, "contractor", "", "", "", "allow", ""))
+	mock.ExpectQuery(`WITH RECURSIVE edges`).
+		WillReturnRows(sqlmock.NewRows([]string{"role_id", "parent_role_id", "role_key"}).
+			AddRow(11, 12, "restricted"))
+	mock.ExpectQuery(`FROM role_permissions`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "role_id", "permission_key", "condition", "effect"}).
+			AddRow(1, 10, "billing:export", "", "allow").
+			AddRow(2, 12, "billing:export", "", "deny"))
+
+	explanation, err := Explain(context.Background(), NewPostgres(db), "default", models.AuthorizationRequest{
+		Email: "alice@example.com", Permission: "billing:export", Context: map[string]interface{}{"amount": 500.0},
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	assert.False(t, explanation.Result.Allowed)
+	assert.Equal(t, models.Principal{Type: models.PrincipalUser, ID: "alice@example.com"}, explanation.Principal)
+
+	roleKeys := []string{}
+	for _, role := range explanation.Roles {
+		roleKeys = append(roleKeys, role.RoleKey)
+	}
+	assert.Equal(t, []string{"billing-admin", "viewer", "approver", "contractor", "restricted"}, roleKeys)
+	assert.True(t, explanation.Roles[4].Inherited)
+	assert.Equal(t, &groupID, explanation.Roles[4].GroupID)
+
+	outcomes := []string{}
+	for _, binding := range explanation.Bindings {
+		outcomes = append(outcomes, binding.Outcome+" "+binding.Reason)
+	}
+	assert.Equal(t, []string{
+		"matched ", "skipped expired", "skipped role_deleted", "skipped scope_mismatch", "skipped condition_failed", "denied ",
+	}, outcomes)
+	assert.Equal(t, []models.EvaluatedBinding{{
+		Kind: DenySourceRolePermission, UserRoleID: 6, RolePermissionID: 2, RoleID: 12, RoleKey: "restricted",
+		Path: []string{"contractor", "restricted"}, Effect: "deny", Outcome: OutcomeDenied,
+	}}, explanation.Bindings[5].RolePermissions)
+
+	assert.Equal(t, RuleDeny, explanation.DecidedBy.Rule)
+	assert.Equal(t, Deny, explanation.DecidedBy.Effect)
+	assert.Equal(t, 2, explanation.DecidedBy.DenyRule.RolePermissionID)
+	assert.Equal(t, "billing:export is denied by role permission 2 on role restricted, reached from user role 6 through contractor > restricted",
+		explanation.DecidedBy.Summary)
+}
+
+func TestExplainMissingAttribute(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectQuery(`FROM user_roles`).
+		WithArgs("default", "alice@example.com").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "group_id", "id", "role_key", "resource_type", "resource_id", "condition", "effect", "inactive"}).
+			AddRow(1, nil, 10, "approver", "", "", "amount < 100", "allow", ""))
+	mock.ExpectQuery(`WITH RECURSIVE edges`).
+		WillReturnRows(sqlmock.NewRows([]string{"role_id", "parent_role_id", "role_key"}))
+	mock.ExpectQuery(`FROM role_permissions`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "role_id", "permission_key", "condition", "effect"}).
+			AddRow(1, 10, "refund:approve", "", "allow"))
+
+	explanation, err := Explain(context.Background(), NewPostgres(db), "default", models.AuthorizationRequest{Email: "alice@example.com", Permission: "refund:approve"})
+	assert.NoError(t, err)
+	assert.Equal(t, SkipConditionFailed, explanation.Bindings[0].Reason)
+	assert.Equal(t, "attribute is not in the request context: amount", explanation.Bindings[0].Detail)
+	assert.Equal(t, RuleDefaultDeny, explanation.DecidedBy.Rule)
+	assert.Nil(t, explanation.DecidedBy.MatchedRole)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestValidatePrincipal(t *testing.T) {
+	valid := []models.AuthorizationRequest{
+		{Email: "jane@example.com"},
+		{PrincipalType: models.PrincipalUser, PrincipalID: "jane@example.com"},
+		{PrincipalType: models.PrincipalService, PrincipalID: "7"},
+		{PrincipalType: models.PrincipalGroup, PrincipalID: "3"},
+	}
+	for _, req := range valid {
+		assert.NoError(t, ValidatePrincipal(req), req)
+	}
+	invalid := []models.AuthorizationRequest{
+		{},
+		{PrincipalType: models.PrincipalService},
+		{PrincipalType: models.PrincipalService, PrincipalID: "ci-bot"},
+		{PrincipalType: "robot", PrincipalID: "7"},
+		{Email: "jane@example.com", PrincipalType: models.PrincipalGroup, PrincipalID: "3"},
+	}
+	for _, req := range invalid {
+		assert.Equal(t, ErrInvalidPrincipal, ValidatePrincipal(req), req)
+	}
+}
+
+func TestScopeMatches(t *testing.T) {
+	testCases := []struct {
+		name         string
+		resourceType string
+		resourceID   string
+		resource     string
+		expected     bool
+	}{
+		{name: "global without resource", expected: true},
+		{name: "global with resource", resource: "project:42", expected: true},
+		{name: "scoped without resource", resourceType: "project", resourceID: "42", expected: false},
+		{name: "exact match", resourceType: "project", resourceID: "42", resource: "project:42", expected: true},
+		{name: "exact mismatch", resourceType: "project", resourceID: "42", resource: "project:43", expected: false},
+		{name: "type mismatch", resourceType: "project", resourceID: "42", resource: "invoice:42", expected: false},
+		{name: "wildcard", resourceType: "project", resourceID: "*", resource: "project:7", expected: true},
+		{name: "prefix match", resourceType: "project", resourceID: "team-a/*", resource: "project:team-a/7", expected: true},
+		{name: "prefix mismatch", resourceType: "project", resourceID: "team-a/*", resource: "project:team-b/7", expected: false},
+		{name: "malformed resource", resourceType: "project", resourceID: "*", resource: "project", expected: false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, ScopeMatches(tc.resourceType, tc.resourceID, tc.resource))
+		})
+	}
+}
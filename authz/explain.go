@@ -0,0 +1,159 @@
+package authz
+
+import (
+	"context"
+	"fmt"
+	models "main/Models"
+	"strings"
+)
+
+// Outcomes of a models.EvaluatedBinding.
+const (
+	OutcomeMatched = "matched"
+	OutcomeDenied  = "denied"
+	OutcomeSkipped = "skipped"
+	OutcomeNoMatch = "no_match"
+)
+
+// Reasons a binding was skipped.
+const (
+	SkipExpired         = "expired"
+	SkipNotYetValid     = "not_yet_valid"
+	SkipRoleDeleted     = "role_deleted"
+	SkipScopeMismatch   = "scope_mismatch"
+	SkipConditionFailed = "condition_failed"
+)
+
+// Rules of a models.DecidingRule.
+const (
+	RuleDeny        = "deny_rule"
+	RuleAllow       = "allow_rule"
+	RuleDefaultDeny = "default_deny"
+)
+
+// trace collects the bindings evaluate considers. A nil trace records
+// nothing, which is what checks use.
+type trace struct {
+	bindings []models.EvaluatedBinding
+}
+
+func (t *trace) record(binding models.EvaluatedBinding) {
+	if t != nil {
+		t.bindings = append(t.bindings, binding)
+	}
+}
+
+// evaluated describes the user role a as a binding that has not matched.
+func (a Assignment) evaluated() models.EvaluatedBinding {
+	return models.EvaluatedBinding{
+		Kind:         DenySourceUserRole,
+		UserRoleID:   a.UserRoleID,
+		GroupID:      a.GroupID,
+		RoleID:       a.RoleID,
+		RoleKey:      a.RoleKey,
+		Effect:       a.Effect,
+		ResourceType: a.ResourceType,
+		ResourceID:   a.ResourceID,
+		Condition:    a.Condition,
+		Outcome:      OutcomeNoMatch,
+	}
+}
+
+// evaluated describes b, reached from the user role a along inherited, as a
+// binding that has not matched.
+func (b Binding) evaluated(a Assignment, inherited inheritedRole) models.EvaluatedBinding {
+	return models.EvaluatedBinding{
+		Kind:             DenySourceRolePermission,
+		UserRoleID:       a.UserRoleID,
+		RolePermissionID: b.RolePermissionID,
+		RoleID:           inherited.RoleID,
+		RoleKey:          inherited.Path[len(inherited.Path)-1],
+		Path:             inherited.Path,
+		Effect:           b.Effect,
+		Condition:        b.Condition,
+		Outcome:          OutcomeNoMatch,
+	}
+}
+
+// skip marks binding as skipped for reason.
+func skip(binding *models.EvaluatedBinding, reason, detail string) {
+	binding.Outcome, binding.Reason, binding.Detail = OutcomeSkipped, reason, detail
+}
+
+// Explain evaluates req like Check, but also returns the trace of how it was
+// decided. Unlike Check, it loads grants that do not count now, so the trace
+// can say why they were skipped.
+func Explain(ctx context.Context, src Source, tenant string, req models.AuthorizationRequest) (models.AuthorizationExplanation, error) {
+	principal := PrincipalOf(req)
+	assignments, err := src.Assignments(ctx, tenant, principal, true)
+	if err != nil {
+		return models.AuthorizationExplanation{}, err
+	}
+	graph, permissionsByRole, err := loadRoles(ctx, src, tenant, assignments)
+	if err != nil {
+		return models.AuthorizationExplanation{}, err
+	}
+
+	trace := &trace{bindings: []models.EvaluatedBinding{}}
+	decision := evaluate(req, assignments, graph, permissionsByRole, conditions{}, trace)
+	return models.AuthorizationExplanation{
+		Result:    decision,
+		Principal: principal,
+		Roles:     heldRoles(assignments, graph),
+		Bindings:  trace.bindings,
+		DecidedBy: decidingRule(decision),
+	}, nil
+}
+
+// heldRoles lists the roles the active allow assignments grant, each
+// followed by the roles it inherits from.
+func heldRoles(assignments []Assignment, graph roleGraph) []models.HeldRole {
+	roles := []models.HeldRole{}
+	for _, a := range assignments {
+		if a.Inactive != "" || a.Effect == models.EffectDeny {
+			continue
+		}
+		for _, inherited := range graph.closure(a.RoleID, a.RoleKey) {
+			roles = append(roles, models.HeldRole{
+				UserRoleID:   a.UserRoleID,
+				GroupID:      a.GroupID,
+				RoleID:       inherited.RoleID,
+				RoleKey:      inherited.Path[len(inherited.Path)-1],
+				Inherited:    inherited.RoleID != a.RoleID,
+				Path:         inherited.Path,
+				ResourceType: a.ResourceType,
+				ResourceID:   a.ResourceID,
+			})
+		}
+	}
+	return roles
+}
+
+// decidingRule names the rule that decided decision.
+func decidingRule(decision models.AuthorizationDecision) models.DecidingRule {
+	if len(decision.DeniedBy) > 0 {
+		rule := decision.DeniedBy[0]
+		summary := fmt.Sprintf("%s is denied by role permission %d on role %s, reached from user role %d through %s",
+			rule.Permission, rule.RolePermissionID, rule.RoleKey, rule.UserRoleID, strings.Join(rule.Path, " > "))
+		if rule.Source == DenySourceUserRole {
+			summary = fmt.Sprintf("%s is denied by user role %d, a deny grant of role %s, which carries it through %s",
+				rule.Permission, rule.UserRoleID, rule.RoleKey, strings.Join(rule.Path, " > "))
+		}
+		return models.DecidingRule{Effect: Deny, Rule: RuleDeny, Summary: summary, DenyRule: &rule}
+	}
+	if len(decision.MatchedRoles) > 0 {
+		matched := decision.MatchedRoles[0]
+		return models.DecidingRule{
+			Effect: Allow,
+			Rule:   RuleAllow,
+			Summary: fmt.Sprintf("%s is allowed by user role %d, a grant of role %s, which carries it through %s",
+				decision.Permission, matched.UserRoleID, matched.RoleKey, strings.Join(matched.Path, " > ")),
+			MatchedRole: &matched,
+		}
+	}
+	return models.DecidingRule{
+		Effect:  Deny,
+		Rule:    RuleDefaultDeny,
+		Summary: fmt.Sprintf("no grant that counts carries %s, so it is denied by default", decision.Permission),
+	}
+}
@@ -0,0 +1,34 @@
+package authz
+
+// roleGraph holds the parent edges reachable from a set of roles, following
+// live roles only so a deleted role also cuts off what it inherited.
+type roleGraph struct {
+	parents map[int][]int
+	keys    map[int]string
+}
+
+// inheritedRole is a role reachable from an assigned role, with the chain of
+// role keys that leads to it.
+type inheritedRole struct {
+	RoleID int
+	Path   []string
+}
+
+// closure walks breadth-first from roleID, so each reachable role is reported
+// once with its shortest inheritance path.
+func (g roleGraph) closure(roleID int, roleKey string) []inheritedRole {
+	result := []inheritedRole{{RoleID: roleID, Path: []string{roleKey}}}
+	visited := map[int]bool{roleID: true}
+	for i := 0; i < len(result); i++ {
+		current := result[i]
+		for _, parentID := range g.parents[current.RoleID] {
+			if visited[parentID] {
+				continue
+			}
+			visited[parentID] = true
+			path := append(append([]string{}, current.Path...), g.keys[parentID])
+			result = append(result, inheritedRole{RoleID: parentID, Path: path})
+		}
+	}
+	return result
+}
@@ -0,0 +1,162 @@
+package authz
+
+import (
+	"context"
+	"database/sql"
+	models "main/Models"
+
+	"github.com/lib/pq"
+)
+
+// Postgres is the Source of checks made against a Postgres database.
+type Postgres struct {
+	db *sql.DB
+}
+
+func NewPostgres(db *sql.DB) *Postgres {
+	return &Postgres{db: db}
+}
+
+// principalConditions selects the grants of each principal type in
+// Assignments: a user's own grants and those of the groups it belongs to, a
+// service account's own grants, and a group's own grants and those of the
+// groups containing it, however deeply nested.
+var principalConditions = map[string]string{
+	models.PrincipalUser: `(user_roles.email = $2 OR user_roles.group_id IN (
+                WITH RECURSIVE member_of(id) AS (
+                    SELECT group_members.group_id FROM group_members
+                    JOIN groups ON groups.id = group_members.group_id AND groups.deleted_at IS NULL
+                    WHERE group_members.tenant_id = $1 AND group_members.email = $2 AND group_members.member_group_id IS NULL
+                    UNION
+                    SELECT group_members.group_id FROM group_members
+                    JOIN member_of ON group_members.member_group_id = member_of.id
+                    JOIN groups ON groups.id = group_members.group_id AND groups.deleted_at IS NULL
+                )
+                SELECT id FROM member_of))`,
+	models.PrincipalService: `user_roles.service_account_id = $2::int`,
+	models.PrincipalGroup: `user_roles.group_id IN (
+                WITH RECURSIVE member_of(id) AS (
+                    SELECT groups.id FROM groups WHERE groups.tenant_id = $1 AND groups.id = $2::int AND groups.deleted_at IS NULL
+                    UNION
+                    SELECT group_members.group_id FROM group_members
+                    JOIN member_of ON group_members.member_group_id = member_of.id
+                    JOIN groups ON groups.id = group_members.group_id AND groups.deleted_at IS NULL
+                )
+                SELECT id FROM member_of)`,
+}
+
+// activeAssignment restricts Assignments to grants that count now.
+const activeAssignment = `
+            AND user_roles.deleted_at IS NULL
+            AND roles.deleted_at IS NULL
+            AND (user_roles.valid_from IS NULL OR user_roles.valid_from <= CURRENT_TIMESTAMP)
+            AND (user_roles.valid_until IS NULL OR user_roles.valid_until > CURRENT_TIMESTAMP)`
+
+// inactiveAssignment restricts Assignments to live grants and to those the
+// sweeper or a cascading role deletion soft-deleted. The purger removes
+// these once the retention window has passed, so every one still present is
+// within it.
+const inactiveAssignment = `
+            AND (user_roles.deleted_at IS NULL OR user_roles.deleted_reason IN ('` + SkipExpired + `', '` + SkipRoleDeleted + `'))`
+
+func (p *Postgres) Assignments(ctx context.Context, tenant string, principal models.Principal, inactive bool) ([]Assignment, error) {
+	condition, ok := principalConditions[principal.Type]
+	if !ok {
+		return []Assignment{}, nil
+	}
+	query := `
+        SELECT user_roles.id, user_roles.group_id, roles.id, roles.role_key, user_roles.resource_type, user_roles.resource_id,
+            user_roles.condition, user_roles.effect,
+            CASE WHEN user_roles.deleted_at IS NOT NULL THEN user_roles.deleted_reason
+                WHEN roles.deleted_at IS NOT NULL THEN 'role_deleted'
+                WHEN user_roles.valid_from > CURRENT_TIMESTAMP THEN 'not_yet_valid'
+                WHEN user_roles.valid_until <= CURRENT_TIMESTAMP THEN 'expired'
+                ELSE '' END
+        FROM user_roles
+        JOIN roles ON user_roles.role_id = roles.id
+        WHERE user_roles.tenant_id = $1
+            AND ` + condition
+	if inactive {
+		query += inactiveAssignment
+	} else {
+		query += activeAssignment
+	}
+	rows, err := p.db.QueryContext(ctx, query+`
+        ORDER BY user_roles.id`, tenant, principal.ID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	assignments := []Assignment{}
+	for rows.Next() {
+		var a Assignment
+		if err := rows.Scan(&a.UserRoleID, &a.GroupID, &a.RoleID, &a.RoleKey, &a.ResourceType, &a.ResourceID, &a.Condition, &a.Effect, &a.Inactive); err != nil {
+			return nil, err
+		}
+		assignments = append(assignments, a)
+	}
+	return assignments, rows.Err()
+}
+
+func (p *Postgres) RolePermissions(ctx context.Context, tenant string, roleIDs []int) ([]Binding, error) {
+	rows, err := p.db.QueryContext(ctx, `
+        SELECT role_permissions.id, role_permissions.role_id, permissions.permission_key, role_permissions.condition, role_permissions.effect
+        FROM role_permissions
+        JOIN permissions ON role_permissions.permission_id = permissions.id
+        WHERE role_permissions.tenant_id = $1 AND role_permissions.role_id = ANY($2)
+            AND permissions.deleted_at IS NULL`, tenant, pq.Array(Int64s(roleIDs)))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	bindings := []Binding{}
+	for rows.Next() {
+		var b Binding
+		if err := rows.Scan(&b.RolePermissionID, &b.RoleID, &b.PermissionKey, &b.Condition, &b.Effect); err != nil {
+			return nil, err
+		}
+		bindings = append(bindings, b)
+	}
+	return bindings, rows.Err()
+}
+
+func (p *Postgres) RoleParents(ctx context.Context, tenant string, roleIDs []int) ([]RoleParent, error) {
+	rows, err := p.db.QueryContext(ctx, `
+        WITH RECURSIVE edges(role_id, parent_role_id) AS (
+            SELECT role_parents.role_id, role_parents.parent_role_id FROM role_parents
+            JOIN roles ON roles.id = role_parents.parent_role_id AND roles.deleted_at IS NULL
+            WHERE role_parents.tenant_id = $1 AND role_parents.role_id = ANY($2)
+            UNION
+            SELECT role_parents.role_id, role_parents.parent_role_id FROM role_parents
+            JOIN edges ON role_parents.role_id = edges.parent_role_id
+            JOIN roles ON roles.id = role_parents.parent_role_id AND roles.deleted_at IS NULL
+        )
+        SELECT edges.role_id, edges.parent_role_id, roles.role_key
+        FROM edges JOIN roles ON roles.id = edges.parent_role_id
+        ORDER BY edges.role_id, edges.parent_role_id`, tenant, pq.Array(Int64s(roleIDs)))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	edges := []RoleParent{}
+	for rows.Next() {
+		var edge RoleParent
+		if err := rows.Scan(&edge.RoleID, &edge.ParentRoleID, &edge.ParentKey); err != nil {
+			return nil, err
+		}
+		edges = append(edges, edge)
+	}
+	return edges, rows.Err()
+}
+
+// Int64s converts ids for pq.Array.
+func Int64s(ids []int) []int64 {
+	converted := make([]int64, 0, len(ids))
+	for _, id := range ids {
+		converted = append(converted, int64(id))
+	}
+	return converted
+}
@@ -0,0 +1,56 @@
+package authz
+
+import (
+	"errors"
+	"strings"
+)
+
+// Wildcard as a scope resource ID matches every resource of the scope's type;
+// a resource ID ending in Wildcard matches every ID with that prefix.
+const Wildcard = "*"
+
+var (
+	ErrInvalidResource = errors.New(`resource must have the form "type:id"`)
+	ErrInvalidScope    = errors.New("resource_type and resource_id must both be set, or both be empty")
+)
+
+// ParseResource splits a "type:id" resource such as "project:42".
+func ParseResource(resource string) (resourceType, resourceID string, err error) {
+	resourceType, resourceID, ok := strings.Cut(resource, ":")
+	if !ok || resourceType == "" || resourceID == "" {
+		return "", "", ErrInvalidResource
+	}
+	return resourceType, resourceID, nil
+}
+
+// ValidateScope checks the scope of an assignment. An empty scope is global.
+func ValidateScope(resourceType, resourceID string) error {
+	if (resourceType == "") != (resourceID == "") {
+		return ErrInvalidScope
+	}
+	if strings.Contains(resourceType, ":") || strings.Contains(resourceType, Wildcard) {
+		return ErrInvalidScope
+	}
+	return nil
+}
+
+// ScopeMatches reports whether an assignment scoped to resourceType and
+// resourceID covers resource. Global assignments cover everything, including
+// requests without a resource; scoped assignments only cover resources of
+// their type whose ID matches exactly, by wildcard, or by prefix.
+func ScopeMatches(resourceType, resourceID, resource string) bool {
+	if resourceType == "" {
+		return true
+	}
+	if resource == "" {
+		return false
+	}
+	reqType, reqID, err := ParseResource(resource)
+	if err != nil || reqType != resourceType {
+		return false
+	}
+	if prefix, ok := strings.CutSuffix(resourceID, Wildcard); ok {
+		return strings.HasPrefix(reqID, prefix)
+	}
+	return reqID == resourceID
+}
@@ -0,0 +1,113 @@
+// Package condition implements the small expression language conditional
+// bindings are written in, such as
+//
+//	amount < 1000 && in_cidr(ip, "10.0.0.0/8")
+//
+// An expression reads the attributes of the request context it is evaluated
+// against and nothing else: it has no loops, assignments or side effects,
+// and its length and nesting are bounded, so evaluating one always
+// terminates quickly. Expressions are type-checked when parsed, as far as
+// their types are known before the context is.
+package condition
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	MaxLength = 1024
+	MaxDepth  = 32
+)
+
+// ErrMissingAttribute is returned by Eval when the expression reads an
+// attribute the context does not have.
+var ErrMissingAttribute = errors.New("attribute is not in the request context")
+
+// Error is a syntax or type error at a byte offset of an expression.
+type Error struct {
+	Pos int
+	Msg string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("at offset %d: %s", e.Pos, e.Msg)
+}
+
+// Type is the type of a value. The type of a context attribute is Dynamic
+// until the expression is evaluated.
+type Type int
+
+const (
+	Dynamic Type = iota
+	Bool
+	Number
+	String
+	List
+)
+
+var typeNames = [...]string{"value", "bool", "number", "string", "list"}
+
+func (t Type) String() string {
+	return typeNames[t]
+}
+
+// Expr is a parsed, type-checked expression.
+type Expr struct {
+	source string
+	root   node
+}
+
+// Parse parses source and checks that it is a bool expression.
+func Parse(source string) (*Expr, error) {
+	if len(source) > MaxLength {
+		return nil, &Error{Pos: MaxLength, Msg: fmt.Sprintf("must be at most %d characters", MaxLength)}
+	}
+	if strings.TrimSpace(source) == "" {
+		return nil, &Error{Pos: 0, Msg: "is empty"}
+	}
+	tokens, err := lex(source)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{tokens: tokens}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, unexpected(t, "expected an operator")
+	}
+	if err := want(root, Bool, 0, "a condition"); err != nil {
+		return nil, err
+	}
+	return &Expr{source: source, root: root}, nil
+}
+
+func (e *Expr) String() string {
+	return e.source
+}
+
+// Eval evaluates the expression against vars, the request context. It fails
+// when an attribute is missing or has a type the expression cannot use.
+func (e *Expr) Eval(vars map[string]interface{}) (bool, error) {
+	value, err := e.root.eval(vars)
+	if err != nil {
+		return false, err
+	}
+	result, ok := value.(bool)
+	if !ok {
+		return false, fmt.Errorf("condition is a %s, not a bool", typeOf(value))
+	}
+	return result, nil
+}
+
+// want fails unless n may be of type t. what names n in the error.
+func want(n node, t Type, pos int, what string) error {
+	if n.typ() != Dynamic && n.typ() != t {
+		return &Error{Pos: pos, Msg: fmt.Sprintf("%s must be a %s, not a %s", what, t, n.typ())}
+	}
+	return nil
+}
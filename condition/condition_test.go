@@ -0,0 +1,109 @@
+package condition
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEval(t *testing.T) {
+	vars := map[string]interface{}{
+		"amount":   float64(250),
+		"currency": "EUR",
+		"ip":       "10.1.2.3",
+		"count":    3,
+		"tags":     []string{"urgent", "refund"},
+		"request":  map[string]interface{}{"method": "POST", "internal": true},
+	}
+
+	testCases := []struct {
+		expr     string
+		expected bool
+	}{
+		{expr: "amount < 1000", expected: true},
+		{expr: "amount >= 1000", expected: false},
+		{expr: "amount <= 250 && amount > 249.5", expected: true},
+		{expr: "-amount < 0", expected: true},
+		{expr: `currency == "EUR" || currency == 'USD'`, expected: true},
+		{expr: `currency in ["USD", "GBP"]`, expected: false},
+		{expr: `in_cidr(ip, "10.0.0.0/8")`, expected: true},
+		{expr: `in_cidr(ip, "192.168.0.0/16")`, expected: false},
+		{expr: `"refund" in tags`, expected: true},
+		{expr: "count == 3", expected: true},
+		{expr: `request.method == "POST" && request.internal`, expected: true},
+		{expr: `!(starts_with(lower(currency), "e")) || amount > 1000`, expected: false},
+		{expr: `"a\"b" != 'a"b'`, expected: false},
+		{expr: "false && missing", expected: false},
+		{expr: "true || missing > 1", expected: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.expr, func(t *testing.T) {
+			expr, err := Parse(tc.expr)
+			require.NoError(t, err)
+			result, err := expr.Eval(vars)
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, result)
+		})
+	}
+}
+
+func TestEvalErrors(t *testing.T) {
+	vars := map[string]interface{}{"amount": "250", "ip": "not-an-ip", "nested": map[string]interface{}{}}
+
+	for _, source := range []string{"missing < 1", "nested.missing", "amount < 1000", `in_cidr(ip, "10.0.0.0/8")`, "amount"} {
+		t.Run(source, func(t *testing.T) {
+			expr, err := Parse(source)
+			require.NoError(t, err)
+			_, err = expr.Eval(vars)
+			assert.Error(t, err)
+		})
+	}
+
+	expr, err := Parse("missing")
+	require.NoError(t, err)
+	_, err = expr.Eval(nil)
+	assert.ErrorIs(t, err, ErrMissingAttribute)
+}
+
+func TestParseErrors(t *testing.T) {
+	testCases := []struct {
+		source string
+		pos    int
+	}{
+		{source: "", pos: 0},
+		{source: "amount <", pos: 8},
+		{source: "amount < 1000 &&", pos: 16},
+		{source: `1 < "1000"`, pos: 2},
+		{source: `amount < "1000"`, pos: -1},
+		{source: "1 + 2", pos: 2},
+		{source: "amount", pos: -1},
+		{source: "42", pos: 0},
+		{source: `"refund" in "tags"`, pos: 9},
+		{source: "[1, 2] == tags", pos: 7},
+		{source: "!amount.x == 1", pos: 10},
+		{source: "0 < amount < 10", pos: 11},
+		{source: "true < false", pos: 5},
+		{source: `"unterminated`, pos: 0},
+		{source: "(amount > 1", pos: 11},
+		{source: "exec(amount)", pos: 0},
+		{source: `in_cidr(ip)`, pos: 0},
+		{source: `in_cidr(ip, "10.0.0.0/33")`, pos: 12},
+		{source: `starts_with(1, "a")`, pos: 12},
+		{source: strings.Repeat("(", MaxDepth) + "true" + strings.Repeat(")", MaxDepth), pos: MaxDepth},
+		{source: strings.Repeat("a", MaxLength+1), pos: MaxLength},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.source, func(t *testing.T) {
+			_, err := Parse(tc.source)
+			if tc.pos < 0 {
+				assert.NoError(t, err)
+				return
+			}
+			var syntaxErr *Error
+			require.ErrorAs(t, err, &syntaxErr)
+			assert.Equal(t, tc.pos, syntaxErr.Pos, syntaxErr.Msg)
+		})
+	}
+}
@@ -0,0 +1,338 @@
+package condition
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// node is a type-checked expression. Values are bool, float64, string or
+// []interface{} of those.
+type node interface {
+	typ() Type
+	eval(vars map[string]interface{}) (interface{}, error)
+}
+
+type literal struct {
+	value interface{}
+}
+
+func (n *literal) typ() Type {
+	return typeOf(n.value)
+}
+
+func (n *literal) eval(map[string]interface{}) (interface{}, error) {
+	return n.value, nil
+}
+
+// attribute reads a context attribute, following dots into nested objects:
+// request.ip is the ip attribute of the request object.
+type attribute struct {
+	path []string
+}
+
+func (n *attribute) typ() Type {
+	return Dynamic
+}
+
+func (n *attribute) eval(vars map[string]interface{}) (interface{}, error) {
+	var value interface{} = vars
+	for _, field := range n.path {
+		object, ok := value.(map[string]interface{})
+		if ok {
+			value, ok = object[field]
+		}
+		if !ok || value == nil {
+			return nil, fmt.Errorf("%w: %s", ErrMissingAttribute, strings.Join(n.path, "."))
+		}
+	}
+	value, err := normalize(value)
+	if err != nil {
+		return nil, fmt.Errorf("%s %w", strings.Join(n.path, "."), err)
+	}
+	return value, nil
+}
+
+type not struct {
+	operand node
+}
+
+func (n *not) typ() Type {
+	return Bool
+}
+
+func (n *not) eval(vars map[string]interface{}) (interface{}, error) {
+	value, err := evalAs(n.operand, Bool, vars)
+	if err != nil {
+		return nil, err
+	}
+	return !value.(bool), nil
+}
+
+type negate struct {
+	operand node
+}
+
+func (n *negate) typ() Type {
+	return Number
+}
+
+func (n *negate) eval(vars map[string]interface{}) (interface{}, error) {
+	value, err := evalAs(n.operand, Number, vars)
+	if err != nil {
+		return nil, err
+	}
+	return -value.(float64), nil
+}
+
+// logical is && or ||. The right operand is only evaluated when the left
+// one does not decide the result.
+type logical struct {
+	and         bool
+	left, right node
+}
+
+func newLogical(and bool, left, right node, pos int) (node, error) {
+	op := "||"
+	if and {
+		op = "&&"
+	}
+	if err := want(left, Bool, pos, "the left operand of "+op); err != nil {
+		return nil, err
+	}
+	if err := want(right, Bool, pos, "the right operand of "+op); err != nil {
+		return nil, err
+	}
+	return &logical{and: and, left: left, right: right}, nil
+}
+
+func (n *logical) typ() Type {
+	return Bool
+}
+
+func (n *logical) eval(vars map[string]interface{}) (interface{}, error) {
+	left, err := evalAs(n.left, Bool, vars)
+	if err != nil {
+		return nil, err
+	}
+	if left.(bool) != n.and {
+		return left, nil
+	}
+	return evalAs(n.right, Bool, vars)
+}
+
+// comparison is ==, != or an ordering of two numbers or two strings.
+type comparison struct {
+	op          string
+	left, right node
+}
+
+func newComparison(op string, left, right node, pos int) (node, error) {
+	l, r := left.typ(), right.typ()
+	switch {
+	case l == List || r == List:
+		return nil, &Error{Pos: pos, Msg: "lists can only be used on the right of in"}
+	case l != Dynamic && r != Dynamic && l != r:
+		return nil, &Error{Pos: pos, Msg: fmt.Sprintf("cannot compare a %s with a %s", l, r)}
+	case op != "==" && op != "!=" && (l == Bool || r == Bool):
+		return nil, &Error{Pos: pos, Msg: op + " cannot compare bools"}
+	}
+	return &comparison{op: op, left: left, right: right}, nil
+}
+
+func (n *comparison) typ() Type {
+	return Bool
+}
+
+func (n *comparison) eval(vars map[string]interface{}) (interface{}, error) {
+	left, err := n.left.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	right, err := n.right.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	l, r := typeOf(left), typeOf(right)
+	if l != r || l == List || (l == Bool && n.op != "==" && n.op != "!=") {
+		return nil, fmt.Errorf("cannot compare a %s with a %s using %s", l, r, n.op)
+	}
+
+	switch n.op {
+	case "==":
+		return left == right, nil
+	case "!=":
+		return left != right, nil
+	}
+	var less bool
+	if l == Number {
+		less = left.(float64) < right.(float64)
+	} else {
+		less = left.(string) < right.(string)
+	}
+	equal := left == right
+	switch n.op {
+	case "<":
+		return less, nil
+	case "<=":
+		return less || equal, nil
+	case ">":
+		return !less && !equal, nil
+	}
+	return !less, nil
+}
+
+// membership is "element in list". Elements of another type never match.
+type membership struct {
+	element, list node
+}
+
+func newMembership(element, list node, pos int) (node, error) {
+	if element.typ() == List {
+		return nil, &Error{Pos: pos, Msg: "the left operand of in cannot be a list"}
+	}
+	if err := want(list, List, pos, "the right operand of in"); err != nil {
+		return nil, err
+	}
+	return &membership{element: element, list: list}, nil
+}
+
+func (n *membership) typ() Type {
+	return Bool
+}
+
+func (n *membership) eval(vars map[string]interface{}) (interface{}, error) {
+	element, err := n.element.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	if typeOf(element) == List {
+		return nil, fmt.Errorf("the left operand of in is a list")
+	}
+	list, err := evalAs(n.list, List, vars)
+	if err != nil {
+		return nil, err
+	}
+	for _, item := range list.([]interface{}) {
+		if item == element {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+type listNode struct {
+	elements []node
+}
+
+func newList(elements []node, pos int) (node, error) {
+	for _, element := range elements {
+		if element.typ() == List {
+			return nil, &Error{Pos: pos, Msg: "lists cannot contain lists"}
+		}
+	}
+	return &listNode{elements: elements}, nil
+}
+
+func (n *listNode) typ() Type {
+	return List
+}
+
+func (n *listNode) eval(vars map[string]interface{}) (interface{}, error) {
+	values := make([]interface{}, 0, len(n.elements))
+	for _, element := range n.elements {
+		value, err := element.eval(vars)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	return values, nil
+}
+
+type call struct {
+	name string
+	fn   function
+	args []node
+}
+
+func (n *call) typ() Type {
+	return n.fn.result
+}
+
+func (n *call) eval(vars map[string]interface{}) (interface{}, error) {
+	args := make([]interface{}, 0, len(n.args))
+	for i, arg := range n.args {
+		value, err := evalAs(arg, n.fn.params[i], vars)
+		if err != nil {
+			return nil, fmt.Errorf("argument %d of %s: %w", i+1, n.name, err)
+		}
+		args = append(args, value)
+	}
+	return n.fn.call(args)
+}
+
+// evalAs evaluates n, failing unless the value is of type t.
+func evalAs(n node, t Type, vars map[string]interface{}) (interface{}, error) {
+	value, err := n.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	if typeOf(value) != t {
+		return nil, fmt.Errorf("expected a %s, got a %s", t, typeOf(value))
+	}
+	return value, nil
+}
+
+func typeOf(value interface{}) Type {
+	switch value.(type) {
+	case bool:
+		return Bool
+	case float64:
+		return Number
+	case string:
+		return String
+	case []interface{}:
+		return List
+	}
+	return Dynamic
+}
+
+// normalize converts a context value to one an expression can use. Go ints
+// and float32s become float64 and []string a list, so contexts built in Go
+// compare like decoded JSON.
+func normalize(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case bool, float64, string:
+		return v, nil
+	case int:
+		return float64(v), nil
+	case int64:
+		return float64(v), nil
+	case float32:
+		return float64(v), nil
+	case json.Number:
+		f, err := v.Float64()
+		if err != nil {
+			return nil, fmt.Errorf("is not a valid number")
+		}
+		return f, nil
+	case []string:
+		items := make([]interface{}, 0, len(v))
+		for _, item := range v {
+			items = append(items, item)
+		}
+		return items, nil
+	case []interface{}:
+		items := make([]interface{}, 0, len(v))
+		for _, item := range v {
+			item, err := normalize(item)
+			if err != nil || typeOf(item) == List {
+				return nil, fmt.Errorf("is a list of something other than bools, numbers and strings")
+			}
+			items = append(items, item)
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("is not a bool, number, string or list")
+}
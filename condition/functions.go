@@ -0,0 +1,54 @@
+package condition
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+)
+
+// function is a built-in function expressions can call.
+type function struct {
+	params []Type
+	result Type
+	call   func(args []interface{}) (interface{}, error)
+	// checkConstant, if set, returns what is wrong with argument i when it
+	// is a literal, so mistakes surface when the expression is parsed.
+	checkConstant func(i int, value interface{}) string
+}
+
+var functions = map[string]function{
+	// in_cidr(ip, "10.0.0.0/8") reports whether an IPv4 or IPv6 address
+	// is in a CIDR range.
+	"in_cidr": {params: []Type{String, String}, result: Bool, call: inCIDR, checkConstant: checkCIDR},
+	"starts_with": {params: []Type{String, String}, result: Bool, call: func(args []interface{}) (interface{}, error) {
+		return strings.HasPrefix(args[0].(string), args[1].(string)), nil
+	}},
+	"ends_with": {params: []Type{String, String}, result: Bool, call: func(args []interface{}) (interface{}, error) {
+		return strings.HasSuffix(args[0].(string), args[1].(string)), nil
+	}},
+	"contains": {params: []Type{String, String}, result: Bool, call: func(args []interface{}) (interface{}, error) {
+		return strings.Contains(args[0].(string), args[1].(string)), nil
+	}},
+	"lower": {params: []Type{String}, result: String, call: func(args []interface{}) (interface{}, error) {
+		return strings.ToLower(args[0].(string)), nil
+	}},
+}
+
+func inCIDR(args []interface{}) (interface{}, error) {
+	addr, err := netip.ParseAddr(args[0].(string))
+	if err != nil {
+		return nil, fmt.Errorf("%q is not an IP address", args[0])
+	}
+	prefix, err := netip.ParsePrefix(args[1].(string))
+	if err != nil {
+		return nil, fmt.Errorf("%q is not a CIDR range", args[1])
+	}
+	return prefix.Contains(addr.Unmap()), nil
+}
+
+func checkCIDR(i int, value interface{}) string {
+	if _, err := netip.ParsePrefix(value.(string)); i == 1 && err != nil {
+		return fmt.Sprintf("%q is not a CIDR range such as 10.0.0.0/8", value)
+	}
+	return ""
+}
@@ -0,0 +1,259 @@
+package condition
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// parser is a recursive descent parser over this grammar, loosest binding
+// first:
+//
+//	or      = and { "||" and }
+//	and     = compare { "&&" compare }
+//	compare = unary [ ( "==" | "!=" | "<" | "<=" | ">" | ">=" | "in" ) unary ]
+//	unary   = ( "!" | "-" ) unary | operand
+//	operand = number | string | "true" | "false" | "[" [ or { "," or } ] "]"
+//	        | name "(" [ or { "," or } ] ")" | name { "." name } | "(" or ")"
+type parser struct {
+	tokens []token
+	pos    int
+	depth  int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+// accept consumes the next token if it is op.
+func (p *parser) accept(op string) bool {
+	if t := p.peek(); t.kind == tokOp && t.text == op {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(op string) error {
+	if !p.accept(op) {
+		return unexpected(p.peek(), "expected "+strconv.Quote(op))
+	}
+	return nil
+}
+
+func unexpected(t token, expected string) error {
+	if t.kind == tokEOF {
+		return &Error{Pos: t.pos, Msg: expected + ", found the end"}
+	}
+	return &Error{Pos: t.pos, Msg: fmt.Sprintf("%s, found %q", expected, t.text)}
+}
+
+// enter bounds how deeply expressions nest. Each call must be paired with a
+// deferred p.leave().
+func (p *parser) enter() error {
+	p.depth++
+	if p.depth > MaxDepth {
+		return &Error{Pos: p.peek().pos, Msg: fmt.Sprintf("must not nest more than %d levels deep", MaxDepth)}
+	}
+	return nil
+}
+
+func (p *parser) leave() {
+	p.depth--
+}
+
+func (p *parser) parseOr() (node, error) {
+	if err := p.enter(); err != nil {
+		return nil, err
+	}
+	defer p.leave()
+
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		pos := p.peek().pos
+		if !p.accept("||") {
+			return left, nil
+		}
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		if left, err = newLogical(false, left, right, pos); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (p *parser) parseAnd() (node, error) {
+	left, err := p.parseCompare()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		pos := p.peek().pos
+		if !p.accept("&&") {
+			return left, nil
+		}
+		right, err := p.parseCompare()
+		if err != nil {
+			return nil, err
+		}
+		if left, err = newLogical(true, left, right, pos); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// comparisonOperators are the operators parseCompare accepts between two
+// operands. Comparisons do not chain: a < b < c is an error.
+var comparisonOperators = map[string]bool{"==": true, "!=": true, "<": true, "<=": true, ">": true, ">=": true}
+
+func (p *parser) parseCompare() (node, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	t := p.peek()
+	isIn := t.kind == tokIdent && t.text == "in"
+	if !isIn && (t.kind != tokOp || !comparisonOperators[t.text]) {
+		return left, nil
+	}
+	p.next()
+	right, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	if next := p.peek(); (next.kind == tokOp && comparisonOperators[next.text]) || (next.kind == tokIdent && next.text == "in") {
+		return nil, &Error{Pos: next.pos, Msg: "comparisons cannot be chained; join them with &&"}
+	}
+	if isIn {
+		return newMembership(left, right, t.pos)
+	}
+	return newComparison(t.text, left, right, t.pos)
+}
+
+func (p *parser) parseUnary() (node, error) {
+	op := p.peek()
+	if !p.accept("!") && !p.accept("-") {
+		return p.parseOperand()
+	}
+	if err := p.enter(); err != nil {
+		return nil, err
+	}
+	defer p.leave()
+
+	pos := p.peek().pos
+	operand, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	if op.text == "!" {
+		if err := want(operand, Bool, pos, "the operand of !"); err != nil {
+			return nil, err
+		}
+		return &not{operand: operand}, nil
+	}
+	if err := want(operand, Number, pos, "the operand of -"); err != nil {
+		return nil, err
+	}
+	return &negate{operand: operand}, nil
+}
+
+func (p *parser) parseOperand() (node, error) {
+	t := p.next()
+	switch {
+	case t.kind == tokNumber:
+		return &literal{value: t.num}, nil
+	case t.kind == tokString:
+		return &literal{value: t.text}, nil
+	case t.kind == tokIdent && (t.text == "true" || t.text == "false"):
+		return &literal{value: t.text == "true"}, nil
+	case t.kind == tokIdent && t.text != "in":
+		if p.accept("(") {
+			return p.parseCall(t)
+		}
+		path := []string{t.text}
+		for p.accept(".") {
+			field := p.next()
+			if field.kind != tokIdent {
+				return nil, unexpected(field, "expected an attribute name")
+			}
+			path = append(path, field.text)
+		}
+		return &attribute{path: path}, nil
+	case t.kind == tokOp && t.text == "(":
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(")"); err != nil {
+			return nil, err
+		}
+		return inner, nil
+	case t.kind == tokOp && t.text == "[":
+		elements, _, err := p.parseList("]")
+		if err != nil {
+			return nil, err
+		}
+		return newList(elements, t.pos)
+	}
+	return nil, unexpected(t, "expected a value")
+}
+
+// parseList parses the comma-separated expressions up to end, returning
+// where each starts.
+func (p *parser) parseList(end string) ([]node, []int, error) {
+	elements, positions := []node{}, []int{}
+	if p.accept(end) {
+		return elements, positions, nil
+	}
+	for {
+		positions = append(positions, p.peek().pos)
+		element, err := p.parseOr()
+		if err != nil {
+			return nil, nil, err
+		}
+		elements = append(elements, element)
+		if p.accept(end) {
+			return elements, positions, nil
+		}
+		if err := p.expect(","); err != nil {
+			return nil, nil, err
+		}
+	}
+}
+
+func (p *parser) parseCall(name token) (node, error) {
+	fn, ok := functions[name.text]
+	if !ok {
+		return nil, &Error{Pos: name.pos, Msg: fmt.Sprintf("unknown function %s", name.text)}
+	}
+	args, positions, err := p.parseList(")")
+	if err != nil {
+		return nil, err
+	}
+	if len(args) != len(fn.params) {
+		return nil, &Error{Pos: name.pos, Msg: fmt.Sprintf("%s takes %d arguments, not %d", name.text, len(fn.params), len(args))}
+	}
+	for i, arg := range args {
+		if err := want(arg, fn.params[i], positions[i], fmt.Sprintf("argument %d of %s", i+1, name.text)); err != nil {
+			return nil, err
+		}
+		if lit, ok := arg.(*literal); ok && fn.checkConstant != nil {
+			if msg := fn.checkConstant(i, lit.value); msg != "" {
+				return nil, &Error{Pos: positions[i], Msg: msg}
+			}
+		}
+	}
+	return &call{name: name.text, fn: fn, args: args}, nil
+}
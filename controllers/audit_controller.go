@@ -0,0 +1,63 @@
+package controllers
+
+import (
+	"encoding/json"
+	models "main/Models"
+	"main/listing"
+	"main/store"
+	"main/utils"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// GetAuditEvents lists one page of the tenant's audit events, newest first
+// unless sort says otherwise. Results are filtered by actor, action,
+// entity_type, entity_id, request_id and a created_at range
+// (created_after/created_before, or from/to, RFC 3339).
+func GetAuditEvents(events store.AuditStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		params := r.URL.Query()
+		page, err := listing.Parse(params, store.AuditEventSortFields, "-id")
+		if err != nil {
+			badRequest(w, r, err.Error())
+			return
+		}
+
+		filter := store.AuditFilter{
+			Actor:      params.Get("actor"),
+			Action:     params.Get("action"),
+			EntityType: params.Get("entity_type"),
+			RequestID:  params.Get("request_id"),
+		}
+		if value := params.Get("entity_id"); value != "" {
+			entityID, err := strconv.Atoi(value)
+			if err != nil {
+				badRequest(w, r, "invalid entity_id")
+				return
+			}
+			filter.EntityID = &entityID
+		}
+		for _, bound := range []struct {
+			param, alias string
+			t            **time.Time
+		}{{"created_after", "from", &filter.CreatedAfter}, {"created_before", "to", &filter.CreatedBefore}} {
+			param := bound.param
+			if params.Get(param) == "" {
+				param = bound.alias
+			}
+			if *bound.t, err = listing.ParseTime(params, param); err != nil {
+				badRequest(w, r, err.Error())
+				return
+			}
+		}
+
+		list, next, err := events.ListAuditEvents(r.Context(), utils.TenantFromContext(r.Context()), filter, page)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		json.NewEncoder(w).Encode(models.AuditEventPage{Events: list, NextCursor: next})
+	}
+}
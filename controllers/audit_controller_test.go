@@ -0,0 +1,110 @@
+package controllers
+
+import (
+	"encoding/json"
+	models "main/Models"
+	"main/listing"
+	"main/store"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetAuditEvents(t *testing.T) {
+	columns := []string{"id", "tenant_id", "actor", "action", "entity_type", "entity_id", "before", "after", "request_id", "created_at"}
+
+	testCases := []struct {
+		name               string
+		url                string
+		expectedCode       int
+		expectedLen        int
+		expectedNextCursor string
+		mockQueries        func(mock sqlmock.Sqlmock)
+	}{
+		{
+			name:               "success - filtered page with more results",
+			url:                "/audit?entity_type=role&entity_id=3&limit=2",
+			expectedCode:       http.StatusOK,
+			expectedLen:        2,
+			expectedNextCursor: listing.Cursor{Sort: "-id", ID: 9}.Encode(),
+			mockQueries: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(`FROM audit_events WHERE tenant_id = \$1 AND entity_type = \$2 AND entity_id = \$3 ORDER BY id DESC LIMIT \$4`).
+					WithArgs("default", "role", 3, 3).
+					WillReturnRows(sqlmock.NewRows(columns).
+						AddRow(10, "default", "anonymous", "update", "role", 3, []byte(`{"id":3}`), []byte(`{"id":3}`), "r1", time.Now()).
+						AddRow(9, "default", "anonymous", "create", "role", 3, nil, []byte(`{"id":3}`), "r0", time.Now()).
+						AddRow(8, "default", "anonymous", "create", "role", 3, nil, []byte(`{"id":3}`), "r0", time.Now()))
+			},
+		},
+		{
+			name:         "success - last page",
+			url:          "/audit?cursor=" + listing.Cursor{Sort: "-id", ID: 9}.Encode(),
+			expectedCode: http.StatusOK,
+			expectedLen:  1,
+			mockQueries: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(`FROM audit_events WHERE tenant_id = \$1 AND id < \$2 ORDER BY id DESC LIMIT \$3`).
+					WithArgs("default", 9, listing.DefaultLimit+1).
+					WillReturnRows(sqlmock.NewRows(columns).
+						AddRow(8, "default", "anonymous", "create", "role", 3, nil, []byte(`{"id":3}`), "r0", time.Now()))
+			},
+		},
+		{
+			name:         "success - oldest first from a date",
+			url:          "/audit?sort=created_at&from=2026-01-01T00:00:00Z",
+			expectedCode: http.StatusOK,
+			expectedLen:  1,
+			mockQueries: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(`FROM audit_events WHERE tenant_id = \$1 AND created_at >= \$2 ORDER BY created_at ASC, id ASC LIMIT \$3`).
+					WithArgs("default", time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC), listing.DefaultLimit+1).
+					WillReturnRows(sqlmock.NewRows(columns).
+						AddRow(8, "default", "anonymous", "create", "role", 3, nil, []byte(`{"id":3}`), "r0", time.Now()))
+			},
+		},
+		{
+			name:         "failure - cursor from another sort",
+			url:          "/audit?sort=created_at&cursor=" + listing.Cursor{Sort: "-id", ID: 9}.Encode(),
+			expectedCode: http.StatusBadRequest,
+			mockQueries:  func(mock sqlmock.Sqlmock) {},
+		},
+		{
+			name:         "failure - sort not allowed",
+			url:          "/audit?sort=actor",
+			expectedCode: http.StatusBadRequest,
+			mockQueries:  func(mock sqlmock.Sqlmock) {},
+		},
+		{
+			name:         "failure - invalid limit",
+			url:          "/audit?limit=0",
+			expectedCode: http.StatusBadRequest,
+			mockQueries:  func(mock sqlmock.Sqlmock) {},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			assert.NoError(t, err)
+			defer db.Close()
+			tc.mockQueries(mock)
+
+			req := httptest.NewRequest("GET", tc.url, nil)
+			w := httptest.NewRecorder()
+
+			handler := GetAuditEvents(store.NewPostgres(db))
+			handler.ServeHTTP(w, req)
+
+			assert.Equal(t, tc.expectedCode, w.Code)
+			if tc.expectedCode == http.StatusOK {
+				var page models.AuditEventPage
+				assert.NoError(t, json.NewDecoder(w.Body).Decode(&page))
+				assert.Len(t, page.Events, tc.expectedLen)
+				assert.Equal(t, tc.expectedNextCursor, page.NextCursor)
+			}
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
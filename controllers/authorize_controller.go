@@ -0,0 +1,106 @@
+package controllers
+
+import (
+	"encoding/json"
+	models "main/Models"
+	"main/authz"
+	"main/store"
+	"main/utils"
+	"net/http"
+)
+
+func Authorize(authorizer store.Authorizer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req models.AuthorizationRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			badRequest(w, r, "Invalid JSON format")
+			return
+		}
+		if msg := authorizationRequestError(req); msg != "" {
+			badRequest(w, r, msg)
+			return
+		}
+
+		decision, err := authorizer.Check(r.Context(), utils.TenantFromContext(r.Context()), req)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		json.NewEncoder(w).Encode(decision)
+	}
+}
+
+// ExplainAuthorization evaluates a check like Authorize, and returns the
+// trace of how it was decided, including the grants that were skipped.
+func ExplainAuthorization(authorizer store.Authorizer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req models.AuthorizationRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			badRequest(w, r, "Invalid JSON format")
+			return
+		}
+		if msg := authorizationRequestError(req); msg != "" {
+			badRequest(w, r, msg)
+			return
+		}
+
+		explanation, err := authorizer.Explain(r.Context(), utils.TenantFromContext(r.Context()), req)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		json.NewEncoder(w).Encode(explanation)
+	}
+}
+
+// authorizationRequestError returns what is wrong with a single check, or "".
+func authorizationRequestError(req models.AuthorizationRequest) string {
+	if req.Permission == "" {
+		return "permission is required"
+	}
+	if err := authz.ValidatePrincipal(req); err != nil {
+		return err.Error()
+	}
+	if req.Resource != "" {
+		if _, _, err := authz.ParseResource(req.Resource); err != nil {
+			return err.Error()
+		}
+	}
+	return ""
+}
+
+func AuthorizeBatch(authorizer store.Authorizer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var batch models.BatchAuthorizationRequest
+		if err := json.NewDecoder(r.Body).Decode(&batch); err != nil {
+			badRequest(w, r, "Invalid JSON format")
+			return
+		}
+		for _, req := range batch.Checks {
+			if req.Permission == "" {
+				badRequest(w, r, "permission is required on every check")
+				return
+			}
+			if err := authz.ValidatePrincipal(req); err != nil {
+				badRequest(w, r, err.Error())
+				return
+			}
+			if req.Resource != "" {
+				if _, _, err := authz.ParseResource(req.Resource); err != nil {
+					badRequest(w, r, err.Error())
+					return
+				}
+			}
+		}
+
+		decisions, err := authorizer.CheckBatch(r.Context(), utils.TenantFromContext(r.Context()), batch.Checks)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		json.NewEncoder(w).Encode(models.BatchAuthorizationResponse{Results: decisions})
+	}
+}
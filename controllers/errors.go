@@ -0,0 +1,87 @@
+package controllers
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	models "main/Models"
+	"main/store"
+	"main/utils"
+	"main/validation"
+	"net/http"
+
+	"github.com/lib/pq"
+)
+
+// constraintMessages explains unique and foreign key violations without
+// exposing SQL.
+var constraintMessages = map[string]string{
+	"unique_tenant_role_key":             "role_key already exists",
+	"unique_email_role":                  "email already holds this role for this resource",
+	"unique_tenant_permission_key":       "permission_key already exists",
+	"unique_role_permission":             "permission is already bound to this role",
+	"unique_tenant_group_key":            "group_key already exists",
+	"unique_group_member_email":          "email is already a member of this group",
+	"unique_group_member_group":          "group is already a member of this group",
+	"unique_tenant_service_account_name": "service account name already exists",
+	"unique_credential_key_hash":         "credential key already exists",
+}
+
+func badRequest(w http.ResponseWriter, r *http.Request, message string) {
+	utils.WriteError(w, r, http.StatusBadRequest, utils.CodeInvalidRequest, message, nil)
+}
+
+func notFound(w http.ResponseWriter, r *http.Request, message string) {
+	utils.WriteError(w, r, http.StatusNotFound, utils.CodeNotFound, message, nil)
+}
+
+// writeError maps err onto the error envelope, as errorResponse describes.
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	status, body := errorResponse(r, err)
+	utils.WriteError(w, r, status, body.Code, body.Message, body.Details)
+}
+
+// errorResponse maps err onto a status and error body: missing rows are 404,
+// unique violations 409, invalid fields and references to missing rows 422,
+// and anything else a 500 whose cause is only logged.
+func errorResponse(r *http.Request, err error) (int, models.ErrorResponse) {
+	var constraintErr *store.ConstraintError
+	var pqErr *pq.Error
+	var fieldErrs validation.Errors
+	switch {
+	case errors.As(err, &fieldErrs):
+		return http.StatusUnprocessableEntity, models.ErrorResponse{Code: utils.CodeValidation, Message: "invalid fields", Details: fieldErrs}
+	case errors.As(err, &constraintErr):
+		return constraintErrorResponse(constraintErr.Err == store.ErrConflict, constraintErr.Constraint)
+	case errors.As(err, &pqErr) && (pqErr.Code == "23505" || pqErr.Code == "23503"):
+		return constraintErrorResponse(pqErr.Code == "23505", pqErr.Constraint)
+	case errors.Is(err, store.ErrNotFound), errors.Is(err, sql.ErrNoRows):
+		return http.StatusNotFound, models.ErrorResponse{Code: utils.CodeNotFound, Message: "not found"}
+	case errors.Is(err, store.ErrConflict):
+		return http.StatusConflict, models.ErrorResponse{Code: utils.CodeConflict, Message: err.Error()}
+	case errors.Is(err, store.ErrRoleCycle), errors.Is(err, store.ErrGroupCycle), errors.Is(err, store.ErrNotDeleted), errors.Is(err, store.ErrUserRoleExpired),
+		errors.Is(err, store.ErrRoleHasAssignments):
+		return http.StatusConflict, models.ErrorResponse{Code: utils.CodeConflict, Message: err.Error()}
+	case errors.Is(err, store.ErrVersionMismatch):
+		return http.StatusPreconditionFailed, models.ErrorResponse{Code: utils.CodePrecondition, Message: err.Error()}
+	case errors.Is(err, store.ErrInvalidReference), errors.Is(err, store.ErrRoleNotFound),
+		errors.Is(err, store.ErrParentRoleNotFound), errors.Is(err, store.ErrGroupNotFound),
+		errors.Is(err, store.ErrServiceAccountNotFound), errors.Is(err, store.ErrPermissionNotFound):
+		return http.StatusUnprocessableEntity, models.ErrorResponse{Code: utils.CodeUnprocessable, Message: err.Error()}
+	default:
+		log.Printf("request %s: %v", utils.RequestIDFromContext(r.Context()), err)
+		return http.StatusInternalServerError, models.ErrorResponse{Code: utils.CodeInternal, Message: "internal server error"}
+	}
+}
+
+func constraintErrorResponse(unique bool, constraint string) (int, models.ErrorResponse) {
+	details := map[string]string{"constraint": constraint}
+	if unique {
+		message := constraintMessages[constraint]
+		if message == "" {
+			message = "resource already exists"
+		}
+		return http.StatusConflict, models.ErrorResponse{Code: utils.CodeConflict, Message: message, Details: details}
+	}
+	return http.StatusUnprocessableEntity, models.ErrorResponse{Code: utils.CodeUnprocessable, Message: "referenced resource does not exist", Details: details}
+}
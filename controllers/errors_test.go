@@ -0,0 +1,61 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"main/Models"
+	"main/store"
+	"main/utils"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteError(t *testing.T) {
+	testCases := []struct {
+		name            string
+		err             error
+		expectedCode    int
+		expectedBody    string
+		expectedMessage string
+	}{
+		{name: "not found", err: store.ErrNotFound, expectedCode: http.StatusNotFound, expectedBody: utils.CodeNotFound, expectedMessage: "not found"},
+		{name: "role_key taken", err: &pq.Error{Code: "23505", Constraint: "unique_tenant_role_key", Message: "duplicate key value violates unique constraint"},
+			expectedCode: http.StatusConflict, expectedBody: utils.CodeConflict, expectedMessage: "role_key already exists"},
+		{name: "grant exists", err: fmt.Errorf("create: %w", &store.ConstraintError{Err: store.ErrConflict, Constraint: "unique_email_role"}),
+			expectedCode: http.StatusConflict, expectedBody: utils.CodeConflict, expectedMessage: "email already holds this role for this resource"},
+		{name: "foreign key", err: &pq.Error{Code: "23503", Constraint: "role_permissions_tenant_id_role_id_fkey"},
+			expectedCode: http.StatusUnprocessableEntity, expectedBody: utils.CodeUnprocessable, expectedMessage: "referenced resource does not exist"},
+		{name: "missing parent role", err: store.ErrParentRoleNotFound, expectedCode: http.StatusUnprocessableEntity, expectedBody: utils.CodeUnprocessable,
+			expectedMessage: store.ErrParentRoleNotFound.Error()},
+		{name: "cycle", err: store.ErrRoleCycle, expectedCode: http.StatusConflict, expectedBody: utils.CodeConflict, expectedMessage: store.ErrRoleCycle.Error()},
+		{name: "group cycle", err: store.ErrGroupCycle, expectedCode: http.StatusConflict, expectedBody: utils.CodeConflict, expectedMessage: store.ErrGroupCycle.Error()},
+		{name: "missing group", err: store.ErrGroupNotFound, expectedCode: http.StatusUnprocessableEntity, expectedBody: utils.CodeUnprocessable,
+			expectedMessage: store.ErrGroupNotFound.Error()},
+		{name: "service account name taken", err: &store.ConstraintError{Err: store.ErrConflict, Constraint: "unique_tenant_service_account_name"},
+			expectedCode: http.StatusConflict, expectedBody: utils.CodeConflict, expectedMessage: "service account name already exists"},
+		{name: "anything else", err: errors.New(`pq: relation "roles" does not exist`), expectedCode: http.StatusInternalServerError,
+			expectedBody: utils.CodeInternal, expectedMessage: "internal server error"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest("GET", "/roles", nil)
+			req.Header.Set(utils.RequestIDHeader, "req-1")
+			var handler http.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { writeError(w, r, tc.err) })
+			utils.RequestIDMiddleware(handler).ServeHTTP(w, req)
+
+			assert.Equal(t, tc.expectedCode, w.Code)
+			var body models.ErrorResponse
+			assert.NoError(t, json.NewDecoder(w.Body).Decode(&body))
+			assert.Equal(t, tc.expectedBody, body.Code)
+			assert.Equal(t, tc.expectedMessage, body.Message)
+			assert.Equal(t, "req-1", body.RequestID)
+		})
+	}
+}
@@ -0,0 +1,42 @@
+package controllers
+
+import (
+	"encoding/json"
+	"main/utils"
+	"net/http"
+)
+
+// writeVersioned writes a single row tagged with its version, or 304 Not
+// Modified when If-None-Match already names that version.
+func writeVersioned(w http.ResponseWriter, r *http.Request, version int, v interface{}) {
+	if utils.NotModified(w, r, utils.VersionETag(version)) {
+		return
+	}
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeListing writes a list page tagged with a hash of its content, so
+// polling clients get 304 Not Modified while the page is unchanged.
+func writeListing(w http.ResponseWriter, r *http.Request, v interface{}) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	body = append(body, '\n')
+	if utils.NotModified(w, r, utils.ContentETag(body)) {
+		return
+	}
+	w.Write(body)
+}
+
+// ifMatchVersion reads If-Match for a PUT or DELETE, writing a 400 when it
+// is malformed.
+func ifMatchVersion(w http.ResponseWriter, r *http.Request) (int, bool) {
+	version, err := utils.IfMatchVersion(r)
+	if err != nil {
+		badRequest(w, r, err.Error())
+		return 0, false
+	}
+	return version, true
+}
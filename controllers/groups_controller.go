@@ -0,0 +1,216 @@
+package controllers
+
+import (
+	"encoding/json"
+	models "main/Models"
+	"main/listing"
+	"main/store"
+	"main/utils"
+	"main/validation"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// GetGroups lists one page of the tenant's live groups, filtered by
+// group_key and sorted by id, group_key or created_at.
+func GetGroups(groups store.GroupStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		page, err := listing.Parse(query, store.GroupSortFields, "id")
+		if err != nil {
+			badRequest(w, r, err.Error())
+			return
+		}
+
+		filter := store.GroupFilter{GroupKey: query.Get("group_key")}
+		list, next, err := groups.ListGroups(r.Context(), utils.TenantFromContext(r.Context()), filter, page)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		writeListing(w, r, models.GroupPage{Groups: list, NextCursor: next})
+	}
+}
+
+// GetGroup returns a group with its version as ETag, honouring
+// If-None-Match.
+func GetGroup(groups store.GroupStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			badRequest(w, r, "invalid id")
+			return
+		}
+
+		group, err := groups.GetGroup(r.Context(), utils.TenantFromContext(r.Context()), id)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		writeVersioned(w, r, group.Version, group)
+	}
+}
+
+func CreateGroup(groups store.GroupStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var group models.Group
+		if err := json.NewDecoder(r.Body).Decode(&group); err != nil {
+			badRequest(w, r, err.Error())
+			return
+		}
+		if err := validation.Group(group); err != nil {
+			writeError(w, r, err)
+			return
+		}
+		group.TenantID = utils.TenantFromContext(r.Context())
+
+		group, err := groups.CreateGroup(r.Context(), group)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		w.Header().Set("ETag", utils.VersionETag(group.Version))
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(group)
+	}
+}
+
+// UpdateGroup replaces a group's key and description. With If-Match, it
+// fails with 412 unless the group is still at that version.
+func UpdateGroup(groups store.GroupStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			badRequest(w, r, "invalid id")
+			return
+		}
+		version, ok := ifMatchVersion(w, r)
+		if !ok {
+			return
+		}
+
+		var group models.Group
+		if err := json.NewDecoder(r.Body).Decode(&group); err != nil {
+			badRequest(w, r, err.Error())
+			return
+		}
+		if err := validation.Group(group); err != nil {
+			writeError(w, r, err)
+			return
+		}
+		group.Version = version
+
+		group, err = groups.UpdateGroup(r.Context(), utils.TenantFromContext(r.Context()), id, group)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		w.Header().Set("ETag", utils.VersionETag(group.Version))
+		json.NewEncoder(w).Encode(group)
+	}
+}
+
+// DeleteGroup soft-deletes a group along with the user roles granted to it,
+// so its members lose them. With If-Match, it fails with 412 unless the
+// group is still at that version.
+func DeleteGroup(groups store.GroupStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			badRequest(w, r, "invalid id")
+			return
+		}
+		version, ok := ifMatchVersion(w, r)
+		if !ok {
+			return
+		}
+
+		if err := groups.DeleteGroup(r.Context(), utils.TenantFromContext(r.Context()), id, version); err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// GetGroupMembers lists the direct members of a group: emails and nested
+// groups.
+func GetGroupMembers(groups store.GroupStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			badRequest(w, r, "invalid id")
+			return
+		}
+
+		members, err := groups.ListGroupMembers(r.Context(), utils.TenantFromContext(r.Context()), id)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		json.NewEncoder(w).Encode(members)
+	}
+}
+
+// AddGroupMember adds an email or, with member_group_id, a nested group to a
+// group. A nested group that already contains the group is refused with 409.
+func AddGroupMember(groups store.GroupStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			badRequest(w, r, "invalid id")
+			return
+		}
+
+		var member models.GroupMember
+		if err := json.NewDecoder(r.Body).Decode(&member); err != nil {
+			badRequest(w, r, err.Error())
+			return
+		}
+		if err := validation.GroupMember(&member); err != nil {
+			writeError(w, r, err)
+			return
+		}
+		member.GroupID = id
+
+		member, err = groups.AddGroupMember(r.Context(), utils.TenantFromContext(r.Context()), member)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(member)
+	}
+}
+
+func RemoveGroupMember(groups store.GroupStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		id, err := strconv.Atoi(vars["id"])
+		if err != nil {
+			badRequest(w, r, "invalid id")
+			return
+		}
+		memberID, err := strconv.Atoi(vars["member_id"])
+		if err != nil {
+			badRequest(w, r, "invalid member_id")
+			return
+		}
+
+		if err := groups.RemoveGroupMember(r.Context(), utils.TenantFromContext(r.Context()), id, memberID); err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
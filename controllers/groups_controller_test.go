@@ -0,0 +1,107 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"main/Models"
+	"main/utils"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAddGroupMember(t *testing.T) {
+	testCases := []struct {
+		name         string
+		groupID      string
+		requestBody  string
+		expectedCode int
+	}{
+		{name: "email", groupID: "2", requestBody: `{"email": "New@Example.com"}`, expectedCode: http.StatusCreated},
+		{name: "nested group", groupID: "1", requestBody: `{"member_group_id": 3}`, expectedCode: http.StatusCreated},
+		{name: "cycle", groupID: "2", requestBody: `{"member_group_id": 1}`, expectedCode: http.StatusConflict},
+		{name: "self", groupID: "1", requestBody: `{"member_group_id": 1}`, expectedCode: http.StatusConflict},
+		{name: "duplicate email", groupID: "2", requestBody: `{"email": "test@example.com"}`, expectedCode: http.StatusConflict},
+		{name: "email and group", groupID: "2", requestBody: `{"email": "new@example.com", "member_group_id": 3}`, expectedCode: http.StatusUnprocessableEntity},
+		{name: "missing member group", groupID: "2", requestBody: `{"member_group_id": 9}`, expectedCode: http.StatusUnprocessableEntity},
+		{name: "group not found", groupID: "9", requestBody: `{"email": "new@example.com"}`, expectedCode: http.StatusNotFound},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := newUserRoleStore(t)
+			ctx := context.Background()
+			// engineering (1) contains platform (2), which contains
+			// test@example.com; security (3) stands alone.
+			for _, key := range []string{"engineering", "platform", "security"} {
+				_, err := s.CreateGroup(ctx, models.Group{TenantID: utils.DefaultTenant, GroupKey: key})
+				require.NoError(t, err)
+			}
+			platform := 2
+			_, err := s.AddGroupMember(ctx, utils.DefaultTenant, models.GroupMember{GroupID: 1, MemberGroupID: &platform})
+			require.NoError(t, err)
+			_, err = s.AddGroupMember(ctx, utils.DefaultTenant, models.GroupMember{GroupID: 2, Email: "test@example.com"})
+			require.NoError(t, err)
+
+			req := httptest.NewRequest("POST", "/groups/"+tc.groupID+"/members", strings.NewReader(tc.requestBody))
+			req = mux.SetURLVars(req, map[string]string{"id": tc.groupID})
+			w := httptest.NewRecorder()
+
+			AddGroupMember(s).ServeHTTP(w, req)
+
+			assert.Equal(t, tc.expectedCode, w.Code)
+			if tc.expectedCode == http.StatusCreated {
+				var member models.GroupMember
+				require.NoError(t, json.NewDecoder(w.Body).Decode(&member))
+				members, err := s.ListGroupMembers(ctx, utils.DefaultTenant, member.GroupID)
+				require.NoError(t, err)
+				stored := members[len(members)-1]
+				assert.Equal(t, member.ID, stored.ID)
+				assert.Equal(t, member.Email, stored.Email)
+				assert.Equal(t, member.MemberGroupID, stored.MemberGroupID)
+			}
+		})
+	}
+}
+
+func TestGetUserRolesThroughGroups(t *testing.T) {
+	s := newUserRoleStore(t)
+	ctx := context.Background()
+	group, err := s.CreateGroup(ctx, models.Group{TenantID: utils.DefaultTenant, GroupKey: "platform"})
+	require.NoError(t, err)
+	_, err = s.AddGroupMember(ctx, utils.DefaultTenant, models.GroupMember{GroupID: group.ID, Email: "test@example.com"})
+	require.NoError(t, err)
+	_, err = s.CreateUserRole(ctx, models.UserRole{TenantID: utils.DefaultTenant, GroupID: &group.ID, RoleID: 2})
+	require.NoError(t, err)
+
+	req := httptest.NewRequest("GET", "/user-roles?email=Test@example.com", nil)
+	w := httptest.NewRecorder()
+	GetUserRoles(s).ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	var page models.UserRolePage
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&page))
+	require.Len(t, page.UserRoles, 2)
+	assert.Equal(t, "direct", page.UserRoles[0].Source)
+	assert.Equal(t, "group", page.UserRoles[1].Source)
+	assert.Equal(t, group.ID, *page.UserRoles[1].GroupID)
+	assert.Equal(t, "viewer", page.UserRoles[1].RoleKey)
+
+	// Once the group is gone, so is the role it passed on.
+	req = httptest.NewRequest("DELETE", "/groups/1", nil)
+	req = mux.SetURLVars(req, map[string]string{"id": "1"})
+	w = httptest.NewRecorder()
+	DeleteGroup(s).ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+
+	req = httptest.NewRequest("GET", "/user-roles?email=test@example.com", nil)
+	w = httptest.NewRecorder()
+	GetUserRoles(s).ServeHTTP(w, req)
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&page))
+	assert.Len(t, page.UserRoles, 1)
+}
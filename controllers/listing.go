@@ -0,0 +1,40 @@
+package controllers
+
+import (
+	"errors"
+	"main/listing"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// parseCreatedRange reads the created_after (inclusive) and created_before
+// (exclusive) filters shared by list endpoints.
+func parseCreatedRange(query url.Values) (after, before *time.Time, err error) {
+	if after, err = listing.ParseTime(query, "created_after"); err != nil {
+		return nil, nil, err
+	}
+	if before, err = listing.ParseTime(query, "created_before"); err != nil {
+		return nil, nil, err
+	}
+	return after, before, nil
+}
+
+// parseIncludeDeleted reads include_deleted, which adds soft-deleted rows to
+// a listing.
+func parseIncludeDeleted(query url.Values) (bool, error) {
+	return parseBool(query, "include_deleted")
+}
+
+// parseBool reads an optional boolean query parameter, false when absent.
+func parseBool(query url.Values, name string) (bool, error) {
+	value := query.Get(name)
+	if value == "" {
+		return false, nil
+	}
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, errors.New("invalid " + name)
+	}
+	return parsed, nil
+}
@@ -0,0 +1,75 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"main/utils"
+	"mime"
+	"net/http"
+	"reflect"
+)
+
+// patchAttempts bounds how often a PATCH without If-Match re-reads and
+// re-applies itself when another write lands between its read and write.
+const patchAttempts = 3
+
+// readMergePatch decodes an RFC 7396 merge patch object from the body,
+// writing the error response when it cannot.
+func readMergePatch(w http.ResponseWriter, r *http.Request) (map[string]interface{}, bool) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != utils.MergePatchContentType && mediaType != "application/json" {
+		utils.WriteError(w, r, http.StatusUnsupportedMediaType, utils.CodeUnsupportedMediaType,
+			"PATCH body must be "+utils.MergePatchContentType, nil)
+		return nil, false
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	decoder.UseNumber()
+	var patch interface{}
+	if err := decoder.Decode(&patch); err != nil {
+		badRequest(w, r, err.Error())
+		return nil, false
+	}
+	patchObject, ok := patch.(map[string]interface{})
+	if !ok {
+		badRequest(w, r, "merge patch must be a JSON object")
+		return nil, false
+	}
+	return patchObject, true
+}
+
+// applyMergePatch patches the JSON form of current into dst. Fields in
+// readOnly may only be repeated with their current value, and fields dst
+// does not have are rejected.
+func applyMergePatch(current interface{}, patch map[string]interface{}, readOnly []string, dst interface{}) error {
+	document, err := toJSONObject(current)
+	if err != nil {
+		return err
+	}
+	for _, field := range readOnly {
+		if value, ok := patch[field]; ok && !reflect.DeepEqual(value, document[field]) {
+			return fmt.Errorf("%s cannot be changed", field)
+		}
+	}
+
+	patched, err := json.Marshal(utils.MergePatch(document, patch))
+	if err != nil {
+		return err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(patched))
+	decoder.DisallowUnknownFields()
+	return decoder.Decode(dst)
+}
+
+func toJSONObject(v interface{}) (map[string]interface{}, error) {
+	encoded, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(encoded))
+	decoder.UseNumber()
+	var object map[string]interface{}
+	err = decoder.Decode(&object)
+	return object, err
+}
@@ -0,0 +1,129 @@
+package controllers
+
+import (
+	"encoding/json"
+	models "main/Models"
+	"main/listing"
+	"main/store"
+	"main/utils"
+	"main/validation"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// GetPermissions lists one page of the tenant's permissions, filtered by
+// permission_key and created_after/created_before, and sorted by id,
+// permission_key or created_at.
+func GetPermissions(permissions store.PermissionStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		page, err := listing.Parse(query, store.PermissionSortFields, "id")
+		if err != nil {
+			badRequest(w, r, err.Error())
+			return
+		}
+
+		filter := store.PermissionFilter{PermissionKey: query.Get("permission_key")}
+		if filter.CreatedAfter, filter.CreatedBefore, err = parseCreatedRange(query); err != nil {
+			badRequest(w, r, err.Error())
+			return
+		}
+
+		list, next, err := permissions.ListPermissions(r.Context(), utils.TenantFromContext(r.Context()), filter, page)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		json.NewEncoder(w).Encode(models.PermissionPage{Permissions: list, NextCursor: next})
+	}
+}
+
+func GetPermission(permissions store.PermissionStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			badRequest(w, r, "invalid id")
+			return
+		}
+
+		permission, err := permissions.GetPermission(r.Context(), utils.TenantFromContext(r.Context()), id)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		json.NewEncoder(w).Encode(permission)
+	}
+}
+
+func CreatePermission(permissions store.PermissionStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var permission models.Permission
+		if err := json.NewDecoder(r.Body).Decode(&permission); err != nil {
+			badRequest(w, r, "Invalid JSON format")
+			return
+		}
+		if err := validation.Permission(permission); err != nil {
+			writeError(w, r, err)
+			return
+		}
+		permission.TenantID = utils.TenantFromContext(r.Context())
+
+		permission, err := permissions.CreatePermission(r.Context(), permission)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(permission)
+	}
+}
+
+func UpdatePermission(permissions store.PermissionStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			badRequest(w, r, "invalid id")
+			return
+		}
+
+		var permission models.Permission
+		if err := json.NewDecoder(r.Body).Decode(&permission); err != nil {
+			badRequest(w, r, err.Error())
+			return
+		}
+		if err := validation.Permission(permission); err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		permission, err = permissions.UpdatePermission(r.Context(), utils.TenantFromContext(r.Context()), id, permission)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		json.NewEncoder(w).Encode(permission)
+	}
+}
+
+func DeletePermission(permissions store.PermissionStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			badRequest(w, r, "invalid id")
+			return
+		}
+
+		if err := permissions.DeletePermission(r.Context(), utils.TenantFromContext(r.Context()), id); err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
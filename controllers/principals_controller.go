@@ -0,0 +1,82 @@
+package controllers
+
+import (
+	models "main/Models"
+	"main/listing"
+	"main/store"
+	"main/utils"
+	"main/validation"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// GetPrincipalRoles lists one page of the live user roles held by a user
+// (by email), a service account or a group (by id), sorted by id, email or
+// created_at. A user's page includes the grants of the groups holding the
+// email, as GetUserRoles does.
+func GetPrincipalRoles(principals store.PrincipalStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		page, err := listing.Parse(r.URL.Query(), store.UserRoleSortFields, "id")
+		if err != nil {
+			badRequest(w, r, err.Error())
+			return
+		}
+
+		tenant := utils.TenantFromContext(r.Context())
+		principal := models.Principal{Type: vars["type"], ID: vars["id"]}
+		filter := store.UserRoleFilter{}
+		switch principal.Type {
+		case models.PrincipalUser:
+			email, err := validation.NormalizeEmail(principal.ID)
+			if err != nil {
+				badRequest(w, r, "invalid id: email "+err.Error())
+				return
+			}
+			principal.ID, principal.DisplayName = email, email
+			filter.Email = email
+		case models.PrincipalService:
+			id, err := strconv.Atoi(principal.ID)
+			if err != nil {
+				badRequest(w, r, "invalid id")
+				return
+			}
+			account, err := principals.GetServiceAccount(r.Context(), tenant, id)
+			if err != nil {
+				writeError(w, r, err)
+				return
+			}
+			principal.DisplayName = account.DisplayName
+			if principal.DisplayName == "" {
+				principal.DisplayName = account.Name
+			}
+			filter.ServiceAccountID = id
+		case models.PrincipalGroup:
+			id, err := strconv.Atoi(principal.ID)
+			if err != nil {
+				badRequest(w, r, "invalid id")
+				return
+			}
+			group, err := principals.GetGroup(r.Context(), tenant, id)
+			if err != nil {
+				writeError(w, r, err)
+				return
+			}
+			principal.DisplayName = group.GroupKey
+			filter.GroupID = id
+		default:
+			badRequest(w, r, `type must be "user", "service" or "group"`)
+			return
+		}
+
+		list, next, err := principals.ListUserRoles(r.Context(), tenant, filter, page)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		writeListing(w, r, models.PrincipalRoles{Principal: principal, UserRoles: list, NextCursor: next})
+	}
+}
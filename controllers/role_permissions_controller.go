@@ -0,0 +1,117 @@
+package controllers
+
+import (
+	"encoding/json"
+	models "main/Models"
+	"main/store"
+	"main/utils"
+	"main/validation"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// GetRolePermissions lists the live permissions granted directly by a role.
+// With ?effective=true it also lists those inherited from ancestor roles;
+// each binding keeps the role_id of the role that actually holds it, and
+// allow bindings for a permission some binding in the set denies are left
+// out, since the deny wins.
+func GetRolePermissions(rolePermissions store.RolePermissionStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		roleID, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			badRequest(w, r, "invalid id")
+			return
+		}
+		effective := r.URL.Query().Get("effective") == "true"
+
+		list, err := rolePermissions.ListRolePermissions(r.Context(), utils.TenantFromContext(r.Context()), roleID, effective)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		if effective {
+			list = withoutDenied(list)
+		}
+
+		json.NewEncoder(w).Encode(list)
+	}
+}
+
+// withoutDenied drops the allow bindings for permissions that a deny binding
+// among rolePermissions revokes. Deny bindings are kept, so the listing still
+// shows why a permission is missing. A conditional deny only revokes the
+// permission when its condition holds, so it does not hide allow bindings.
+func withoutDenied(rolePermissions []models.RolePermission) []models.RolePermission {
+	denied := map[int]bool{}
+	for _, rolePermission := range rolePermissions {
+		if rolePermission.Effect == models.EffectDeny && rolePermission.Condition == "" {
+			denied[rolePermission.PermissionID] = true
+		}
+	}
+	kept := rolePermissions[:0]
+	for _, rolePermission := range rolePermissions {
+		if rolePermission.Effect == models.EffectDeny || !denied[rolePermission.PermissionID] {
+			kept = append(kept, rolePermission)
+		}
+	}
+	return kept
+}
+
+// AddRolePermission binds an existing permission to a role, optionally
+// under a condition, which is parsed and type-checked first. A deny binding
+// revokes the permission from the role and every role inheriting it.
+func AddRolePermission(rolePermissions store.RolePermissionStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		roleID, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			badRequest(w, r, "invalid id")
+			return
+		}
+
+		var rolePermission models.RolePermission
+		if err := json.NewDecoder(r.Body).Decode(&rolePermission); err != nil {
+			badRequest(w, r, "Invalid JSON format")
+			return
+		}
+		if err := validation.RolePermission(&rolePermission); err != nil {
+			writeError(w, r, err)
+			return
+		}
+		rolePermission.RoleID = roleID
+
+		rolePermission, err = rolePermissions.AddRolePermission(r.Context(), utils.TenantFromContext(r.Context()), rolePermission)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(rolePermission)
+	}
+}
+
+// RemoveRolePermission unbinds a permission from a role.
+func RemoveRolePermission(rolePermissions store.RolePermissionStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		roleID, err := strconv.Atoi(vars["id"])
+		if err != nil {
+			badRequest(w, r, "invalid id")
+			return
+		}
+		permissionID, err := strconv.Atoi(vars["permission_id"])
+		if err != nil {
+			badRequest(w, r, "invalid permission_id")
+			return
+		}
+
+		if err := rolePermissions.RemoveRolePermission(r.Context(), utils.TenantFromContext(r.Context()), roleID, permissionID); err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,196 @@
+package controllers
+
+import (
+	"encoding/json"
+	models "main/Models"
+	"main/store"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAddRolePermission(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	testCases := []struct {
+		name         string
+		roleID       string
+		requestBody  string
+		expectedCode int
+		mockQueries  func()
+	}{
+		{
+			name:         "success - permission bound",
+			roleID:       "1",
+			requestBody:  `{"permission_id": 3}`,
+			expectedCode: http.StatusCreated,
+			mockQueries: func() {
+				mock.ExpectBegin()
+				mock.ExpectQuery(`SELECT EXISTS \(SELECT 1 FROM roles WHERE id = \$1 AND tenant_id = \$2 AND deleted_at IS NULL\)`).
+					WithArgs(1, "default").
+					WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+
+				mock.ExpectQuery(`SELECT permission_key FROM permissions WHERE id = \$1 AND tenant_id = \$2 AND deleted_at IS NULL`).
+					WithArgs(3, "default").
+					WillReturnRows(sqlmock.NewRows([]string{"permission_key"}).AddRow("invoice:read"))
+
+				mock.ExpectQuery(`INSERT INTO role_permissions \(tenant_id, role_id, permission_id, condition, effect\) VALUES \(\$1, \$2, \$3, \$4, \$5\) RETURNING id, created_at`).
+					WithArgs("default", 1, 3, "", "allow").
+					WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(1, time.Now()))
+
+				mock.ExpectExec(`INSERT INTO audit_events`).
+					WithArgs("default", "anonymous", "create", "role_permission", 1, nil, sqlmock.AnyArg(), "").
+					WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectCommit()
+			},
+		},
+		{
+			name:         "failure - role does not exist",
+			roleID:       "1",
+			requestBody:  `{"permission_id": 3}`,
+			expectedCode: http.StatusNotFound,
+			mockQueries: func() {
+				mock.ExpectBegin()
+				mock.ExpectQuery(`SELECT EXISTS \(SELECT 1 FROM roles WHERE id = \$1 AND tenant_id = \$2 AND deleted_at IS NULL\)`).
+					WithArgs(1, "default").
+					WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+				mock.ExpectRollback()
+			},
+		},
+		{
+			name:         "failure - permission does not exist",
+			roleID:       "1",
+			requestBody:  `{"permission_id": 3}`,
+			expectedCode: http.StatusUnprocessableEntity,
+			mockQueries: func() {
+				mock.ExpectBegin()
+				mock.ExpectQuery(`SELECT EXISTS \(SELECT 1 FROM roles WHERE id = \$1 AND tenant_id = \$2 AND deleted_at IS NULL\)`).
+					WithArgs(1, "default").
+					WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+
+				mock.ExpectQuery(`SELECT permission_key FROM permissions WHERE id = \$1 AND tenant_id = \$2 AND deleted_at IS NULL`).
+					WithArgs(3, "default").
+					WillReturnRows(sqlmock.NewRows([]string{"permission_key"}))
+				mock.ExpectRollback()
+			},
+		},
+		{
+			name:         "success - conditional binding",
+			roleID:       "1",
+			requestBody:  `{"permission_id": 3, "condition": "amount < 1000"}`,
+			expectedCode: http.StatusCreated,
+			mockQueries: func() {
+				mock.ExpectBegin()
+				mock.ExpectQuery(`SELECT EXISTS`).
+					WithArgs(1, "default").
+					WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+				mock.ExpectQuery(`SELECT permission_key FROM permissions`).
+					WithArgs(3, "default").
+					WillReturnRows(sqlmock.NewRows([]string{"permission_key"}).AddRow("refund:approve"))
+				mock.ExpectQuery(`INSERT INTO role_permissions`).
+					WithArgs("default", 1, 3, "amount < 1000", "allow").
+					WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(2, time.Now()))
+				mock.ExpectExec(`INSERT INTO audit_events`).
+					WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectCommit()
+			},
+		},
+		{
+			name:         "failure - condition does not type-check",
+			roleID:       "1",
+			requestBody:  `{"permission_id": 3, "condition": "amount < 1000 && 'EUR'"}`,
+			expectedCode: http.StatusUnprocessableEntity,
+			mockQueries:  func() {},
+		},
+		{
+			name:         "success - deny binding",
+			roleID:       "1",
+			requestBody:  `{"permission_id": 3, "effect": "deny"}`,
+			expectedCode: http.StatusCreated,
+			mockQueries: func() {
+				mock.ExpectBegin()
+				mock.ExpectQuery(`SELECT EXISTS`).
+					WithArgs(1, "default").
+					WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+				mock.ExpectQuery(`SELECT permission_key FROM permissions`).
+					WithArgs(3, "default").
+					WillReturnRows(sqlmock.NewRows([]string{"permission_key"}).AddRow("billing:export"))
+				mock.ExpectQuery(`INSERT INTO role_permissions`).
+					WithArgs("default", 1, 3, "", "deny").
+					WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(3, time.Now()))
+				mock.ExpectExec(`INSERT INTO audit_events`).
+					WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectCommit()
+			},
+		},
+		{
+			name:         "failure - unknown effect",
+			roleID:       "1",
+			requestBody:  `{"permission_id": 3, "effect": "block"}`,
+			expectedCode: http.StatusUnprocessableEntity,
+			mockQueries:  func() {},
+		},
+		{
+			name:         "failure - invalid role id",
+			roleID:       "abc",
+			requestBody:  `{"permission_id": 3}`,
+			expectedCode: http.StatusBadRequest,
+			mockQueries:  func() {},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.mockQueries()
+
+			req := httptest.NewRequest("POST", "/roles/"+tc.roleID+"/permissions", strings.NewReader(tc.requestBody))
+			req.Header.Set("Content-Type", "application/json")
+			req = mux.SetURLVars(req, map[string]string{"id": tc.roleID})
+			w := httptest.NewRecorder()
+
+			handler := AddRolePermission(store.NewPostgres(db))
+			handler.ServeHTTP(w, req)
+
+			assert.Equal(t, tc.expectedCode, w.Code)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestGetEffectiveRolePermissions(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectQuery(`WITH RECURSIVE effective`).
+		WithArgs(2, "default").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "role_id", "permission_id", "permission_key", "condition", "effect", "created_at"}).
+			AddRow(1, 1, 3, "billing:export", "", "allow", time.Now()).
+			AddRow(2, 1, 4, "billing:read", "", "allow", time.Now()).
+			AddRow(3, 2, 3, "billing:export", "", "deny", time.Now()).
+			AddRow(4, 1, 5, "billing:refund", "", "allow", time.Now()).
+			AddRow(5, 2, 5, "billing:refund", "amount > 1000", "deny", time.Now()))
+
+	req := httptest.NewRequest("GET", "/roles/2/permissions?effective=true", nil)
+	req = mux.SetURLVars(req, map[string]string{"id": "2"})
+	w := httptest.NewRecorder()
+	GetRolePermissions(store.NewPostgres(db)).ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	var rolePermissions []models.RolePermission
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&rolePermissions))
+	ids := []int{}
+	for _, rolePermission := range rolePermissions {
+		ids = append(ids, rolePermission.ID)
+	}
+	assert.Equal(t, []int{2, 3, 4, 5}, ids, "the unconditional deny hides the inherited allow")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -1,106 +1,352 @@
 package controllers
 
 import (
-	"database/sql"
+	"context"
 	"encoding/json"
-	"log"
+	"errors"
+	"fmt"
 	models "main/Models"
+	"main/listing"
+	"main/store"
+	"main/utils"
+	"main/validation"
 	"net/http"
+	"strconv"
 
 	"github.com/gorilla/mux"
 )
 
-func GetRoles(db *sql.DB) http.HandlerFunc {
+// GetRoles lists one page of the tenant's roles, filtered by role_key and
+// created_after/created_before, and sorted by id, role_key or created_at.
+// Deleted roles are included with include_deleted=true.
+func GetRoles(roles store.RoleStore) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		rows, err := db.Query("SELECT * FROM roles WHERE deleted_at IS NULL")
+		query := r.URL.Query()
+		page, err := listing.Parse(query, store.RoleSortFields, "id")
 		if err != nil {
-			log.Fatal(err)
+			badRequest(w, r, err.Error())
+			return
 		}
-		defer rows.Close()
 
-		roles := []models.Role{}
-		for rows.Next() {
-			var role models.Role
-			if err := rows.Scan(&role.ID, &role.RoleKey, &role.Description, &role.CreatedAt, &role.UpdatedAt, &role.DeletedAt); err != nil {
-				log.Fatal(err)
-			}
-			roles = append(roles, role)
+		filter := store.RoleFilter{RoleKey: query.Get("role_key")}
+		if filter.CreatedAfter, filter.CreatedBefore, err = parseCreatedRange(query); err != nil {
+			badRequest(w, r, err.Error())
+			return
 		}
-		if err := rows.Err(); err != nil {
-			log.Fatal(err)
+		if filter.IncludeDeleted, err = parseIncludeDeleted(query); err != nil {
+			badRequest(w, r, err.Error())
+			return
 		}
 
-		json.NewEncoder(w).Encode(roles)
+		list, next, err := roles.ListRoles(r.Context(), utils.TenantFromContext(r.Context()), filter, page)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		writeListing(w, r, models.RolePage{Roles: list, NextCursor: next})
 	}
 }
 
-func GetRole(db *sql.DB) http.HandlerFunc {
+// GetRole returns a role with its version as ETag, honouring If-None-Match.
+func GetRole(roles store.RoleStore) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		vars := mux.Vars(r)
-		id := vars["id"]
+		id, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			badRequest(w, r, "invalid id")
+			return
+		}
 
-		var role models.Role
-		err := db.QueryRow("SELECT * FROM roles WHERE id = $1 AND deleted_at IS NULL", id).
-			Scan(&role.ID, &role.RoleKey, &role.Description, &role.CreatedAt, &role.UpdatedAt, &role.DeletedAt)
+		role, err := roles.GetRole(r.Context(), utils.TenantFromContext(r.Context()), id)
 		if err != nil {
-			log.Fatal(err)
+			writeError(w, r, err)
+			return
 		}
 
-		json.NewEncoder(w).Encode(role)
+		writeVersioned(w, r, role.Version, role)
 	}
 }
 
-func CreateRole(db *sql.DB) http.HandlerFunc {
+func CreateRole(roles store.RoleStore) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var role models.Role
 		if err := json.NewDecoder(r.Body).Decode(&role); err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+			badRequest(w, r, err.Error())
 			return
 		}
+		if err := validation.Role(role); err != nil {
+			writeError(w, r, err)
+			return
+		}
+		role.TenantID = utils.TenantFromContext(r.Context())
 
-		err := db.QueryRow("INSERT INTO roles (role_key, description) VALUES ($1, $2) RETURNING id, created_at, updated_at", role.RoleKey, role.Description).
-			Scan(&role.ID, &role.CreatedAt, &role.UpdatedAt)
+		role, err := roles.CreateRole(r.Context(), role)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			writeError(w, r, err)
 			return
 		}
 
+		w.Header().Set("ETag", utils.VersionETag(role.Version))
 		json.NewEncoder(w).Encode(role)
 	}
 }
 
-func UpdateRole(db *sql.DB) http.HandlerFunc {
+// UpdateRole replaces a role. Its role_key is only validated when it
+// changes. With If-Match, it fails with 412 unless the role is still at that
+// version.
+func UpdateRole(roles store.RoleStore) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		vars := mux.Vars(r)
-		id := vars["id"]
+		id, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			badRequest(w, r, "invalid id")
+			return
+		}
+		version, ok := ifMatchVersion(w, r)
+		if !ok {
+			return
+		}
 
 		var role models.Role
 		if err := json.NewDecoder(r.Body).Decode(&role); err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+			badRequest(w, r, err.Error())
 			return
 		}
+		tenant := utils.TenantFromContext(r.Context())
+		current, err := roles.GetRole(r.Context(), tenant, id)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		if err := validation.RoleChange(current, role); err != nil {
+			writeError(w, r, err)
+			return
+		}
+		role.Version = version
 
-		_, err := db.Exec("UPDATE roles SET role_key = $1, description = $2, updated_at = CURRENT_TIMESTAMP WHERE id = $3 AND deleted_at IS NULL", role.RoleKey, role.Description, id)
+		role, err = roles.UpdateRole(r.Context(), tenant, id, role)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			writeError(w, r, err)
 			return
 		}
 
+		w.Header().Set("ETag", utils.VersionETag(role.Version))
 		json.NewEncoder(w).Encode(role)
 	}
 }
 
-func DeleteRole(db *sql.DB) http.HandlerFunc {
+// roleReadOnlyFields are the role fields a PATCH cannot change.
+var roleReadOnlyFields = []string{"id", "tenant_id", "created_at", "updated_at", "deleted_at", "version"}
+
+// PatchRole applies an RFC 7396 merge patch to a role and returns the role as
+// stored afterwards. A null parent_ids removes every parent. With If-Match,
+// it fails with 412 unless the role is still at that version.
+func PatchRole(roles store.RoleStore) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		vars := mux.Vars(r)
-		id := vars["id"]
+		id, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			badRequest(w, r, "invalid id")
+			return
+		}
+		version, ok := ifMatchVersion(w, r)
+		if !ok {
+			return
+		}
+		patch, ok := readMergePatch(w, r)
+		if !ok {
+			return
+		}
+		tenant := utils.TenantFromContext(r.Context())
+
+		for attempt := 1; ; attempt++ {
+			current, err := roles.GetRole(r.Context(), tenant, id)
+			if err != nil {
+				writeError(w, r, err)
+				return
+			}
 
-		_, err := db.Exec("UPDATE roles SET deleted_at = CURRENT_TIMESTAMP WHERE id = $1", id)
+			var role models.Role
+			if err := applyMergePatch(current, patch, roleReadOnlyFields, &role); err != nil {
+				badRequest(w, r, err.Error())
+				return
+			}
+			if err := validation.RoleChange(current, role); err != nil {
+				writeError(w, r, err)
+				return
+			}
+			if role.ParentIDs == nil {
+				role.ParentIDs = []int{}
+			}
+
+			// Without If-Match, patch the version just read and start over
+			// if another write got in first.
+			role.Version = version
+			if version == 0 {
+				role.Version = current.Version
+			}
+			_, err = roles.UpdateRole(r.Context(), tenant, id, role)
+			if errors.Is(err, store.ErrVersionMismatch) && version == 0 && attempt < patchAttempts {
+				continue
+			}
+			if err != nil {
+				writeError(w, r, err)
+				return
+			}
+			break
+		}
+
+		role, err := roles.GetRole(r.Context(), tenant, id)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		w.Header().Set("ETag", utils.VersionETag(role.Version))
+		json.NewEncoder(w).Encode(role)
+	}
+}
+
+// DeleteRole soft-deletes a role. The assignments parameter decides what
+// happens to its user roles: restrict (the default) refuses while any are
+// unexpired and retires expired ones, cascade deletes them too, and reassign moves them to
+// replacement_role_id. With If-Match, it fails with 412 unless the role is
+// still at that version.
+func DeleteRole(roles store.RoleStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(mux.Vars(r)["id"])
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			badRequest(w, r, "invalid id")
+			return
+		}
+		version, ok := ifMatchVersion(w, r)
+		if !ok {
+			return
+		}
+
+		query := r.URL.Query()
+		options := store.DeleteRoleOptions{Assignments: store.AssignmentPolicy(query.Get("assignments")), Version: version}
+		switch options.Assignments {
+		case "":
+			options.Assignments = store.AssignmentsRestrict
+		case store.AssignmentsRestrict, store.AssignmentsCascade:
+		case store.AssignmentsReassign:
+			if options.ReplacementRoleID, err = strconv.Atoi(query.Get("replacement_role_id")); err != nil || options.ReplacementRoleID == id {
+				badRequest(w, r, "reassign needs a replacement_role_id other than the deleted role")
+				return
+			}
+		default:
+			badRequest(w, r, "assignments must be restrict, cascade or reassign")
+			return
+		}
+
+		if err := roles.DeleteRole(r.Context(), utils.TenantFromContext(r.Context()), id, options); err != nil {
+			writeError(w, r, err)
 			return
 		}
 
 		w.WriteHeader(http.StatusNoContent)
 	}
 }
+
+// RestoreRole undeletes a soft-deleted role and returns it.
+func RestoreRole(roles store.RoleStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			badRequest(w, r, "invalid id")
+			return
+		}
+
+		role, err := roles.RestoreRole(r.Context(), utils.TenantFromContext(r.Context()), id)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		w.Header().Set("ETag", utils.VersionETag(role.Version))
+		json.NewEncoder(w).Encode(role)
+	}
+}
+
+// GetRoleAncestors lists every live role the given role inherits from,
+// directly or transitively.
+func GetRoleAncestors(roles store.RoleStore) http.HandlerFunc {
+	return getRoleRelatives(roles.RoleAncestors)
+}
+
+// GetRoleDescendants lists every live role that inherits from the given
+// role, directly or transitively.
+func GetRoleDescendants(roles store.RoleStore) http.HandlerFunc {
+	return getRoleRelatives(roles.RoleDescendants)
+}
+
+func getRoleRelatives(relatives func(ctx context.Context, tenant string, id int) ([]models.Role, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			badRequest(w, r, "invalid id")
+			return
+		}
+
+		list, err := relatives(r.Context(), utils.TenantFromContext(r.Context()), id)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		json.NewEncoder(w).Encode(list)
+	}
+}
+
+// SyncRoleMembers makes the emails in the body the exact members of a role,
+// granting it to the missing ones and revoking it from the rest in one
+// transaction, and returns the diff. With dry_run=true nothing changes.
+// Only unscoped grants count as membership.
+func SyncRoleMembers(userRoles store.UserRoleStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			badRequest(w, r, "invalid id")
+			return
+		}
+		dryRun, err := parseBool(r.URL.Query(), "dry_run")
+		if err != nil {
+			badRequest(w, r, err.Error())
+			return
+		}
+
+		var members models.RoleMembers
+		if err := json.NewDecoder(r.Body).Decode(&members); err != nil {
+			badRequest(w, r, "Invalid JSON format")
+			return
+		}
+		if members.Emails == nil {
+			badRequest(w, r, "emails is required")
+			return
+		}
+		emails := []string{}
+		seen := map[string]bool{}
+		errs := validation.Errors{}
+		for i, email := range members.Emails {
+			email, err := validation.NormalizeEmail(email)
+			if err != nil {
+				errs[fmt.Sprintf("emails[%d]", i)] = err.Error()
+				continue
+			}
+			if !seen[email] {
+				seen[email] = true
+				emails = append(emails, email)
+			}
+		}
+		if len(errs) > 0 {
+			writeError(w, r, errs)
+			return
+		}
+
+		diff, err := userRoles.SyncRoleMembers(r.Context(), utils.TenantFromContext(r.Context()), id, emails, dryRun)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		json.NewEncoder(w).Encode(diff)
+	}
+}
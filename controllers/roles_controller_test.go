@@ -0,0 +1,219 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"main/Models"
+	"main/listing"
+	"main/store"
+	"main/utils"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeleteRole(t *testing.T) {
+	testCases := []struct {
+		name         string
+		roleID       string
+		query        string
+		ifMatch      string
+		expectedCode int
+		grantsLeft   int
+	}{
+		{name: "restrict by default", roleID: "1", expectedCode: http.StatusConflict, grantsLeft: 1},
+		{name: "unused role", roleID: "2", query: "?assignments=restrict", expectedCode: http.StatusNoContent, grantsLeft: 1},
+		{name: "cascade", roleID: "1", query: "?assignments=cascade", expectedCode: http.StatusNoContent, grantsLeft: 0},
+		{name: "reassign", roleID: "1", query: "?assignments=reassign&replacement_role_id=2", expectedCode: http.StatusNoContent, grantsLeft: 1},
+		{name: "reassign to missing role", roleID: "1", query: "?assignments=reassign&replacement_role_id=9", expectedCode: http.StatusUnprocessableEntity, grantsLeft: 1},
+		{name: "reassign to itself", roleID: "1", query: "?assignments=reassign&replacement_role_id=1", expectedCode: http.StatusBadRequest, grantsLeft: 1},
+		{name: "unknown policy", roleID: "1", query: "?assignments=orphan", expectedCode: http.StatusBadRequest, grantsLeft: 1},
+		{name: "role not found", roleID: "99", expectedCode: http.StatusNotFound, grantsLeft: 1},
+		{name: "current version", roleID: "2", ifMatch: `"1"`, expectedCode: http.StatusNoContent, grantsLeft: 1},
+		{name: "stale version", roleID: "1", query: "?assignments=cascade", ifMatch: `"3"`, expectedCode: http.StatusPreconditionFailed, grantsLeft: 1},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := newUserRoleStore(t)
+
+			req := httptest.NewRequest("DELETE", "/roles/"+tc.roleID+tc.query, nil)
+			req = mux.SetURLVars(req, map[string]string{"id": tc.roleID})
+			if tc.ifMatch != "" {
+				req.Header.Set("If-Match", tc.ifMatch)
+			}
+			w := httptest.NewRecorder()
+
+			DeleteRole(s).ServeHTTP(w, req)
+
+			assert.Equal(t, tc.expectedCode, w.Code)
+			grants, _, err := s.ListUserRoles(context.Background(), utils.DefaultTenant, store.UserRoleFilter{}, listing.Params{Limit: listing.DefaultLimit, Sort: listing.Sort{Field: "id"}})
+			assert.NoError(t, err)
+			assert.Len(t, grants, tc.grantsLeft)
+		})
+	}
+}
+
+func TestPatchRole(t *testing.T) {
+	testCases := []struct {
+		name                string
+		currentKey          string
+		contentType         string
+		patch               string
+		ifMatch             string
+		expectedCode        int
+		expectedKey         string
+		expectedDescription string
+		expectedParents     []int
+	}{
+		{name: "description only", patch: `{"description": "Full access"}`, expectedCode: http.StatusOK, expectedKey: "admin", expectedDescription: "Full access", expectedParents: []int{2}},
+		{name: "plain json", contentType: "application/json", patch: `{"role_key": "root"}`, expectedCode: http.StatusOK, expectedKey: "root", expectedDescription: "admin", expectedParents: []int{2}},
+		{name: "null removes parents", patch: `{"parent_ids": null}`, expectedCode: http.StatusOK, expectedKey: "admin", expectedDescription: "admin", expectedParents: []int{}},
+		{name: "unchanged read-only field", patch: `{"id": 1, "description": "x"}`, expectedCode: http.StatusOK, expectedKey: "admin", expectedDescription: "x", expectedParents: []int{2}},
+		{name: "current version", patch: `{"description": "x"}`, ifMatch: `"2"`, expectedCode: http.StatusOK, expectedKey: "admin", expectedDescription: "x", expectedParents: []int{2}},
+		{name: "stale version", patch: `{"description": "x"}`, ifMatch: `"1"`, expectedCode: http.StatusPreconditionFailed},
+		{name: "null role_key", patch: `{"role_key": null}`, expectedCode: http.StatusUnprocessableEntity},
+		{name: "reserved role_key", patch: `{"role_key": "system"}`, expectedCode: http.StatusUnprocessableEntity},
+		{name: "changed read-only field", patch: `{"version": 7}`, expectedCode: http.StatusBadRequest},
+		{name: "unknown field", patch: `{"colour": "red"}`, expectedCode: http.StatusBadRequest},
+		{name: "not an object", patch: `["description"]`, expectedCode: http.StatusBadRequest},
+		{name: "wrong media type", contentType: "text/plain", patch: `{"description": "x"}`, expectedCode: http.StatusUnsupportedMediaType},
+		{name: "duplicate key", patch: `{"role_key": "viewer"}`, expectedCode: http.StatusConflict},
+		{name: "description only on a legacy key", currentKey: "Legacy_Admin", patch: `{"description": "x"}`, expectedCode: http.StatusOK, expectedKey: "Legacy_Admin", expectedDescription: "x", expectedParents: []int{2}},
+		{name: "invalid new key on a legacy key", currentKey: "Legacy_Admin", patch: `{"role_key": "Other_Admin"}`, expectedCode: http.StatusUnprocessableEntity},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := newUserRoleStore(t)
+			currentKey := tc.currentKey
+			if currentKey == "" {
+				currentKey = "admin"
+			}
+			_, err := s.UpdateRole(context.Background(), utils.DefaultTenant, 1, models.Role{RoleKey: currentKey, Description: "admin", ParentIDs: []int{2}})
+			require.NoError(t, err)
+
+			req := httptest.NewRequest("PATCH", "/roles/1", strings.NewReader(tc.patch))
+			contentType := tc.contentType
+			if contentType == "" {
+				contentType = utils.MergePatchContentType
+			}
+			req.Header.Set("Content-Type", contentType)
+			if tc.ifMatch != "" {
+				req.Header.Set("If-Match", tc.ifMatch)
+			}
+			req = mux.SetURLVars(req, map[string]string{"id": "1"})
+			w := httptest.NewRecorder()
+
+			PatchRole(s).ServeHTTP(w, req)
+
+			assert.Equal(t, tc.expectedCode, w.Code)
+			if tc.expectedCode != http.StatusOK {
+				return
+			}
+			var role models.Role
+			require.NoError(t, json.NewDecoder(w.Body).Decode(&role))
+			assert.Equal(t, tc.expectedKey, role.RoleKey)
+			assert.Equal(t, tc.expectedDescription, role.Description)
+			assert.Equal(t, tc.expectedParents, role.ParentIDs)
+			assert.Equal(t, 3, role.Version)
+			assert.Equal(t, `"3"`, w.Header().Get("ETag"))
+		})
+	}
+}
+
+func TestUpdateRole(t *testing.T) {
+	testCases := []struct {
+		name         string
+		roleID       string
+		body         string
+		expectedCode int
+	}{
+		{name: "unchanged legacy key", roleID: "1", body: `{"role_key": "Legacy_Admin", "description": "x"}`, expectedCode: http.StatusOK},
+		{name: "invalid new key", roleID: "1", body: `{"role_key": "Other_Admin"}`, expectedCode: http.StatusUnprocessableEntity},
+		{name: "valid new key", roleID: "1", body: `{"role_key": "root"}`, expectedCode: http.StatusOK},
+		{name: "role not found", roleID: "99", body: `{"role_key": "root"}`, expectedCode: http.StatusNotFound},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := newUserRoleStore(t)
+			_, err := s.UpdateRole(context.Background(), utils.DefaultTenant, 1, models.Role{RoleKey: "Legacy_Admin"})
+			require.NoError(t, err)
+
+			req := httptest.NewRequest("PUT", "/roles/"+tc.roleID, strings.NewReader(tc.body))
+			req = mux.SetURLVars(req, map[string]string{"id": tc.roleID})
+			w := httptest.NewRecorder()
+
+			UpdateRole(s).ServeHTTP(w, req)
+
+			assert.Equal(t, tc.expectedCode, w.Code)
+		})
+	}
+}
+
+func TestSyncRoleMembers(t *testing.T) {
+	testCases := []struct {
+		name         string
+		roleID       string
+		query        string
+		requestBody  string
+		expectedCode int
+		expectedDiff models.RoleMembersDiff
+		members      []string
+	}{
+		{name: "sync", roleID: "1", requestBody: `{"emails": ["b@example.com", "A@example.com", "a@example.com"]}`, expectedCode: http.StatusOK,
+			expectedDiff: models.RoleMembersDiff{RoleID: 1, Added: []string{"a@example.com", "b@example.com"}, Removed: []string{"test@example.com"}, Unchanged: []string{}},
+			members:      []string{"a@example.com", "b@example.com"}},
+		{name: "dry run", roleID: "1", query: "?dry_run=true", requestBody: `{"emails": ["a@example.com", "Test@example.com"]}`, expectedCode: http.StatusOK,
+			expectedDiff: models.RoleMembersDiff{RoleID: 1, DryRun: true, Added: []string{"a@example.com"}, Removed: []string{}, Unchanged: []string{"test@example.com"}},
+			members:      []string{"test@example.com"}},
+		{name: "no members", roleID: "1", requestBody: `{"emails": []}`, expectedCode: http.StatusOK,
+			expectedDiff: models.RoleMembersDiff{RoleID: 1, Added: []string{}, Removed: []string{"test@example.com"}, Unchanged: []string{}},
+			members:      []string{}},
+		{name: "invalid email", roleID: "1", requestBody: `{"emails": ["a@example.com", "nope"]}`, expectedCode: http.StatusUnprocessableEntity, members: []string{"test@example.com"}},
+		{name: "missing emails", roleID: "1", requestBody: `{}`, expectedCode: http.StatusBadRequest, members: []string{"test@example.com"}},
+		{name: "invalid dry_run", roleID: "1", query: "?dry_run=maybe", requestBody: `{"emails": []}`, expectedCode: http.StatusBadRequest, members: []string{"test@example.com"}},
+		{name: "role not found", roleID: "9", requestBody: `{"emails": []}`, expectedCode: http.StatusNotFound, members: []string{"test@example.com"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := newUserRoleStore(t)
+			ctx := context.Background()
+			// A scoped grant is not membership, and a sync leaves it alone.
+			_, err := s.CreateUserRole(ctx, models.UserRole{TenantID: utils.DefaultTenant, Email: "scoped@example.com", RoleID: 1, ResourceType: "project", ResourceID: "apollo"})
+			require.NoError(t, err)
+
+			req := httptest.NewRequest("PUT", "/roles/"+tc.roleID+"/members"+tc.query, strings.NewReader(tc.requestBody))
+			req = mux.SetURLVars(req, map[string]string{"id": tc.roleID})
+			w := httptest.NewRecorder()
+
+			SyncRoleMembers(s).ServeHTTP(w, req)
+
+			assert.Equal(t, tc.expectedCode, w.Code)
+			if tc.expectedCode == http.StatusOK {
+				var diff models.RoleMembersDiff
+				require.NoError(t, json.NewDecoder(w.Body).Decode(&diff))
+				assert.Equal(t, tc.expectedDiff, diff)
+			}
+
+			grants, _, err := s.ListUserRoles(ctx, utils.DefaultTenant, store.UserRoleFilter{RoleID: 1}, listing.Params{Limit: listing.DefaultLimit, Sort: listing.Sort{Field: "id"}})
+			require.NoError(t, err)
+			members := []string{}
+			for _, grant := range grants {
+				if grant.ResourceType == "" {
+					members = append(members, grant.Email)
+				} else {
+					assert.Equal(t, "scoped@example.com", grant.Email)
+				}
+			}
+			assert.Equal(t, tc.members, members)
+		})
+	}
+}
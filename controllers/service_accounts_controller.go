@@ -0,0 +1,256 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	models "main/Models"
+	"main/listing"
+	"main/store"
+	"main/utils"
+	"main/validation"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// keyPrefixLength is how much of an API key is kept in the clear, so callers
+// can tell their keys apart.
+const keyPrefixLength = len(utils.ServiceAccountKeyPrefix) + 8
+
+// GetServiceAccounts lists one page of the tenant's live service accounts,
+// filtered by name and sorted by id, name or created_at.
+func GetServiceAccounts(accounts store.ServiceAccountStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		page, err := listing.Parse(query, store.ServiceAccountSortFields, "id")
+		if err != nil {
+			badRequest(w, r, err.Error())
+			return
+		}
+
+		filter := store.ServiceAccountFilter{Name: query.Get("name")}
+		list, next, err := accounts.ListServiceAccounts(r.Context(), utils.TenantFromContext(r.Context()), filter, page)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		writeListing(w, r, models.ServiceAccountPage{ServiceAccounts: list, NextCursor: next})
+	}
+}
+
+// GetServiceAccount returns a service account with its version as ETag,
+// honouring If-None-Match.
+func GetServiceAccount(accounts store.ServiceAccountStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			badRequest(w, r, "invalid id")
+			return
+		}
+
+		account, err := accounts.GetServiceAccount(r.Context(), utils.TenantFromContext(r.Context()), id)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		writeVersioned(w, r, account.Version, account)
+	}
+}
+
+func CreateServiceAccount(accounts store.ServiceAccountStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var account models.ServiceAccount
+		if err := json.NewDecoder(r.Body).Decode(&account); err != nil {
+			badRequest(w, r, err.Error())
+			return
+		}
+		if err := validation.ServiceAccount(account); err != nil {
+			writeError(w, r, err)
+			return
+		}
+		account.TenantID = utils.TenantFromContext(r.Context())
+
+		account, err := accounts.CreateServiceAccount(r.Context(), account)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		w.Header().Set("ETag", utils.VersionETag(account.Version))
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(account)
+	}
+}
+
+// UpdateServiceAccount replaces a service account's name and display name.
+// With If-Match, it fails with 412 unless the account is still at that
+// version.
+func UpdateServiceAccount(accounts store.ServiceAccountStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			badRequest(w, r, "invalid id")
+			return
+		}
+		version, ok := ifMatchVersion(w, r)
+		if !ok {
+			return
+		}
+
+		var account models.ServiceAccount
+		if err := json.NewDecoder(r.Body).Decode(&account); err != nil {
+			badRequest(w, r, err.Error())
+			return
+		}
+		if err := validation.ServiceAccount(account); err != nil {
+			writeError(w, r, err)
+			return
+		}
+		account.Version = version
+
+		account, err = accounts.UpdateServiceAccount(r.Context(), utils.TenantFromContext(r.Context()), id, account)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		w.Header().Set("ETag", utils.VersionETag(account.Version))
+		json.NewEncoder(w).Encode(account)
+	}
+}
+
+// DeleteServiceAccount soft-deletes a service account, removes the user
+// roles granted to it and revokes its credentials. With If-Match, it fails
+// with 412 unless the account is still at that version.
+func DeleteServiceAccount(accounts store.ServiceAccountStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			badRequest(w, r, "invalid id")
+			return
+		}
+		version, ok := ifMatchVersion(w, r)
+		if !ok {
+			return
+		}
+
+		if err := accounts.DeleteServiceAccount(r.Context(), utils.TenantFromContext(r.Context()), id, version); err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// GetCredentials lists a service account's credentials, revoked and expired
+// ones included. Keys are never returned, only their prefixes.
+func GetCredentials(accounts store.ServiceAccountStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			badRequest(w, r, "invalid id")
+			return
+		}
+
+		credentials, err := accounts.ListCredentials(r.Context(), utils.TenantFromContext(r.Context()), id)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		json.NewEncoder(w).Encode(credentials)
+	}
+}
+
+// CreateCredential issues an additional API key for a service account. The
+// key is in the response and cannot be read again.
+func CreateCredential(accounts store.ServiceAccountStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			badRequest(w, r, "invalid id")
+			return
+		}
+
+		issueCredential(w, r, accounts, id, nil)
+	}
+}
+
+// RotateCredentials issues a new API key for a service account and retires
+// its other keys after grace_period_seconds, immediately by default, so
+// callers can switch keys without downtime.
+func RotateCredentials(accounts store.ServiceAccountStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			badRequest(w, r, "invalid id")
+			return
+		}
+
+		var rotation models.CredentialRotation
+		if err := json.NewDecoder(r.Body).Decode(&rotation); err != nil && !errors.Is(err, io.EOF) {
+			badRequest(w, r, err.Error())
+			return
+		}
+		if rotation.GracePeriodSeconds < 0 {
+			badRequest(w, r, "grace_period_seconds must not be negative")
+			return
+		}
+
+		gracePeriod := time.Duration(rotation.GracePeriodSeconds) * time.Second
+		issueCredential(w, r, accounts, id, &gracePeriod)
+	}
+}
+
+// issueCredential generates a key for a service account, stores its digest
+// and writes the credential, key included.
+func issueCredential(w http.ResponseWriter, r *http.Request, accounts store.ServiceAccountStore, id int, retireOthersAfter *time.Duration) {
+	key, err := utils.NewServiceAccountKey()
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	credential, err := accounts.CreateCredential(r.Context(), utils.TenantFromContext(r.Context()), models.ServiceAccountCredential{
+		ServiceAccountID: id,
+		KeyPrefix:        key[:keyPrefixLength],
+		KeyHash:          utils.HashAPIKey(key),
+	}, retireOthersAfter)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	credential.Key = key
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(credential)
+}
+
+func RevokeCredential(accounts store.ServiceAccountStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		id, err := strconv.Atoi(vars["id"])
+		if err != nil {
+			badRequest(w, r, "invalid id")
+			return
+		}
+		credentialID, err := strconv.Atoi(vars["credential_id"])
+		if err != nil {
+			badRequest(w, r, "invalid credential_id")
+			return
+		}
+
+		if err := accounts.RevokeCredential(r.Context(), utils.TenantFromContext(r.Context()), id, credentialID); err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
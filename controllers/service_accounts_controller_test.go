@@ -0,0 +1,115 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"main/Models"
+	"main/utils"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRotateCredentials(t *testing.T) {
+	s := newUserRoleStore(t)
+	ctx := context.Background()
+	account, err := s.CreateServiceAccount(ctx, models.ServiceAccount{TenantID: utils.DefaultTenant, Name: "ci-bot"})
+	require.NoError(t, err)
+	id := strconv.Itoa(account.ID)
+
+	issue := func(handler http.HandlerFunc, body string) (int, models.ServiceAccountCredential) {
+		req := httptest.NewRequest("POST", "/service-accounts/"+id+"/credentials", strings.NewReader(body))
+		req = mux.SetURLVars(req, map[string]string{"id": id})
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		var credential models.ServiceAccountCredential
+		if w.Code == http.StatusCreated {
+			require.NoError(t, json.NewDecoder(w.Body).Decode(&credential))
+		}
+		return w.Code, credential
+	}
+
+	code, first := issue(CreateCredential(s), "")
+	require.Equal(t, http.StatusCreated, code)
+	assert.True(t, strings.HasPrefix(first.Key, first.KeyPrefix))
+	assert.True(t, strings.HasPrefix(first.Key, utils.ServiceAccountKeyPrefix))
+	authenticated, err := s.AuthenticateCredential(ctx, utils.HashAPIKey(first.Key))
+	require.NoError(t, err)
+	assert.Equal(t, account.ID, authenticated.ID)
+
+	code, _ = issue(RotateCredentials(s), `{"grace_period_seconds": -1}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+	code, second := issue(RotateCredentials(s), "")
+	require.Equal(t, http.StatusCreated, code)
+	_, err = s.AuthenticateCredential(ctx, utils.HashAPIKey(first.Key))
+	assert.Error(t, err, "rotation without a grace period retires the old key at once")
+	_, err = s.AuthenticateCredential(ctx, utils.HashAPIKey(second.Key))
+	assert.NoError(t, err)
+
+	// Listing credentials never returns keys.
+	req := httptest.NewRequest("GET", "/service-accounts/"+id+"/credentials", nil)
+	req = mux.SetURLVars(req, map[string]string{"id": id})
+	w := httptest.NewRecorder()
+	GetCredentials(s).ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NotContains(t, w.Body.String(), second.Key)
+	assert.NotContains(t, w.Body.String(), utils.HashAPIKey(second.Key))
+}
+
+func TestGetPrincipalRoles(t *testing.T) {
+	s := newUserRoleStore(t)
+	ctx := context.Background()
+	account, err := s.CreateServiceAccount(ctx, models.ServiceAccount{TenantID: utils.DefaultTenant, Name: "ci-bot", DisplayName: "CI bot"})
+	require.NoError(t, err)
+	_, err = s.CreateUserRole(ctx, models.UserRole{TenantID: utils.DefaultTenant, ServiceAccountID: &account.ID, RoleID: 2})
+	require.NoError(t, err)
+	group, err := s.CreateGroup(ctx, models.Group{TenantID: utils.DefaultTenant, GroupKey: "platform"})
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name             string
+		principalType    string
+		id               string
+		expectedCode     int
+		expectedName     string
+		expectedRoleKeys []string
+	}{
+		{name: "user", principalType: "user", id: "Test@example.com", expectedCode: http.StatusOK, expectedName: "test@example.com", expectedRoleKeys: []string{"admin"}},
+		{name: "service account", principalType: "service", id: strconv.Itoa(account.ID), expectedCode: http.StatusOK, expectedName: "CI bot", expectedRoleKeys: []string{"viewer"}},
+		{name: "group without roles", principalType: "group", id: strconv.Itoa(group.ID), expectedCode: http.StatusOK, expectedName: "platform", expectedRoleKeys: []string{}},
+		{name: "missing service account", principalType: "service", id: "42", expectedCode: http.StatusNotFound},
+		{name: "non-numeric id", principalType: "group", id: "platform", expectedCode: http.StatusBadRequest},
+		{name: "invalid email", principalType: "user", id: "not-an-email", expectedCode: http.StatusBadRequest},
+		{name: "unknown type", principalType: "robot", id: "1", expectedCode: http.StatusBadRequest},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/principals/"+tc.principalType+"/"+tc.id+"/roles", nil)
+			req = mux.SetURLVars(req, map[string]string{"type": tc.principalType, "id": tc.id})
+			w := httptest.NewRecorder()
+
+			GetPrincipalRoles(s).ServeHTTP(w, req)
+
+			assert.Equal(t, tc.expectedCode, w.Code)
+			if tc.expectedCode == http.StatusOK {
+				var page models.PrincipalRoles
+				require.NoError(t, json.NewDecoder(w.Body).Decode(&page))
+				assert.Equal(t, tc.principalType, page.Principal.Type)
+				assert.Equal(t, tc.expectedName, page.Principal.DisplayName)
+				roleKeys := []string{}
+				for _, userRole := range page.UserRoles {
+					roleKeys = append(roleKeys, userRole.RoleKey)
+					assert.Equal(t, page.Principal.Type, userRole.Principal.Type)
+				}
+				assert.Equal(t, tc.expectedRoleKeys, roleKeys)
+			}
+		})
+	}
+}
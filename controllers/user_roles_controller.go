@@ -1,12 +1,18 @@
 package controllers
 
 import (
-	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	models "main/Models"
+	"main/authz"
+	"main/listing"
+	"main/store"
+	"main/utils"
+	"main/validation"
 	"strconv"
+	"strings"
 
 	"net/http"
 
@@ -14,238 +20,377 @@ import (
 )
 
 
-func GetUserRoles(db *sql.DB) http.HandlerFunc {
+// GetUserRoles lists one page of the tenant's live user roles, filtered by
+// email, email_prefix, email_domain, group_id, service_account_id, role_id,
+// role_key, resource and created_after/created_before, and sorted by id,
+// email or created_at.
+// The email filter also matches the grants of every group holding the email.
+// Deleted and expired grants are included with include_deleted=true.
+func GetUserRoles(userRoles store.UserRoleStore) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		email := r.URL.Query().Get("email")
-		fmt.Println("Email parameter:", email) // Debugging log
-
-		var rows *sql.Rows
-		var err error
-		var query string
+		query := r.URL.Query()
+		page, err := listing.Parse(query, store.UserRoleSortFields, "id")
+		if err != nil {
+			badRequest(w, r, err.Error())
+			return
+		}
 
-		query = `SELECT user_roles.id, user_roles.email, user_roles.role_id, user_roles.created_at, user_roles.updated_at,
-        user_roles.deleted_at, roles.role_key 
-		FROM user_roles 
-		LEFT JOIN roles ON user_roles.role_id = roles.id  
-		WHERE user_roles.deleted_at IS NULL`
+		filter := store.UserRoleFilter{
+			Email:       strings.ToLower(query.Get("email")),
+			EmailPrefix: strings.ToLower(query.Get("email_prefix")),
+			EmailDomain: strings.ToLower(query.Get("email_domain")),
+			RoleKey:     query.Get("role_key"),
+		}
 
-		if email != "" {
-			query += ` AND user_roles.email = $1`
-			rows, err = db.Query(query, email)
-		} else {
-			rows, err = db.Query(query)
+		if value := query.Get("role_id"); value != "" {
+			if filter.RoleID, err = strconv.Atoi(value); err != nil {
+				badRequest(w, r, "invalid role_id")
+				return
+			}
 		}
-		if err != nil {
-			http.Error(w, "Error fetching user roles: "+err.Error(), http.StatusInternalServerError)
+		if value := query.Get("group_id"); value != "" {
+			if filter.GroupID, err = strconv.Atoi(value); err != nil {
+				badRequest(w, r, "invalid group_id")
+				return
+			}
+		}
+		if value := query.Get("service_account_id"); value != "" {
+			if filter.ServiceAccountID, err = strconv.Atoi(value); err != nil {
+				badRequest(w, r, "invalid service_account_id")
+				return
+			}
+		}
+		if filter.CreatedAfter, filter.CreatedBefore, err = parseCreatedRange(query); err != nil {
+			badRequest(w, r, err.Error())
 			return
 		}
-		defer rows.Close()
-
-		userRoles := []models.UserRole{}
-		for rows.Next() {
-			var userRole models.UserRole
-			if err := rows.Scan(&userRole.ID, &userRole.Email, &userRole.RoleID, &userRole.CreatedAt,
-				&userRole.UpdatedAt, &userRole.DeletedAt, &userRole.RoleKey); err != nil {
-				http.Error(w, "Error scanning user roles: "+err.Error(), http.StatusInternalServerError)
+		if filter.IncludeDeleted, err = parseIncludeDeleted(query); err != nil {
+			badRequest(w, r, err.Error())
+			return
+		}
+		// Only return assignments whose scope covers the requested resource.
+		if resource := query.Get("resource"); resource != "" {
+			resourceType, resourceID, err := authz.ParseResource(resource)
+			if err != nil {
+				badRequest(w, r, err.Error())
 				return
 			}
-			userRoles = append(userRoles, userRole)
+			filter.ResourceType, filter.ResourceID = resourceType, resourceID
 		}
 
-		if err := rows.Err(); err != nil {
-			http.Error(w, "Error iterating user roles: "+err.Error(), http.StatusInternalServerError)
+		list, next, err := userRoles.ListUserRoles(r.Context(), utils.TenantFromContext(r.Context()), filter, page)
+		if err != nil {
+			writeError(w, r, err)
 			return
 		}
 
-		fmt.Println("Number of roles found:", len(userRoles)) // Debugging log
-		fmt.Println("User roles:", userRoles)                 // Debugging log
-		json.NewEncoder(w).Encode(userRoles)
+		writeListing(w, r, models.UserRolePage{UserRoles: list, NextCursor: next})
 	}
 }
 
 
-func GetUserRole(db *sql.DB) http.HandlerFunc {
+func GetUserRole(userRoles store.UserRoleStore) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		vars := mux.Vars(r)
-		id := vars["id"]
-
-		var userRole models.UserRole
-		err := db.QueryRow(`
-            SELECT user_roles.id, user_roles.email, user_roles.role_id, user_roles.created_at, user_roles.updated_at, 
-                user_roles.deleted_at, roles.role_key 
-            FROM user_roles
-            LEFT JOIN roles ON user_roles.role_id = roles.id
-            WHERE user_roles.id = $1 AND user_roles.deleted_at IS NULL`, id).
-			Scan(&userRole.ID, &userRole.Email, &userRole.RoleID, &userRole.CreatedAt,
-				&userRole.UpdatedAt, &userRole.DeletedAt, &userRole.RoleKey)
+		id, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			badRequest(w, r, "invalid id")
+			return
+		}
 
+		userRole, err := userRoles.GetUserRole(r.Context(), utils.TenantFromContext(r.Context()), id)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			writeError(w, r, err)
 			return
 		}
 
-		json.NewEncoder(w).Encode(userRole)
+		writeVersioned(w, r, userRole.Version, userRole)
 	}
 }
 
 
 
-func CreateUserRole(db *sql.DB) http.HandlerFunc {
-    return func(w http.ResponseWriter, r *http.Request) {
-        var userRole models.UserRole
-        
-        // Decode JSON body
-        if err := json.NewDecoder(r.Body).Decode(&userRole); err != nil {
-            http.Error(w, "Invalid JSON format", http.StatusBadRequest)
-            return
-        }
-
-        // Check if role exists and is not deleted
-        var exists bool
-        err := db.QueryRow("SELECT EXISTS (SELECT 1 FROM roles WHERE id = $1 AND deleted_at IS NULL)", userRole.RoleID).Scan(&exists)
-        if err != nil {
-            http.Error(w, "Database error while checking role", http.StatusInternalServerError)
-            return
-        }
-        if !exists {
-            http.Error(w, "Role is either deleted or does not exist", http.StatusBadRequest)
-            return
-        }
-
-        // Insert the user role
-        err = db.QueryRow("INSERT INTO user_roles (email, role_id) VALUES ($1, $2) RETURNING id, created_at, updated_at",
-            userRole.Email, userRole.RoleID).Scan(&userRole.ID, &userRole.CreatedAt, &userRole.UpdatedAt)
-
-        if err != nil {
-            http.Error(w, "Database error while inserting user role", http.StatusInternalServerError)
-            return
-        }
-
-        // Return 201 Created status with the new user role
-        w.WriteHeader(http.StatusCreated)
-        json.NewEncoder(w).Encode(userRole)
-    }
+func CreateUserRole(userRoles store.UserRoleStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var userRole models.UserRole
+
+		// Decode JSON body
+		if err := json.NewDecoder(r.Body).Decode(&userRole); err != nil {
+			badRequest(w, r, "Invalid JSON format")
+			return
+		}
+		if err := validation.UserRole(&userRole); err != nil {
+			writeError(w, r, err)
+			return
+		}
+		userRole.TenantID = utils.TenantFromContext(r.Context())
+
+		userRole, err := userRoles.CreateUserRole(r.Context(), userRole)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		// Return 201 Created status with the new user role
+		w.Header().Set("ETag", utils.VersionETag(userRole.Version))
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(userRole)
+	}
 }
 
-// func CreateUserRole(db *sql.DB) http.HandlerFunc {
-// 	return func(w http.ResponseWriter, r *http.Request) {
-// 		var userRole models.UserRole
-// 		if err := json.NewDecoder(r.Body).Decode(&userRole); err != nil {
-// 			http.Error(w, err.Error(), http.StatusBadRequest)
-// 			return
-// 		}
-
-// 		// Check if the role exists and is not deleted
-// 		var exists bool
-// 		err := db.QueryRow("SELECT EXISTS (SELECT 1 FROM roles WHERE id = $1 AND deleted_at IS NULL)", userRole.RoleID).Scan(&exists)
-// 		if err != nil {
-// 			http.Error(w, "Error validating role ID", http.StatusInternalServerError)
-// 			return
-// 		}
-// 		if !exists {
-// 			http.Error(w, "Role is either deleted or does not exist", http.StatusBadRequest)
-// 			return
-// 		}
-
-// 		// Insert the user role
-// 		err = db.QueryRow("INSERT INTO user_roles (email, role_id) VALUES ($1, $2) RETURNING id, created_at, updated_at", userRole.Email, userRole.RoleID).
-// 			Scan(&userRole.ID, &userRole.CreatedAt, &userRole.UpdatedAt)
-// 		if err != nil {
-// 			http.Error(w, err.Error(), http.StatusInternalServerError)
-// 			return
-// 		}
-
-// 		json.NewEncoder(w).Encode(userRole)
-// 	}
-// }
-
-func UpdateUserRole(db *sql.DB) http.HandlerFunc {
+func UpdateUserRole(userRoles store.UserRoleStore) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		vars := mux.Vars(r)
-		id := vars["id"]
+		id, err := strconv.Atoi(vars["id"])
+		if err != nil {
+			badRequest(w, r, "invalid id")
+			return
+		}
+		version, ok := ifMatchVersion(w, r)
+		if !ok {
+			return
+		}
 
 		var userRole models.UserRole
 		if err := json.NewDecoder(r.Body).Decode(&userRole); err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+			badRequest(w, r, err.Error())
+			return
+		}
+		userRole.Version = version
+		if err := validation.UserRole(&userRole); err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		userRole, err = userRoles.UpdateUserRole(r.Context(), utils.TenantFromContext(r.Context()), id, userRole)
+		if err != nil {
+			writeError(w, r, err)
 			return
 		}
 
-		// Check if the role exists and is not deleted
-		var exists bool
-		err := db.QueryRow("SELECT EXISTS (SELECT 1 FROM roles WHERE id = $1 AND deleted_at IS NULL)", userRole.RoleID).Scan(&exists)
+		w.Header().Set("ETag", utils.VersionETag(userRole.Version))
+		json.NewEncoder(w).Encode(userRole)
+	}
+}
+
+// userRoleReadOnlyFields are the user role fields a PATCH cannot change.
+var userRoleReadOnlyFields = []string{"id", "tenant_id", "role_key", "source", "principal", "created_at", "updated_at", "deleted_at", "deleted_reason", "version"}
+
+// PatchUserRole applies an RFC 7396 merge patch to a user role and returns
+// the user role as stored afterwards. With If-Match, it fails with 412 unless
+// the user role is still at that version.
+func PatchUserRole(userRoles store.UserRoleStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			badRequest(w, r, "invalid id")
+			return
+		}
+		version, ok := ifMatchVersion(w, r)
+		if !ok {
+			return
+		}
+		patch, ok := readMergePatch(w, r)
+		if !ok {
+			return
+		}
+		tenant := utils.TenantFromContext(r.Context())
+
+		for attempt := 1; ; attempt++ {
+			current, err := userRoles.GetUserRole(r.Context(), tenant, id)
+			if err != nil {
+				writeError(w, r, err)
+				return
+			}
+
+			var userRole models.UserRole
+			if err := applyMergePatch(current, patch, userRoleReadOnlyFields, &userRole); err != nil {
+				badRequest(w, r, err.Error())
+				return
+			}
+			if err := validation.UserRole(&userRole); err != nil {
+				writeError(w, r, err)
+				return
+			}
+
+			// Without If-Match, patch the version just read and start over
+			// if another write got in first.
+			userRole.Version = version
+			if version == 0 {
+				userRole.Version = current.Version
+			}
+			_, err = userRoles.UpdateUserRole(r.Context(), tenant, id, userRole)
+			if errors.Is(err, store.ErrVersionMismatch) && version == 0 && attempt < patchAttempts {
+				continue
+			}
+			if err != nil {
+				writeError(w, r, err)
+				return
+			}
+			break
+		}
+
+		userRole, err := userRoles.GetUserRole(r.Context(), tenant, id)
 		if err != nil {
-			http.Error(w, "Error validating role ID", http.StatusInternalServerError)
+			writeError(w, r, err)
 			return
 		}
+		w.Header().Set("ETag", utils.VersionETag(userRole.Version))
+		json.NewEncoder(w).Encode(userRole)
+	}
+}
+
+func DeleteUserRole(userRoles store.UserRoleStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		idStr, exists := vars["id"]
 		if !exists {
-			http.Error(w, "Role is either deleted or does not exist", http.StatusBadRequest)
+			badRequest(w, r, "missing id")
 			return
 		}
 
-		// Update the user role
-		_, err = db.Exec("UPDATE user_roles SET email = $1, role_id = $2, updated_at = CURRENT_TIMESTAMP WHERE id = $3 AND deleted_at IS NULL",
-			userRole.Email, userRole.RoleID, id)
+		// Convert ID to integer
+		id, err := strconv.Atoi(idStr)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			log.Printf("Invalid ID: %v", err)
+			badRequest(w, r, "invalid id")
+			return
+		}
+		version, ok := ifMatchVersion(w, r)
+		if !ok {
 			return
 		}
 
-		json.NewEncoder(w).Encode(userRole)
+		// Soft delete
+		if err := userRoles.DeleteUserRole(r.Context(), utils.TenantFromContext(r.Context()), id, version); err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent) // 204 success
 	}
 }
-func DeleteUserRole(db *sql.DB) http.HandlerFunc {
-    return func(w http.ResponseWriter, r *http.Request) {
-        vars := mux.Vars(r)
-        idStr, exists := vars["id"]
-        if !exists {
-            http.Error(w, "missing id", http.StatusBadRequest)
-            return
-        }
-
-        // Convert ID to integer
-        id, err := strconv.Atoi(idStr)
-        if err != nil {
-            log.Printf("Invalid ID: %v", err)
-            http.Error(w, "invalid id", http.StatusBadRequest)
-            return
-        }
-
-        // Execute soft delete query
-        res, err := db.Exec("UPDATE user_roles SET deleted_at = CURRENT_TIMESTAMP WHERE id = $1", id)
-        if err != nil {
-            log.Printf("Database error: %v", err)  // Log error
-            http.Error(w, "database error", http.StatusInternalServerError)
-            return
-        }
-
-        rowsAffected, err := res.RowsAffected()
-        if err != nil {
-            log.Printf("Error getting rows affected: %v", err)
-            http.Error(w, "server error", http.StatusInternalServerError)
-            return
-        }
-
-        if rowsAffected == 0 {
-            http.Error(w, "user role not found", http.StatusNotFound)
-            return
-        }
-
-        w.WriteHeader(http.StatusNoContent) // 204 success
-    }
+
+// RestoreUserRole undeletes a soft-deleted user role and returns it. Grants
+// whose validity window has ended cannot be restored; create them again.
+func RestoreUserRole(userRoles store.UserRoleStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			badRequest(w, r, "invalid id")
+			return
+		}
+
+		userRole, err := userRoles.RestoreUserRole(r.Context(), utils.TenantFromContext(r.Context()), id)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		w.Header().Set("ETag", utils.VersionETag(userRole.Version))
+		json.NewEncoder(w).Encode(userRole)
+	}
 }
 
-// func DeleteUserRole(db *sql.DB) http.HandlerFunc {
-// 	return func(w http.ResponseWriter, r *http.Request) {
-// 		vars := mux.Vars(r)
-// 		id := vars["id"]
+// maxBatchItems bounds the grants and revocations of one batch request.
+const maxBatchItems = 500
+
+// BatchUserRoles grants and revokes many user roles in one request. An
+// atomic batch applies every item or, on the first failing item, none and
+// responds with that item's error. Otherwise every item is tried and gets
+// its own result.
+func BatchUserRoles(userRoles store.UserRoleStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var batch models.UserRoleBatch
+		if err := json.NewDecoder(r.Body).Decode(&batch); err != nil {
+			badRequest(w, r, "Invalid JSON format")
+			return
+		}
+		items := len(batch.Grants) + len(batch.Revocations)
+		if items == 0 {
+			badRequest(w, r, "batch has no grants or revocations")
+			return
+		}
+		if items > maxBatchItems {
+			badRequest(w, r, fmt.Sprintf("batch has more than %d items", maxBatchItems))
+			return
+		}
+
+		// Invalid grants never reach the store; the valid ones are sent on
+		// in order and matched back to their index afterwards.
+		invalid := map[int]error{}
+		valid := models.UserRoleBatch{Atomic: batch.Atomic, Grants: []models.UserRole{}, Revocations: batch.Revocations}
+		for i := range batch.Grants {
+			if err := validation.UserRole(&batch.Grants[i]); err != nil {
+				if batch.Atomic {
+					writeBatchItemError(w, r, &store.BatchItemError{Op: store.BatchGrant, Index: i, Err: err})
+					return
+				}
+				invalid[i] = err
+				continue
+			}
+			valid.Grants = append(valid.Grants, batch.Grants[i])
+		}
+
+		stored, err := userRoles.BatchUserRoles(r.Context(), utils.TenantFromContext(r.Context()), valid)
+		var itemErr *store.BatchItemError
+		if errors.As(err, &itemErr) {
+			if itemErr.Op == store.BatchGrant {
+				itemErr.Index = grantIndex(itemErr.Index, invalid)
+			}
+			writeBatchItemError(w, r, itemErr)
+			return
+		}
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		results := make([]models.UserRoleBatchResult, 0, items)
+		for i := range batch.Grants {
+			if err := invalid[i]; err != nil {
+				results = append(results, batchResult(r, store.BatchGrant, i, http.StatusCreated, store.BatchResult{Err: err}))
+				continue
+			}
+			results = append(results, batchResult(r, store.BatchGrant, i, http.StatusCreated, stored[0]))
+			stored = stored[1:]
+		}
+		for i := range batch.Revocations {
+			results = append(results, batchResult(r, store.BatchRevoke, i, http.StatusOK, stored[i]))
+		}
 
-// 		_, err := db.Exec("UPDATE user_roles SET deleted_at = CURRENT_TIMESTAMP WHERE id = $1", id)
-// 		if err != nil {
-// 			http.Error(w, err.Error(), http.StatusInternalServerError)
-// 			return
-// 		}
+		json.NewEncoder(w).Encode(models.UserRoleBatchResponse{Results: results})
+	}
+}
 
-// 		w.WriteHeader(http.StatusNoContent)
-// 	}
-// }
+// grantIndex maps the index of a grant among the valid grants back to its
+// index in the request.
+func grantIndex(validIndex int, invalid map[int]error) int {
+	for i := 0; ; i++ {
+		if invalid[i] != nil {
+			continue
+		}
+		if validIndex == 0 {
+			return i
+		}
+		validIndex--
+	}
+}
+
+func batchResult(r *http.Request, op string, index, status int, result store.BatchResult) models.UserRoleBatchResult {
+	if result.Err != nil {
+		status, body := errorResponse(r, result.Err)
+		return models.UserRoleBatchResult{Op: op, Index: index, Status: status, Error: &body}
+	}
+	return models.UserRoleBatchResult{Op: op, Index: index, Status: status, UserRole: &result.UserRole}
+}
 
+// writeBatchItemError responds with the error of the item an atomic batch
+// failed on, naming the item in the message.
+func writeBatchItemError(w http.ResponseWriter, r *http.Request, itemErr *store.BatchItemError) {
+	status, body := errorResponse(r, itemErr.Err)
+	field := "grants"
+	if itemErr.Op == store.BatchRevoke {
+		field = "revocations"
+	}
+	utils.WriteError(w, r, status, body.Code, fmt.Sprintf("%s[%d]: %s", field, itemErr.Index, body.Message), body.Details)
+}
@@ -1,457 +1,472 @@
 package controllers
 
 import (
-	"database/sql"
-	"database/sql/driver"
+	"context"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"main/Models"
+	"main/audit"
+	"main/listing"
+	"main/store"
+	"main/utils"
 	"net/http"
 	"net/http/httptest"
-	"regexp"
 	"strings"
 	"testing"
 	"time"
 
-	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/gorilla/mux"
 	"github.com/stretchr/testify/assert"
-	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
 )
 
-type Database interface {
-	Query(query string, args ...interface{}) (*sql.Rows, error)
-}
-
-type MockDB struct {
-	mock.Mock
-}
-
-// Implement Query method for MockDB
-func (m *MockDB) Query(query string, args ...interface{}) (*sql.Rows, error) {
-	argsList := m.Called(append([]interface{}{query}, args...)...)
-	if result, ok := argsList.Get(0).(*sql.Rows); ok {
-		return result, argsList.Error(1)
+// newUserRoleStore returns an in-memory store holding the roles admin (1)
+// and viewer (2), and one grant of admin to test@example.com (1).
+func newUserRoleStore(t *testing.T) *store.Memory {
+	s := store.NewMemory()
+	ctx := context.Background()
+	for _, key := range []string{"admin", "viewer"} {
+		_, err := s.CreateRole(ctx, models.Role{TenantID: utils.DefaultTenant, RoleKey: key, Description: key})
+		require.NoError(t, err)
 	}
-	return nil, argsList.Error(1)
+	_, err := s.CreateUserRole(ctx, models.UserRole{TenantID: utils.DefaultTenant, Email: "test@example.com", RoleID: 1})
+	require.NoError(t, err)
+	return s
 }
 
 func TestGetUserRoles(t *testing.T) {
-	type testCase struct {
-		name        string
-		email       string
-		mockData    [][]interface{}
-		expectedLen int
-		mockError   error
+	s := newUserRoleStore(t)
+	ctx := context.Background()
+	inAnHour := time.Now().Add(time.Hour)
+	for _, userRole := range []models.UserRole{
+		{TenantID: utils.DefaultTenant, Email: "user@example.com", RoleID: 2, ResourceType: "project", ResourceID: "42", ValidUntil: &inAnHour},
+		{TenantID: utils.DefaultTenant, Email: "user@example.com", RoleID: 2, ResourceType: "project", ResourceID: "team-a/*"},
+		{TenantID: "billing", Email: "test@example.com", RoleID: 2},
+	} {
+		if userRole.TenantID == "billing" {
+			_, err := s.CreateRole(ctx, models.Role{TenantID: "billing", RoleKey: "viewer"})
+			require.NoError(t, err)
+			userRole.RoleID = 3
+		}
+		_, err := s.CreateUserRole(ctx, userRole)
+		require.NoError(t, err)
 	}
 
-	testCases := []testCase{
-		{
-			name:  "success - email filter",
-			email: "test@example.com",
-			mockData: [][]interface{}{
-				{1, "test@example.com", 2, time.Now(), time.Now(), nil, "admin"},
-			},
-			expectedLen: 1,
-		},
-		{
-			name:  "success - all users",
-			email: "",
-			mockData: [][]interface{}{
-				{1, "test@example.com", 2, time.Now(), time.Now(), nil, "admin"},
-				{2, "user@example.com", 3, time.Now(), time.Now(), nil, "user"},
-			},
-			expectedLen: 2,
-		},
-		{
-			name:        "no users found",
-			email:       "notfound@example.com",
-			mockData:    [][]interface{}{}, // No data
-			expectedLen: 0,
-		},
-		{
-			name:        "database error",
-			email:       "",
-			mockData:    nil,
-			expectedLen: 0,
-			mockError:   errors.New("database error"),
-		},
+	testCases := []struct {
+		name         string
+		query        string
+		expectedCode int
+		expectedIDs  []int
+	}{
+		{name: "all user roles in tenant", query: "", expectedCode: http.StatusOK, expectedIDs: []int{1, 2, 3}},
+		{name: "email filter", query: "?email=test@example.com", expectedCode: http.StatusOK, expectedIDs: []int{1}},
+		{name: "no users found", query: "?email=notfound@example.com", expectedCode: http.StatusOK, expectedIDs: []int{}},
+		{name: "resource filter", query: "?resource=project:team-a/7", expectedCode: http.StatusOK, expectedIDs: []int{1, 3}},
+		{name: "invalid resource", query: "?resource=project", expectedCode: http.StatusBadRequest},
+		{name: "email domain", query: "?email_domain=example.com&email_prefix=user", expectedCode: http.StatusOK, expectedIDs: []int{2, 3}},
+		{name: "role key", query: "?role_key=admin", expectedCode: http.StatusOK, expectedIDs: []int{1}},
+		{name: "role id", query: "?role_id=2", expectedCode: http.StatusOK, expectedIDs: []int{2, 3}},
+		{name: "created before", query: "?created_before=2000-01-01T00:00:00Z", expectedCode: http.StatusOK, expectedIDs: []int{}},
+		{name: "sorted by email descending", query: "?sort=-email", expectedCode: http.StatusOK, expectedIDs: []int{3, 2, 1}},
+		{name: "sort not allowed", query: "?sort=resource_id", expectedCode: http.StatusBadRequest},
+		{name: "include deleted", query: "?include_deleted=true", expectedCode: http.StatusOK, expectedIDs: []int{1, 2, 3}},
+		{name: "invalid include_deleted", query: "?include_deleted=maybe", expectedCode: http.StatusBadRequest},
 	}
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			db, mock, err := sqlmock.New()
-			assert.NoError(t, err)
-
-			// Define expected query behavior
-			query := `SELECT user_roles.id, user_roles.email, user_roles.role_id, user_roles.created_at, user_roles.updated_at,
-				user_roles.deleted_at, roles.role_key 
-				FROM user_roles 
-				LEFT JOIN roles ON user_roles.role_id = roles.id  
-				WHERE user_roles.deleted_at IS NULL`
-
-			if tc.email != "" {
-				query += ` AND user_roles.email = ?`
-			}
+			req := httptest.NewRequest("GET", "/user-roles"+tc.query, nil)
+			w := httptest.NewRecorder()
 
-			if tc.mockError != nil {
-				mock.ExpectQuery(query).WillReturnError(tc.mockError)
-			} else {
-				rows := sqlmock.NewRows([]string{"id", "email", "role_id", "created_at", "updated_at", "deleted_at", "role_key"})
-				for _, row := range tc.mockData {
-					// Convert row to driver.Values and add to rows
-					var values []driver.Value
-					for _, v := range row {
-						values = append(values, v)
-					}
-					rows.AddRow(values...)
+			GetUserRoles(s).ServeHTTP(w, req)
+
+			assert.Equal(t, tc.expectedCode, w.Code)
+			if tc.expectedCode == http.StatusOK {
+				var page models.UserRolePage
+				assert.NoError(t, json.NewDecoder(w.Body).Decode(&page))
+				ids := []int{}
+				for _, userRole := range page.UserRoles {
+					ids = append(ids, userRole.ID)
 				}
-				mock.ExpectQuery(query).WillReturnRows(rows)
+				assert.Equal(t, tc.expectedIDs, ids)
 			}
+		})
+	}
+}
+
+func TestGetUserRole(t *testing.T) {
+	s := newUserRoleStore(t)
+
+	testCases := []struct {
+		name         string
+		userID       string
+		tenant       string
+		ifNoneMatch  string
+		expectedCode int
+	}{
+		{name: "success - valid user", userID: "1", expectedCode: http.StatusOK},
+		{name: "not modified", userID: "1", ifNoneMatch: `"1"`, expectedCode: http.StatusNotModified},
+		{name: "modified since", userID: "1", ifNoneMatch: `"7", W/"abc"`, expectedCode: http.StatusOK},
+		{name: "user not found", userID: "99", expectedCode: http.StatusNotFound},
+		{name: "other tenant", userID: "1", tenant: "billing", expectedCode: http.StatusNotFound},
+		{name: "invalid id", userID: "abc", expectedCode: http.StatusBadRequest},
+	}
 
-			// Create test HTTP request
-			url := "/roles"
-			if tc.email != "" {
-				url += "?email=" + tc.email
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/user-roles/"+tc.userID, nil)
+			req = mux.SetURLVars(req, map[string]string{"id": tc.userID})
+			if tc.tenant != "" {
+				req = req.WithContext(utils.WithTenant(req.Context(), tc.tenant))
+			}
+			if tc.ifNoneMatch != "" {
+				req.Header.Set("If-None-Match", tc.ifNoneMatch)
 			}
-			req := httptest.NewRequest("GET", url, nil)
 			w := httptest.NewRecorder()
 
-			// Call the handler
-			handler := GetUserRoles(db)
-			handler.ServeHTTP(w, req)
+			GetUserRole(s).ServeHTTP(w, req)
 
-			// Assert HTTP response
-			if tc.mockError != nil {
-				assert.Equal(t, http.StatusInternalServerError, w.Code)
-			} else {
-				assert.Equal(t, http.StatusOK, w.Code)
+			assert.Equal(t, tc.expectedCode, w.Code)
+			if tc.expectedCode == http.StatusNotModified {
+				assert.Empty(t, w.Body.String())
+			}
+			if tc.expectedCode == http.StatusOK {
+				assert.Equal(t, `"1"`, w.Header().Get("ETag"))
+				var userRole models.UserRole
+				assert.NoError(t, json.NewDecoder(w.Body).Decode(&userRole))
+				assert.Equal(t, "test@example.com", userRole.Email)
+				assert.Equal(t, "admin", userRole.RoleKey)
+			}
+		})
+	}
+}
+
+func TestCreateUserRole(t *testing.T) {
+	testCases := []struct {
+		name         string
+		requestBody  string
+		expectedCode int
+	}{
+		{name: "success - valid request", requestBody: `{"email": "new@example.com", "role_id": 2}`, expectedCode: http.StatusCreated},
+		{name: "success - conditional grant", requestBody: `{"email": "new@example.com", "role_id": 2, "condition": "amount < 1000"}`, expectedCode: http.StatusCreated},
+		{name: "failure - role does not exist", requestBody: `{"email": "new@example.com", "role_id": 9}`, expectedCode: http.StatusUnprocessableEntity},
+		{name: "failure - invalid condition", requestBody: `{"email": "new@example.com", "role_id": 2, "condition": "amount <"}`, expectedCode: http.StatusUnprocessableEntity},
+		{name: "failure - invalid JSON", requestBody: `{"email": "new@example.com", "role_id":}`, expectedCode: http.StatusBadRequest},
+		{name: "failure - grant already expired", requestBody: `{"email": "new@example.com", "role_id": 2, "valid_until": "2020-01-01T00:00:00Z"}`, expectedCode: http.StatusUnprocessableEntity},
+		{name: "failure - invalid scope", requestBody: `{"email": "new@example.com", "role_id": 2, "resource_type": "project"}`, expectedCode: http.StatusUnprocessableEntity},
+		{name: "failure - invalid email", requestBody: `{"email": "Jane <new@example.com>", "role_id": 2}`, expectedCode: http.StatusUnprocessableEntity},
+		{name: "failure - email and group", requestBody: `{"email": "new@example.com", "group_id": 1, "role_id": 2}`, expectedCode: http.StatusUnprocessableEntity},
+		{name: "failure - group does not exist", requestBody: `{"group_id": 9, "role_id": 2}`, expectedCode: http.StatusUnprocessableEntity},
+		{name: "failure - duplicate grant in another case", requestBody: `{"email": "Test@Example.com", "role_id": 1}`, expectedCode: http.StatusConflict},
+		{name: "failure - duplicate grant", requestBody: `{"email": "test@example.com", "role_id": 1}`, expectedCode: http.StatusConflict},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := newUserRoleStore(t)
+			events := len(s.Events())
 
-				// Decode response
-				var roles []models.UserRole
-				json.NewDecoder(w.Body).Decode(&roles)
-				assert.Len(t, roles, tc.expectedLen)
+			req := httptest.NewRequest("POST", "/user-roles", strings.NewReader(tc.requestBody))
+			req.Header.Set("Content-Type", "application/json")
+			req = req.WithContext(utils.WithActor(req.Context(), "alice@example.com"))
+			w := httptest.NewRecorder()
+
+			CreateUserRole(s).ServeHTTP(w, req)
+
+			assert.Equal(t, tc.expectedCode, w.Code)
+			if tc.expectedCode != http.StatusCreated {
+				assert.Len(t, s.Events(), events, "failed writes are not audited")
+				return
 			}
 
-			// Ensure all expectations were met
-			assert.NoError(t, mock.ExpectationsWereMet())
+			var userRole models.UserRole
+			assert.NoError(t, json.NewDecoder(w.Body).Decode(&userRole))
+			assert.Equal(t, 2, userRole.ID)
+			assert.Equal(t, "viewer", userRole.RoleKey)
+
+			event := s.Events()[len(s.Events())-1]
+			assert.Equal(t, audit.ActionCreate, event.Action)
+			assert.Equal(t, audit.EntityUserRole, event.EntityType)
+			assert.Equal(t, "alice@example.com", event.Actor)
+			assert.Nil(t, event.Before)
 		})
 	}
 }
 
-// Test GetUserRole
+func TestUpdateUserRole(t *testing.T) {
+	testCases := []struct {
+		name         string
+		userID       string
+		ifMatch      string
+		requestBody  string
+		expectedCode int
+	}{
+		{name: "success - valid request", userID: "1", requestBody: `{"email": "updated@example.com", "role_id": 2}`, expectedCode: http.StatusOK},
+		{name: "success - current version", userID: "1", ifMatch: `"1"`, requestBody: `{"email": "updated@example.com", "role_id": 2}`, expectedCode: http.StatusOK},
+		{name: "failure - stale version", userID: "1", ifMatch: `"0"`, requestBody: `{"email": "updated@example.com", "role_id": 2}`, expectedCode: http.StatusPreconditionFailed},
+		{name: "failure - weak etag", userID: "1", ifMatch: `W/"1"`, requestBody: `{"email": "updated@example.com", "role_id": 2}`, expectedCode: http.StatusPreconditionFailed},
+		{name: "failure - several etags", userID: "1", ifMatch: `"1", "2"`, requestBody: `{"email": "updated@example.com", "role_id": 2}`, expectedCode: http.StatusBadRequest},
+		{name: "failure - version in body is ignored", userID: "1", ifMatch: `"2"`, requestBody: `{"email": "updated@example.com", "role_id": 2, "version": 2}`, expectedCode: http.StatusPreconditionFailed},
+		{name: "failure - role does not exist", userID: "1", requestBody: `{"email": "updated@example.com", "role_id": 99}`, expectedCode: http.StatusUnprocessableEntity},
+		{name: "failure - user role not found", userID: "99", requestBody: `{"email": "updated@example.com", "role_id": 2}`, expectedCode: http.StatusNotFound},
+		{name: "failure - invalid JSON", userID: "1", requestBody: `{"email": "updated@example.com", "role_id":}`, expectedCode: http.StatusBadRequest},
+	}
 
-func TestGetUserRole(t *testing.T) {
-	type testCase struct {
-		name      string
-		userID    string
-		mockData  []interface{}
-		expectErr bool
-		mockError error
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := newUserRoleStore(t)
+
+			req := httptest.NewRequest("PUT", "/user-roles/"+tc.userID, strings.NewReader(tc.requestBody))
+			req.Header.Set("Content-Type", "application/json")
+			if tc.ifMatch != "" {
+				req.Header.Set("If-Match", tc.ifMatch)
+			}
+			req = mux.SetURLVars(req, map[string]string{"id": tc.userID})
+			w := httptest.NewRecorder()
+
+			UpdateUserRole(s).ServeHTTP(w, req)
+
+			assert.Equal(t, tc.expectedCode, w.Code)
+			if tc.expectedCode == http.StatusOK {
+				assert.Equal(t, `"2"`, w.Header().Get("ETag"))
+				var userRole models.UserRole
+				assert.NoError(t, json.NewDecoder(w.Body).Decode(&userRole))
+				assert.Equal(t, "updated@example.com", userRole.Email)
+				assert.Equal(t, "viewer", userRole.RoleKey)
+
+				event := s.Events()[len(s.Events())-1]
+				assert.Equal(t, audit.ActionUpdate, event.Action)
+				assert.Equal(t, "test@example.com", event.Before.(models.UserRole).Email)
+				assert.Equal(t, "updated@example.com", event.After.(models.UserRole).Email)
+			}
+		})
 	}
+}
 
-	testCases := []testCase{
-		{
-			name:   "success - valid user",
-			userID: "1",
-			mockData: []interface{}{
-				1, "test@example.com", 2, time.Now(), time.Now(), nil, "admin",
-			},
-			expectErr: false,
-		},
-		{
-			name:      "user not found",
-			userID:    "99",
-			mockData:  nil,
-			expectErr: true,
-		},
-		{
-			name:      "database error",
-			userID:    "1",
-			mockData:  nil,
-			mockError: errors.New("database error"),
-			expectErr: true,
-		},
+func TestDeleteUserRole(t *testing.T) {
+	testCases := []struct {
+		name         string
+		userID       int
+		expectedCode int
+	}{
+		{name: "success - user role deleted", userID: 1, expectedCode: http.StatusNoContent},
+		{name: "failure - user role not found", userID: 99, expectedCode: http.StatusNotFound},
 	}
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			db, mock, err := sqlmock.New()
-			assert.NoError(t, err)
-
-			query := regexp.QuoteMeta(`SELECT user_roles.id, user_roles.email, user_roles.role_id, 
-				user_roles.created_at, user_roles.updated_at, user_roles.deleted_at, roles.role_key 
-				FROM user_roles 
-				LEFT JOIN roles ON user_roles.role_id = roles.id  
-				WHERE user_roles.id = $1 AND user_roles.deleted_at IS NULL`)
-
-			if tc.mockError != nil {
-				mock.ExpectQuery(query).WithArgs(tc.userID).WillReturnError(tc.mockError)
-			} else if tc.mockData != nil {
-				rowValues := make([]driver.Value, len(tc.mockData))
-				for i, v := range tc.mockData {
-					rowValues[i] = v
-				}
+			s := newUserRoleStore(t)
 
-				rows := sqlmock.NewRows([]string{"id", "email", "role_id", "created_at", "updated_at", "deleted_at", "role_key"}).
-					AddRow(rowValues...)
+			req := httptest.NewRequest("DELETE", fmt.Sprintf("/user-roles/%d", tc.userID), nil)
+			req = mux.SetURLVars(req, map[string]string{"id": fmt.Sprintf("%d", tc.userID)})
+			w := httptest.NewRecorder()
 
-				mock.ExpectQuery(query).WithArgs(tc.userID).WillReturnRows(rows).RowsWillBeClosed()
+			DeleteUserRole(s).ServeHTTP(w, req)
+
+			assert.Equal(t, tc.expectedCode, w.Code)
+			if tc.expectedCode == http.StatusNoContent {
+				_, err := s.GetUserRole(context.Background(), utils.DefaultTenant, tc.userID)
+				assert.Equal(t, store.ErrNotFound, err)
 			}
+		})
+	}
+}
 
-			req := httptest.NewRequest("GET", "/roles/"+tc.userID, nil)
-			w := httptest.NewRecorder()
-			req = mux.SetURLVars(req, map[string]string{"id": tc.userID})
+func TestRestoreUserRole(t *testing.T) {
+	testCases := []struct {
+		name         string
+		userID       int
+		deleted      bool
+		expectedCode int
+	}{
+		{name: "success - user role restored", userID: 1, deleted: true, expectedCode: http.StatusOK},
+		{name: "failure - user role not deleted", userID: 1, expectedCode: http.StatusConflict},
+		{name: "failure - user role not found", userID: 99, expectedCode: http.StatusNotFound},
+	}
 
-			handler := GetUserRole(db)
-			handler.ServeHTTP(w, req)
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := newUserRoleStore(t)
+			if tc.deleted {
+				require.NoError(t, s.DeleteUserRole(context.Background(), utils.DefaultTenant, tc.userID, 0))
+			}
 
-			// Debug response body
-			fmt.Println("Response Code:", w.Code)
-			fmt.Println("Response Body:", w.Body.String())
+			req := httptest.NewRequest("POST", fmt.Sprintf("/user-roles/%d/restore", tc.userID), nil)
+			req = mux.SetURLVars(req, map[string]string{"id": fmt.Sprintf("%d", tc.userID)})
+			w := httptest.NewRecorder()
 
-			if tc.expectErr {
-				assert.Equal(t, http.StatusInternalServerError, w.Code)
-			} else {
-				assert.Equal(t, http.StatusOK, w.Code)
+			RestoreUserRole(s).ServeHTTP(w, req)
 
-				var userRole models.UserRole
-				err := json.NewDecoder(w.Body).Decode(&userRole)
+			assert.Equal(t, tc.expectedCode, w.Code)
+			if tc.expectedCode == http.StatusOK {
+				_, err := s.GetUserRole(context.Background(), utils.DefaultTenant, tc.userID)
 				assert.NoError(t, err)
-				assert.Equal(t, tc.mockData[1], userRole.Email)
 			}
-
-			assert.NoError(t, mock.ExpectationsWereMet())
 		})
 	}
 }
 
-// Test CreateUserRole
-
-func TestCreateUserRole(t *testing.T) {
-    db, mock, err := sqlmock.New()
-    assert.NoError(t, err)
-    defer db.Close()
-
-    testCases := []struct {
-        name         string
-        requestBody  string
-        expectedCode int
-        mockQueries  func()
-    }{
-        {
-            name:         "success - valid request",
-            requestBody:  `{"email": "test@example.com", "role_id": 2}`,
-            expectedCode: http.StatusCreated,
-            mockQueries: func() {
-                mock.ExpectQuery(`SELECT EXISTS \(SELECT 1 FROM roles WHERE id = \$1 AND deleted_at IS NULL\)`).
-                    WithArgs(2).
-                    WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
-
-                mock.ExpectQuery(`INSERT INTO user_roles \(email, role_id\) VALUES \(\$1, \$2\) RETURNING id, created_at, updated_at`).
-                    WithArgs("test@example.com", 2).
-                    WillReturnRows(sqlmock.NewRows([]string{"id", "created_at", "updated_at"}).
-                        AddRow(1, time.Now(), time.Now()))
-            },
-        },
-        {
-            name:         "failure - role does not exist",
-            requestBody:  `{"email": "test@example.com", "role_id": 2}`,
-            expectedCode: http.StatusBadRequest,
-            mockQueries: func() {
-                mock.ExpectQuery(`SELECT EXISTS \(SELECT 1 FROM roles WHERE id = \$1 AND deleted_at IS NULL\)`).
-                    WithArgs(2).
-                    WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
-            },
-        },
-        {
-            name:         "failure - invalid JSON",
-            requestBody:  `{"email": "test@example.com", "role_id":}`,
-            expectedCode: http.StatusBadRequest,
-            mockQueries: func() {
-                // No DB queries should run because JSON is invalid
-            },
-        },
-        {
-            name:         "failure - database error on insert",
-            requestBody:  `{"email": "test@example.com", "role_id": 2}`,
-            expectedCode: http.StatusInternalServerError,
-            mockQueries: func() {
-                mock.ExpectQuery(`SELECT EXISTS \(SELECT 1 FROM roles WHERE id = \$1 AND deleted_at IS NULL\)`).
-                    WithArgs(2).
-                    WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
-
-                mock.ExpectQuery(`INSERT INTO user_roles \(email, role_id\) VALUES \(\$1, \$2\) RETURNING id, created_at, updated_at`).
-                    WithArgs("test@example.com", 2).
-                    WillReturnError(errors.New("insert error"))
-            },
-        },
-    }
-
-    for _, tc := range testCases {
-        t.Run(tc.name, func(t *testing.T) {
-            tc.mockQueries()
-
-            req := httptest.NewRequest("POST", "/user_roles", strings.NewReader(tc.requestBody))
-            req.Header.Set("Content-Type", "application/json")
-            w := httptest.NewRecorder()
-
-            handler := CreateUserRole(db)
-            handler.ServeHTTP(w, req)
-
-            assert.Equal(t, tc.expectedCode, w.Code)
-
-            assert.NoError(t, mock.ExpectationsWereMet())
-        })
-    }
-}
+func TestGetUserRolesPagination(t *testing.T) {
+	s := newUserRoleStore(t)
+	for _, email := range []string{"b@example.com", "c@example.com", "a@example.com"} {
+		_, err := s.CreateUserRole(context.Background(), models.UserRole{TenantID: utils.DefaultTenant, Email: email, RoleID: 2})
+		require.NoError(t, err)
+	}
 
+	emails := []string{}
+	url := "/user-roles?sort=email&limit=3"
+	for pages := 0; pages < 3; pages++ {
+		req := httptest.NewRequest("GET", url, nil)
+		w := httptest.NewRecorder()
+		GetUserRoles(s).ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var page models.UserRolePage
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&page))
+		for _, userRole := range page.UserRoles {
+			emails = append(emails, userRole.Email)
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		url = "/user-roles?sort=email&limit=3&cursor=" + page.NextCursor
+	}
 
-func TestUpdateUserRole(t *testing.T) {
-    db, mock, err := sqlmock.New()
-    assert.NoError(t, err)
-    defer db.Close()
-
-    testCases := []struct {
-        name         string
-        userID       string
-        requestBody  string
-        expectedCode int
-        mockQueries  func()
-    }{
-        {
-            name:         "success - valid request",
-            userID:       "1",
-            requestBody:  `{"email": "updated@example.com", "role_id": 2}`,
-            expectedCode: http.StatusOK,
-            mockQueries: func() {
-                mock.ExpectQuery(`SELECT EXISTS \(SELECT 1 FROM roles WHERE id = \$1 AND deleted_at IS NULL\)`).
-                    WithArgs(2).
-                    WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
-
-                mock.ExpectExec(`UPDATE user_roles SET email = \$1, role_id = \$2, updated_at = CURRENT_TIMESTAMP WHERE id = \$3 AND deleted_at IS NULL`).
-                    WithArgs("updated@example.com", 2, "1").
-                    WillReturnResult(sqlmock.NewResult(1, 1))
-            },
-        },
-        {
-            name:         "failure - role does not exist",
-            userID:       "1",
-            requestBody:  `{"email": "updated@example.com", "role_id": 99}`,
-            expectedCode: http.StatusBadRequest,
-            mockQueries: func() {
-                mock.ExpectQuery(`SELECT EXISTS \(SELECT 1 FROM roles WHERE id = \$1 AND deleted_at IS NULL\)`).
-                    WithArgs(99).
-                    WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
-            },
-        },
-        {
-            name:         "failure - invalid JSON",
-            userID:       "1",
-            requestBody:  `{"email": "updated@example.com", "role_id":}`,
-            expectedCode: http.StatusBadRequest,
-            mockQueries: func() {},
-        },
-        {
-            name:         "failure - database error on update",
-            userID:       "1",
-            requestBody:  `{"email": "updated@example.com", "role_id": 2}`,
-            expectedCode: http.StatusInternalServerError,
-            mockQueries: func() {
-                mock.ExpectQuery(`SELECT EXISTS \(SELECT 1 FROM roles WHERE id = \$1 AND deleted_at IS NULL\)`).
-                    WithArgs(2).
-                    WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
-
-                mock.ExpectExec(`UPDATE user_roles SET email = \$1, role_id = \$2, updated_at = CURRENT_TIMESTAMP WHERE id = \$3 AND deleted_at IS NULL`).
-                    WithArgs("updated@example.com", 2, "1").
-                    WillReturnError(errors.New("update error"))
-            },
-        },
-    }
-
-    for _, tc := range testCases {
-        t.Run(tc.name, func(t *testing.T) {
-            tc.mockQueries()
-
-            req := httptest.NewRequest("PUT", "/user_roles/"+tc.userID, strings.NewReader(tc.requestBody))
-            req.Header.Set("Content-Type", "application/json")
-            w := httptest.NewRecorder()
-            req = mux.SetURLVars(req, map[string]string{"id": tc.userID})
-
-            handler := UpdateUserRole(db)
-            handler.ServeHTTP(w, req)
-
-            assert.Equal(t, tc.expectedCode, w.Code)
-            assert.NoError(t, mock.ExpectationsWereMet())
-        })
-    }
+	assert.Equal(t, []string{"a@example.com", "b@example.com", "c@example.com", "test@example.com"}, emails)
 }
 
+func TestGetUserRolesNotModified(t *testing.T) {
+	s := newUserRoleStore(t)
+
+	req := httptest.NewRequest("GET", "/user-roles", nil)
+	w := httptest.NewRecorder()
+	GetUserRoles(s).ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	etag := w.Header().Get("ETag")
+	require.NotEmpty(t, etag)
+
+	req = httptest.NewRequest("GET", "/user-roles", nil)
+	req.Header.Set("If-None-Match", etag)
+	w = httptest.NewRecorder()
+	GetUserRoles(s).ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotModified, w.Code)
+
+	_, err := s.CreateUserRole(context.Background(), models.UserRole{TenantID: utils.DefaultTenant, Email: "new@example.com", RoleID: 2})
+	require.NoError(t, err)
+	w = httptest.NewRecorder()
+	GetUserRoles(s).ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NotEqual(t, etag, w.Header().Get("ETag"))
+}
 
-func TestDeleteUserRole(t *testing.T) {
-	db, mock, err := sqlmock.New()
-	assert.NoError(t, err)
-	defer db.Close()
-
+func TestPatchUserRole(t *testing.T) {
 	testCases := []struct {
-		name         string
-		userID       int // Now an int
-		expectedCode int
-		mockExec     func()
+		name            string
+		patch           string
+		expectedCode    int
+		expectedEmail   string
+		expectedRoleKey string
 	}{
-		{
-			name:         "success - user role deleted",
-			userID:       1,
-			expectedCode: http.StatusNoContent,
-			mockExec: func() {
-				mock.ExpectExec(`UPDATE user_roles SET deleted_at = CURRENT_TIMESTAMP WHERE id = \$1`).
-					WithArgs(1).
-					WillReturnResult(sqlmock.NewResult(0, 1)) // 1 row affected
-			},
-		},
-		{
-			name:         "failure - user role not found",
-			userID:       99,
-			expectedCode: http.StatusNotFound,
-			mockExec: func() {
-				mock.ExpectExec(`UPDATE user_roles SET deleted_at = CURRENT_TIMESTAMP WHERE id = \$1`).
-					WithArgs(99).
-					WillReturnResult(sqlmock.NewResult(0, 0)) // 0 rows affected
-			},
-		},
-		{
-			name:         "failure - database error",
-			userID:       1,
-			expectedCode: http.StatusInternalServerError,
-			mockExec: func() {
-				mock.ExpectExec(`UPDATE user_roles SET deleted_at = CURRENT_TIMESTAMP WHERE id = \$1`).
-					WithArgs(1).
-					WillReturnError(errors.New("database error"))
-			},
-		},
+		{name: "email only", patch: `{"email": "patched@example.com"}`, expectedCode: http.StatusOK, expectedEmail: "patched@example.com", expectedRoleKey: "admin"},
+		{name: "role only", patch: `{"role_id": 2}`, expectedCode: http.StatusOK, expectedEmail: "test@example.com", expectedRoleKey: "viewer"},
+		{name: "scope", patch: `{"resource_type": "project", "resource_id": "42"}`, expectedCode: http.StatusOK, expectedEmail: "test@example.com", expectedRoleKey: "admin"},
+		{name: "invalid scope", patch: `{"resource_type": "project"}`, expectedCode: http.StatusUnprocessableEntity},
+		{name: "null email", patch: `{"email": null}`, expectedCode: http.StatusUnprocessableEntity},
+		{name: "role key is derived", patch: `{"role_key": "viewer"}`, expectedCode: http.StatusBadRequest},
+		{name: "missing role", patch: `{"role_id": 9}`, expectedCode: http.StatusUnprocessableEntity},
+		{name: "not found", patch: `{"email": "x@example.com"}`, expectedCode: http.StatusNotFound},
 	}
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			tc.mockExec()
+			s := newUserRoleStore(t)
+			id := "1"
+			if tc.expectedCode == http.StatusNotFound {
+				id = "99"
+			}
 
-			req := httptest.NewRequest("DELETE", fmt.Sprintf("/user_roles/%d", tc.userID), nil)
-			req = mux.SetURLVars(req, map[string]string{"id": fmt.Sprintf("%d", tc.userID)})
+			req := httptest.NewRequest("PATCH", "/user-roles/"+id, strings.NewReader(tc.patch))
+			req.Header.Set("Content-Type", utils.MergePatchContentType)
+			req = mux.SetURLVars(req, map[string]string{"id": id})
 			w := httptest.NewRecorder()
 
-			handler := DeleteUserRole(db)
-			handler.ServeHTTP(w, req)
+			PatchUserRole(s).ServeHTTP(w, req)
 
 			assert.Equal(t, tc.expectedCode, w.Code)
-
-			err := mock.ExpectationsWereMet()
-			assert.NoError(t, err)
+			if tc.expectedCode != http.StatusOK {
+				return
+			}
+			var userRole models.UserRole
+			require.NoError(t, json.NewDecoder(w.Body).Decode(&userRole))
+			assert.Equal(t, tc.expectedEmail, userRole.Email)
+			assert.Equal(t, tc.expectedRoleKey, userRole.RoleKey)
+			assert.Equal(t, 2, userRole.Version)
+
+			stored, err := s.GetUserRole(context.Background(), utils.DefaultTenant, 1)
+			require.NoError(t, err)
+			expected, _ := json.Marshal(stored)
+			actual, _ := json.Marshal(userRole)
+			assert.JSONEq(t, string(expected), string(actual), "the response is the stored user role")
 		})
 	}
 }
 
+func TestBatchUserRoles(t *testing.T) {
+	testCases := []struct {
+		name             string
+		requestBody      string
+		expectedCode     int
+		expectedMessage  string
+		expectedStatuses []int
+		grantsLeft       int
+	}{
+		{name: "atomic", requestBody: `{"atomic": true, "grants": [{"email": "a@example.com", "role_id": 2}, {"email": "B@example.com", "role_id": 2}], "revocations": [{"id": 1}]}`,
+			expectedCode: http.StatusOK, expectedStatuses: []int{http.StatusCreated, http.StatusCreated, http.StatusOK}, grantsLeft: 2},
+		{name: "atomic with a duplicate grant", requestBody: `{"atomic": true, "grants": [{"email": "a@example.com", "role_id": 2}, {"email": "test@example.com", "role_id": 1}]}`,
+			expectedCode: http.StatusConflict, expectedMessage: "grants[1]: email already holds this role for this resource", grantsLeft: 1},
+		{name: "atomic with an invalid grant", requestBody: `{"atomic": true, "grants": [{"email": "a@example.com", "role_id": 2}, {"email": "not-an-email", "role_id": 2}]}`,
+			expectedCode: http.StatusUnprocessableEntity, expectedMessage: "grants[1]: invalid fields", grantsLeft: 1},
+		{name: "atomic with a missing revocation", requestBody: `{"atomic": true, "grants": [{"email": "a@example.com", "role_id": 2}], "revocations": [{"id": 1}, {"id": 9}]}`,
+			expectedCode: http.StatusNotFound, expectedMessage: "revocations[1]: not found", grantsLeft: 1},
+		{name: "not atomic", requestBody: `{"grants": [{"email": "not-an-email", "role_id": 2}, {"email": "a@example.com", "role_id": 9}, {"email": "b@example.com", "role_id": 2}, {"email": "b@example.com", "role_id": 2}], "revocations": [{"id": 1, "version": 5}, {"id": 1}]}`,
+			expectedCode: http.StatusOK, grantsLeft: 1,
+			expectedStatuses: []int{http.StatusUnprocessableEntity, http.StatusUnprocessableEntity, http.StatusCreated, http.StatusConflict, http.StatusPreconditionFailed, http.StatusOK}},
+		{name: "empty", requestBody: `{"atomic": true}`, expectedCode: http.StatusBadRequest, grantsLeft: 1},
+		{name: "invalid JSON", requestBody: `{"grants": [}`, expectedCode: http.StatusBadRequest, grantsLeft: 1},
+	}
 
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := newUserRoleStore(t)
+			events := len(s.Events())
 
+			req := httptest.NewRequest("POST", "/user-roles:batch", strings.NewReader(tc.requestBody))
+			w := httptest.NewRecorder()
 
+			BatchUserRoles(s).ServeHTTP(w, req)
 
+			assert.Equal(t, tc.expectedCode, w.Code)
+			grants, _, err := s.ListUserRoles(context.Background(), utils.DefaultTenant, store.UserRoleFilter{}, listing.Params{Limit: listing.DefaultLimit, Sort: listing.Sort{Field: "id"}})
+			require.NoError(t, err)
+			assert.Len(t, grants, tc.grantsLeft)
+			if tc.expectedCode != http.StatusOK {
+				var body models.ErrorResponse
+				require.NoError(t, json.NewDecoder(w.Body).Decode(&body))
+				if tc.expectedMessage != "" {
+					assert.Equal(t, tc.expectedMessage, body.Message)
+				}
+				assert.Len(t, s.Events(), events, "failed batches are not audited")
+				return
+			}
+
+			var response models.UserRoleBatchResponse
+			require.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+			statuses := []int{}
+			for _, result := range response.Results {
+				statuses = append(statuses, result.Status)
+				assert.Equal(t, result.Error == nil, result.UserRole != nil)
+			}
+			assert.Equal(t, tc.expectedStatuses, statuses)
+		})
+	}
+}
@@ -0,0 +1,39 @@
+// Package jobs holds the background maintenance loops started by main.
+package jobs
+
+import (
+	"context"
+	"log"
+	"main/store"
+	"main/utils"
+	"time"
+)
+
+// SweeperActor is the audit actor for changes made by the sweeper.
+const SweeperActor = "system:expiry-sweeper"
+
+// StartExpirySweeper runs s.SweepExpiredUserRoles every interval until ctx
+// is done. Read and check paths already ignore expired grants, so the
+// sweeper only has to keep the store honest, not be exact.
+func StartExpirySweeper(ctx context.Context, s store.MaintenanceStore, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				swept, err := s.SweepExpiredUserRoles(utils.WithActor(ctx, SweeperActor))
+				if err != nil {
+					log.Printf("Expiry sweeper error: %v", err)
+					continue
+				}
+				if swept > 0 {
+					log.Printf("Expiry sweeper removed %d expired user roles", swept)
+				}
+			}
+		}
+	}()
+}
@@ -0,0 +1,36 @@
+package jobs
+
+import (
+	"context"
+	"log"
+	"main/store"
+	"main/utils"
+	"time"
+)
+
+// PurgerActor is the audit actor for rows removed by the purger.
+const PurgerActor = "system:purger"
+
+// StartPurger runs s.PurgeDeleted every interval until ctx is done.
+func StartPurger(ctx context.Context, s store.MaintenanceStore, interval, retention time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				purged, err := s.PurgeDeleted(utils.WithActor(ctx, PurgerActor), retention)
+				if err != nil {
+					log.Printf("Purger error: %v", err)
+					continue
+				}
+				if purged > 0 {
+					log.Printf("Purger removed %d deleted rows", purged)
+				}
+			}
+		}
+	}()
+}
@@ -0,0 +1,234 @@
+// Package listing implements the keyset pagination, sorting and created_at
+// filters shared by every list endpoint. A request carries limit, sort (a
+// field name, "-" prefixed for descending) and the opaque cursor returned as
+// next_cursor by the previous page. Items are always ordered by the sort
+// field and then by id, so pages stay stable while rows are inserted.
+package listing
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultLimit = 50
+	MaxLimit     = 200
+)
+
+// Sort orders a collection by Field, breaking ties by id.
+type Sort struct {
+	Field string
+	Desc  bool
+}
+
+func (s Sort) String() string {
+	if s.Desc {
+		return "-" + s.Field
+	}
+	return s.Field
+}
+
+// Cursor identifies the last item of a page under a given sort.
+type Cursor struct {
+	Sort  string `json:"s"`
+	Value string `json:"v,omitempty"`
+	ID    int    `json:"id"`
+}
+
+func (c Cursor) Encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// Params selects one page of a collection.
+type Params struct {
+	Limit int
+	Sort  Sort
+	After *Cursor
+}
+
+// Parse reads limit, sort and cursor from query. Only fields listed in
+// sortable may be sorted on; "id" always may.
+func Parse(query url.Values, sortable []string, defaultSort string) (Params, error) {
+	p := Params{Limit: DefaultLimit}
+
+	if value := query.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > MaxLimit {
+			return p, fmt.Errorf("limit must be between 1 and %d", MaxLimit)
+		}
+		p.Limit = limit
+	}
+
+	sortParam := query.Get("sort")
+	if sortParam == "" {
+		sortParam = defaultSort
+	}
+	p.Sort = Sort{Field: strings.TrimPrefix(sortParam, "-"), Desc: strings.HasPrefix(sortParam, "-")}
+	allowed := p.Sort.Field == "id"
+	for _, field := range sortable {
+		allowed = allowed || p.Sort.Field == field
+	}
+	if !allowed {
+		return p, fmt.Errorf("sort must be one of id, %s", strings.Join(sortable, ", "))
+	}
+
+	if value := query.Get("cursor"); value != "" {
+		data, err := base64.RawURLEncoding.DecodeString(value)
+		var cursor Cursor
+		if err != nil || json.Unmarshal(data, &cursor) != nil {
+			return p, fmt.Errorf("invalid cursor")
+		}
+		if cursor.Sort != p.Sort.String() {
+			return p, fmt.Errorf("cursor was issued for sort %q", cursor.Sort)
+		}
+		p.After = &cursor
+	}
+	return p, nil
+}
+
+// ParseTime reads an optional RFC 3339 timestamp parameter.
+func ParseTime(query url.Values, param string) (*time.Time, error) {
+	value := query.Get(param)
+	if value == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: expected RFC 3339 timestamp", param)
+	}
+	return &t, nil
+}
+
+// SQL appends the keyset condition, ORDER BY and LIMIT for p to query, which
+// must already have a WHERE clause. columns maps sort fields to columns;
+// one extra row is fetched so Page can tell whether another page exists.
+func (p Params) SQL(query string, args []interface{}, columns map[string]string, idColumn string) (string, []interface{}) {
+	direction, op := "ASC", ">"
+	if p.Sort.Desc {
+		direction, op = "DESC", "<"
+	}
+	column := columns[p.Sort.Field]
+	if p.Sort.Field == "id" || column == "" {
+		column = idColumn
+	}
+
+	if p.After != nil {
+		if column == idColumn {
+			args = append(args, p.After.ID)
+			query += fmt.Sprintf(" AND %s %s $%d", idColumn, op, len(args))
+		} else {
+			args = append(args, p.After.Value, p.After.ID)
+			query += fmt.Sprintf(" AND (%s, %s) %s ($%d, $%d)", column, idColumn, op, len(args)-1, len(args))
+		}
+	}
+
+	if column == idColumn {
+		query += fmt.Sprintf(" ORDER BY %s %s", idColumn, direction)
+	} else {
+		query += fmt.Sprintf(" ORDER BY %s %s, %s %s", column, direction, idColumn, direction)
+	}
+	args = append(args, p.Limit+1)
+	query += fmt.Sprintf(" LIMIT $%d", len(args))
+	return query, args
+}
+
+// Page trims items fetched with SQL to the page size and returns the cursor
+// of the next page, or "" on the last page. key returns an item's sort
+// value, as an int, string or time.Time, and its id.
+func Page[T any](items []T, p Params, key func(item T, field string) (interface{}, int)) ([]T, string) {
+	if len(items) <= p.Limit {
+		return items, ""
+	}
+	items = items[:p.Limit]
+	value, id := key(items[p.Limit-1], p.Sort.Field)
+	cursor := Cursor{Sort: p.Sort.String(), ID: id}
+	if p.Sort.Field != "id" {
+		cursor.Value = formatValue(value)
+	}
+	return items, cursor.Encode()
+}
+
+// Apply sorts and pages items held in memory the same way SQL and Page do.
+func Apply[T any](items []T, p Params, key func(item T, field string) (interface{}, int)) ([]T, string) {
+	less := func(a, b T) bool {
+		va, ida := key(a, p.Sort.Field)
+		vb, idb := key(b, p.Sort.Field)
+		c := compareValues(va, vb)
+		if p.Sort.Field == "id" || c == 0 {
+			c = ida - idb
+		}
+		if p.Sort.Desc {
+			return c > 0
+		}
+		return c < 0
+	}
+	sort.SliceStable(items, func(i, j int) bool { return less(items[i], items[j]) })
+
+	if p.After != nil {
+		start := len(items)
+		for i, item := range items {
+			value, id := key(item, p.Sort.Field)
+			c := 0
+			if p.Sort.Field != "id" {
+				c = compareValues(value, parseValue(value, p.After.Value))
+			}
+			if c == 0 {
+				c = id - p.After.ID
+			}
+			if (!p.Sort.Desc && c > 0) || (p.Sort.Desc && c < 0) {
+				start = i
+				break
+			}
+		}
+		items = items[start:]
+	}
+
+	if len(items) > p.Limit+1 {
+		items = items[:p.Limit+1]
+	}
+	return Page(items, p, key)
+}
+
+func formatValue(value interface{}) string {
+	switch v := value.(type) {
+	case time.Time:
+		return v.UTC().Format(time.RFC3339Nano)
+	case int:
+		return strconv.Itoa(v)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// parseValue converts a cursor value back to the type of like.
+func parseValue(like interface{}, value string) interface{} {
+	switch like.(type) {
+	case time.Time:
+		t, _ := time.Parse(time.RFC3339Nano, value)
+		return t
+	case int:
+		n, _ := strconv.Atoi(value)
+		return n
+	default:
+		return value
+	}
+}
+
+func compareValues(a, b interface{}) int {
+	switch va := a.(type) {
+	case time.Time:
+		return va.Compare(b.(time.Time))
+	case int:
+		return va - b.(int)
+	case string:
+		return strings.Compare(va, b.(string))
+	}
+	return 0
+}
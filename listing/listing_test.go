@@ -0,0 +1,111 @@
+package listing
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type item struct {
+	ID        int
+	Name      string
+	CreatedAt time.Time
+}
+
+func itemKey(it item, field string) (interface{}, int) {
+	switch field {
+	case "name":
+		return it.Name, it.ID
+	case "created_at":
+		return it.CreatedAt, it.ID
+	}
+	return it.ID, it.ID
+}
+
+func TestParse(t *testing.T) {
+	testCases := []struct {
+		name        string
+		query       string
+		expected    Params
+		expectedErr string
+	}{
+		{name: "defaults", query: "", expected: Params{Limit: DefaultLimit, Sort: Sort{Field: "id"}}},
+		{name: "descending sort", query: "sort=-name&limit=5", expected: Params{Limit: 5, Sort: Sort{Field: "name", Desc: true}}},
+		{name: "cursor", query: "sort=name&cursor=" + Cursor{Sort: "name", Value: "b", ID: 2}.Encode(),
+			expected: Params{Limit: DefaultLimit, Sort: Sort{Field: "name"}, After: &Cursor{Sort: "name", Value: "b", ID: 2}}},
+		{name: "limit too large", query: "limit=201", expectedErr: "limit must be between 1 and 200"},
+		{name: "sort not allowed", query: "sort=secret", expectedErr: "sort must be one of id, name, created_at"},
+		{name: "garbled cursor", query: "cursor=bm90LWpzb24", expectedErr: "invalid cursor"},
+		{name: "cursor from another sort", query: "sort=-name&cursor=" + Cursor{Sort: "name", ID: 2}.Encode(), expectedErr: `cursor was issued for sort "name"`},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			query, _ := url.ParseQuery(tc.query)
+			p, err := Parse(query, []string{"name", "created_at"}, "id")
+			if tc.expectedErr != "" {
+				assert.EqualError(t, err, tc.expectedErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, p)
+		})
+	}
+}
+
+func TestSQL(t *testing.T) {
+	columns := map[string]string{"name": "items.name"}
+
+	query, args := Params{Limit: 10, Sort: Sort{Field: "id"}}.SQL("SELECT * FROM items WHERE tenant_id = $1", []interface{}{"default"}, columns, "items.id")
+	assert.Equal(t, "SELECT * FROM items WHERE tenant_id = $1 ORDER BY items.id ASC LIMIT $2", query)
+	assert.Equal(t, []interface{}{"default", 11}, args)
+
+	after := &Cursor{Sort: "-name", Value: "m", ID: 7}
+	query, args = Params{Limit: 10, Sort: Sort{Field: "name", Desc: true}, After: after}.SQL("SELECT * FROM items WHERE tenant_id = $1", []interface{}{"default"}, columns, "items.id")
+	assert.Equal(t, "SELECT * FROM items WHERE tenant_id = $1 AND (items.name, items.id) < ($2, $3) ORDER BY items.name DESC, items.id DESC LIMIT $4", query)
+	assert.Equal(t, []interface{}{"default", "m", 7, 11}, args)
+}
+
+func TestApply(t *testing.T) {
+	base := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	items := []item{
+		{ID: 1, Name: "carol", CreatedAt: base.Add(2 * time.Hour)},
+		{ID: 2, Name: "alice", CreatedAt: base},
+		{ID: 3, Name: "bob", CreatedAt: base.Add(time.Hour)},
+		{ID: 4, Name: "bob", CreatedAt: base.Add(time.Hour)},
+		{ID: 5, Name: "dave", CreatedAt: base.Add(3 * time.Hour)},
+	}
+
+	testCases := []struct {
+		sort     Sort
+		expected []int
+	}{
+		{sort: Sort{Field: "id"}, expected: []int{1, 2, 3, 4, 5}},
+		{sort: Sort{Field: "id", Desc: true}, expected: []int{5, 4, 3, 2, 1}},
+		{sort: Sort{Field: "name"}, expected: []int{2, 3, 4, 1, 5}},
+		{sort: Sort{Field: "created_at", Desc: true}, expected: []int{5, 1, 4, 3, 2}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.sort.String(), func(t *testing.T) {
+			p := Params{Limit: 2, Sort: tc.sort}
+			ids := []int{}
+			for pages := 0; pages < 5; pages++ {
+				page, next := Apply(append([]item{}, items...), p, itemKey)
+				for _, it := range page {
+					ids = append(ids, it.ID)
+				}
+				if next == "" {
+					break
+				}
+				query := url.Values{"cursor": {next}, "sort": {tc.sort.String()}, "limit": {"2"}}
+				var err error
+				p, err = Parse(query, []string{"name", "created_at"}, "id")
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tc.expected, ids)
+		})
+	}
+}
@@ -1,10 +1,16 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"fmt"
 	"log"
 	"main/app"
+	"main/jobs"
+	"main/migrations"
 	"os"
+	"time"
+
 	_ "github.com/lib/pq"
 )
 
@@ -15,31 +21,88 @@ func main() {
 	}
 	defer db.Close()
 
-	_, err = db.Exec(`
-		CREATE TABLE IF NOT EXISTS roles (
-			id SERIAL PRIMARY KEY,
-			role_key VARCHAR UNIQUE NOT NULL,
-			description VARCHAR NOT NULL,
-			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-			deleted_at TIMESTAMP
-		);
-
-		CREATE TABLE IF NOT EXISTS user_roles (
-			id SERIAL PRIMARY KEY,
-			email VARCHAR NOT NULL,
-			role_id INT NOT NULL REFERENCES roles(id),
-			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-			deleted_at TIMESTAMP,
-			CONSTRAINT unique_email_role UNIQUE (email, role_id)
-		);
-		
-	`)
+	if len(os.Args) > 1 {
+		if os.Args[1] != "migrate" || len(os.Args) != 3 {
+			log.Fatal("usage: ", os.Args[0], " [migrate up|down|status]")
+		}
+		if err := migrate(db, os.Args[2]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	backend, err := app.BackendFromEnv()
 	if err != nil {
 		log.Fatal(err)
 	}
+	// The memory backend keeps nothing in the database, so it needs no
+	// schema.
+	if backend == app.BackendPostgres {
+		if _, err := migrations.Up(context.Background(), db); err != nil {
+			log.Fatal(err)
+		}
+	}
+	s := app.NewStore(backend, db)
+
+	jobs.StartExpirySweeper(context.Background(), s, durationFromEnv("EXPIRY_SWEEP_INTERVAL", time.Minute))
+	// Soft-deleted rows stay restorable for PURGE_RETENTION.
+	jobs.StartPurger(context.Background(), s, durationFromEnv("PURGE_INTERVAL", time.Hour), durationFromEnv("PURGE_RETENTION", 30*24*time.Hour))
+
+	app.InitializeRoute(s)
+}
 
-	
-	app.InitializeRoute(db)
+// durationFromEnv parses the duration in the named variable, or returns
+// fallback when it is unset.
+func durationFromEnv(name string, fallback time.Duration) time.Duration {
+	v := os.Getenv(name)
+	if v == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		log.Fatalf("%s: %v", name, err)
+	}
+	return d
+}
+
+func migrate(db *sql.DB, command string) error {
+	ctx := context.Background()
+	switch command {
+	case "up":
+		applied, err := migrations.Up(ctx, db)
+		if err != nil {
+			return err
+		}
+		for _, m := range applied {
+			fmt.Printf("applied %04d_%s\n", m.Version, m.Name)
+		}
+		if len(applied) == 0 {
+			fmt.Println("schema is up to date")
+		}
+	case "down":
+		reverted, err := migrations.Down(ctx, db)
+		if err != nil {
+			return err
+		}
+		if reverted == nil {
+			fmt.Println("no migrations applied")
+		} else {
+			fmt.Printf("reverted %04d_%s\n", reverted.Version, reverted.Name)
+		}
+	case "status":
+		statuses, err := migrations.Statuses(ctx, db)
+		if err != nil {
+			return err
+		}
+		for _, s := range statuses {
+			appliedAt := "pending"
+			if s.AppliedAt != nil {
+				appliedAt = s.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Printf("%04d_%s\t%s\n", s.Version, s.Name, appliedAt)
+		}
+	default:
+		return fmt.Errorf("unknown migrate command %q: expected up, down or status", command)
+	}
+	return nil
 }
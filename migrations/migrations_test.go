@@ -0,0 +1,69 @@
+package migrations
+
+import (
+	"context"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoad(t *testing.T) {
+	migrations, err := Load()
+	assert.NoError(t, err)
+	assert.NotEmpty(t, migrations)
+	assert.Equal(t, 1, migrations[0].Version)
+	assert.Equal(t, "initial_schema", migrations[0].Name)
+	for i := 1; i < len(migrations); i++ {
+		assert.Less(t, migrations[i-1].Version, migrations[i].Version)
+	}
+
+	testCases := []struct {
+		name  string
+		files fstest.MapFS
+	}{
+		{name: "missing down", files: fstest.MapFS{"sql/0001_a.up.sql": {Data: []byte("SELECT 1")}}},
+		{name: "bad name", files: fstest.MapFS{"sql/0001_a.sql": {Data: []byte("SELECT 1")}}},
+		{name: "no version", files: fstest.MapFS{"sql/a.up.sql": {Data: []byte("SELECT 1")}, "sql/a.down.sql": {Data: []byte("SELECT 1")}}},
+		{name: "duplicate version", files: fstest.MapFS{
+			"sql/0001_a.up.sql": {Data: []byte("SELECT 1")}, "sql/0001_a.down.sql": {Data: []byte("SELECT 1")},
+			"sql/0001_b.up.sql": {Data: []byte("SELECT 1")}, "sql/0001_b.down.sql": {Data: []byte("SELECT 1")},
+		}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := load(tc.files)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestUp(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	migrations, err := Load()
+	assert.NoError(t, err)
+
+	mock.ExpectExec(`SELECT pg_advisory_lock`).WithArgs(lockKey).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`CREATE TABLE IF NOT EXISTS schema_migrations`).WillReturnResult(sqlmock.NewResult(0, 0))
+	rows := sqlmock.NewRows([]string{"version", "applied_at"})
+	for _, m := range migrations[:len(migrations)-1] {
+		rows.AddRow(m.Version, time.Now())
+	}
+	mock.ExpectQuery(`SELECT version, applied_at FROM schema_migrations`).WillReturnRows(rows)
+	last := migrations[len(migrations)-1]
+	mock.ExpectBegin()
+	mock.ExpectExec(`.+`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`INSERT INTO schema_migrations`).WithArgs(last.Version, last.Name).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectExec(`SELECT pg_advisory_unlock`).WithArgs(lockKey).WillReturnResult(sqlmock.NewResult(0, 0))
+
+	applied, err := Up(context.Background(), db)
+	assert.NoError(t, err)
+	assert.Equal(t, []Migration{last}, applied)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
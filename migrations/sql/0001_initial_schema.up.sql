@@ -0,0 +1,18 @@
+CREATE TABLE IF NOT EXISTS roles (
+	id SERIAL PRIMARY KEY,
+	role_key VARCHAR UNIQUE NOT NULL,
+	description VARCHAR NOT NULL,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	deleted_at TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS user_roles (
+	id SERIAL PRIMARY KEY,
+	email VARCHAR NOT NULL,
+	role_id INT NOT NULL REFERENCES roles(id),
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	deleted_at TIMESTAMP,
+	CONSTRAINT unique_email_role UNIQUE (email, role_id)
+);
@@ -0,0 +1,17 @@
+-- Roles carry permissions, which authorization checks are made against.
+CREATE TABLE IF NOT EXISTS permissions (
+	id SERIAL PRIMARY KEY,
+	permission_key VARCHAR UNIQUE NOT NULL,
+	description VARCHAR NOT NULL,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	deleted_at TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS role_permissions (
+	id SERIAL PRIMARY KEY,
+	role_id INT NOT NULL REFERENCES roles(id),
+	permission_id INT NOT NULL REFERENCES permissions(id),
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	CONSTRAINT unique_role_permission UNIQUE (role_id, permission_id)
+);
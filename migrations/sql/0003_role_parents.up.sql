@@ -0,0 +1,8 @@
+-- A role inherits the permissions of its parents.
+CREATE TABLE IF NOT EXISTS role_parents (
+	role_id INT NOT NULL REFERENCES roles(id),
+	parent_role_id INT NOT NULL REFERENCES roles(id),
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (role_id, parent_role_id),
+	CONSTRAINT role_parent_not_self CHECK (role_id <> parent_role_id)
+);
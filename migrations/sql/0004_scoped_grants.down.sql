@@ -0,0 +1,7 @@
+-- Fails if a role is granted to the same email in more than one scope;
+-- revoke the scoped grants first.
+ALTER TABLE user_roles DROP CONSTRAINT IF EXISTS unique_email_role;
+ALTER TABLE user_roles ADD CONSTRAINT unique_email_role UNIQUE (email, role_id);
+
+ALTER TABLE user_roles DROP COLUMN IF EXISTS resource_id;
+ALTER TABLE user_roles DROP COLUMN IF EXISTS resource_type;
@@ -0,0 +1,7 @@
+-- A grant may be scoped to a resource. Existing grants are unscoped, and the
+-- same role may be granted once per scope.
+ALTER TABLE user_roles ADD COLUMN IF NOT EXISTS resource_type VARCHAR NOT NULL DEFAULT '';
+ALTER TABLE user_roles ADD COLUMN IF NOT EXISTS resource_id VARCHAR NOT NULL DEFAULT '';
+
+ALTER TABLE user_roles DROP CONSTRAINT IF EXISTS unique_email_role;
+ALTER TABLE user_roles ADD CONSTRAINT unique_email_role UNIQUE (email, role_id, resource_type, resource_id);
@@ -0,0 +1,30 @@
+-- Fails if two tenants share a role or permission key, or grant the same
+-- role to the same email; remove all but the default tenant first.
+ALTER TABLE role_parents DROP CONSTRAINT IF EXISTS role_parents_tenant_id_parent_role_id_fkey;
+ALTER TABLE role_parents DROP CONSTRAINT IF EXISTS role_parents_tenant_id_role_id_fkey;
+ALTER TABLE role_permissions DROP CONSTRAINT IF EXISTS role_permissions_tenant_id_permission_id_fkey;
+ALTER TABLE role_permissions DROP CONSTRAINT IF EXISTS role_permissions_tenant_id_role_id_fkey;
+ALTER TABLE user_roles DROP CONSTRAINT IF EXISTS user_roles_tenant_id_role_id_fkey;
+
+ALTER TABLE user_roles DROP CONSTRAINT IF EXISTS unique_email_role;
+ALTER TABLE user_roles ADD CONSTRAINT unique_email_role UNIQUE (email, role_id, resource_type, resource_id);
+
+ALTER TABLE permissions DROP CONSTRAINT IF EXISTS unique_tenant_permission;
+ALTER TABLE permissions DROP CONSTRAINT IF EXISTS unique_tenant_permission_key;
+ALTER TABLE permissions ADD CONSTRAINT permissions_permission_key_key UNIQUE (permission_key);
+
+ALTER TABLE roles DROP CONSTRAINT IF EXISTS unique_tenant_role;
+ALTER TABLE roles DROP CONSTRAINT IF EXISTS unique_tenant_role_key;
+ALTER TABLE roles ADD CONSTRAINT roles_role_key_key UNIQUE (role_key);
+
+ALTER TABLE user_roles ADD CONSTRAINT user_roles_role_id_fkey FOREIGN KEY (role_id) REFERENCES roles(id);
+ALTER TABLE role_permissions ADD CONSTRAINT role_permissions_role_id_fkey FOREIGN KEY (role_id) REFERENCES roles(id);
+ALTER TABLE role_permissions ADD CONSTRAINT role_permissions_permission_id_fkey FOREIGN KEY (permission_id) REFERENCES permissions(id);
+ALTER TABLE role_parents ADD CONSTRAINT role_parents_role_id_fkey FOREIGN KEY (role_id) REFERENCES roles(id);
+ALTER TABLE role_parents ADD CONSTRAINT role_parents_parent_role_id_fkey FOREIGN KEY (parent_role_id) REFERENCES roles(id);
+
+ALTER TABLE role_parents DROP COLUMN IF EXISTS tenant_id;
+ALTER TABLE role_permissions DROP COLUMN IF EXISTS tenant_id;
+ALTER TABLE permissions DROP COLUMN IF EXISTS tenant_id;
+ALTER TABLE user_roles DROP COLUMN IF EXISTS tenant_id;
+ALTER TABLE roles DROP COLUMN IF EXISTS tenant_id;
@@ -0,0 +1,48 @@
+-- Every row belongs to a tenant. The column default backfills existing rows
+-- into the 'default' tenant, which is where requests without a tenant land.
+ALTER TABLE roles ADD COLUMN IF NOT EXISTS tenant_id VARCHAR NOT NULL DEFAULT 'default';
+ALTER TABLE user_roles ADD COLUMN IF NOT EXISTS tenant_id VARCHAR NOT NULL DEFAULT 'default';
+ALTER TABLE permissions ADD COLUMN IF NOT EXISTS tenant_id VARCHAR NOT NULL DEFAULT 'default';
+ALTER TABLE role_permissions ADD COLUMN IF NOT EXISTS tenant_id VARCHAR NOT NULL DEFAULT 'default';
+ALTER TABLE role_parents ADD COLUMN IF NOT EXISTS tenant_id VARCHAR NOT NULL DEFAULT 'default';
+
+-- Foreign keys go first: the tenant ones depend on the unique constraints
+-- recreated below.
+ALTER TABLE user_roles DROP CONSTRAINT IF EXISTS user_roles_role_id_fkey;
+ALTER TABLE user_roles DROP CONSTRAINT IF EXISTS user_roles_tenant_id_role_id_fkey;
+ALTER TABLE role_permissions DROP CONSTRAINT IF EXISTS role_permissions_role_id_fkey;
+ALTER TABLE role_permissions DROP CONSTRAINT IF EXISTS role_permissions_permission_id_fkey;
+ALTER TABLE role_permissions DROP CONSTRAINT IF EXISTS role_permissions_tenant_id_role_id_fkey;
+ALTER TABLE role_permissions DROP CONSTRAINT IF EXISTS role_permissions_tenant_id_permission_id_fkey;
+ALTER TABLE role_parents DROP CONSTRAINT IF EXISTS role_parents_role_id_fkey;
+ALTER TABLE role_parents DROP CONSTRAINT IF EXISTS role_parents_parent_role_id_fkey;
+ALTER TABLE role_parents DROP CONSTRAINT IF EXISTS role_parents_tenant_id_role_id_fkey;
+ALTER TABLE role_parents DROP CONSTRAINT IF EXISTS role_parents_tenant_id_parent_role_id_fkey;
+
+-- Keys are unique per tenant rather than globally.
+ALTER TABLE roles DROP CONSTRAINT IF EXISTS roles_role_key_key;
+ALTER TABLE roles DROP CONSTRAINT IF EXISTS unique_tenant_role_key;
+ALTER TABLE roles ADD CONSTRAINT unique_tenant_role_key UNIQUE (tenant_id, role_key);
+ALTER TABLE roles DROP CONSTRAINT IF EXISTS unique_tenant_role;
+ALTER TABLE roles ADD CONSTRAINT unique_tenant_role UNIQUE (tenant_id, id);
+
+ALTER TABLE permissions DROP CONSTRAINT IF EXISTS permissions_permission_key_key;
+ALTER TABLE permissions DROP CONSTRAINT IF EXISTS unique_tenant_permission_key;
+ALTER TABLE permissions ADD CONSTRAINT unique_tenant_permission_key UNIQUE (tenant_id, permission_key);
+ALTER TABLE permissions DROP CONSTRAINT IF EXISTS unique_tenant_permission;
+ALTER TABLE permissions ADD CONSTRAINT unique_tenant_permission UNIQUE (tenant_id, id);
+
+ALTER TABLE user_roles DROP CONSTRAINT IF EXISTS unique_email_role;
+ALTER TABLE user_roles ADD CONSTRAINT unique_email_role UNIQUE (tenant_id, email, role_id, resource_type, resource_id);
+
+-- A row may only point at rows of its own tenant.
+ALTER TABLE user_roles ADD CONSTRAINT user_roles_tenant_id_role_id_fkey
+	FOREIGN KEY (tenant_id, role_id) REFERENCES roles(tenant_id, id);
+ALTER TABLE role_permissions ADD CONSTRAINT role_permissions_tenant_id_role_id_fkey
+	FOREIGN KEY (tenant_id, role_id) REFERENCES roles(tenant_id, id);
+ALTER TABLE role_permissions ADD CONSTRAINT role_permissions_tenant_id_permission_id_fkey
+	FOREIGN KEY (tenant_id, permission_id) REFERENCES permissions(tenant_id, id);
+ALTER TABLE role_parents ADD CONSTRAINT role_parents_tenant_id_role_id_fkey
+	FOREIGN KEY (tenant_id, role_id) REFERENCES roles(tenant_id, id);
+ALTER TABLE role_parents ADD CONSTRAINT role_parents_tenant_id_parent_role_id_fkey
+	FOREIGN KEY (tenant_id, parent_role_id) REFERENCES roles(tenant_id, id);
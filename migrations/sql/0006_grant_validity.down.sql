@@ -0,0 +1,3 @@
+ALTER TABLE user_roles DROP COLUMN IF EXISTS deleted_reason;
+ALTER TABLE user_roles DROP COLUMN IF EXISTS valid_until;
+ALTER TABLE user_roles DROP COLUMN IF EXISTS valid_from;
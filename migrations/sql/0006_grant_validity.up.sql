@@ -0,0 +1,5 @@
+-- A grant may only count within a window, and records why it was revoked.
+-- Existing grants have no window and no reason.
+ALTER TABLE user_roles ADD COLUMN IF NOT EXISTS valid_from TIMESTAMPTZ;
+ALTER TABLE user_roles ADD COLUMN IF NOT EXISTS valid_until TIMESTAMPTZ;
+ALTER TABLE user_roles ADD COLUMN IF NOT EXISTS deleted_reason VARCHAR;
@@ -0,0 +1,25 @@
+-- Writes are recorded in an append-only log.
+CREATE TABLE IF NOT EXISTS audit_events (
+	id SERIAL PRIMARY KEY,
+	tenant_id VARCHAR NOT NULL,
+	actor VARCHAR NOT NULL,
+	action VARCHAR NOT NULL,
+	entity_type VARCHAR NOT NULL,
+	entity_id INT NOT NULL,
+	before JSONB,
+	after JSONB,
+	request_id VARCHAR NOT NULL DEFAULT '',
+	created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS audit_events_entity ON audit_events (tenant_id, entity_type, entity_id);
+
+CREATE OR REPLACE FUNCTION audit_events_append_only() RETURNS trigger AS $$
+BEGIN
+	RAISE EXCEPTION 'audit_events is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS audit_events_append_only ON audit_events;
+CREATE TRIGGER audit_events_append_only BEFORE UPDATE OR DELETE ON audit_events
+	FOR EACH ROW EXECUTE PROCEDURE audit_events_append_only();
@@ -0,0 +1,10 @@
+-- Fails if a deleted row shares its key with another row; purge or rename
+-- those rows first.
+DROP INDEX IF EXISTS user_roles_deleted_at;
+DROP INDEX IF EXISTS roles_deleted_at;
+
+DROP INDEX IF EXISTS unique_email_role;
+ALTER TABLE user_roles ADD CONSTRAINT unique_email_role UNIQUE (tenant_id, email, role_id, resource_type, resource_id);
+
+DROP INDEX IF EXISTS unique_tenant_role_key;
+ALTER TABLE roles ADD CONSTRAINT unique_tenant_role_key UNIQUE (tenant_id, role_key);
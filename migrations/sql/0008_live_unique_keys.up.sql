@@ -0,0 +1,10 @@
+-- Soft-deleted rows no longer hold their unique keys, so a deleted role key
+-- can be reused and a revoked grant given again.
+ALTER TABLE roles DROP CONSTRAINT IF EXISTS unique_tenant_role_key;
+CREATE UNIQUE INDEX IF NOT EXISTS unique_tenant_role_key ON roles (tenant_id, role_key) WHERE deleted_at IS NULL;
+
+ALTER TABLE user_roles DROP CONSTRAINT IF EXISTS unique_email_role;
+CREATE UNIQUE INDEX IF NOT EXISTS unique_email_role ON user_roles (tenant_id, email, role_id, resource_type, resource_id) WHERE deleted_at IS NULL;
+
+CREATE INDEX IF NOT EXISTS roles_deleted_at ON roles (deleted_at) WHERE deleted_at IS NOT NULL;
+CREATE INDEX IF NOT EXISTS user_roles_deleted_at ON user_roles (deleted_at) WHERE deleted_at IS NOT NULL;
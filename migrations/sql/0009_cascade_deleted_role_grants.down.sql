@@ -0,0 +1,3 @@
+-- The grants deleted by the up migration cannot be told apart from later
+-- cascades, so they stay deleted.
+SELECT 1;
@@ -0,0 +1,7 @@
+-- Roles used to be deleted without touching their grants. Delete the grants
+-- still pointing at a deleted role, as DELETE /roles?assignments=cascade
+-- now does.
+UPDATE user_roles SET deleted_at = roles.deleted_at, deleted_reason = 'role_deleted', updated_at = CURRENT_TIMESTAMP
+FROM roles
+WHERE user_roles.role_id = roles.id AND user_roles.tenant_id = roles.tenant_id
+	AND user_roles.deleted_at IS NULL AND roles.deleted_at IS NOT NULL;
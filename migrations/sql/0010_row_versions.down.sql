@@ -0,0 +1,2 @@
+ALTER TABLE user_roles DROP COLUMN IF EXISTS version;
+ALTER TABLE roles DROP COLUMN IF EXISTS version;
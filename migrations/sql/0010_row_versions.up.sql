@@ -0,0 +1,3 @@
+-- version is bumped by every write, and backs ETag and If-Match.
+ALTER TABLE roles ADD COLUMN IF NOT EXISTS version INT NOT NULL DEFAULT 1;
+ALTER TABLE user_roles ADD COLUMN IF NOT EXISTS version INT NOT NULL DEFAULT 1;
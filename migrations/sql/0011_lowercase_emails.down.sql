@@ -0,0 +1,2 @@
+-- The original case of each email is not kept, so there is nothing to undo.
+SELECT 1;
@@ -0,0 +1,13 @@
+-- Emails are now stored lowercased. Grants that differ only by the case of
+-- their email would collide on unique_email_role, so keep the oldest one.
+UPDATE user_roles SET deleted_at = CURRENT_TIMESTAMP, deleted_reason = 'duplicate_email', updated_at = CURRENT_TIMESTAMP, version = user_roles.version + 1
+FROM user_roles kept
+WHERE kept.tenant_id = user_roles.tenant_id AND lower(trim(kept.email)) = lower(trim(user_roles.email))
+	AND kept.role_id = user_roles.role_id
+	AND kept.resource_type = user_roles.resource_type
+	AND kept.resource_id = user_roles.resource_id
+	AND kept.id < user_roles.id
+	AND kept.deleted_at IS NULL AND user_roles.deleted_at IS NULL;
+
+UPDATE user_roles SET email = lower(trim(email)), updated_at = CURRENT_TIMESTAMP, version = version + 1
+WHERE email <> lower(trim(email));
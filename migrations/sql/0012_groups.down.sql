@@ -0,0 +1,11 @@
+-- Group grants cannot be expressed without groups, so they are dropped.
+DELETE FROM user_roles WHERE group_id IS NOT NULL;
+DROP INDEX IF EXISTS user_roles_group;
+DROP INDEX IF EXISTS unique_email_role;
+CREATE UNIQUE INDEX unique_email_role ON user_roles (tenant_id, email, role_id, resource_type, resource_id) WHERE deleted_at IS NULL;
+ALTER TABLE user_roles DROP CONSTRAINT IF EXISTS user_role_email_or_group;
+ALTER TABLE user_roles DROP CONSTRAINT IF EXISTS user_roles_group_fkey;
+ALTER TABLE user_roles DROP COLUMN IF EXISTS group_id;
+
+DROP TABLE IF EXISTS group_members;
+DROP TABLE IF EXISTS groups;
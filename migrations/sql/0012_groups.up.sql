@@ -0,0 +1,42 @@
+-- Groups hold roles on behalf of their members: emails, and other groups.
+CREATE TABLE IF NOT EXISTS groups (
+	id SERIAL PRIMARY KEY,
+	tenant_id VARCHAR NOT NULL DEFAULT 'default',
+	group_key VARCHAR NOT NULL,
+	description VARCHAR NOT NULL DEFAULT '',
+	created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	deleted_at TIMESTAMPTZ,
+	version INT NOT NULL DEFAULT 1,
+	CONSTRAINT unique_tenant_group UNIQUE (tenant_id, id)
+);
+CREATE UNIQUE INDEX IF NOT EXISTS unique_tenant_group_key ON groups (tenant_id, group_key) WHERE deleted_at IS NULL;
+
+-- A member is either an email or a nested group, never both.
+CREATE TABLE IF NOT EXISTS group_members (
+	id SERIAL PRIMARY KEY,
+	tenant_id VARCHAR NOT NULL DEFAULT 'default',
+	group_id INT NOT NULL,
+	email VARCHAR NOT NULL DEFAULT '',
+	member_group_id INT,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (tenant_id, group_id) REFERENCES groups(tenant_id, id),
+	FOREIGN KEY (tenant_id, member_group_id) REFERENCES groups(tenant_id, id),
+	CONSTRAINT group_member_email_or_group CHECK ((email = '') <> (member_group_id IS NULL)),
+	CONSTRAINT group_member_not_self CHECK (member_group_id <> group_id)
+);
+CREATE UNIQUE INDEX IF NOT EXISTS unique_group_member_email ON group_members (group_id, email) WHERE member_group_id IS NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS unique_group_member_group ON group_members (group_id, member_group_id) WHERE member_group_id IS NOT NULL;
+CREATE INDEX IF NOT EXISTS group_members_email ON group_members (tenant_id, email) WHERE member_group_id IS NULL;
+CREATE INDEX IF NOT EXISTS group_members_member_group ON group_members (member_group_id) WHERE member_group_id IS NOT NULL;
+
+-- A user role grants its role either to an email or to a group.
+ALTER TABLE user_roles ADD COLUMN IF NOT EXISTS group_id INT;
+ALTER TABLE user_roles DROP CONSTRAINT IF EXISTS user_roles_group_fkey;
+ALTER TABLE user_roles ADD CONSTRAINT user_roles_group_fkey FOREIGN KEY (tenant_id, group_id) REFERENCES groups(tenant_id, id);
+ALTER TABLE user_roles DROP CONSTRAINT IF EXISTS user_role_email_or_group;
+ALTER TABLE user_roles ADD CONSTRAINT user_role_email_or_group CHECK ((email = '') <> (group_id IS NULL));
+
+DROP INDEX IF EXISTS unique_email_role;
+CREATE UNIQUE INDEX unique_email_role ON user_roles (tenant_id, email, COALESCE(group_id, 0), role_id, resource_type, resource_id) WHERE deleted_at IS NULL;
+CREATE INDEX IF NOT EXISTS user_roles_group ON user_roles (group_id) WHERE group_id IS NOT NULL;
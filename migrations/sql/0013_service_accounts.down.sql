@@ -0,0 +1,13 @@
+-- Service account grants cannot be expressed without service accounts, so
+-- they are dropped.
+DELETE FROM user_roles WHERE service_account_id IS NOT NULL;
+DROP INDEX IF EXISTS user_roles_service_account;
+DROP INDEX IF EXISTS unique_email_role;
+CREATE UNIQUE INDEX unique_email_role ON user_roles (tenant_id, email, COALESCE(group_id, 0), role_id, resource_type, resource_id) WHERE deleted_at IS NULL;
+ALTER TABLE user_roles DROP CONSTRAINT IF EXISTS user_role_one_principal;
+ALTER TABLE user_roles ADD CONSTRAINT user_role_email_or_group CHECK ((email = '') <> (group_id IS NULL));
+ALTER TABLE user_roles DROP CONSTRAINT IF EXISTS user_roles_service_account_fkey;
+ALTER TABLE user_roles DROP COLUMN IF EXISTS service_account_id;
+
+DROP TABLE IF EXISTS service_account_credentials;
+DROP TABLE IF EXISTS service_accounts;
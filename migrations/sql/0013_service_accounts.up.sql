@@ -0,0 +1,43 @@
+-- Service accounts are non-human principals, such as CI bots and services.
+CREATE TABLE IF NOT EXISTS service_accounts (
+	id SERIAL PRIMARY KEY,
+	tenant_id VARCHAR NOT NULL DEFAULT 'default',
+	name VARCHAR NOT NULL,
+	display_name VARCHAR NOT NULL DEFAULT '',
+	created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	deleted_at TIMESTAMPTZ,
+	version INT NOT NULL DEFAULT 1,
+	CONSTRAINT unique_tenant_service_account UNIQUE (tenant_id, id)
+);
+CREATE UNIQUE INDEX IF NOT EXISTS unique_tenant_service_account_name ON service_accounts (tenant_id, name) WHERE deleted_at IS NULL;
+
+-- Only a SHA-256 digest of each API key is kept; the key itself is shown
+-- once, when it is created.
+CREATE TABLE IF NOT EXISTS service_account_credentials (
+	id SERIAL PRIMARY KEY,
+	tenant_id VARCHAR NOT NULL DEFAULT 'default',
+	service_account_id INT NOT NULL,
+	key_prefix VARCHAR NOT NULL,
+	key_hash VARCHAR NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	expires_at TIMESTAMPTZ,
+	revoked_at TIMESTAMPTZ,
+	FOREIGN KEY (tenant_id, service_account_id) REFERENCES service_accounts(tenant_id, id),
+	CONSTRAINT unique_credential_key_hash UNIQUE (key_hash)
+);
+CREATE INDEX IF NOT EXISTS service_account_credentials_account ON service_account_credentials (service_account_id);
+
+-- A user role grants its role to exactly one principal: an email, a group
+-- or a service account.
+ALTER TABLE user_roles ADD COLUMN IF NOT EXISTS service_account_id INT;
+ALTER TABLE user_roles DROP CONSTRAINT IF EXISTS user_roles_service_account_fkey;
+ALTER TABLE user_roles ADD CONSTRAINT user_roles_service_account_fkey FOREIGN KEY (tenant_id, service_account_id) REFERENCES service_accounts(tenant_id, id);
+ALTER TABLE user_roles DROP CONSTRAINT IF EXISTS user_role_email_or_group;
+ALTER TABLE user_roles DROP CONSTRAINT IF EXISTS user_role_one_principal;
+ALTER TABLE user_roles ADD CONSTRAINT user_role_one_principal
+	CHECK ((email <> '')::int + (group_id IS NOT NULL)::int + (service_account_id IS NOT NULL)::int = 1);
+
+DROP INDEX IF EXISTS unique_email_role;
+CREATE UNIQUE INDEX unique_email_role ON user_roles (tenant_id, email, COALESCE(group_id, 0), COALESCE(service_account_id, 0), role_id, resource_type, resource_id) WHERE deleted_at IS NULL;
+CREATE INDEX IF NOT EXISTS user_roles_service_account ON user_roles (service_account_id) WHERE service_account_id IS NOT NULL;
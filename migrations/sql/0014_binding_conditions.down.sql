@@ -0,0 +1,2 @@
+ALTER TABLE role_permissions DROP COLUMN IF EXISTS condition;
+ALTER TABLE user_roles DROP COLUMN IF EXISTS condition;
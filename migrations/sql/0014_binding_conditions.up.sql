@@ -0,0 +1,4 @@
+-- A binding may carry a condition expression, evaluated against the request
+-- context of each authorization check. An empty condition always holds.
+ALTER TABLE user_roles ADD COLUMN IF NOT EXISTS condition TEXT NOT NULL DEFAULT '';
+ALTER TABLE role_permissions ADD COLUMN IF NOT EXISTS condition TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,7 @@
+-- Deny bindings cannot be expressed without an effect, so they are dropped.
+DELETE FROM user_roles WHERE effect = 'deny';
+DELETE FROM role_permissions WHERE effect = 'deny';
+DROP INDEX IF EXISTS unique_email_role;
+CREATE UNIQUE INDEX unique_email_role ON user_roles (tenant_id, email, COALESCE(group_id, 0), COALESCE(service_account_id, 0), role_id, resource_type, resource_id) WHERE deleted_at IS NULL;
+ALTER TABLE role_permissions DROP COLUMN IF EXISTS effect;
+ALTER TABLE user_roles DROP COLUMN IF EXISTS effect;
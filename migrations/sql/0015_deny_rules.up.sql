@@ -0,0 +1,10 @@
+-- A binding's effect is allow or deny. A deny binding revokes the permissions
+-- it covers even when another binding allows them.
+ALTER TABLE user_roles ADD COLUMN IF NOT EXISTS effect VARCHAR NOT NULL DEFAULT 'allow'
+	CONSTRAINT user_roles_effect_check CHECK (effect IN ('allow', 'deny'));
+ALTER TABLE role_permissions ADD COLUMN IF NOT EXISTS effect VARCHAR NOT NULL DEFAULT 'allow'
+	CONSTRAINT role_permissions_effect_check CHECK (effect IN ('allow', 'deny'));
+
+-- A principal may be both granted and denied the same role.
+DROP INDEX IF EXISTS unique_email_role;
+CREATE UNIQUE INDEX unique_email_role ON user_roles (tenant_id, email, COALESCE(group_id, 0), COALESCE(service_account_id, 0), role_id, resource_type, resource_id, effect) WHERE deleted_at IS NULL;
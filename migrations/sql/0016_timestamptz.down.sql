@@ -0,0 +1,14 @@
+ALTER TABLE role_parents ALTER COLUMN created_at TYPE TIMESTAMP;
+ALTER TABLE role_permissions ALTER COLUMN created_at TYPE TIMESTAMP;
+ALTER TABLE permissions
+	ALTER COLUMN created_at TYPE TIMESTAMP,
+	ALTER COLUMN updated_at TYPE TIMESTAMP,
+	ALTER COLUMN deleted_at TYPE TIMESTAMP;
+ALTER TABLE user_roles
+	ALTER COLUMN created_at TYPE TIMESTAMP,
+	ALTER COLUMN updated_at TYPE TIMESTAMP,
+	ALTER COLUMN deleted_at TYPE TIMESTAMP;
+ALTER TABLE roles
+	ALTER COLUMN created_at TYPE TIMESTAMP,
+	ALTER COLUMN updated_at TYPE TIMESTAMP,
+	ALTER COLUMN deleted_at TYPE TIMESTAMP;
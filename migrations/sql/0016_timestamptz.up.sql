@@ -0,0 +1,17 @@
+-- The first tables stored timestamps without a time zone, so they did not
+-- compare reliably with the TIMESTAMPTZ columns added since. Existing values
+-- were written in the session time zone, which is how the cast reads them.
+ALTER TABLE roles
+	ALTER COLUMN created_at TYPE TIMESTAMPTZ,
+	ALTER COLUMN updated_at TYPE TIMESTAMPTZ,
+	ALTER COLUMN deleted_at TYPE TIMESTAMPTZ;
+ALTER TABLE user_roles
+	ALTER COLUMN created_at TYPE TIMESTAMPTZ,
+	ALTER COLUMN updated_at TYPE TIMESTAMPTZ,
+	ALTER COLUMN deleted_at TYPE TIMESTAMPTZ;
+ALTER TABLE permissions
+	ALTER COLUMN created_at TYPE TIMESTAMPTZ,
+	ALTER COLUMN updated_at TYPE TIMESTAMPTZ,
+	ALTER COLUMN deleted_at TYPE TIMESTAMPTZ;
+ALTER TABLE role_permissions ALTER COLUMN created_at TYPE TIMESTAMPTZ;
+ALTER TABLE role_parents ALTER COLUMN created_at TYPE TIMESTAMPTZ;
@@ -0,0 +1,746 @@
+package store
+
+import (
+	"context"
+	models "main/Models"
+	"main/audit"
+	"main/authz"
+	"main/listing"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Memory implements Store in process memory, with the same tenant isolation,
+// soft deletes and constraints as Postgres. Audit events are kept in memory
+// too.
+type Memory struct {
+	mu                   sync.Mutex
+	now                  func() time.Time
+	nextRoleID           int
+	nextUserRoleID       int
+	nextGroupID          int
+	nextGroupMemberID    int
+	nextAccountID        int
+	nextCredentialID     int
+	nextPermissionID     int
+	nextRolePermissionID int
+	roles                map[int]models.Role
+	parents              map[int][]int
+	userRoles            map[int]models.UserRole
+	groups               map[int]models.Group
+	groupMembers         map[int]models.GroupMember
+	accounts             map[int]models.ServiceAccount
+	credentials          map[int]models.ServiceAccountCredential
+	permissions          map[int]models.Permission
+	rolePermissions      map[int]models.RolePermission
+	events               []audit.Event
+	eventTimes           []time.Time
+}
+
+func NewMemory() *Memory {
+	return &Memory{
+		now:             time.Now,
+		roles:           map[int]models.Role{},
+		parents:         map[int][]int{},
+		userRoles:       map[int]models.UserRole{},
+		groups:          map[int]models.Group{},
+		groupMembers:    map[int]models.GroupMember{},
+		accounts:        map[int]models.ServiceAccount{},
+		credentials:     map[int]models.ServiceAccountCredential{},
+		permissions:     map[int]models.Permission{},
+		rolePermissions: map[int]models.RolePermission{},
+	}
+}
+
+// Events returns the audit events recorded so far, oldest first.
+func (m *Memory) Events() []audit.Event {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]audit.Event{}, m.events...)
+}
+
+func (m *Memory) timestamp() string {
+	return m.now().UTC().Format(time.RFC3339Nano)
+}
+
+func (m *Memory) record(ctx context.Context, action, entityType string, id int, before, after interface{}) {
+	m.events = append(m.events, audit.NewEvent(ctx, action, entityType, id, before, after))
+	m.eventTimes = append(m.eventTimes, m.now())
+}
+
+// liveRole returns a live role of tenant with its live parents.
+func (m *Memory) liveRole(tenant string, id int) (models.Role, bool) {
+	role, ok := m.role(tenant, id)
+	if !ok || role.DeletedAt != nil {
+		return models.Role{}, false
+	}
+	return role, true
+}
+
+// role returns a live or deleted role of tenant with its live parents.
+func (m *Memory) role(tenant string, id int) (models.Role, bool) {
+	role, ok := m.roles[id]
+	if !ok || role.TenantID != tenant {
+		return models.Role{}, false
+	}
+	role.ParentIDs = []int{}
+	for _, parentID := range m.parents[id] {
+		if parent := m.roles[parentID]; parent.DeletedAt == nil {
+			role.ParentIDs = append(role.ParentIDs, parentID)
+		}
+	}
+	sort.Ints(role.ParentIDs)
+	return role, true
+}
+
+func (m *Memory) ListRoles(ctx context.Context, tenant string, filter RoleFilter, page listing.Params) ([]models.Role, string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	roles := []models.Role{}
+	for _, id := range sortedKeys(m.roles) {
+		role, ok := m.role(tenant, id)
+		if !ok || (role.DeletedAt != nil && !filter.IncludeDeleted) {
+			continue
+		}
+		if filter.RoleKey != "" && role.RoleKey != filter.RoleKey {
+			continue
+		}
+		if createdAt, _ := time.Parse(time.RFC3339Nano, role.CreatedAt); !inRange(createdAt, filter.CreatedAfter, filter.CreatedBefore) {
+			continue
+		}
+		roles = append(roles, role)
+	}
+	roles, next := listing.Apply(roles, page, roleSortKey)
+	return roles, next, nil
+}
+
+// inRange reports whether t lies in [after, before).
+func inRange(t time.Time, after, before *time.Time) bool {
+	return (after == nil || !t.Before(*after)) && (before == nil || t.Before(*before))
+}
+
+func (m *Memory) GetRole(ctx context.Context, tenant string, id int) (models.Role, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	role, ok := m.liveRole(tenant, id)
+	if !ok {
+		return models.Role{}, ErrNotFound
+	}
+	return role, nil
+}
+
+func (m *Memory) CreateRole(ctx context.Context, role models.Role) (models.Role, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.roleKeyExists(0, role.TenantID, role.RoleKey) {
+		return models.Role{}, &ConstraintError{Err: ErrConflict, Constraint: "unique_tenant_role_key"}
+	}
+
+	m.nextRoleID++
+	role.ID = m.nextRoleID
+	if role.ParentIDs == nil {
+		role.ParentIDs = []int{}
+	}
+	role.ParentIDs = distinctInts(role.ParentIDs)
+	if err := m.checkParents(role.TenantID, role.ID, role.ParentIDs); err != nil {
+		m.nextRoleID--
+		return models.Role{}, err
+	}
+
+	role.CreatedAt = m.timestamp()
+	role.UpdatedAt = role.CreatedAt
+	role.DeletedAt = nil
+	role.Version = 1
+	m.roles[role.ID] = role
+	m.parents[role.ID] = role.ParentIDs
+	m.record(ctx, audit.ActionCreate, audit.EntityRole, role.ID, nil, role)
+	return role, nil
+}
+
+func (m *Memory) UpdateRole(ctx context.Context, tenant string, id int, role models.Role) (models.Role, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	before, ok := m.liveRole(tenant, id)
+	if !ok {
+		return models.Role{}, ErrNotFound
+	}
+	if err := checkVersion(before.Version, role.Version); err != nil {
+		return models.Role{}, err
+	}
+	if m.roleKeyExists(id, tenant, role.RoleKey) {
+		return models.Role{}, &ConstraintError{Err: ErrConflict, Constraint: "unique_tenant_role_key"}
+	}
+
+	after := before
+	after.RoleKey = role.RoleKey
+	after.Description = role.Description
+	after.UpdatedAt = m.timestamp()
+	after.Version++
+	// Parents are only replaced when the request carries parent_ids.
+	if role.ParentIDs != nil {
+		parentIDs := distinctInts(role.ParentIDs)
+		if err := m.checkParents(tenant, id, parentIDs); err != nil {
+			return models.Role{}, err
+		}
+		m.parents[id] = parentIDs
+		after.ParentIDs = parentIDs
+	}
+
+	stored := after
+	stored.ParentIDs = nil
+	m.roles[id] = stored
+	m.record(ctx, audit.ActionUpdate, audit.EntityRole, id, before, after)
+	return after, nil
+}
+
+func (m *Memory) DeleteRole(ctx context.Context, tenant string, id int, options DeleteRoleOptions) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	before, ok := m.liveRole(tenant, id)
+	if !ok {
+		return ErrNotFound
+	}
+	if err := checkVersion(before.Version, options.Version); err != nil {
+		return err
+	}
+
+	// Grants are live until deleted, even once expired, like in Postgres.
+	grants := []int{}
+	for _, userRoleID := range sortedKeys(m.userRoles) {
+		if userRole := m.userRoles[userRoleID]; userRole.TenantID == tenant && userRole.RoleID == id && userRole.DeletedAt == nil {
+			grants = append(grants, userRoleID)
+		}
+	}
+
+	switch options.Assignments {
+	case AssignmentsCascade:
+		for _, userRoleID := range grants {
+			userRole := m.userRoles[userRoleID]
+			userRole.RoleKey = before.RoleKey
+			deletedAt := m.now()
+			reason := ReasonRoleDeleted
+			deleted := userRole
+			deleted.DeletedAt = &deletedAt
+			deleted.DeletedReason = &reason
+			deleted.UpdatedAt = deletedAt
+			deleted.Version++
+			m.userRoles[userRoleID] = deleted
+			m.record(ctx, audit.ActionDelete, audit.EntityUserRole, userRoleID, userRole, nil)
+		}
+	case AssignmentsReassign:
+		replacement, ok := m.liveRole(tenant, options.ReplacementRoleID)
+		if !ok || replacement.ID == id {
+			return ErrRoleNotFound
+		}
+		for _, userRoleID := range grants {
+			userRole := m.userRoles[userRoleID]
+			userRole.RoleID = replacement.ID
+			if m.userRoleExists(userRoleID, tenant, userRole) {
+				return &ConstraintError{Err: ErrConflict, Constraint: "unique_email_role"}
+			}
+		}
+		for _, userRoleID := range grants {
+			userRole := m.userRoles[userRoleID]
+			userRole.RoleKey = before.RoleKey
+			after := userRole
+			after.RoleID = replacement.ID
+			after.RoleKey = replacement.RoleKey
+			after.UpdatedAt = m.now()
+			after.Version++
+			m.userRoles[userRoleID] = after
+			m.record(ctx, audit.ActionUpdate, audit.EntityUserRole, userRoleID, userRole, after)
+		}
+	default:
+		for _, userRoleID := range grants {
+			if _, ok := m.liveUserRole(tenant, userRoleID); ok {
+				return ErrRoleHasAssignments
+			}
+		}
+		// Only expired grants are left. Retire them as the sweeper would so
+		// none stays live on a deleted role.
+		for _, userRoleID := range grants {
+			m.expireUserRole(ctx, userRoleID)
+		}
+	}
+
+	role := m.roles[id]
+	deletedAt := m.timestamp()
+	role.DeletedAt = &deletedAt
+	role.Version++
+	m.roles[id] = role
+	m.record(ctx, audit.ActionDelete, audit.EntityRole, id, before, nil)
+	return nil
+}
+
+func (m *Memory) RestoreRole(ctx context.Context, tenant string, id int) (models.Role, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	before, ok := m.role(tenant, id)
+	if !ok {
+		return models.Role{}, ErrNotFound
+	}
+	if before.DeletedAt == nil {
+		return models.Role{}, ErrNotDeleted
+	}
+	if m.roleKeyExists(id, tenant, before.RoleKey) {
+		return models.Role{}, &ConstraintError{Err: ErrConflict, Constraint: "unique_tenant_role_key"}
+	}
+
+	role := m.roles[id]
+	role.DeletedAt = nil
+	role.UpdatedAt = m.timestamp()
+	role.Version++
+	m.roles[id] = role
+
+	after := before
+	after.DeletedAt = nil
+	after.UpdatedAt = role.UpdatedAt
+	after.Version = role.Version
+	m.record(ctx, audit.ActionRestore, audit.EntityRole, id, before, after)
+	return after, nil
+}
+
+// roleKeyExists mirrors the unique_tenant_role_key index, which only covers
+// live roles.
+func (m *Memory) roleKeyExists(exceptID int, tenant, roleKey string) bool {
+	for id, existing := range m.roles {
+		if id != exceptID && existing.TenantID == tenant && existing.RoleKey == roleKey && existing.DeletedAt == nil {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *Memory) RoleAncestors(ctx context.Context, tenant string, id int) ([]models.Role, error) {
+	return m.roleRelatives(tenant, id, func(roleID int) []int { return m.parents[roleID] })
+}
+
+func (m *Memory) RoleDescendants(ctx context.Context, tenant string, id int) ([]models.Role, error) {
+	return m.roleRelatives(tenant, id, func(roleID int) []int {
+		children := []int{}
+		for _, childID := range sortedKeys(m.roles) {
+			for _, parentID := range m.parents[childID] {
+				if parentID == roleID {
+					children = append(children, childID)
+				}
+			}
+		}
+		return children
+	})
+}
+
+// roleRelatives walks next from id through live roles only, like the
+// recursive queries in Postgres.
+func (m *Memory) roleRelatives(tenant string, id int, next func(roleID int) []int) ([]models.Role, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.liveRole(tenant, id); !ok {
+		return nil, ErrNotFound
+	}
+
+	roles := []models.Role{}
+	seen := map[int]bool{id: true}
+	queue := []int{id}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		for _, relativeID := range next(current) {
+			if seen[relativeID] {
+				continue
+			}
+			seen[relativeID] = true
+			if role, ok := m.liveRole(tenant, relativeID); ok {
+				roles = append(roles, role)
+				queue = append(queue, relativeID)
+			}
+		}
+	}
+	return roles, nil
+}
+
+// checkParents rejects missing parents and any parent that already inherits
+// from roleID.
+func (m *Memory) checkParents(tenant string, roleID int, parentIDs []int) error {
+	for _, parentID := range parentIDs {
+		if parentID == roleID {
+			return ErrRoleCycle
+		}
+		if _, ok := m.liveRole(tenant, parentID); !ok {
+			return ErrParentRoleNotFound
+		}
+	}
+
+	seen := map[int]bool{}
+	queue := append([]int{}, parentIDs...)
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		if current == roleID {
+			return ErrRoleCycle
+		}
+		if seen[current] {
+			continue
+		}
+		seen[current] = true
+		queue = append(queue, m.parents[current]...)
+	}
+	return nil
+}
+
+// liveUserRole returns an unexpired, undeleted user role of tenant.
+func (m *Memory) liveUserRole(tenant string, id int) (models.UserRole, bool) {
+	userRole, ok := m.userRoles[id]
+	if !ok || userRole.TenantID != tenant || userRole.DeletedAt != nil {
+		return models.UserRole{}, false
+	}
+	if userRole.ValidUntil != nil && !userRole.ValidUntil.After(m.now()) {
+		return models.UserRole{}, false
+	}
+	userRole.RoleKey = m.roles[userRole.RoleID].RoleKey
+	return userRole, true
+}
+
+func (m *Memory) ListUserRoles(ctx context.Context, tenant string, filter UserRoleFilter, page listing.Params) ([]models.UserRole, string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var emailGroups map[int]bool
+	if filter.Email != "" {
+		emailGroups = m.groupsOf(tenant, filter.Email)
+	}
+
+	userRoles := []models.UserRole{}
+	for _, id := range sortedKeys(m.userRoles) {
+		userRole, ok := m.liveUserRole(tenant, id)
+		if !ok && filter.IncludeDeleted {
+			userRole, ok = m.userRoles[id]
+			ok = ok && userRole.TenantID == tenant
+			userRole.RoleKey = m.roles[userRole.RoleID].RoleKey
+		}
+		if !ok {
+			continue
+		}
+		if filter.Email != "" && userRole.Email != filter.Email && (userRole.GroupID == nil || !emailGroups[*userRole.GroupID]) {
+			continue
+		}
+		if filter.EmailPrefix != "" && !strings.HasPrefix(userRole.Email, filter.EmailPrefix) {
+			continue
+		}
+		if _, domain, _ := strings.Cut(userRole.Email, "@"); filter.EmailDomain != "" && domain != filter.EmailDomain {
+			continue
+		}
+		if filter.GroupID != 0 && (userRole.GroupID == nil || *userRole.GroupID != filter.GroupID) {
+			continue
+		}
+		if filter.ServiceAccountID != 0 && (userRole.ServiceAccountID == nil || *userRole.ServiceAccountID != filter.ServiceAccountID) {
+			continue
+		}
+		if filter.RoleID != 0 && userRole.RoleID != filter.RoleID {
+			continue
+		}
+		if filter.RoleKey != "" && userRole.RoleKey != filter.RoleKey {
+			continue
+		}
+		if !inRange(userRole.CreatedAt, filter.CreatedAfter, filter.CreatedBefore) {
+			continue
+		}
+		if filter.ResourceType != "" && !authz.ScopeMatches(userRole.ResourceType, userRole.ResourceID, filter.ResourceType+":"+filter.ResourceID) {
+			continue
+		}
+		userRoles = append(userRoles, userRole)
+	}
+	userRoles, next := listing.Apply(userRoles, page, userRoleSortKey)
+	return userRoles, next, nil
+}
+
+func (m *Memory) GetUserRole(ctx context.Context, tenant string, id int) (models.UserRole, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	userRole, ok := m.liveUserRole(tenant, id)
+	if !ok {
+		return models.UserRole{}, ErrNotFound
+	}
+	return userRole, nil
+}
+
+func (m *Memory) CreateUserRole(ctx context.Context, userRole models.UserRole) (models.UserRole, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	role, ok := m.liveRole(userRole.TenantID, userRole.RoleID)
+	if !ok {
+		return models.UserRole{}, ErrRoleNotFound
+	}
+	return m.createUserRole(ctx, userRole, role.RoleKey)
+}
+
+// createUserRole stores userRole, whose role is known to be live.
+func (m *Memory) createUserRole(ctx context.Context, userRole models.UserRole, roleKey string) (models.UserRole, error) {
+	userRole = withEffect(userRole)
+	if err := m.checkPrincipal(userRole.TenantID, userRole); err != nil {
+		return models.UserRole{}, err
+	}
+	if m.userRoleExists(0, userRole.TenantID, userRole) {
+		return models.UserRole{}, &ConstraintError{Err: ErrConflict, Constraint: "unique_email_role"}
+	}
+
+	m.nextUserRoleID++
+	userRole.ID = m.nextUserRoleID
+	userRole.RoleKey = roleKey
+	describeUserRole(&userRole)
+	userRole.CreatedAt = m.now()
+	userRole.UpdatedAt = userRole.CreatedAt
+	userRole.DeletedAt = nil
+	userRole.DeletedReason = nil
+	userRole.Version = 1
+	m.userRoles[userRole.ID] = userRole
+	m.record(ctx, audit.ActionCreate, audit.EntityUserRole, userRole.ID, nil, userRole)
+	return userRole, nil
+}
+
+func (m *Memory) UpdateUserRole(ctx context.Context, tenant string, id int, userRole models.UserRole) (models.UserRole, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	userRole = withEffect(userRole)
+	role, ok := m.liveRole(tenant, userRole.RoleID)
+	if !ok {
+		return models.UserRole{}, ErrRoleNotFound
+	}
+	if err := m.checkPrincipal(tenant, userRole); err != nil {
+		return models.UserRole{}, err
+	}
+	// Like Postgres, an update may still reach a grant that has expired but
+	// not yet been swept.
+	before, ok := m.userRoles[id]
+	if !ok || before.TenantID != tenant || before.DeletedAt != nil {
+		return models.UserRole{}, ErrNotFound
+	}
+	before.RoleKey = m.roles[before.RoleID].RoleKey
+	if err := checkVersion(before.Version, userRole.Version); err != nil {
+		return models.UserRole{}, err
+	}
+	if m.userRoleExists(id, tenant, userRole) {
+		return models.UserRole{}, &ConstraintError{Err: ErrConflict, Constraint: "unique_email_role"}
+	}
+
+	after := before
+	after.Email = userRole.Email
+	after.GroupID = userRole.GroupID
+	after.ServiceAccountID = userRole.ServiceAccountID
+	describeUserRole(&after)
+	after.RoleID = userRole.RoleID
+	after.RoleKey = role.RoleKey
+	after.ResourceType = userRole.ResourceType
+	after.ResourceID = userRole.ResourceID
+	after.ValidFrom = userRole.ValidFrom
+	after.ValidUntil = userRole.ValidUntil
+	after.Condition = userRole.Condition
+	after.Effect = userRole.Effect
+	after.UpdatedAt = m.now()
+	after.Version++
+	m.userRoles[id] = after
+	m.record(ctx, audit.ActionUpdate, audit.EntityUserRole, id, before, after)
+	return after, nil
+}
+
+func (m *Memory) DeleteUserRole(ctx context.Context, tenant string, id int, version int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, err := m.deleteUserRole(ctx, tenant, id, version)
+	return err
+}
+
+// deleteUserRole soft-deletes a live user role and returns it as it was
+// before.
+func (m *Memory) deleteUserRole(ctx context.Context, tenant string, id int, version int) (models.UserRole, error) {
+	before, ok := m.userRoles[id]
+	if !ok || before.TenantID != tenant || before.DeletedAt != nil {
+		return models.UserRole{}, ErrNotFound
+	}
+	before.RoleKey = m.roles[before.RoleID].RoleKey
+	if err := checkVersion(before.Version, version); err != nil {
+		return models.UserRole{}, err
+	}
+
+	m.removeUserRole(ctx, before, ReasonRevoked)
+	return before, nil
+}
+
+// BatchUserRoles applies the batch under one lock. An atomic batch that
+// fails is undone by restoring the user roles, ids and events it started
+// from.
+func (m *Memory) BatchUserRoles(ctx context.Context, tenant string, batch models.UserRoleBatch) ([]BatchResult, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	userRoles := make(map[int]models.UserRole, len(m.userRoles))
+	for id, userRole := range m.userRoles {
+		userRoles[id] = userRole
+	}
+	nextUserRoleID, events := m.nextUserRoleID, len(m.events)
+	rollback := func() {
+		m.userRoles, m.nextUserRoleID, m.events = userRoles, nextUserRoleID, m.events[:events]
+	}
+
+	roleKeys := map[int]string{}
+	for _, grant := range batch.Grants {
+		if _, ok := roleKeys[grant.RoleID]; !ok {
+			role, _ := m.liveRole(tenant, grant.RoleID)
+			roleKeys[grant.RoleID] = role.RoleKey
+		}
+	}
+
+	results := make([]BatchResult, 0, len(batch.Grants)+len(batch.Revocations))
+	for i, grant := range batch.Grants {
+		grant.TenantID = tenant
+		userRole, err := models.UserRole{}, ErrRoleNotFound
+		if roleKeys[grant.RoleID] != "" {
+			userRole, err = m.createUserRole(ctx, grant, roleKeys[grant.RoleID])
+		}
+		if err != nil && batch.Atomic {
+			rollback()
+			return nil, &BatchItemError{Op: BatchGrant, Index: i, Err: err}
+		}
+		results = append(results, BatchResult{UserRole: userRole, Err: err})
+	}
+	for i, revocation := range batch.Revocations {
+		userRole, err := m.deleteUserRole(ctx, tenant, revocation.ID, revocation.Version)
+		if err != nil && batch.Atomic {
+			rollback()
+			return nil, &BatchItemError{Op: BatchRevoke, Index: i, Err: err}
+		}
+		results = append(results, BatchResult{UserRole: userRole, Err: err})
+	}
+	return results, nil
+}
+
+func (m *Memory) RestoreUserRole(ctx context.Context, tenant string, id int) (models.UserRole, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	before, ok := m.userRoles[id]
+	if !ok || before.TenantID != tenant {
+		return models.UserRole{}, ErrNotFound
+	}
+	if before.DeletedAt == nil {
+		return models.UserRole{}, ErrNotDeleted
+	}
+	if before.ValidUntil != nil && !before.ValidUntil.After(m.now()) {
+		return models.UserRole{}, ErrUserRoleExpired
+	}
+	before.RoleKey = m.roles[before.RoleID].RoleKey
+	if _, ok := m.liveRole(tenant, before.RoleID); !ok {
+		return models.UserRole{}, ErrRoleNotFound
+	}
+	if err := m.checkPrincipal(tenant, before); err != nil {
+		return models.UserRole{}, err
+	}
+	if m.userRoleExists(id, tenant, before) {
+		return models.UserRole{}, &ConstraintError{Err: ErrConflict, Constraint: "unique_email_role"}
+	}
+
+	after := before
+	after.DeletedAt = nil
+	after.DeletedReason = nil
+	after.UpdatedAt = m.now()
+	after.Version++
+	m.userRoles[id] = after
+	m.record(ctx, audit.ActionRestore, audit.EntityUserRole, id, before, after)
+	return after, nil
+}
+
+func (m *Memory) SyncRoleMembers(ctx context.Context, tenant string, roleID int, emails []string, dryRun bool) (models.RoleMembersDiff, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	role, ok := m.liveRole(tenant, roleID)
+	if !ok {
+		return models.RoleMembersDiff{}, ErrNotFound
+	}
+
+	current, expired := map[string]models.UserRole{}, map[string]models.UserRole{}
+	for _, id := range sortedKeys(m.userRoles) {
+		grant := m.userRoles[id]
+		if grant.TenantID != tenant || grant.RoleID != roleID || grant.DeletedAt != nil || grant.ResourceType != "" || grant.Email == "" || grant.Effect == models.EffectDeny {
+			continue
+		}
+		grant.RoleKey = role.RoleKey
+		if grant.ValidUntil != nil && !grant.ValidUntil.After(m.now()) {
+			expired[grant.Email] = grant
+		} else {
+			current[grant.Email] = grant
+		}
+	}
+
+	diff := diffMembers(current, emails, roleID, dryRun)
+	if dryRun {
+		return diff, nil
+	}
+	for _, email := range diff.Removed {
+		m.removeUserRole(ctx, current[email], ReasonMembersSynced)
+	}
+	for _, email := range diff.Added {
+		if grant, ok := expired[email]; ok {
+			m.removeUserRole(ctx, grant, ReasonMembersSynced)
+		}
+		if _, err := m.createUserRole(ctx, models.UserRole{TenantID: tenant, Email: email, RoleID: roleID}, role.RoleKey); err != nil {
+			return models.RoleMembersDiff{}, err
+		}
+	}
+	return diff, nil
+}
+
+// removeUserRole soft-deletes a live user role, recording reason.
+func (m *Memory) removeUserRole(ctx context.Context, before models.UserRole, reason string) {
+	userRole := m.userRoles[before.ID]
+	deletedAt := m.now()
+	userRole.DeletedAt = &deletedAt
+	userRole.DeletedReason = &reason
+	userRole.UpdatedAt = deletedAt
+	userRole.Version++
+	m.userRoles[before.ID] = userRole
+	m.record(ctx, audit.ActionDelete, audit.EntityUserRole, before.ID, before, nil)
+}
+
+// userRoleExists mirrors the unique_email_role index, which only covers
+// live rows, expired or not.
+func (m *Memory) userRoleExists(exceptID int, tenant string, userRole models.UserRole) bool {
+	for id, existing := range m.userRoles {
+		if id != exceptID && existing.TenantID == tenant && existing.DeletedAt == nil && existing.Email == userRole.Email &&
+			sameID(existing.GroupID, userRole.GroupID) && sameID(existing.ServiceAccountID, userRole.ServiceAccountID) &&
+			existing.RoleID == userRole.RoleID && existing.ResourceType == userRole.ResourceType && existing.ResourceID == userRole.ResourceID &&
+			existing.Effect == userRole.Effect {
+			return true
+		}
+	}
+	return false
+}
+
+// sameID reports whether two optional ids are equal.
+func sameID(a, b *int) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return *a == *b
+}
+
+func sortedKeys[V any](m map[int]V) []int {
+	keys := make([]int, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Ints(keys)
+	return keys
+}
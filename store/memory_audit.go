@@ -0,0 +1,56 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	models "main/Models"
+	"main/listing"
+)
+
+func (m *Memory) ListAuditEvents(ctx context.Context, tenant string, filter AuditFilter, page listing.Params) ([]models.AuditEvent, string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	events := []models.AuditEvent{}
+	for i, recorded := range m.events {
+		if recorded.TenantID != tenant ||
+			(filter.Actor != "" && recorded.Actor != filter.Actor) ||
+			(filter.Action != "" && recorded.Action != filter.Action) ||
+			(filter.EntityType != "" && recorded.EntityType != filter.EntityType) ||
+			(filter.EntityID != nil && recorded.EntityID != *filter.EntityID) ||
+			(filter.RequestID != "" && recorded.RequestID != filter.RequestID) ||
+			!inRange(m.eventTimes[i], filter.CreatedAfter, filter.CreatedBefore) {
+			continue
+		}
+
+		event := models.AuditEvent{
+			ID:         i + 1,
+			TenantID:   recorded.TenantID,
+			Actor:      recorded.Actor,
+			Action:     recorded.Action,
+			EntityType: recorded.EntityType,
+			EntityID:   recorded.EntityID,
+			RequestID:  recorded.RequestID,
+			CreatedAt:  m.eventTimes[i],
+		}
+		var err error
+		if event.Before, err = marshalState(recorded.Before); err != nil {
+			return nil, "", err
+		}
+		if event.After, err = marshalState(recorded.After); err != nil {
+			return nil, "", err
+		}
+		events = append(events, event)
+	}
+	events, next := listing.Apply(events, page, auditEventSortKey)
+	return events, next, nil
+}
+
+// marshalState encodes the state of an audit event as Postgres stores it:
+// JSON, or nothing for a missing state.
+func marshalState(state interface{}) (json.RawMessage, error) {
+	if state == nil {
+		return nil, nil
+	}
+	return json.Marshal(state)
+}
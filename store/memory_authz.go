@@ -0,0 +1,201 @@
+package store
+
+import (
+	"context"
+	models "main/Models"
+	"main/authz"
+	"sort"
+	"strconv"
+)
+
+// memorySource is the authz.Source of checks made against a Memory. Its
+// callers hold m.mu.
+type memorySource struct {
+	m *Memory
+}
+
+func (m *Memory) Check(ctx context.Context, tenant string, req models.AuthorizationRequest) (models.AuthorizationDecision, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return authz.Check(ctx, memorySource{m}, tenant, req)
+}
+
+func (m *Memory) CheckBatch(ctx context.Context, tenant string, reqs []models.AuthorizationRequest) ([]models.AuthorizationDecision, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return authz.CheckBatch(ctx, memorySource{m}, tenant, reqs)
+}
+
+func (m *Memory) Explain(ctx context.Context, tenant string, req models.AuthorizationRequest) (models.AuthorizationExplanation, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return authz.Explain(ctx, memorySource{m}, tenant, req)
+}
+
+// grantees returns a filter matching the user roles held by principal, as
+// principalConditions in package authz selects them. It reports false when a
+// group or service account id is not numeric.
+func (m *Memory) grantees(tenant string, principal models.Principal) (func(userRole models.UserRole) bool, bool) {
+	switch principal.Type {
+	case models.PrincipalUser:
+		groups := m.groupsOf(tenant, principal.ID)
+		return func(userRole models.UserRole) bool {
+			return userRole.Email == principal.ID || (userRole.GroupID != nil && groups[*userRole.GroupID])
+		}, true
+	case models.PrincipalService:
+		id, err := strconv.Atoi(principal.ID)
+		return func(userRole models.UserRole) bool {
+			return userRole.ServiceAccountID != nil && *userRole.ServiceAccountID == id
+		}, err == nil
+	case models.PrincipalGroup:
+		id, err := strconv.Atoi(principal.ID)
+		groups := m.containingGroups(tenant, []int{id})
+		return func(userRole models.UserRole) bool {
+			return userRole.GroupID != nil && groups[*userRole.GroupID]
+		}, err == nil
+	}
+	return nil, false
+}
+
+func (s memorySource) Assignments(ctx context.Context, tenant string, principal models.Principal, inactive bool) ([]authz.Assignment, error) {
+	m := s.m
+	assignments := []authz.Assignment{}
+	held, ok := m.grantees(tenant, principal)
+	if !ok {
+		return assignments, nil
+	}
+
+	now := m.now()
+	for _, id := range sortedKeys(m.userRoles) {
+		userRole := m.userRoles[id]
+		if userRole.TenantID != tenant || !held(userRole) {
+			continue
+		}
+		if userRole.DeletedAt != nil && !(inactive && sweptOrCascaded(userRole)) {
+			continue
+		}
+		role := m.roles[userRole.RoleID]
+		a := authz.Assignment{
+			UserRoleID:   userRole.ID,
+			GroupID:      userRole.GroupID,
+			RoleID:       role.ID,
+			RoleKey:      role.RoleKey,
+			ResourceType: userRole.ResourceType,
+			ResourceID:   userRole.ResourceID,
+			Condition:    userRole.Condition,
+			Effect:       withEffect(userRole).Effect,
+		}
+		switch {
+		case userRole.DeletedAt != nil:
+			a.Inactive = *userRole.DeletedReason
+		case role.DeletedAt != nil:
+			a.Inactive = authz.SkipRoleDeleted
+		case userRole.ValidFrom != nil && userRole.ValidFrom.After(now):
+			a.Inactive = authz.SkipNotYetValid
+		case userRole.ValidUntil != nil && !userRole.ValidUntil.After(now):
+			a.Inactive = authz.SkipExpired
+		}
+		if a.Inactive == "" || inactive {
+			assignments = append(assignments, a)
+		}
+	}
+	return assignments, nil
+}
+
+// sweptOrCascaded reports whether userRole was soft-deleted by the sweeper
+// or by a cascading role deletion, which Explain still reports until it is
+// purged.
+func sweptOrCascaded(userRole models.UserRole) bool {
+	reason := userRole.DeletedReason
+	return reason != nil && (*reason == ReasonExpired || *reason == ReasonRoleDeleted)
+}
+
+func (s memorySource) RoleParents(ctx context.Context, tenant string, roleIDs []int) ([]authz.RoleParent, error) {
+	m := s.m
+	edges := []authz.RoleParent{}
+	for roleID := range m.liveClosure(roleIDs) {
+		for _, parentID := range m.parents[roleID] {
+			if parent := m.roles[parentID]; parent.TenantID == tenant && parent.DeletedAt == nil {
+				edges = append(edges, authz.RoleParent{RoleID: roleID, ParentRoleID: parentID, ParentKey: parent.RoleKey})
+			}
+		}
+	}
+	sort.Slice(edges, func(i, j int) bool {
+		if edges[i].RoleID != edges[j].RoleID {
+			return edges[i].RoleID < edges[j].RoleID
+		}
+		return edges[i].ParentRoleID < edges[j].ParentRoleID
+	})
+	return edges, nil
+}
+
+func (s memorySource) RolePermissions(ctx context.Context, tenant string, roleIDs []int) ([]authz.Binding, error) {
+	set := map[int]bool{}
+	for _, id := range roleIDs {
+		set[id] = true
+	}
+	bindings := []authz.Binding{}
+	for _, rolePermission := range s.m.rolePermissionsOf(tenant, set) {
+		bindings = append(bindings, authz.Binding{
+			RolePermissionID: rolePermission.ID,
+			RoleID:           rolePermission.RoleID,
+			PermissionKey:    rolePermission.PermissionKey,
+			Condition:        rolePermission.Condition,
+			Effect:           rolePermission.Effect,
+		})
+	}
+	return bindings, nil
+}
+
+func (m *Memory) RoleKeys(ctx context.Context, tenant string, roleIDs, userRoleIDs []int) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	keys := map[string]bool{}
+	for _, id := range userRoleIDs {
+		if userRole, ok := m.userRoles[id]; ok && userRole.TenantID == tenant {
+			roleIDs = append(roleIDs, userRole.RoleID)
+		}
+	}
+	named := []int{}
+	for _, id := range roleIDs {
+		if _, ok := m.role(tenant, id); ok {
+			named = append(named, id)
+		}
+	}
+	for id := range m.liveClosure(named) {
+		keys[m.roles[id].RoleKey] = true
+	}
+	return sortedStrings(keys), nil
+}
+
+func (m *Memory) HeldRoleKeys(ctx context.Context, tenant string, principal models.Principal) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	held, ok := m.grantees(tenant, principal)
+	if !ok || principal.Type == models.PrincipalUser {
+		return []string{}, nil
+	}
+	roleIDs := []int{}
+	for _, userRole := range m.userRoles {
+		if _, live := m.liveRole(tenant, userRole.RoleID); live && userRole.TenantID == tenant && userRole.DeletedAt == nil && held(userRole) {
+			roleIDs = append(roleIDs, userRole.RoleID)
+		}
+	}
+	keys := map[string]bool{}
+	for id := range m.liveClosure(roleIDs) {
+		keys[m.roles[id].RoleKey] = true
+	}
+	return sortedStrings(keys), nil
+}
+
+// sortedStrings returns the members of set in order.
+func sortedStrings(set map[string]bool) []string {
+	values := make([]string, 0, len(set))
+	for value := range set {
+		values = append(values, value)
+	}
+	sort.Strings(values)
+	return values
+}
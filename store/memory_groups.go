@@ -0,0 +1,266 @@
+package store
+
+import (
+	"context"
+	models "main/Models"
+	"main/audit"
+	"main/listing"
+)
+
+// liveGroup returns an undeleted group of tenant.
+func (m *Memory) liveGroup(tenant string, id int) (models.Group, bool) {
+	group, ok := m.groups[id]
+	if !ok || group.TenantID != tenant || group.DeletedAt != nil {
+		return models.Group{}, false
+	}
+	return group, true
+}
+
+// liveGroupOrNil reports whether id is nil or names a live group of tenant,
+// like checkGroup does in Postgres.
+func (m *Memory) liveGroupOrNil(tenant string, id *int) bool {
+	if id == nil {
+		return true
+	}
+	_, ok := m.liveGroup(tenant, *id)
+	return ok
+}
+
+// groupsOf returns the ids of the live groups that contain email, directly
+// or through nested groups.
+func (m *Memory) groupsOf(tenant, email string) map[int]bool {
+	queue := []int{}
+	for _, member := range m.groupMembers {
+		if member.MemberGroupID == nil && member.Email == email {
+			queue = append(queue, member.GroupID)
+		}
+	}
+	return m.containingGroups(tenant, queue)
+}
+
+// containingGroups returns the ids of the live groups among queue and of
+// every live group that contains one of them, however deeply.
+func (m *Memory) containingGroups(tenant string, queue []int) map[int]bool {
+	groups := map[int]bool{}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		if groups[current] {
+			continue
+		}
+		if _, ok := m.liveGroup(tenant, current); !ok {
+			continue
+		}
+		groups[current] = true
+		for _, member := range m.groupMembers {
+			if member.MemberGroupID != nil && *member.MemberGroupID == current {
+				queue = append(queue, member.GroupID)
+			}
+		}
+	}
+	return groups
+}
+
+// containsGroup reports whether groupID is memberGroupID or is nested in it,
+// however deeply.
+func (m *Memory) containsGroup(memberGroupID, groupID int) bool {
+	seen := map[int]bool{}
+	queue := []int{memberGroupID}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		if current == groupID {
+			return true
+		}
+		if seen[current] {
+			continue
+		}
+		seen[current] = true
+		for _, member := range m.groupMembers {
+			if member.GroupID == current && member.MemberGroupID != nil {
+				queue = append(queue, *member.MemberGroupID)
+			}
+		}
+	}
+	return false
+}
+
+func (m *Memory) ListGroups(ctx context.Context, tenant string, filter GroupFilter, page listing.Params) ([]models.Group, string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	groups := []models.Group{}
+	for _, id := range sortedKeys(m.groups) {
+		group, ok := m.liveGroup(tenant, id)
+		if !ok {
+			continue
+		}
+		if filter.GroupKey != "" && group.GroupKey != filter.GroupKey {
+			continue
+		}
+		groups = append(groups, group)
+	}
+	groups, next := listing.Apply(groups, page, groupSortKey)
+	return groups, next, nil
+}
+
+func (m *Memory) GetGroup(ctx context.Context, tenant string, id int) (models.Group, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	group, ok := m.liveGroup(tenant, id)
+	if !ok {
+		return models.Group{}, ErrNotFound
+	}
+	return group, nil
+}
+
+func (m *Memory) CreateGroup(ctx context.Context, group models.Group) (models.Group, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.groupKeyExists(0, group.TenantID, group.GroupKey) {
+		return models.Group{}, &ConstraintError{Err: ErrConflict, Constraint: "unique_tenant_group_key"}
+	}
+
+	m.nextGroupID++
+	group.ID = m.nextGroupID
+	group.CreatedAt = m.now()
+	group.UpdatedAt = group.CreatedAt
+	group.DeletedAt = nil
+	group.Version = 1
+	m.groups[group.ID] = group
+	m.record(ctx, audit.ActionCreate, audit.EntityGroup, group.ID, nil, group)
+	return group, nil
+}
+
+func (m *Memory) UpdateGroup(ctx context.Context, tenant string, id int, group models.Group) (models.Group, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	before, ok := m.liveGroup(tenant, id)
+	if !ok {
+		return models.Group{}, ErrNotFound
+	}
+	if err := checkVersion(before.Version, group.Version); err != nil {
+		return models.Group{}, err
+	}
+	if m.groupKeyExists(id, tenant, group.GroupKey) {
+		return models.Group{}, &ConstraintError{Err: ErrConflict, Constraint: "unique_tenant_group_key"}
+	}
+
+	after := before
+	after.GroupKey = group.GroupKey
+	after.Description = group.Description
+	after.UpdatedAt = m.now()
+	after.Version++
+	m.groups[id] = after
+	m.record(ctx, audit.ActionUpdate, audit.EntityGroup, id, before, after)
+	return after, nil
+}
+
+func (m *Memory) DeleteGroup(ctx context.Context, tenant string, id int, version int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	before, ok := m.liveGroup(tenant, id)
+	if !ok {
+		return ErrNotFound
+	}
+	if err := checkVersion(before.Version, version); err != nil {
+		return err
+	}
+
+	for _, userRoleID := range sortedKeys(m.userRoles) {
+		grant := m.userRoles[userRoleID]
+		if grant.TenantID == tenant && grant.DeletedAt == nil && grant.GroupID != nil && *grant.GroupID == id {
+			grant.RoleKey = m.roles[grant.RoleID].RoleKey
+			m.removeUserRole(ctx, grant, ReasonGroupDeleted)
+		}
+	}
+
+	deletedAt := m.now()
+	after := before
+	after.DeletedAt = &deletedAt
+	after.Version++
+	m.groups[id] = after
+	m.record(ctx, audit.ActionDelete, audit.EntityGroup, id, before, nil)
+	return nil
+}
+
+func (m *Memory) ListGroupMembers(ctx context.Context, tenant string, groupID int) ([]models.GroupMember, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.liveGroup(tenant, groupID); !ok {
+		return nil, ErrNotFound
+	}
+	members := []models.GroupMember{}
+	for _, id := range sortedKeys(m.groupMembers) {
+		if member := m.groupMembers[id]; member.GroupID == groupID {
+			members = append(members, member)
+		}
+	}
+	return members, nil
+}
+
+func (m *Memory) AddGroupMember(ctx context.Context, tenant string, member models.GroupMember) (models.GroupMember, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.liveGroup(tenant, member.GroupID); !ok {
+		return models.GroupMember{}, ErrNotFound
+	}
+	if member.MemberGroupID != nil {
+		if !m.liveGroupOrNil(tenant, member.MemberGroupID) {
+			return models.GroupMember{}, ErrGroupNotFound
+		}
+		if m.containsGroup(*member.MemberGroupID, member.GroupID) {
+			return models.GroupMember{}, ErrGroupCycle
+		}
+	}
+	for _, existing := range m.groupMembers {
+		if existing.GroupID != member.GroupID {
+			continue
+		}
+		if member.MemberGroupID == nil && existing.MemberGroupID == nil && existing.Email == member.Email {
+			return models.GroupMember{}, &ConstraintError{Err: ErrConflict, Constraint: "unique_group_member_email"}
+		}
+		if member.MemberGroupID != nil && sameID(existing.MemberGroupID, member.MemberGroupID) {
+			return models.GroupMember{}, &ConstraintError{Err: ErrConflict, Constraint: "unique_group_member_group"}
+		}
+	}
+
+	m.nextGroupMemberID++
+	member.ID = m.nextGroupMemberID
+	member.CreatedAt = m.now()
+	m.groupMembers[member.ID] = member
+	m.record(ctx, audit.ActionCreate, audit.EntityGroupMember, member.ID, nil, member)
+	return member, nil
+}
+
+func (m *Memory) RemoveGroupMember(ctx context.Context, tenant string, groupID, memberID int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.liveGroup(tenant, groupID); !ok {
+		return ErrNotFound
+	}
+	before, ok := m.groupMembers[memberID]
+	if !ok || before.GroupID != groupID {
+		return ErrNotFound
+	}
+	delete(m.groupMembers, memberID)
+	m.record(ctx, audit.ActionDelete, audit.EntityGroupMember, memberID, before, nil)
+	return nil
+}
+
+// groupKeyExists mirrors the unique_tenant_group_key index.
+func (m *Memory) groupKeyExists(exceptID int, tenant, groupKey string) bool {
+	for id, group := range m.groups {
+		if id != exceptID && group.TenantID == tenant && group.DeletedAt == nil && group.GroupKey == groupKey {
+			return true
+		}
+	}
+	return false
+}
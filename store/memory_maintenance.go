@@ -0,0 +1,91 @@
+package store
+
+import (
+	"context"
+	"main/audit"
+	"main/utils"
+	"time"
+)
+
+func (m *Memory) SweepExpiredUserRoles(ctx context.Context) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	var swept int64
+	for _, id := range sortedKeys(m.userRoles) {
+		userRole := m.userRoles[id]
+		if userRole.DeletedAt != nil || userRole.ValidUntil == nil || userRole.ValidUntil.After(now) {
+			continue
+		}
+		m.expireUserRole(ctx, id)
+		swept++
+	}
+	return swept, nil
+}
+
+// expireUserRole soft-deletes the user role id with reason expired and
+// records an expire event for it.
+func (m *Memory) expireUserRole(ctx context.Context, id int) {
+	userRole := m.userRoles[id]
+	now := m.now()
+	reason := ReasonExpired
+	deleted := userRole
+	deleted.DeletedAt = &now
+	deleted.DeletedReason = &reason
+	deleted.UpdatedAt = now
+	deleted.Version++
+	m.userRoles[id] = deleted
+	m.record(utils.WithTenant(ctx, userRole.TenantID), audit.ActionExpire, audit.EntityUserRole, id, userRole, nil)
+}
+
+func (m *Memory) PurgeDeleted(ctx context.Context, retention time.Duration) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	cutoff := m.now().Add(-retention)
+	var purged int64
+	for _, id := range sortedKeys(m.userRoles) {
+		userRole := m.userRoles[id]
+		if userRole.DeletedAt == nil || !userRole.DeletedAt.Before(cutoff) {
+			continue
+		}
+		delete(m.userRoles, id)
+		m.record(utils.WithTenant(ctx, userRole.TenantID), audit.ActionPurge, audit.EntityUserRole, id, userRole, nil)
+		purged++
+	}
+
+	// A deleted role is kept while any user role still references it.
+	referenced := map[int]bool{}
+	for _, userRole := range m.userRoles {
+		referenced[userRole.RoleID] = true
+	}
+	for _, id := range sortedKeys(m.roles) {
+		role := m.roles[id]
+		if role.DeletedAt == nil || referenced[id] {
+			continue
+		}
+		if deletedAt, err := time.Parse(time.RFC3339Nano, *role.DeletedAt); err != nil || !deletedAt.Before(cutoff) {
+			continue
+		}
+		delete(m.roles, id)
+		delete(m.parents, id)
+		for childID, parentIDs := range m.parents {
+			kept := []int{}
+			for _, parentID := range parentIDs {
+				if parentID != id {
+					kept = append(kept, parentID)
+				}
+			}
+			m.parents[childID] = kept
+		}
+		for rolePermissionID, rolePermission := range m.rolePermissions {
+			if rolePermission.RoleID == id {
+				delete(m.rolePermissions, rolePermissionID)
+			}
+		}
+		m.record(utils.WithTenant(ctx, role.TenantID), audit.ActionPurge, audit.EntityRole, id, role, nil)
+		purged++
+	}
+	return purged, nil
+}
@@ -0,0 +1,210 @@
+package store
+
+import (
+	"context"
+	models "main/Models"
+	"main/audit"
+	"main/listing"
+)
+
+// livePermission returns an undeleted permission of tenant.
+func (m *Memory) livePermission(tenant string, id int) (models.Permission, bool) {
+	permission, ok := m.permissions[id]
+	if !ok || permission.TenantID != tenant || permission.DeletedAt != nil {
+		return models.Permission{}, false
+	}
+	return permission, true
+}
+
+// permissionKeyExists reports whether another permission of tenant, deleted
+// or not, has permissionKey, as unique_tenant_permission_key does.
+func (m *Memory) permissionKeyExists(exceptID int, tenant, permissionKey string) bool {
+	for id, permission := range m.permissions {
+		if id != exceptID && permission.TenantID == tenant && permission.PermissionKey == permissionKey {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *Memory) ListPermissions(ctx context.Context, tenant string, filter PermissionFilter, page listing.Params) ([]models.Permission, string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	permissions := []models.Permission{}
+	for _, id := range sortedKeys(m.permissions) {
+		permission, ok := m.livePermission(tenant, id)
+		if !ok {
+			continue
+		}
+		if filter.PermissionKey != "" && permission.PermissionKey != filter.PermissionKey {
+			continue
+		}
+		if !inRange(permission.CreatedAt, filter.CreatedAfter, filter.CreatedBefore) {
+			continue
+		}
+		permissions = append(permissions, permission)
+	}
+	permissions, next := listing.Apply(permissions, page, permissionSortKey)
+	return permissions, next, nil
+}
+
+func (m *Memory) GetPermission(ctx context.Context, tenant string, id int) (models.Permission, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	permission, ok := m.livePermission(tenant, id)
+	if !ok {
+		return models.Permission{}, ErrNotFound
+	}
+	return permission, nil
+}
+
+func (m *Memory) CreatePermission(ctx context.Context, permission models.Permission) (models.Permission, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.permissionKeyExists(0, permission.TenantID, permission.PermissionKey) {
+		return models.Permission{}, &ConstraintError{Err: ErrConflict, Constraint: "unique_tenant_permission_key"}
+	}
+
+	m.nextPermissionID++
+	permission.ID = m.nextPermissionID
+	permission.CreatedAt = m.now()
+	permission.UpdatedAt = permission.CreatedAt
+	permission.DeletedAt = nil
+	m.permissions[permission.ID] = permission
+	m.record(ctx, audit.ActionCreate, audit.EntityPermission, permission.ID, nil, permission)
+	return permission, nil
+}
+
+func (m *Memory) UpdatePermission(ctx context.Context, tenant string, id int, permission models.Permission) (models.Permission, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	before, ok := m.livePermission(tenant, id)
+	if !ok {
+		return models.Permission{}, ErrNotFound
+	}
+	if m.permissionKeyExists(id, tenant, permission.PermissionKey) {
+		return models.Permission{}, &ConstraintError{Err: ErrConflict, Constraint: "unique_tenant_permission_key"}
+	}
+
+	after := before
+	after.PermissionKey = permission.PermissionKey
+	after.Description = permission.Description
+	after.UpdatedAt = m.now()
+	m.permissions[id] = after
+	m.record(ctx, audit.ActionUpdate, audit.EntityPermission, id, before, after)
+	return after, nil
+}
+
+func (m *Memory) DeletePermission(ctx context.Context, tenant string, id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	before, ok := m.livePermission(tenant, id)
+	if !ok {
+		return ErrNotFound
+	}
+
+	after := before
+	now := m.now()
+	after.DeletedAt = &now
+	m.permissions[id] = after
+	m.record(ctx, audit.ActionDelete, audit.EntityPermission, id, before, nil)
+	return nil
+}
+
+// rolePermissionsOf returns the bindings of tenant's roles in roleIDs to
+// live permissions, ordered by id, with their permission keys set.
+func (m *Memory) rolePermissionsOf(tenant string, roleIDs map[int]bool) []models.RolePermission {
+	rolePermissions := []models.RolePermission{}
+	for _, id := range sortedKeys(m.rolePermissions) {
+		rolePermission := m.rolePermissions[id]
+		permission, ok := m.livePermission(tenant, rolePermission.PermissionID)
+		if !ok || !roleIDs[rolePermission.RoleID] || m.roles[rolePermission.RoleID].TenantID != tenant {
+			continue
+		}
+		rolePermission.PermissionKey = permission.PermissionKey
+		rolePermissions = append(rolePermissions, rolePermission)
+	}
+	return rolePermissions
+}
+
+func (m *Memory) ListRolePermissions(ctx context.Context, tenant string, roleID int, effective bool) ([]models.RolePermission, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	roleIDs := map[int]bool{roleID: true}
+	if effective {
+		roleIDs = map[int]bool{}
+		if _, ok := m.liveRole(tenant, roleID); ok {
+			roleIDs = m.liveClosure([]int{roleID})
+		}
+	}
+	return m.rolePermissionsOf(tenant, roleIDs), nil
+}
+
+// liveClosure returns roleIDs and every live role they inherit from,
+// following live parents only.
+func (m *Memory) liveClosure(roleIDs []int) map[int]bool {
+	closure := map[int]bool{}
+	queue := append([]int{}, roleIDs...)
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		if closure[current] {
+			continue
+		}
+		closure[current] = true
+		for _, parentID := range m.parents[current] {
+			if m.roles[parentID].DeletedAt == nil {
+				queue = append(queue, parentID)
+			}
+		}
+	}
+	return closure
+}
+
+func (m *Memory) AddRolePermission(ctx context.Context, tenant string, rolePermission models.RolePermission) (models.RolePermission, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.liveRole(tenant, rolePermission.RoleID); !ok {
+		return models.RolePermission{}, ErrNotFound
+	}
+	permission, ok := m.livePermission(tenant, rolePermission.PermissionID)
+	if !ok {
+		return models.RolePermission{}, ErrPermissionNotFound
+	}
+	for _, existing := range m.rolePermissions {
+		if existing.RoleID == rolePermission.RoleID && existing.PermissionID == rolePermission.PermissionID {
+			return models.RolePermission{}, &ConstraintError{Err: ErrConflict, Constraint: "unique_role_permission"}
+		}
+	}
+
+	m.nextRolePermissionID++
+	rolePermission.ID = m.nextRolePermissionID
+	rolePermission.PermissionKey = permission.PermissionKey
+	rolePermission.CreatedAt = m.now()
+	m.rolePermissions[rolePermission.ID] = rolePermission
+	m.record(ctx, audit.ActionCreate, audit.EntityRolePermission, rolePermission.ID, nil, rolePermission)
+	return rolePermission, nil
+}
+
+func (m *Memory) RemoveRolePermission(ctx context.Context, tenant string, roleID, permissionID int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for id, before := range m.rolePermissions {
+		if before.RoleID != roleID || before.PermissionID != permissionID || m.roles[roleID].TenantID != tenant {
+			continue
+		}
+		before.PermissionKey = m.permissions[permissionID].PermissionKey
+		delete(m.rolePermissions, id)
+		m.record(ctx, audit.ActionDelete, audit.EntityRolePermission, id, before, nil)
+		return nil
+	}
+	return ErrNotFound
+}
@@ -0,0 +1,244 @@
+package store
+
+import (
+	"context"
+	models "main/Models"
+	"main/audit"
+	"main/listing"
+	"time"
+)
+
+// liveServiceAccount returns an undeleted service account of tenant.
+func (m *Memory) liveServiceAccount(tenant string, id int) (models.ServiceAccount, bool) {
+	account, ok := m.accounts[id]
+	if !ok || account.TenantID != tenant || account.DeletedAt != nil {
+		return models.ServiceAccount{}, false
+	}
+	return account, true
+}
+
+// checkPrincipal fails unless the group or service account userRole is
+// granted to, if any, is live, like checkPrincipal in Postgres.
+func (m *Memory) checkPrincipal(tenant string, userRole models.UserRole) error {
+	if !m.liveGroupOrNil(tenant, userRole.GroupID) {
+		return ErrGroupNotFound
+	}
+	if userRole.ServiceAccountID != nil {
+		if _, ok := m.liveServiceAccount(tenant, *userRole.ServiceAccountID); !ok {
+			return ErrServiceAccountNotFound
+		}
+	}
+	return nil
+}
+
+// credentialActive reports whether credential is neither revoked nor expired.
+func (m *Memory) credentialActive(credential models.ServiceAccountCredential) bool {
+	return credential.RevokedAt == nil && (credential.ExpiresAt == nil || credential.ExpiresAt.After(m.now()))
+}
+
+func (m *Memory) ListServiceAccounts(ctx context.Context, tenant string, filter ServiceAccountFilter, page listing.Params) ([]models.ServiceAccount, string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	accounts := []models.ServiceAccount{}
+	for _, id := range sortedKeys(m.accounts) {
+		account, ok := m.liveServiceAccount(tenant, id)
+		if !ok {
+			continue
+		}
+		if filter.Name != "" && account.Name != filter.Name {
+			continue
+		}
+		accounts = append(accounts, account)
+	}
+	accounts, next := listing.Apply(accounts, page, serviceAccountSortKey)
+	return accounts, next, nil
+}
+
+func (m *Memory) GetServiceAccount(ctx context.Context, tenant string, id int) (models.ServiceAccount, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	account, ok := m.liveServiceAccount(tenant, id)
+	if !ok {
+		return models.ServiceAccount{}, ErrNotFound
+	}
+	return account, nil
+}
+
+func (m *Memory) CreateServiceAccount(ctx context.Context, account models.ServiceAccount) (models.ServiceAccount, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.serviceAccountNameExists(0, account.TenantID, account.Name) {
+		return models.ServiceAccount{}, &ConstraintError{Err: ErrConflict, Constraint: "unique_tenant_service_account_name"}
+	}
+
+	m.nextAccountID++
+	account.ID = m.nextAccountID
+	account.CreatedAt = m.now()
+	account.UpdatedAt = account.CreatedAt
+	account.DeletedAt = nil
+	account.Version = 1
+	m.accounts[account.ID] = account
+	m.record(ctx, audit.ActionCreate, audit.EntityServiceAccount, account.ID, nil, account)
+	return account, nil
+}
+
+func (m *Memory) UpdateServiceAccount(ctx context.Context, tenant string, id int, account models.ServiceAccount) (models.ServiceAccount, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	before, ok := m.liveServiceAccount(tenant, id)
+	if !ok {
+		return models.ServiceAccount{}, ErrNotFound
+	}
+	if err := checkVersion(before.Version, account.Version); err != nil {
+		return models.ServiceAccount{}, err
+	}
+	if m.serviceAccountNameExists(id, tenant, account.Name) {
+		return models.ServiceAccount{}, &ConstraintError{Err: ErrConflict, Constraint: "unique_tenant_service_account_name"}
+	}
+
+	after := before
+	after.Name = account.Name
+	after.DisplayName = account.DisplayName
+	after.UpdatedAt = m.now()
+	after.Version++
+	m.accounts[id] = after
+	m.record(ctx, audit.ActionUpdate, audit.EntityServiceAccount, id, before, after)
+	return after, nil
+}
+
+func (m *Memory) DeleteServiceAccount(ctx context.Context, tenant string, id int, version int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	before, ok := m.liveServiceAccount(tenant, id)
+	if !ok {
+		return ErrNotFound
+	}
+	if err := checkVersion(before.Version, version); err != nil {
+		return err
+	}
+
+	for _, userRoleID := range sortedKeys(m.userRoles) {
+		grant := m.userRoles[userRoleID]
+		if grant.TenantID == tenant && grant.DeletedAt == nil && grant.ServiceAccountID != nil && *grant.ServiceAccountID == id {
+			grant.RoleKey = m.roles[grant.RoleID].RoleKey
+			m.removeUserRole(ctx, grant, ReasonServiceAccountDeleted)
+		}
+	}
+	deletedAt := m.now()
+	for credentialID, credential := range m.credentials {
+		if credential.ServiceAccountID == id && credential.RevokedAt == nil {
+			credential.RevokedAt = &deletedAt
+			m.credentials[credentialID] = credential
+		}
+	}
+
+	after := before
+	after.DeletedAt = &deletedAt
+	after.Version++
+	m.accounts[id] = after
+	m.record(ctx, audit.ActionDelete, audit.EntityServiceAccount, id, before, nil)
+	return nil
+}
+
+func (m *Memory) ListCredentials(ctx context.Context, tenant string, serviceAccountID int) ([]models.ServiceAccountCredential, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.liveServiceAccount(tenant, serviceAccountID); !ok {
+		return nil, ErrNotFound
+	}
+	credentials := []models.ServiceAccountCredential{}
+	for _, id := range sortedKeys(m.credentials) {
+		if credential := m.credentials[id]; credential.ServiceAccountID == serviceAccountID {
+			credentials = append(credentials, credential)
+		}
+	}
+	return credentials, nil
+}
+
+func (m *Memory) CreateCredential(ctx context.Context, tenant string, credential models.ServiceAccountCredential, retireOthersAfter *time.Duration) (models.ServiceAccountCredential, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.liveServiceAccount(tenant, credential.ServiceAccountID); !ok {
+		return models.ServiceAccountCredential{}, ErrNotFound
+	}
+	for _, existing := range m.credentials {
+		if existing.KeyHash == credential.KeyHash {
+			return models.ServiceAccountCredential{}, &ConstraintError{Err: ErrConflict, Constraint: "unique_credential_key_hash"}
+		}
+	}
+
+	if retireOthersAfter != nil {
+		retireAt := m.now().Add(*retireOthersAfter)
+		for _, id := range sortedKeys(m.credentials) {
+			before := m.credentials[id]
+			if before.ServiceAccountID != credential.ServiceAccountID || !m.credentialActive(before) ||
+				(before.ExpiresAt != nil && !before.ExpiresAt.After(retireAt)) {
+				continue
+			}
+			after := before
+			after.ExpiresAt = &retireAt
+			m.credentials[id] = after
+			m.record(ctx, audit.ActionUpdate, audit.EntityCredential, id, before, after)
+		}
+	}
+
+	m.nextCredentialID++
+	credential.ID = m.nextCredentialID
+	credential.CreatedAt = m.now()
+	credential.RevokedAt = nil
+	m.credentials[credential.ID] = credential
+	m.record(ctx, audit.ActionCreate, audit.EntityCredential, credential.ID, nil, credential)
+	return credential, nil
+}
+
+func (m *Memory) RevokeCredential(ctx context.Context, tenant string, serviceAccountID, credentialID int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.liveServiceAccount(tenant, serviceAccountID); !ok {
+		return ErrNotFound
+	}
+	before, ok := m.credentials[credentialID]
+	if !ok || before.ServiceAccountID != serviceAccountID || before.RevokedAt != nil {
+		return ErrNotFound
+	}
+	revokedAt := m.now()
+	after := before
+	after.RevokedAt = &revokedAt
+	m.credentials[credentialID] = after
+	m.record(ctx, audit.ActionDelete, audit.EntityCredential, credentialID, before, nil)
+	return nil
+}
+
+func (m *Memory) AuthenticateCredential(ctx context.Context, keyHash string) (models.ServiceAccount, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, credential := range m.credentials {
+		if credential.KeyHash != keyHash || !m.credentialActive(credential) {
+			continue
+		}
+		if account := m.accounts[credential.ServiceAccountID]; account.DeletedAt == nil {
+			return account, nil
+		}
+	}
+	return models.ServiceAccount{}, ErrNotFound
+}
+
+// serviceAccountNameExists mirrors the unique_tenant_service_account_name
+// index.
+func (m *Memory) serviceAccountNameExists(exceptID int, tenant, name string) bool {
+	for id, account := range m.accounts {
+		if id != exceptID && account.TenantID == tenant && account.DeletedAt == nil && account.Name == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,556 @@
+package store
+
+import (
+	"context"
+	models "main/Models"
+	"main/authz"
+	"main/listing"
+	"main/utils"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryRoleHierarchy(t *testing.T) {
+	s := NewMemory()
+	ctx := context.Background()
+
+	create := func(key string, parents ...int) models.Role {
+		role, err := s.CreateRole(ctx, models.Role{TenantID: "default", RoleKey: key, ParentIDs: parents})
+		require.NoError(t, err)
+		return role
+	}
+	viewer := create("viewer")
+	editor := create("editor", viewer.ID)
+	admin := create("admin", editor.ID)
+
+	_, err := s.CreateRole(ctx, models.Role{TenantID: "default", RoleKey: "viewer"})
+	assert.ErrorIs(t, err, ErrConflict)
+	_, err = s.CreateRole(ctx, models.Role{TenantID: "default", RoleKey: "orphan", ParentIDs: []int{42}})
+	assert.Equal(t, ErrParentRoleNotFound, err)
+	_, err = s.UpdateRole(ctx, "default", viewer.ID, models.Role{RoleKey: "viewer", ParentIDs: []int{admin.ID}})
+	assert.Equal(t, ErrRoleCycle, err)
+
+	ancestors, err := s.RoleAncestors(ctx, "default", admin.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"editor", "viewer"}, roleKeys(ancestors))
+
+	descendants, err := s.RoleDescendants(ctx, "default", viewer.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"editor", "admin"}, roleKeys(descendants))
+
+	_, err = s.RoleAncestors(ctx, "billing", admin.ID)
+	assert.Equal(t, ErrNotFound, err, "roles are isolated by tenant")
+
+	// Deleting a role hides it from its children and cuts inheritance through it.
+	assert.NoError(t, s.DeleteRole(ctx, "default", editor.ID, DeleteRoleOptions{}))
+	role, err := s.GetRole(ctx, "default", admin.ID)
+	assert.NoError(t, err)
+	assert.Empty(t, role.ParentIDs)
+	ancestors, err = s.RoleAncestors(ctx, "default", admin.ID)
+	assert.NoError(t, err)
+	assert.Empty(t, ancestors)
+}
+
+func TestMemoryUserRoleExpiry(t *testing.T) {
+	s := NewMemory()
+	ctx := context.Background()
+	now := time.Now()
+	s.now = func() time.Time { return now }
+
+	role, err := s.CreateRole(ctx, models.Role{TenantID: "default", RoleKey: "viewer"})
+	require.NoError(t, err)
+	validUntil := now.Add(time.Hour)
+	userRole, err := s.CreateUserRole(ctx, models.UserRole{TenantID: "default", Email: "a@example.com", RoleID: role.ID, ValidUntil: &validUntil})
+	require.NoError(t, err)
+
+	firstPage := listing.Params{Limit: listing.DefaultLimit, Sort: listing.Sort{Field: "id"}}
+	list, _, err := s.ListUserRoles(ctx, "default", UserRoleFilter{}, firstPage)
+	assert.NoError(t, err)
+	assert.Len(t, list, 1)
+
+	now = now.Add(2 * time.Hour)
+	list, _, err = s.ListUserRoles(ctx, "default", UserRoleFilter{}, firstPage)
+	assert.NoError(t, err)
+	assert.Empty(t, list)
+	_, err = s.GetUserRole(ctx, "default", userRole.ID)
+	assert.Equal(t, ErrNotFound, err)
+}
+
+func TestMemorySweepAndPurge(t *testing.T) {
+	s := NewMemory()
+	ctx := utils.WithActor(context.Background(), "system:test")
+	now := time.Now()
+	s.now = func() time.Time { return now }
+	firstPage := listing.Params{Limit: listing.DefaultLimit, Sort: listing.Sort{Field: "id"}}
+
+	viewer, err := s.CreateRole(ctx, models.Role{TenantID: "default", RoleKey: "viewer"})
+	require.NoError(t, err)
+	legacy, err := s.CreateRole(ctx, models.Role{TenantID: "default", RoleKey: "legacy", ParentIDs: []int{viewer.ID}})
+	require.NoError(t, err)
+	validUntil := now.Add(time.Hour)
+	_, err = s.CreateUserRole(ctx, models.UserRole{TenantID: "default", Email: "a@example.com", RoleID: viewer.ID, ValidUntil: &validUntil})
+	require.NoError(t, err)
+	former, err := s.CreateUserRole(ctx, models.UserRole{TenantID: "default", Email: "b@example.com", RoleID: legacy.ID})
+	require.NoError(t, err)
+
+	swept, err := s.SweepExpiredUserRoles(ctx)
+	assert.NoError(t, err)
+	assert.Zero(t, swept)
+	now = now.Add(2 * time.Hour)
+	swept, err = s.SweepExpiredUserRoles(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), swept)
+	events := s.Events()
+	assert.Equal(t, "system:test", events[len(events)-1].Actor)
+	assert.Equal(t, "default", events[len(events)-1].TenantID)
+	list, _, err := s.ListUserRoles(ctx, "default", UserRoleFilter{IncludeDeleted: true}, firstPage)
+	assert.NoError(t, err)
+	require.Len(t, list, 2)
+	require.NotNil(t, list[0].DeletedReason)
+	assert.Equal(t, ReasonExpired, *list[0].DeletedReason)
+	explanation, err := s.Explain(ctx, "default", models.AuthorizationRequest{Email: "a@example.com", Permission: "invoice:read"})
+	assert.NoError(t, err)
+	require.Len(t, explanation.Bindings, 1)
+	assert.Equal(t, authz.SkipExpired, explanation.Bindings[0].Reason, "a swept grant is explained until it is purged")
+
+	// The legacy role is kept until the grant referencing it is purged too.
+	require.NoError(t, s.DeleteUserRole(ctx, "default", former.ID, 0))
+	require.NoError(t, s.DeleteRole(ctx, "default", legacy.ID, DeleteRoleOptions{}))
+	purged, err := s.PurgeDeleted(ctx, 24*time.Hour)
+	assert.NoError(t, err)
+	assert.Zero(t, purged)
+	now = now.Add(48 * time.Hour)
+	purged, err = s.PurgeDeleted(ctx, 24*time.Hour)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), purged)
+
+	list, _, err = s.ListUserRoles(ctx, "default", UserRoleFilter{IncludeDeleted: true}, firstPage)
+	assert.NoError(t, err)
+	assert.Empty(t, list)
+	roles, _, err := s.ListRoles(ctx, "default", RoleFilter{IncludeDeleted: true}, firstPage)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"viewer"}, roleKeys(roles))
+}
+
+func TestMemoryRestore(t *testing.T) {
+	s := NewMemory()
+	ctx := context.Background()
+	now := time.Now()
+	s.now = func() time.Time { return now }
+	firstPage := listing.Params{Limit: listing.DefaultLimit, Sort: listing.Sort{Field: "id"}}
+
+	role, err := s.CreateRole(ctx, models.Role{TenantID: "default", RoleKey: "viewer"})
+	require.NoError(t, err)
+	grant := models.UserRole{TenantID: "default", Email: "a@example.com", RoleID: role.ID}
+	userRole, err := s.CreateUserRole(ctx, grant)
+	require.NoError(t, err)
+
+	_, err = s.RestoreUserRole(ctx, "default", userRole.ID)
+	assert.Equal(t, ErrNotDeleted, err)
+
+	// A deleted grant no longer holds unique_email_role, so it can be given
+	// again, but then the old one cannot be restored.
+	require.NoError(t, s.DeleteUserRole(ctx, "default", userRole.ID, 0))
+	regranted, err := s.CreateUserRole(ctx, grant)
+	require.NoError(t, err)
+	_, err = s.RestoreUserRole(ctx, "default", userRole.ID)
+	assert.ErrorIs(t, err, ErrConflict)
+
+	now = now.Add(time.Minute)
+	require.NoError(t, s.DeleteUserRole(ctx, "default", regranted.ID, 0))
+	list, _, err := s.ListUserRoles(ctx, "default", UserRoleFilter{IncludeDeleted: true}, firstPage)
+	require.NoError(t, err)
+	require.Len(t, list, 2)
+	revoked := list[1]
+	require.NotNil(t, revoked.DeletedReason)
+	assert.Equal(t, ReasonRevoked, *revoked.DeletedReason)
+	assert.Equal(t, now, revoked.UpdatedAt)
+	restored, err := s.RestoreUserRole(ctx, "default", userRole.ID)
+	assert.NoError(t, err)
+	assert.Nil(t, restored.DeletedAt)
+	assert.Equal(t, "viewer", restored.RoleKey)
+
+	list, _, err = s.ListUserRoles(ctx, "default", UserRoleFilter{IncludeDeleted: true}, firstPage)
+	assert.NoError(t, err)
+	assert.Len(t, list, 2)
+	list, _, err = s.ListUserRoles(ctx, "default", UserRoleFilter{}, firstPage)
+	assert.NoError(t, err)
+	assert.Len(t, list, 1)
+
+	// A grant cannot be restored onto a deleted role, and a role cannot be
+	// restored once its key is reused.
+	require.NoError(t, s.DeleteUserRole(ctx, "default", userRole.ID, 0))
+	require.NoError(t, s.DeleteRole(ctx, "default", role.ID, DeleteRoleOptions{}))
+	_, err = s.RestoreUserRole(ctx, "default", userRole.ID)
+	assert.Equal(t, ErrRoleNotFound, err)
+
+	roles, _, err := s.ListRoles(ctx, "default", RoleFilter{IncludeDeleted: true}, firstPage)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"viewer"}, roleKeys(roles))
+
+	reused, err := s.CreateRole(ctx, models.Role{TenantID: "default", RoleKey: "viewer"})
+	require.NoError(t, err)
+	_, err = s.RestoreRole(ctx, "default", role.ID)
+	assert.ErrorIs(t, err, ErrConflict)
+	require.NoError(t, s.DeleteRole(ctx, "default", reused.ID, DeleteRoleOptions{}))
+	_, err = s.RestoreRole(ctx, "default", role.ID)
+	assert.NoError(t, err)
+	_, err = s.RestoreUserRole(ctx, "default", userRole.ID)
+	assert.NoError(t, err)
+
+	// Expired grants stay deleted.
+	validUntil := now.Add(time.Hour)
+	expiring, err := s.CreateUserRole(ctx, models.UserRole{TenantID: "default", Email: "b@example.com", RoleID: role.ID, ValidUntil: &validUntil})
+	require.NoError(t, err)
+	require.NoError(t, s.DeleteUserRole(ctx, "default", expiring.ID, 0))
+	now = now.Add(2 * time.Hour)
+	_, err = s.RestoreUserRole(ctx, "default", expiring.ID)
+	assert.Equal(t, ErrUserRoleExpired, err)
+}
+
+func TestMemoryDeleteRoleAssignments(t *testing.T) {
+	ctx := context.Background()
+	setup := func(t *testing.T) (*Memory, models.Role, models.Role) {
+		s := NewMemory()
+		viewer, err := s.CreateRole(ctx, models.Role{TenantID: "default", RoleKey: "viewer"})
+		require.NoError(t, err)
+		reader, err := s.CreateRole(ctx, models.Role{TenantID: "default", RoleKey: "reader"})
+		require.NoError(t, err)
+		for _, email := range []string{"a@example.com", "b@example.com"} {
+			_, err := s.CreateUserRole(ctx, models.UserRole{TenantID: "default", Email: email, RoleID: viewer.ID})
+			require.NoError(t, err)
+		}
+		return s, viewer, reader
+	}
+	firstPage := listing.Params{Limit: listing.DefaultLimit, Sort: listing.Sort{Field: "id"}}
+
+	t.Run("restrict", func(t *testing.T) {
+		s, viewer, _ := setup(t)
+		assert.Equal(t, ErrRoleHasAssignments, s.DeleteRole(ctx, "default", viewer.ID, DeleteRoleOptions{Assignments: AssignmentsRestrict}))
+		_, err := s.GetRole(ctx, "default", viewer.ID)
+		assert.NoError(t, err)
+	})
+
+	t.Run("restrict retires expired grants", func(t *testing.T) {
+		s, _, reader := setup(t)
+		now := time.Now()
+		s.now = func() time.Time { return now }
+		validUntil := now.Add(time.Hour)
+		_, err := s.CreateUserRole(ctx, models.UserRole{TenantID: "default", Email: "a@example.com", RoleID: reader.ID, ValidUntil: &validUntil})
+		require.NoError(t, err)
+		now = now.Add(2 * time.Hour)
+
+		assert.NoError(t, s.DeleteRole(ctx, "default", reader.ID, DeleteRoleOptions{}))
+		list, _, err := s.ListUserRoles(ctx, "default", UserRoleFilter{RoleKey: "reader", IncludeDeleted: true}, firstPage)
+		assert.NoError(t, err)
+		require.Len(t, list, 1)
+		require.NotNil(t, list[0].DeletedAt, "the expired grant does not stay live on the deleted role")
+		assert.Equal(t, ReasonExpired, *list[0].DeletedReason)
+	})
+
+	t.Run("cascade", func(t *testing.T) {
+		s, viewer, _ := setup(t)
+		assert.NoError(t, s.DeleteRole(ctx, "default", viewer.ID, DeleteRoleOptions{Assignments: AssignmentsCascade}))
+		list, _, err := s.ListUserRoles(ctx, "default", UserRoleFilter{}, firstPage)
+		assert.NoError(t, err)
+		assert.Empty(t, list)
+		list, _, err = s.ListUserRoles(ctx, "default", UserRoleFilter{IncludeDeleted: true}, firstPage)
+		assert.NoError(t, err)
+		require.Len(t, list, 2)
+		assert.Equal(t, ReasonRoleDeleted, *list[0].DeletedReason)
+
+		events := s.Events()
+		assert.Equal(t, "user_role", events[len(events)-2].EntityType)
+		assert.Equal(t, "delete", events[len(events)-2].Action)
+	})
+
+	t.Run("reassign", func(t *testing.T) {
+		s, viewer, reader := setup(t)
+		assert.Equal(t, ErrRoleNotFound, s.DeleteRole(ctx, "default", viewer.ID, DeleteRoleOptions{Assignments: AssignmentsReassign, ReplacementRoleID: 42}))
+		assert.NoError(t, s.DeleteRole(ctx, "default", viewer.ID, DeleteRoleOptions{Assignments: AssignmentsReassign, ReplacementRoleID: reader.ID}))
+		list, _, err := s.ListUserRoles(ctx, "default", UserRoleFilter{RoleKey: "reader"}, firstPage)
+		assert.NoError(t, err)
+		assert.Len(t, list, 2)
+	})
+
+	t.Run("reassign conflict", func(t *testing.T) {
+		s, viewer, reader := setup(t)
+		_, err := s.CreateUserRole(ctx, models.UserRole{TenantID: "default", Email: "a@example.com", RoleID: reader.ID})
+		require.NoError(t, err)
+		err = s.DeleteRole(ctx, "default", viewer.ID, DeleteRoleOptions{Assignments: AssignmentsReassign, ReplacementRoleID: reader.ID})
+		assert.ErrorIs(t, err, ErrConflict)
+		list, _, err := s.ListUserRoles(ctx, "default", UserRoleFilter{RoleKey: "viewer"}, firstPage)
+		assert.NoError(t, err)
+		assert.Len(t, list, 2, "a failed reassign changes nothing")
+	})
+}
+
+func TestMemorySyncRoleMembers(t *testing.T) {
+	s := NewMemory()
+	ctx := context.Background()
+	now := time.Now()
+	s.now = func() time.Time { return now }
+
+	role, err := s.CreateRole(ctx, models.Role{TenantID: "default", RoleKey: "finance-approver"})
+	require.NoError(t, err)
+	validUntil := now.Add(time.Hour)
+	expiring, err := s.CreateUserRole(ctx, models.UserRole{TenantID: "default", Email: "a@example.com", RoleID: role.ID, ValidUntil: &validUntil})
+	require.NoError(t, err)
+	now = now.Add(2 * time.Hour)
+
+	// The expired grant is not a member, but still has to make way for the
+	// new one.
+	diff, err := s.SyncRoleMembers(ctx, "default", role.ID, []string{"a@example.com"}, false)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a@example.com"}, diff.Added)
+	assert.Empty(t, diff.Removed)
+
+	list, _, err := s.ListUserRoles(ctx, "default", UserRoleFilter{IncludeDeleted: true}, listing.Params{Limit: listing.DefaultLimit, Sort: listing.Sort{Field: "id"}})
+	require.NoError(t, err)
+	require.Len(t, list, 2)
+	assert.Equal(t, expiring.ID, list[0].ID)
+	assert.Equal(t, ReasonMembersSynced, *list[0].DeletedReason)
+	assert.Nil(t, list[1].DeletedAt)
+	assert.Nil(t, list[1].ValidUntil)
+	assert.Equal(t, models.EffectAllow, list[1].Effect)
+
+	// A deny grant is not a membership: syncing neither removes it nor is
+	// blocked by it.
+	_, err = s.CreateUserRole(ctx, models.UserRole{TenantID: "default", Email: "b@example.com", RoleID: role.ID, Effect: models.EffectDeny})
+	require.NoError(t, err)
+	diff, err = s.SyncRoleMembers(ctx, "default", role.ID, []string{"a@example.com", "b@example.com"}, false)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"b@example.com"}, diff.Added)
+	assert.Empty(t, diff.Removed)
+
+	_, err = s.SyncRoleMembers(ctx, "billing", role.ID, nil, false)
+	assert.Equal(t, ErrNotFound, err, "roles are isolated by tenant")
+}
+
+func TestMemoryGroups(t *testing.T) {
+	s := NewMemory()
+	ctx := context.Background()
+	firstPage := listing.Params{Limit: listing.DefaultLimit, Sort: listing.Sort{Field: "id"}}
+
+	role, err := s.CreateRole(ctx, models.Role{TenantID: "default", RoleKey: "deployer"})
+	require.NoError(t, err)
+	group := func(key string) models.Group {
+		group, err := s.CreateGroup(ctx, models.Group{TenantID: "default", GroupKey: key})
+		require.NoError(t, err)
+		return group
+	}
+	engineering := group("engineering")
+	platform := group("platform")
+	_, err = s.AddGroupMember(ctx, "default", models.GroupMember{GroupID: engineering.ID, MemberGroupID: &platform.ID})
+	require.NoError(t, err)
+	_, err = s.AddGroupMember(ctx, "default", models.GroupMember{GroupID: platform.ID, Email: "a@example.com"})
+	require.NoError(t, err)
+
+	_, err = s.AddGroupMember(ctx, "default", models.GroupMember{GroupID: platform.ID, MemberGroupID: &engineering.ID})
+	assert.Equal(t, ErrGroupCycle, err)
+	_, err = s.AddGroupMember(ctx, "default", models.GroupMember{GroupID: platform.ID, Email: "a@example.com"})
+	assert.ErrorIs(t, err, ErrConflict)
+	missing := 42
+	_, err = s.CreateUserRole(ctx, models.UserRole{TenantID: "default", GroupID: &missing, RoleID: role.ID})
+	assert.Equal(t, ErrGroupNotFound, err)
+
+	// a@example.com holds the role through platform, nested in engineering.
+	grant, err := s.CreateUserRole(ctx, models.UserRole{TenantID: "default", GroupID: &engineering.ID, RoleID: role.ID})
+	require.NoError(t, err)
+	assert.Equal(t, SourceGroup, grant.Source)
+	list, _, err := s.ListUserRoles(ctx, "default", UserRoleFilter{Email: "a@example.com"}, firstPage)
+	require.NoError(t, err)
+	require.Len(t, list, 1)
+	assert.Equal(t, grant.ID, list[0].ID)
+	list, _, err = s.ListUserRoles(ctx, "default", UserRoleFilter{Email: "b@example.com"}, firstPage)
+	require.NoError(t, err)
+	assert.Empty(t, list)
+
+	// Deleting the group revokes its grants.
+	require.NoError(t, s.DeleteGroup(ctx, "default", engineering.ID, 0))
+	list, _, err = s.ListUserRoles(ctx, "default", UserRoleFilter{IncludeDeleted: true}, firstPage)
+	require.NoError(t, err)
+	require.Len(t, list, 1)
+	assert.Equal(t, ReasonGroupDeleted, *list[0].DeletedReason)
+	_, err = s.RestoreUserRole(ctx, "default", grant.ID)
+	assert.Equal(t, ErrGroupNotFound, err)
+	_, err = s.ListGroupMembers(ctx, "billing", platform.ID)
+	assert.Equal(t, ErrNotFound, err, "groups are isolated by tenant")
+}
+
+func TestMemoryServiceAccounts(t *testing.T) {
+	s := NewMemory()
+	ctx := context.Background()
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	s.now = func() time.Time { return now }
+	firstPage := listing.Params{Limit: listing.DefaultLimit, Sort: listing.Sort{Field: "id"}}
+
+	role, err := s.CreateRole(ctx, models.Role{TenantID: "default", RoleKey: "deployer"})
+	require.NoError(t, err)
+	account, err := s.CreateServiceAccount(ctx, models.ServiceAccount{TenantID: "default", Name: "ci-bot"})
+	require.NoError(t, err)
+	_, err = s.CreateServiceAccount(ctx, models.ServiceAccount{TenantID: "default", Name: "ci-bot"})
+	assert.ErrorIs(t, err, ErrConflict)
+	missing := 42
+	_, err = s.CreateUserRole(ctx, models.UserRole{TenantID: "default", ServiceAccountID: &missing, RoleID: role.ID})
+	assert.Equal(t, ErrServiceAccountNotFound, err)
+
+	grant, err := s.CreateUserRole(ctx, models.UserRole{TenantID: "default", ServiceAccountID: &account.ID, RoleID: role.ID})
+	require.NoError(t, err)
+	assert.Equal(t, models.Principal{Type: models.PrincipalService, ID: strconv.Itoa(account.ID)}, grant.Principal)
+	list, _, err := s.ListUserRoles(ctx, "default", UserRoleFilter{ServiceAccountID: account.ID}, firstPage)
+	require.NoError(t, err)
+	require.Len(t, list, 1)
+	assert.Equal(t, grant.ID, list[0].ID)
+
+	// Rotating with a grace period keeps the old key working until it ends.
+	first, err := s.CreateCredential(ctx, "default", models.ServiceAccountCredential{ServiceAccountID: account.ID, KeyHash: "first"}, nil)
+	require.NoError(t, err)
+	grace := time.Hour
+	_, err = s.CreateCredential(ctx, "default", models.ServiceAccountCredential{ServiceAccountID: account.ID, KeyHash: "second"}, &grace)
+	require.NoError(t, err)
+	authenticated, err := s.AuthenticateCredential(ctx, "first")
+	require.NoError(t, err)
+	assert.Equal(t, account.ID, authenticated.ID)
+	now = now.Add(2 * time.Hour)
+	_, err = s.AuthenticateCredential(ctx, "first")
+	assert.Equal(t, ErrNotFound, err)
+	_, err = s.AuthenticateCredential(ctx, "second")
+	assert.NoError(t, err)
+	_, err = s.CreateCredential(ctx, "default", models.ServiceAccountCredential{ServiceAccountID: account.ID, KeyHash: "second"}, nil)
+	assert.ErrorIs(t, err, ErrConflict)
+	assert.Equal(t, ErrNotFound, s.RevokeCredential(ctx, "billing", account.ID, first.ID), "service accounts are isolated by tenant")
+
+	// Deleting the account revokes its grants and credentials.
+	require.NoError(t, s.DeleteServiceAccount(ctx, "default", account.ID, 0))
+	_, err = s.AuthenticateCredential(ctx, "second")
+	assert.Equal(t, ErrNotFound, err)
+	list, _, err = s.ListUserRoles(ctx, "default", UserRoleFilter{IncludeDeleted: true}, firstPage)
+	require.NoError(t, err)
+	require.Len(t, list, 1)
+	assert.Equal(t, ReasonServiceAccountDeleted, *list[0].DeletedReason)
+	_, err = s.RestoreUserRole(ctx, "default", grant.ID)
+	assert.Equal(t, ErrServiceAccountNotFound, err)
+}
+
+func roleKeys(roles []models.Role) []string {
+	keys := []string{}
+	for _, role := range roles {
+		keys = append(keys, role.RoleKey)
+	}
+	return keys
+}
+
+func TestMemoryPermissions(t *testing.T) {
+	s := NewMemory()
+	ctx := context.Background()
+	firstPage := listing.Params{Limit: listing.DefaultLimit, Sort: listing.Sort{Field: "id"}}
+
+	viewer, err := s.CreateRole(ctx, models.Role{TenantID: "default", RoleKey: "viewer"})
+	require.NoError(t, err)
+	editor, err := s.CreateRole(ctx, models.Role{TenantID: "default", RoleKey: "editor", ParentIDs: []int{viewer.ID}})
+	require.NoError(t, err)
+	permission := func(key string) models.Permission {
+		permission, err := s.CreatePermission(ctx, models.Permission{TenantID: "default", PermissionKey: key})
+		require.NoError(t, err)
+		return permission
+	}
+	read := permission("invoice:read")
+	export := permission("invoice:export")
+	_, err = s.CreatePermission(ctx, models.Permission{TenantID: "default", PermissionKey: "invoice:read"})
+	assert.ErrorIs(t, err, ErrConflict)
+
+	bind := func(role models.Role, permission models.Permission, effect string) models.RolePermission {
+		rolePermission, err := s.AddRolePermission(ctx, "default", models.RolePermission{RoleID: role.ID, PermissionID: permission.ID, Effect: effect})
+		require.NoError(t, err)
+		return rolePermission
+	}
+	bind(viewer, read, models.EffectAllow)
+	bind(viewer, export, models.EffectAllow)
+	bind(editor, export, models.EffectDeny)
+	_, err = s.AddRolePermission(ctx, "default", models.RolePermission{RoleID: viewer.ID, PermissionID: read.ID, Effect: models.EffectAllow})
+	assert.ErrorIs(t, err, ErrConflict)
+	_, err = s.AddRolePermission(ctx, "default", models.RolePermission{RoleID: 42, PermissionID: read.ID})
+	assert.Equal(t, ErrNotFound, err)
+	_, err = s.AddRolePermission(ctx, "default", models.RolePermission{RoleID: viewer.ID, PermissionID: 42})
+	assert.Equal(t, ErrPermissionNotFound, err)
+
+	direct, err := s.ListRolePermissions(ctx, "default", editor.ID, false)
+	require.NoError(t, err)
+	assert.Len(t, direct, 1)
+	effective, err := s.ListRolePermissions(ctx, "default", editor.ID, true)
+	require.NoError(t, err)
+	assert.Len(t, effective, 3, "editor inherits viewer's bindings")
+
+	// Deleting a permission drops its bindings from every listing.
+	require.NoError(t, s.DeletePermission(ctx, "default", export.ID))
+	effective, err = s.ListRolePermissions(ctx, "default", editor.ID, true)
+	require.NoError(t, err)
+	require.Len(t, effective, 1)
+	assert.Equal(t, "invoice:read", effective[0].PermissionKey)
+	assert.Equal(t, ErrNotFound, s.DeletePermission(ctx, "default", export.ID))
+	assert.Equal(t, ErrNotFound, s.RemoveRolePermission(ctx, "billing", viewer.ID, read.ID), "bindings are isolated by tenant")
+	require.NoError(t, s.RemoveRolePermission(ctx, "default", viewer.ID, read.ID))
+
+	list, _, err := s.ListPermissions(ctx, "default", PermissionFilter{}, firstPage)
+	require.NoError(t, err)
+	require.Len(t, list, 1)
+	assert.Equal(t, read.ID, list[0].ID)
+
+	// Every write above was audited, newest first by default.
+	events, _, err := s.ListAuditEvents(ctx, "default", AuditFilter{EntityType: "role_permission"}, listing.Params{Limit: listing.DefaultLimit, Sort: listing.Sort{Field: "id", Desc: true}})
+	require.NoError(t, err)
+	require.Len(t, events, 4)
+	assert.Equal(t, "delete", events[0].Action)
+	events, _, err = s.ListAuditEvents(ctx, "billing", AuditFilter{}, firstPage)
+	require.NoError(t, err)
+	assert.Empty(t, events, "audit events are isolated by tenant")
+}
+
+func TestMemoryCheck(t *testing.T) {
+	s := NewMemory()
+	ctx := context.Background()
+
+	viewer, err := s.CreateRole(ctx, models.Role{TenantID: "default", RoleKey: "viewer"})
+	require.NoError(t, err)
+	editor, err := s.CreateRole(ctx, models.Role{TenantID: "default", RoleKey: "editor", ParentIDs: []int{viewer.ID}})
+	require.NoError(t, err)
+	read, err := s.CreatePermission(ctx, models.Permission{TenantID: "default", PermissionKey: "invoice:read"})
+	require.NoError(t, err)
+	_, err = s.AddRolePermission(ctx, "default", models.RolePermission{RoleID: viewer.ID, PermissionID: read.ID, Effect: models.EffectAllow})
+	require.NoError(t, err)
+
+	group, err := s.CreateGroup(ctx, models.Group{TenantID: "default", GroupKey: "finance"})
+	require.NoError(t, err)
+	_, err = s.AddGroupMember(ctx, "default", models.GroupMember{GroupID: group.ID, Email: "a@example.com"})
+	require.NoError(t, err)
+	_, err = s.CreateUserRole(ctx, models.UserRole{TenantID: "default", GroupID: &group.ID, RoleID: editor.ID})
+	require.NoError(t, err)
+
+	check := func(email, tenant string) bool {
+		decision, err := s.Check(ctx, tenant, models.AuthorizationRequest{Email: email, Permission: "invoice:read"})
+		require.NoError(t, err)
+		return decision.Allowed
+	}
+	assert.True(t, check("a@example.com", "default"), "granted through the group and inherited from viewer")
+	assert.False(t, check("b@example.com", "default"))
+	assert.False(t, check("a@example.com", "billing"), "grants are isolated by tenant")
+
+	held, err := s.HeldRoleKeys(ctx, "default", models.Principal{Type: models.PrincipalGroup, ID: strconv.Itoa(group.ID)})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"editor", "viewer"}, held)
+	keys, err := s.RoleKeys(ctx, "default", []int{viewer.ID, 42}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"viewer"}, keys)
+	keys, err = s.RoleKeys(ctx, "default", []int{editor.ID}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"editor", "viewer"}, keys, "inherited roles are included")
+
+	// Deleting the inherited role takes its permissions with it.
+	require.NoError(t, s.DeleteRole(ctx, "default", viewer.ID, DeleteRoleOptions{}))
+	assert.False(t, check("a@example.com", "default"))
+}
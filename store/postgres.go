@@ -0,0 +1,987 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	models "main/Models"
+	"main/audit"
+	"main/listing"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const roleColumns = "roles.id, roles.tenant_id, roles.role_key, roles.description, roles.created_at, roles.updated_at, roles.deleted_at, roles.version"
+
+const userRoleColumns = `user_roles.id, user_roles.tenant_id, user_roles.email, user_roles.role_id, user_roles.resource_type, user_roles.resource_id,
+        user_roles.valid_from, user_roles.valid_until, user_roles.created_at, user_roles.updated_at, user_roles.deleted_at,
+        user_roles.deleted_reason, user_roles.version, user_roles.group_id, user_roles.service_account_id, user_roles.condition, user_roles.effect, roles.role_key`
+
+// groupsOfEmail selects the live groups an email belongs to, directly or
+// through nested groups. The tenant is $1; format in the email's parameter
+// number.
+const groupsOfEmail = `
+            WITH RECURSIVE member_of(id) AS (
+                SELECT group_members.group_id FROM group_members
+                JOIN groups ON groups.id = group_members.group_id AND groups.deleted_at IS NULL
+                WHERE group_members.tenant_id = $1 AND group_members.email = $%d AND group_members.member_group_id IS NULL
+                UNION
+                SELECT group_members.group_id FROM group_members
+                JOIN member_of ON group_members.member_group_id = member_of.id
+                JOIN groups ON groups.id = group_members.group_id AND groups.deleted_at IS NULL
+            )
+            SELECT id FROM member_of`
+
+// Postgres implements Store on a Postgres database.
+// Writes run in a transaction that also records the audit event.
+type Postgres struct {
+	db *sql.DB
+}
+
+func NewPostgres(db *sql.DB) *Postgres {
+	return &Postgres{db: db}
+}
+
+// queryer is satisfied by both *sql.DB and *sql.Tx.
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanRole(row scanner) (models.Role, error) {
+	var role models.Role
+	err := row.Scan(&role.ID, &role.TenantID, &role.RoleKey, &role.Description, &role.CreatedAt, &role.UpdatedAt, &role.DeletedAt, &role.Version)
+	return role, err
+}
+
+func scanUserRole(row scanner) (models.UserRole, error) {
+	var userRole models.UserRole
+	err := row.Scan(&userRole.ID, &userRole.TenantID, &userRole.Email, &userRole.RoleID, &userRole.ResourceType, &userRole.ResourceID,
+		&userRole.ValidFrom, &userRole.ValidUntil, &userRole.CreatedAt, &userRole.UpdatedAt, &userRole.DeletedAt,
+		&userRole.DeletedReason, &userRole.Version, &userRole.GroupID, &userRole.ServiceAccountID, &userRole.Condition, &userRole.Effect, &userRole.RoleKey)
+	describeUserRole(&userRole)
+	return userRole, err
+}
+
+// inTx runs fn in a transaction, committing only when fn succeeds.
+func (s *Postgres) inTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := fn(tx); err != nil {
+		return translate(err)
+	}
+	return tx.Commit()
+}
+
+// translate maps unique and foreign key violations onto store errors.
+func translate(err error) error {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) {
+		return err
+	}
+	switch pqErr.Code {
+	case "23505":
+		return &ConstraintError{Err: ErrConflict, Constraint: pqErr.Constraint}
+	case "23503":
+		return &ConstraintError{Err: ErrInvalidReference, Constraint: pqErr.Constraint}
+	}
+	return err
+}
+
+func (s *Postgres) ListRoles(ctx context.Context, tenant string, filter RoleFilter, page listing.Params) ([]models.Role, string, error) {
+	args := []interface{}{tenant}
+	query := "SELECT " + roleColumns + " FROM roles WHERE roles.tenant_id = $1"
+	if !filter.IncludeDeleted {
+		query += " AND roles.deleted_at IS NULL"
+	}
+	if filter.RoleKey != "" {
+		args = append(args, filter.RoleKey)
+		query += fmt.Sprintf(" AND roles.role_key = $%d", len(args))
+	}
+	query, args = createdRange(query, args, "roles.created_at", filter.CreatedAfter, filter.CreatedBefore)
+	query, args = page.SQL(query, args, map[string]string{"role_key": "roles.role_key", "created_at": "roles.created_at"}, "roles.id")
+
+	roles, err := s.queryRoles(ctx, tenant, query, args...)
+	if err != nil {
+		return nil, "", err
+	}
+	roles, next := listing.Page(roles, page, roleSortKey)
+	return roles, next, nil
+}
+
+// createdRange restricts query to rows created in [after, before).
+func createdRange(query string, args []interface{}, column string, after, before *time.Time) (string, []interface{}) {
+	if after != nil {
+		args = append(args, *after)
+		query += fmt.Sprintf(" AND %s >= $%d", column, len(args))
+	}
+	if before != nil {
+		args = append(args, *before)
+		query += fmt.Sprintf(" AND %s < $%d", column, len(args))
+	}
+	return query, args
+}
+
+func (s *Postgres) GetRole(ctx context.Context, tenant string, id int) (models.Role, error) {
+	role, err := scanRole(s.db.QueryRowContext(ctx, "SELECT "+roleColumns+" FROM roles WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL", id, tenant))
+	if err == sql.ErrNoRows {
+		return role, ErrNotFound
+	}
+	if err != nil {
+		return role, err
+	}
+
+	roles := []models.Role{role}
+	if err := attachParentIDs(ctx, s.db, tenant, roles); err != nil {
+		return role, err
+	}
+	return roles[0], nil
+}
+
+func (s *Postgres) CreateRole(ctx context.Context, role models.Role) (models.Role, error) {
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		err := tx.QueryRowContext(ctx, "INSERT INTO roles (tenant_id, role_key, description) VALUES ($1, $2, $3) RETURNING id, created_at, updated_at, version", role.TenantID, role.RoleKey, role.Description).
+			Scan(&role.ID, &role.CreatedAt, &role.UpdatedAt, &role.Version)
+		if err != nil {
+			return err
+		}
+
+		if role.ParentIDs == nil {
+			role.ParentIDs = []int{}
+		}
+		role.ParentIDs = distinctInts(role.ParentIDs)
+		if err := setParentRoles(ctx, tx, role.TenantID, role.ID, role.ParentIDs); err != nil {
+			return err
+		}
+
+		return audit.Record(ctx, tx, audit.NewEvent(ctx, audit.ActionCreate, audit.EntityRole, role.ID, nil, role))
+	})
+	return role, err
+}
+
+func (s *Postgres) UpdateRole(ctx context.Context, tenant string, id int, role models.Role) (models.Role, error) {
+	var after models.Role
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		before, err := lockRole(ctx, tx, tenant, id)
+		if err != nil {
+			return err
+		}
+		if err := checkVersion(before.Version, role.Version); err != nil {
+			return err
+		}
+
+		after = before
+		after.RoleKey = role.RoleKey
+		after.Description = role.Description
+		err = tx.QueryRowContext(ctx, "UPDATE roles SET role_key = $1, description = $2, updated_at = CURRENT_TIMESTAMP, version = version + 1 WHERE id = $3 AND tenant_id = $4 AND deleted_at IS NULL RETURNING updated_at, version", role.RoleKey, role.Description, id, tenant).
+			Scan(&after.UpdatedAt, &after.Version)
+		if err != nil {
+			return err
+		}
+
+		// Parents are only replaced when the request carries parent_ids.
+		if role.ParentIDs != nil {
+			if err := setParentRoles(ctx, tx, tenant, id, role.ParentIDs); err != nil {
+				return err
+			}
+			after.ParentIDs = distinctInts(role.ParentIDs)
+		}
+
+		return audit.Record(ctx, tx, audit.NewEvent(ctx, audit.ActionUpdate, audit.EntityRole, id, before, after))
+	})
+	return after, err
+}
+
+func (s *Postgres) DeleteRole(ctx context.Context, tenant string, id int, options DeleteRoleOptions) error {
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		before, err := lockRole(ctx, tx, tenant, id)
+		if err != nil {
+			return err
+		}
+		if err := checkVersion(before.Version, options.Version); err != nil {
+			return err
+		}
+
+		switch options.Assignments {
+		case AssignmentsCascade:
+			err = cascadeUserRoles(ctx, tx, tenant, id)
+		case AssignmentsReassign:
+			err = reassignUserRoles(ctx, tx, tenant, id, options.ReplacementRoleID)
+		default:
+			var granted bool
+			err = tx.QueryRowContext(ctx, `
+                SELECT EXISTS (SELECT 1 FROM user_roles WHERE role_id = $1 AND tenant_id = $2 AND deleted_at IS NULL
+                    AND (valid_until IS NULL OR valid_until > CURRENT_TIMESTAMP))`, id, tenant).Scan(&granted)
+			if err == nil && granted {
+				err = ErrRoleHasAssignments
+			}
+			// Only expired grants are left. Retire them as the sweeper would
+			// so none stays live on a deleted role.
+			if err == nil {
+				_, err = expireUserRoles(ctx, tx, "user_roles.role_id = $1 AND user_roles.tenant_id = $2 AND user_roles.deleted_at IS NULL", id, tenant)
+			}
+		}
+		if err != nil {
+			return err
+		}
+
+		if _, err := tx.ExecContext(ctx, "UPDATE roles SET deleted_at = CURRENT_TIMESTAMP, version = version + 1 WHERE id = $1 AND tenant_id = $2", id, tenant); err != nil {
+			return err
+		}
+
+		return audit.Record(ctx, tx, audit.NewEvent(ctx, audit.ActionDelete, audit.EntityRole, id, before, nil))
+	})
+}
+
+func (s *Postgres) RestoreRole(ctx context.Context, tenant string, id int) (models.Role, error) {
+	var after models.Role
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		before, err := scanRole(tx.QueryRowContext(ctx, "SELECT "+roleColumns+" FROM roles WHERE id = $1 AND tenant_id = $2 FOR UPDATE", id, tenant))
+		if err == sql.ErrNoRows {
+			return ErrNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if before.DeletedAt == nil {
+			return ErrNotDeleted
+		}
+
+		roles := []models.Role{before}
+		if err := attachParentIDs(ctx, tx, tenant, roles); err != nil {
+			return err
+		}
+		before = roles[0]
+
+		after = before
+		after.DeletedAt = nil
+		err = tx.QueryRowContext(ctx, "UPDATE roles SET deleted_at = NULL, updated_at = CURRENT_TIMESTAMP, version = version + 1 WHERE id = $1 AND tenant_id = $2 RETURNING updated_at, version", id, tenant).
+			Scan(&after.UpdatedAt, &after.Version)
+		if err != nil {
+			return err
+		}
+
+		return audit.Record(ctx, tx, audit.NewEvent(ctx, audit.ActionRestore, audit.EntityRole, id, before, after))
+	})
+	return after, err
+}
+
+// lockRoleGrants reads the live user roles granting roleID, expired or not,
+// and locks them for the rest of tx.
+func lockRoleGrants(ctx context.Context, tx *sql.Tx, tenant string, roleID int) ([]models.UserRole, error) {
+	return lockGrants(ctx, tx, tenant, "role_id", roleID)
+}
+
+// lockGroupGrants reads the live user roles granted to groupID, expired or
+// not, and locks them for the rest of tx.
+func lockGroupGrants(ctx context.Context, tx *sql.Tx, tenant string, groupID int) ([]models.UserRole, error) {
+	return lockGrants(ctx, tx, tenant, "group_id", groupID)
+}
+
+// lockServiceAccountGrants reads the live user roles granted to a service
+// account, expired or not, and locks them for the rest of tx.
+func lockServiceAccountGrants(ctx context.Context, tx *sql.Tx, tenant string, serviceAccountID int) ([]models.UserRole, error) {
+	return lockGrants(ctx, tx, tenant, "service_account_id", serviceAccountID)
+}
+
+func lockGrants(ctx context.Context, tx *sql.Tx, tenant, column string, id int) ([]models.UserRole, error) {
+	return lockUserRoles(ctx, tx, "user_roles."+column+" = $1 AND user_roles.tenant_id = $2 AND user_roles.deleted_at IS NULL", id, tenant)
+}
+
+// lockUserRoles reads the user roles matching condition, in id order, and
+// locks them for the rest of tx.
+func lockUserRoles(ctx context.Context, tx *sql.Tx, condition string, args ...interface{}) ([]models.UserRole, error) {
+	rows, err := tx.QueryContext(ctx, `
+        SELECT `+userRoleColumns+`
+        FROM user_roles
+        LEFT JOIN roles ON user_roles.role_id = roles.id
+        WHERE `+condition+`
+        ORDER BY user_roles.id
+        FOR UPDATE OF user_roles`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	userRoles := []models.UserRole{}
+	for rows.Next() {
+		userRole, err := scanUserRole(rows)
+		if err != nil {
+			return nil, err
+		}
+		userRoles = append(userRoles, userRole)
+	}
+	return userRoles, rows.Err()
+}
+
+// cascadeUserRoles soft-deletes the grants of a role being deleted.
+func cascadeUserRoles(ctx context.Context, tx *sql.Tx, tenant string, roleID int) error {
+	userRoles, err := lockRoleGrants(ctx, tx, tenant, roleID)
+	if err != nil {
+		return err
+	}
+	if len(userRoles) == 0 {
+		return nil
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE user_roles SET deleted_at = CURRENT_TIMESTAMP, deleted_reason = $1, updated_at = CURRENT_TIMESTAMP, version = version + 1
+        WHERE role_id = $2 AND tenant_id = $3 AND deleted_at IS NULL`, ReasonRoleDeleted, roleID, tenant)
+	if err != nil {
+		return err
+	}
+
+	for _, userRole := range userRoles {
+		if err := audit.Record(ctx, tx, audit.NewEvent(ctx, audit.ActionDelete, audit.EntityUserRole, userRole.ID, userRole, nil)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reassignUserRoles moves the grants of a role being deleted to the live role
+// replacementID. A grant the replacement already covers is a conflict.
+func reassignUserRoles(ctx context.Context, tx *sql.Tx, tenant string, roleID, replacementID int) error {
+	if replacementID == roleID {
+		return ErrRoleNotFound
+	}
+	replacementKey, err := liveRoleKey(ctx, tx, tenant, replacementID)
+	if err != nil {
+		return err
+	}
+
+	userRoles, err := lockRoleGrants(ctx, tx, tenant, roleID)
+	if err != nil {
+		return err
+	}
+	for _, before := range userRoles {
+		after := before
+		after.RoleID = replacementID
+		after.RoleKey = replacementKey
+		err := tx.QueryRowContext(ctx, "UPDATE user_roles SET role_id = $1, updated_at = CURRENT_TIMESTAMP, version = version + 1 WHERE id = $2 AND tenant_id = $3 RETURNING updated_at, version", replacementID, before.ID, tenant).
+			Scan(&after.UpdatedAt, &after.Version)
+		if err != nil {
+			return err
+		}
+
+		if err := audit.Record(ctx, tx, audit.NewEvent(ctx, audit.ActionUpdate, audit.EntityUserRole, before.ID, before, after)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Postgres) RoleAncestors(ctx context.Context, tenant string, id int) ([]models.Role, error) {
+	return s.roleRelatives(ctx, tenant, id, `
+        WITH RECURSIVE related(id) AS (
+            SELECT role_parents.parent_role_id FROM role_parents
+            JOIN roles ON roles.id = role_parents.parent_role_id AND roles.deleted_at IS NULL
+            WHERE role_parents.role_id = $1 AND role_parents.tenant_id = $2
+            UNION
+            SELECT role_parents.parent_role_id FROM role_parents
+            JOIN related ON role_parents.role_id = related.id
+            JOIN roles ON roles.id = role_parents.parent_role_id AND roles.deleted_at IS NULL
+        )
+        SELECT `+roleColumns+`
+        FROM roles JOIN related ON roles.id = related.id`)
+}
+
+func (s *Postgres) RoleDescendants(ctx context.Context, tenant string, id int) ([]models.Role, error) {
+	return s.roleRelatives(ctx, tenant, id, `
+        WITH RECURSIVE related(id) AS (
+            SELECT role_parents.role_id FROM role_parents
+            JOIN roles ON roles.id = role_parents.role_id AND roles.deleted_at IS NULL
+            WHERE role_parents.parent_role_id = $1 AND role_parents.tenant_id = $2
+            UNION
+            SELECT role_parents.role_id FROM role_parents
+            JOIN related ON role_parents.parent_role_id = related.id
+            JOIN roles ON roles.id = role_parents.role_id AND roles.deleted_at IS NULL
+        )
+        SELECT `+roleColumns+`
+        FROM roles JOIN related ON roles.id = related.id`)
+}
+
+func (s *Postgres) roleRelatives(ctx context.Context, tenant string, id int, query string) ([]models.Role, error) {
+	var exists bool
+	err := s.db.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM roles WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL)", id, tenant).Scan(&exists)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, ErrNotFound
+	}
+	return s.queryRoles(ctx, tenant, query, id, tenant)
+}
+
+func (s *Postgres) queryRoles(ctx context.Context, tenant, query string, args ...interface{}) ([]models.Role, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	roles := []models.Role{}
+	for rows.Next() {
+		role, err := scanRole(rows)
+		if err != nil {
+			return nil, err
+		}
+		roles = append(roles, role)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := attachParentIDs(ctx, s.db, tenant, roles); err != nil {
+		return nil, err
+	}
+	return roles, nil
+}
+
+// lockRole reads a live role and its parents, locking the row for the rest
+// of tx so the audit "before" state cannot go stale.
+func lockRole(ctx context.Context, tx *sql.Tx, tenant string, id int) (models.Role, error) {
+	role, err := scanRole(tx.QueryRowContext(ctx, "SELECT "+roleColumns+" FROM roles WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL FOR UPDATE", id, tenant))
+	if err == sql.ErrNoRows {
+		return role, ErrNotFound
+	}
+	if err != nil {
+		return role, err
+	}
+
+	roles := []models.Role{role}
+	if err := attachParentIDs(ctx, tx, tenant, roles); err != nil {
+		return role, err
+	}
+	return roles[0], nil
+}
+
+// attachParentIDs fills ParentIDs on each role with its live parent roles.
+func attachParentIDs(ctx context.Context, db queryer, tenant string, roles []models.Role) error {
+	ids := make([]int64, 0, len(roles))
+	for i := range roles {
+		roles[i].ParentIDs = []int{}
+		ids = append(ids, int64(roles[i].ID))
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	rows, err := db.QueryContext(ctx, `
+        SELECT role_parents.role_id, role_parents.parent_role_id
+        FROM role_parents
+        JOIN roles ON roles.id = role_parents.parent_role_id
+        WHERE role_parents.role_id = ANY($1) AND role_parents.tenant_id = $2 AND roles.deleted_at IS NULL
+        ORDER BY role_parents.parent_role_id`, pq.Array(ids), tenant)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	parents := map[int][]int{}
+	for rows.Next() {
+		var roleID, parentID int
+		if err := rows.Scan(&roleID, &parentID); err != nil {
+			return err
+		}
+		parents[roleID] = append(parents[roleID], parentID)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for i := range roles {
+		if p, ok := parents[roles[i].ID]; ok {
+			roles[i].ParentIDs = p
+		}
+	}
+	return nil
+}
+
+// Advisory lock classes taken by lockHierarchy.
+const (
+	roleHierarchyLock  = 1
+	groupHierarchyLock = 2
+)
+
+// lockHierarchy serializes changes to one of tenant's hierarchies for the
+// rest of tx. Cycle checks read the whole hierarchy, so two transactions
+// adding edges that only close a cycle together must not run side by side.
+func lockHierarchy(ctx context.Context, tx *sql.Tx, class int, tenant string) error {
+	_, err := tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock($1, hashtext($2))", class, tenant)
+	return err
+}
+
+// setParentRoles replaces the parents of roleID, rejecting missing parents
+// and any parent that already inherits from roleID.
+func setParentRoles(ctx context.Context, tx *sql.Tx, tenant string, roleID int, parentIDs []int) error {
+	ids := make([]int64, 0, len(parentIDs))
+	for _, parentID := range parentIDs {
+		if parentID == roleID {
+			return ErrRoleCycle
+		}
+		ids = append(ids, int64(parentID))
+	}
+
+	if len(ids) > 0 {
+		if err := lockHierarchy(ctx, tx, roleHierarchyLock, tenant); err != nil {
+			return err
+		}
+
+		var found int
+		err := tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM roles WHERE id = ANY($1) AND tenant_id = $2 AND deleted_at IS NULL", pq.Array(ids), tenant).Scan(&found)
+		if err != nil {
+			return err
+		}
+		if found != len(distinctInts(parentIDs)) {
+			return ErrParentRoleNotFound
+		}
+
+		var cycle bool
+		err = tx.QueryRowContext(ctx, `
+            WITH RECURSIVE ancestors(id) AS (
+                SELECT parent_role_id FROM role_parents WHERE role_id = ANY($1) AND tenant_id = $3
+                UNION
+                SELECT role_parents.parent_role_id FROM role_parents JOIN ancestors ON role_parents.role_id = ancestors.id
+            )
+            SELECT EXISTS (SELECT 1 FROM ancestors WHERE id = $2)`, pq.Array(ids), roleID, tenant).Scan(&cycle)
+		if err != nil {
+			return err
+		}
+		if cycle {
+			return ErrRoleCycle
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM role_parents WHERE role_id = $1 AND tenant_id = $2", roleID, tenant); err != nil {
+		return err
+	}
+	for _, parentID := range distinctInts(parentIDs) {
+		if _, err := tx.ExecContext(ctx, "INSERT INTO role_parents (tenant_id, role_id, parent_role_id) VALUES ($1, $2, $3)", tenant, roleID, parentID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Postgres) ListUserRoles(ctx context.Context, tenant string, filter UserRoleFilter, page listing.Params) ([]models.UserRole, string, error) {
+	args := []interface{}{tenant}
+	query := `SELECT ` + userRoleColumns + `
+        FROM user_roles
+        LEFT JOIN roles ON user_roles.role_id = roles.id
+        WHERE user_roles.tenant_id = $1`
+	if !filter.IncludeDeleted {
+		query += ` AND user_roles.deleted_at IS NULL
+            AND (user_roles.valid_until IS NULL OR user_roles.valid_until > CURRENT_TIMESTAMP)`
+	}
+
+	if filter.Email != "" {
+		args = append(args, filter.Email)
+		query += fmt.Sprintf(` AND (user_roles.email = $%d OR user_roles.group_id IN (`+groupsOfEmail+`))`, len(args), len(args))
+	}
+	if filter.EmailPrefix != "" {
+		args = append(args, filter.EmailPrefix)
+		query += fmt.Sprintf(` AND starts_with(user_roles.email, $%d)`, len(args))
+	}
+	if filter.EmailDomain != "" {
+		args = append(args, filter.EmailDomain)
+		query += fmt.Sprintf(` AND split_part(user_roles.email, '@', 2) = $%d`, len(args))
+	}
+	if filter.GroupID != 0 {
+		args = append(args, filter.GroupID)
+		query += fmt.Sprintf(` AND user_roles.group_id = $%d`, len(args))
+	}
+	if filter.ServiceAccountID != 0 {
+		args = append(args, filter.ServiceAccountID)
+		query += fmt.Sprintf(` AND user_roles.service_account_id = $%d`, len(args))
+	}
+	if filter.RoleID != 0 {
+		args = append(args, filter.RoleID)
+		query += fmt.Sprintf(` AND user_roles.role_id = $%d`, len(args))
+	}
+	if filter.RoleKey != "" {
+		args = append(args, filter.RoleKey)
+		query += fmt.Sprintf(` AND roles.role_key = $%d`, len(args))
+	}
+	// Only return assignments whose scope covers the requested resource.
+	if filter.ResourceType != "" {
+		args = append(args, filter.ResourceType, filter.ResourceID)
+		query += fmt.Sprintf(` AND (user_roles.resource_type = '' OR (user_roles.resource_type = $%d
+            AND (user_roles.resource_id = $%d OR (right(user_roles.resource_id, 1) = '*' AND starts_with($%d, left(user_roles.resource_id, -1))))))`,
+			len(args)-1, len(args), len(args))
+	}
+	query, args = createdRange(query, args, "user_roles.created_at", filter.CreatedAfter, filter.CreatedBefore)
+	query, args = page.SQL(query, args, map[string]string{"email": "user_roles.email", "created_at": "user_roles.created_at"}, "user_roles.id")
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+
+	userRoles := []models.UserRole{}
+	for rows.Next() {
+		userRole, err := scanUserRole(rows)
+		if err != nil {
+			return nil, "", err
+		}
+		userRoles = append(userRoles, userRole)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, "", err
+	}
+	userRoles, next := listing.Page(userRoles, page, userRoleSortKey)
+	return userRoles, next, nil
+}
+
+func (s *Postgres) GetUserRole(ctx context.Context, tenant string, id int) (models.UserRole, error) {
+	userRole, err := scanUserRole(s.db.QueryRowContext(ctx, `
+        SELECT `+userRoleColumns+`
+        FROM user_roles
+        LEFT JOIN roles ON user_roles.role_id = roles.id
+        WHERE user_roles.id = $1 AND user_roles.tenant_id = $2 AND user_roles.deleted_at IS NULL
+            AND (user_roles.valid_until IS NULL OR user_roles.valid_until > CURRENT_TIMESTAMP)`, id, tenant))
+	if err == sql.ErrNoRows {
+		return userRole, ErrNotFound
+	}
+	return userRole, err
+}
+
+func (s *Postgres) CreateUserRole(ctx context.Context, userRole models.UserRole) (models.UserRole, error) {
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		roleKey, err := liveRoleKey(ctx, tx, userRole.TenantID, userRole.RoleID)
+		if err != nil {
+			return err
+		}
+		userRole.RoleKey = roleKey
+		if err := checkPrincipal(ctx, tx, userRole.TenantID, userRole); err != nil {
+			return err
+		}
+
+		return insertUserRole(ctx, tx, &userRole)
+	})
+	return userRole, err
+}
+
+// insertUserRole stores userRole, whose role and principal are known to be
+// live, filling in the columns the database sets.
+func insertUserRole(ctx context.Context, tx *sql.Tx, userRole *models.UserRole) error {
+	*userRole = withEffect(*userRole)
+	err := tx.QueryRowContext(ctx, "INSERT INTO user_roles (tenant_id, email, group_id, service_account_id, role_id, resource_type, resource_id, valid_from, valid_until, condition, effect) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11) RETURNING id, created_at, updated_at, version",
+		userRole.TenantID, userRole.Email, userRole.GroupID, userRole.ServiceAccountID, userRole.RoleID, userRole.ResourceType, userRole.ResourceID, userRole.ValidFrom, userRole.ValidUntil, userRole.Condition, userRole.Effect).
+		Scan(&userRole.ID, &userRole.CreatedAt, &userRole.UpdatedAt, &userRole.Version)
+	if err != nil {
+		return err
+	}
+	describeUserRole(userRole)
+
+	return audit.Record(ctx, tx, audit.NewEvent(ctx, audit.ActionCreate, audit.EntityUserRole, userRole.ID, nil, *userRole))
+}
+
+func (s *Postgres) UpdateUserRole(ctx context.Context, tenant string, id int, userRole models.UserRole) (models.UserRole, error) {
+	userRole = withEffect(userRole)
+	var after models.UserRole
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		roleKey, err := liveRoleKey(ctx, tx, tenant, userRole.RoleID)
+		if err != nil {
+			return err
+		}
+		if err := checkPrincipal(ctx, tx, tenant, userRole); err != nil {
+			return err
+		}
+
+		before, err := lockUserRole(ctx, tx, tenant, id)
+		if err != nil {
+			return err
+		}
+		if err := checkVersion(before.Version, userRole.Version); err != nil {
+			return err
+		}
+
+		after = before
+		after.Email = userRole.Email
+		after.GroupID = userRole.GroupID
+		after.ServiceAccountID = userRole.ServiceAccountID
+		describeUserRole(&after)
+		after.RoleID = userRole.RoleID
+		after.RoleKey = roleKey
+		after.ResourceType = userRole.ResourceType
+		after.ResourceID = userRole.ResourceID
+		after.ValidFrom = userRole.ValidFrom
+		after.ValidUntil = userRole.ValidUntil
+		after.Condition = userRole.Condition
+		after.Effect = userRole.Effect
+		err = tx.QueryRowContext(ctx, "UPDATE user_roles SET email = $1, group_id = $2, service_account_id = $3, role_id = $4, resource_type = $5, resource_id = $6, valid_from = $7, valid_until = $8, condition = $9, effect = $10, updated_at = CURRENT_TIMESTAMP, version = version + 1 WHERE id = $11 AND tenant_id = $12 AND deleted_at IS NULL RETURNING updated_at, version",
+			userRole.Email, userRole.GroupID, userRole.ServiceAccountID, userRole.RoleID, userRole.ResourceType, userRole.ResourceID, userRole.ValidFrom, userRole.ValidUntil, userRole.Condition, userRole.Effect, id, tenant).Scan(&after.UpdatedAt, &after.Version)
+		if err != nil {
+			return err
+		}
+
+		return audit.Record(ctx, tx, audit.NewEvent(ctx, audit.ActionUpdate, audit.EntityUserRole, id, before, after))
+	})
+	return after, err
+}
+
+func (s *Postgres) DeleteUserRole(ctx context.Context, tenant string, id int, version int) error {
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		_, err := deleteUserRole(ctx, tx, tenant, id, version)
+		return err
+	})
+}
+
+// deleteUserRole soft-deletes a live user role and returns it as it was
+// before.
+func deleteUserRole(ctx context.Context, tx *sql.Tx, tenant string, id int, version int) (models.UserRole, error) {
+	before, err := lockUserRole(ctx, tx, tenant, id)
+	if err != nil {
+		return before, err
+	}
+	if err := checkVersion(before.Version, version); err != nil {
+		return before, err
+	}
+
+	return before, removeUserRole(ctx, tx, before, ReasonRevoked)
+}
+
+// BatchUserRoles runs the whole batch in one transaction. A non-atomic
+// batch wraps each item in a savepoint, so a failed item is undone without
+// aborting the rest.
+func (s *Postgres) BatchUserRoles(ctx context.Context, tenant string, batch models.UserRoleBatch) ([]BatchResult, error) {
+	var results []BatchResult
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		results = make([]BatchResult, 0, len(batch.Grants)+len(batch.Revocations))
+
+		roleKeys := map[int]string{}
+		for _, grant := range batch.Grants {
+			if _, ok := roleKeys[grant.RoleID]; ok {
+				continue
+			}
+			roleKey, err := liveRoleKey(ctx, tx, tenant, grant.RoleID)
+			if err != nil && err != ErrRoleNotFound {
+				return err
+			}
+			roleKeys[grant.RoleID] = roleKey
+		}
+
+		apply := func(op string, index int, fn func() (models.UserRole, error)) error {
+			if !batch.Atomic {
+				if _, err := tx.ExecContext(ctx, "SAVEPOINT batch_item"); err != nil {
+					return err
+				}
+			}
+			userRole, err := fn()
+			if err == nil {
+				results = append(results, BatchResult{UserRole: userRole})
+				if !batch.Atomic {
+					_, err = tx.ExecContext(ctx, "RELEASE SAVEPOINT batch_item")
+				}
+				return err
+			}
+
+			err = translate(err)
+			if batch.Atomic {
+				return &BatchItemError{Op: op, Index: index, Err: err}
+			}
+			if _, rollbackErr := tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT batch_item"); rollbackErr != nil {
+				return rollbackErr
+			}
+			results = append(results, BatchResult{Err: err})
+			return nil
+		}
+
+		for i, grant := range batch.Grants {
+			grant.TenantID = tenant
+			err := apply(BatchGrant, i, func() (models.UserRole, error) {
+				if roleKeys[grant.RoleID] == "" {
+					return grant, ErrRoleNotFound
+				}
+				grant.RoleKey = roleKeys[grant.RoleID]
+				if err := checkPrincipal(ctx, tx, tenant, grant); err != nil {
+					return grant, err
+				}
+				return grant, insertUserRole(ctx, tx, &grant)
+			})
+			if err != nil {
+				return err
+			}
+		}
+		for i, revocation := range batch.Revocations {
+			err := apply(BatchRevoke, i, func() (models.UserRole, error) {
+				return deleteUserRole(ctx, tx, tenant, revocation.ID, revocation.Version)
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+func (s *Postgres) RestoreUserRole(ctx context.Context, tenant string, id int) (models.UserRole, error) {
+	var after models.UserRole
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		before, err := scanUserRole(tx.QueryRowContext(ctx, `
+            SELECT `+userRoleColumns+`
+            FROM user_roles
+            LEFT JOIN roles ON user_roles.role_id = roles.id
+            WHERE user_roles.id = $1 AND user_roles.tenant_id = $2
+            FOR UPDATE OF user_roles`, id, tenant))
+		if err == sql.ErrNoRows {
+			return ErrNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if before.DeletedAt == nil {
+			return ErrNotDeleted
+		}
+		if before.ValidUntil != nil && !before.ValidUntil.After(time.Now()) {
+			return ErrUserRoleExpired
+		}
+		if _, err := liveRoleKey(ctx, tx, tenant, before.RoleID); err != nil {
+			return err
+		}
+		if err := checkPrincipal(ctx, tx, tenant, before); err != nil {
+			return err
+		}
+
+		after = before
+		after.DeletedAt = nil
+		after.DeletedReason = nil
+		err = tx.QueryRowContext(ctx, "UPDATE user_roles SET deleted_at = NULL, deleted_reason = NULL, updated_at = CURRENT_TIMESTAMP, version = version + 1 WHERE id = $1 AND tenant_id = $2 RETURNING updated_at, version", id, tenant).
+			Scan(&after.UpdatedAt, &after.Version)
+		if err != nil {
+			return err
+		}
+
+		return audit.Record(ctx, tx, audit.NewEvent(ctx, audit.ActionRestore, audit.EntityUserRole, id, before, after))
+	})
+	return after, err
+}
+
+// SyncRoleMembers locks the role's grants for the rest of the transaction,
+// so concurrent syncs of one role apply one after the other.
+func (s *Postgres) SyncRoleMembers(ctx context.Context, tenant string, roleID int, emails []string, dryRun bool) (models.RoleMembersDiff, error) {
+	var diff models.RoleMembersDiff
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		roleKey, err := liveRoleKey(ctx, tx, tenant, roleID)
+		if err == ErrRoleNotFound {
+			return ErrNotFound
+		}
+		if err != nil {
+			return err
+		}
+
+		grants, err := lockRoleGrants(ctx, tx, tenant, roleID)
+		if err != nil {
+			return err
+		}
+		// An expired grant the sweeper has not reached yet is no longer a
+		// member, but still holds its unique key until it is deleted. Deny
+		// grants are not memberships and are left alone.
+		current, expired := map[string]models.UserRole{}, map[string]models.UserRole{}
+		for _, grant := range grants {
+			switch {
+			case grant.ResourceType != "" || grant.Email == "" || grant.Effect == models.EffectDeny:
+			case grant.ValidUntil != nil && !grant.ValidUntil.After(time.Now()):
+				expired[grant.Email] = grant
+			default:
+				current[grant.Email] = grant
+			}
+		}
+
+		diff = diffMembers(current, emails, roleID, dryRun)
+		if dryRun {
+			return nil
+		}
+		for _, email := range diff.Removed {
+			if err := removeUserRole(ctx, tx, current[email], ReasonMembersSynced); err != nil {
+				return err
+			}
+		}
+		for _, email := range diff.Added {
+			if grant, ok := expired[email]; ok {
+				if err := removeUserRole(ctx, tx, grant, ReasonMembersSynced); err != nil {
+					return err
+				}
+			}
+			userRole := models.UserRole{TenantID: tenant, Email: email, RoleID: roleID, RoleKey: roleKey}
+			if err := insertUserRole(ctx, tx, &userRole); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return diff, err
+}
+
+// removeUserRole soft-deletes a user role locked by tx, recording reason.
+func removeUserRole(ctx context.Context, tx *sql.Tx, before models.UserRole, reason string) error {
+	_, err := tx.ExecContext(ctx, `UPDATE user_roles SET deleted_at = CURRENT_TIMESTAMP, deleted_reason = $1, updated_at = CURRENT_TIMESTAMP, version = version + 1
+        WHERE id = $2 AND tenant_id = $3`, reason, before.ID, before.TenantID)
+	if err != nil {
+		return err
+	}
+
+	return audit.Record(ctx, tx, audit.NewEvent(ctx, audit.ActionDelete, audit.EntityUserRole, before.ID, before, nil))
+}
+
+// checkPrincipal fails unless the group or service account userRole is
+// granted to, if any, is live.
+func checkPrincipal(ctx context.Context, tx *sql.Tx, tenant string, userRole models.UserRole) error {
+	if err := checkGroup(ctx, tx, tenant, userRole.GroupID); err != nil {
+		return err
+	}
+	return checkServiceAccount(ctx, tx, tenant, userRole.ServiceAccountID)
+}
+
+// checkGroup fails with ErrGroupNotFound unless groupID is nil or names a
+// live group, which it then locks against deletion for the rest of tx.
+func checkGroup(ctx context.Context, tx *sql.Tx, tenant string, groupID *int) error {
+	if groupID == nil {
+		return nil
+	}
+	var id int
+	err := tx.QueryRowContext(ctx, "SELECT id FROM groups WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL FOR SHARE", *groupID, tenant).Scan(&id)
+	if err == sql.ErrNoRows {
+		return ErrGroupNotFound
+	}
+	return err
+}
+
+// liveRoleKey returns the key of a live role, or ErrRoleNotFound.
+func liveRoleKey(ctx context.Context, tx *sql.Tx, tenant string, roleID int) (string, error) {
+	var roleKey string
+	err := tx.QueryRowContext(ctx, "SELECT role_key FROM roles WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL", roleID, tenant).Scan(&roleKey)
+	if err == sql.ErrNoRows {
+		return "", ErrRoleNotFound
+	}
+	return roleKey, err
+}
+
+// lockUserRole reads a live user role and locks it for the rest of tx.
+func lockUserRole(ctx context.Context, tx *sql.Tx, tenant string, id int) (models.UserRole, error) {
+	userRole, err := scanUserRole(tx.QueryRowContext(ctx, `
+        SELECT `+userRoleColumns+`
+        FROM user_roles
+        LEFT JOIN roles ON user_roles.role_id = roles.id
+        WHERE user_roles.id = $1 AND user_roles.tenant_id = $2 AND user_roles.deleted_at IS NULL
+        FOR UPDATE OF user_roles`, id, tenant))
+	if err == sql.ErrNoRows {
+		return userRole, ErrNotFound
+	}
+	return userRole, err
+}
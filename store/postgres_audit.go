@@ -0,0 +1,52 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	models "main/Models"
+	"main/listing"
+)
+
+func (s *Postgres) ListAuditEvents(ctx context.Context, tenant string, filter AuditFilter, page listing.Params) ([]models.AuditEvent, string, error) {
+	args := []interface{}{tenant}
+	query := `SELECT id, tenant_id, actor, action, entity_type, entity_id, before, after, request_id, created_at
+            FROM audit_events WHERE tenant_id = $1`
+	for _, match := range []struct{ column, value string }{
+		{"actor", filter.Actor}, {"action", filter.Action}, {"entity_type", filter.EntityType}, {"request_id", filter.RequestID},
+	} {
+		if match.value != "" {
+			args = append(args, match.value)
+			query += fmt.Sprintf(" AND %s = $%d", match.column, len(args))
+		}
+	}
+	if filter.EntityID != nil {
+		args = append(args, *filter.EntityID)
+		query += fmt.Sprintf(" AND entity_id = $%d", len(args))
+	}
+	query, args = createdRange(query, args, "created_at", filter.CreatedAfter, filter.CreatedBefore)
+	query, args = page.SQL(query, args, map[string]string{"created_at": "created_at"}, "id")
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+
+	events := []models.AuditEvent{}
+	for rows.Next() {
+		var event models.AuditEvent
+		var before, after []byte
+		if err := rows.Scan(&event.ID, &event.TenantID, &event.Actor, &event.Action, &event.EntityType, &event.EntityID,
+			&before, &after, &event.RequestID, &event.CreatedAt); err != nil {
+			return nil, "", err
+		}
+		event.Before = before
+		event.After = after
+		events = append(events, event)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, "", err
+	}
+	events, next := listing.Page(events, page, auditEventSortKey)
+	return events, next, nil
+}
@@ -0,0 +1,97 @@
+package store
+
+import (
+	"context"
+	models "main/Models"
+	"main/authz"
+	"strconv"
+
+	"github.com/lib/pq"
+)
+
+func (s *Postgres) Check(ctx context.Context, tenant string, req models.AuthorizationRequest) (models.AuthorizationDecision, error) {
+	return authz.Check(ctx, authz.NewPostgres(s.db), tenant, req)
+}
+
+func (s *Postgres) CheckBatch(ctx context.Context, tenant string, reqs []models.AuthorizationRequest) ([]models.AuthorizationDecision, error) {
+	return authz.CheckBatch(ctx, authz.NewPostgres(s.db), tenant, reqs)
+}
+
+func (s *Postgres) Explain(ctx context.Context, tenant string, req models.AuthorizationRequest) (models.AuthorizationExplanation, error) {
+	return authz.Explain(ctx, authz.NewPostgres(s.db), tenant, req)
+}
+
+func (s *Postgres) RoleKeys(ctx context.Context, tenant string, roleIDs, userRoleIDs []int) ([]string, error) {
+	return s.queryRoleKeys(ctx, `
+        WITH RECURSIVE touched(id) AS (
+            SELECT roles.id FROM roles
+            WHERE roles.tenant_id = $1 AND (roles.id = ANY($2)
+                OR roles.id IN (SELECT role_id FROM user_roles WHERE tenant_id = $1 AND id = ANY($3)))
+            UNION
+            SELECT role_parents.parent_role_id FROM role_parents
+            JOIN touched ON role_parents.role_id = touched.id
+            JOIN roles ON roles.id = role_parents.parent_role_id AND roles.deleted_at IS NULL
+        )
+        SELECT DISTINCT roles.role_key FROM roles
+        JOIN touched ON roles.id = touched.id
+        ORDER BY roles.role_key`, tenant, pq.Array(authz.Int64s(roleIDs)), pq.Array(authz.Int64s(userRoleIDs)))
+}
+
+// groupsContaining selects the live group $2 and every live group that
+// contains it, however deeply, whose grants its members hold too.
+const groupsContaining = `
+            WITH RECURSIVE containing(id) AS (
+                SELECT groups.id FROM groups WHERE groups.tenant_id = $1 AND groups.id = $2 AND groups.deleted_at IS NULL
+                UNION
+                SELECT group_members.group_id FROM group_members
+                JOIN containing ON group_members.member_group_id = containing.id
+                JOIN groups ON groups.id = group_members.group_id AND groups.deleted_at IS NULL
+            )
+            SELECT id FROM containing`
+
+// heldRoleHolders selects the user roles of each principal type HeldRoleKeys
+// supports.
+var heldRoleHolders = map[string]string{
+	models.PrincipalGroup:   "user_roles.group_id IN (" + groupsContaining + ")",
+	models.PrincipalService: "user_roles.service_account_id = $2",
+}
+
+func (s *Postgres) HeldRoleKeys(ctx context.Context, tenant string, principal models.Principal) ([]string, error) {
+	holder, ok := heldRoleHolders[principal.Type]
+	id, err := strconv.Atoi(principal.ID)
+	if !ok || err != nil {
+		return []string{}, nil
+	}
+	return s.queryRoleKeys(ctx, `
+        WITH RECURSIVE held(id) AS (
+            SELECT roles.id FROM user_roles
+            JOIN roles ON roles.id = user_roles.role_id AND roles.deleted_at IS NULL
+            WHERE user_roles.tenant_id = $1 AND user_roles.deleted_at IS NULL AND `+holder+`
+            UNION
+            SELECT role_parents.parent_role_id FROM role_parents
+            JOIN held ON role_parents.role_id = held.id
+            JOIN roles ON roles.id = role_parents.parent_role_id AND roles.deleted_at IS NULL
+        )
+        SELECT DISTINCT roles.role_key FROM roles
+        JOIN held ON roles.id = held.id
+        ORDER BY roles.role_key`, tenant, id)
+}
+
+// queryRoleKeys runs a query selecting role keys.
+func (s *Postgres) queryRoleKeys(ctx context.Context, query string, args ...interface{}) ([]string, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	roleKeys := []string{}
+	for rows.Next() {
+		var roleKey string
+		if err := rows.Scan(&roleKey); err != nil {
+			return nil, err
+		}
+		roleKeys = append(roleKeys, roleKey)
+	}
+	return roleKeys, rows.Err()
+}
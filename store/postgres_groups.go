@@ -0,0 +1,223 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	models "main/Models"
+	"main/audit"
+	"main/listing"
+)
+
+const groupColumns = "groups.id, groups.tenant_id, groups.group_key, groups.description, groups.created_at, groups.updated_at, groups.deleted_at, groups.version"
+
+const groupMemberColumns = "group_members.id, group_members.group_id, group_members.email, group_members.member_group_id, group_members.created_at"
+
+func scanGroup(row scanner) (models.Group, error) {
+	var group models.Group
+	err := row.Scan(&group.ID, &group.TenantID, &group.GroupKey, &group.Description, &group.CreatedAt, &group.UpdatedAt, &group.DeletedAt, &group.Version)
+	return group, err
+}
+
+func scanGroupMember(row scanner) (models.GroupMember, error) {
+	var member models.GroupMember
+	err := row.Scan(&member.ID, &member.GroupID, &member.Email, &member.MemberGroupID, &member.CreatedAt)
+	return member, err
+}
+
+func (s *Postgres) ListGroups(ctx context.Context, tenant string, filter GroupFilter, page listing.Params) ([]models.Group, string, error) {
+	args := []interface{}{tenant}
+	query := "SELECT " + groupColumns + " FROM groups WHERE groups.tenant_id = $1 AND groups.deleted_at IS NULL"
+	if filter.GroupKey != "" {
+		args = append(args, filter.GroupKey)
+		query += fmt.Sprintf(" AND groups.group_key = $%d", len(args))
+	}
+	query, args = page.SQL(query, args, map[string]string{"group_key": "groups.group_key", "created_at": "groups.created_at"}, "groups.id")
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+
+	groups := []models.Group{}
+	for rows.Next() {
+		group, err := scanGroup(rows)
+		if err != nil {
+			return nil, "", err
+		}
+		groups = append(groups, group)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, "", err
+	}
+	groups, next := listing.Page(groups, page, groupSortKey)
+	return groups, next, nil
+}
+
+func (s *Postgres) GetGroup(ctx context.Context, tenant string, id int) (models.Group, error) {
+	group, err := scanGroup(s.db.QueryRowContext(ctx, "SELECT "+groupColumns+" FROM groups WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL", id, tenant))
+	if err == sql.ErrNoRows {
+		return group, ErrNotFound
+	}
+	return group, err
+}
+
+func (s *Postgres) CreateGroup(ctx context.Context, group models.Group) (models.Group, error) {
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		err := tx.QueryRowContext(ctx, "INSERT INTO groups (tenant_id, group_key, description) VALUES ($1, $2, $3) RETURNING id, created_at, updated_at, version", group.TenantID, group.GroupKey, group.Description).
+			Scan(&group.ID, &group.CreatedAt, &group.UpdatedAt, &group.Version)
+		if err != nil {
+			return err
+		}
+
+		return audit.Record(ctx, tx, audit.NewEvent(ctx, audit.ActionCreate, audit.EntityGroup, group.ID, nil, group))
+	})
+	return group, err
+}
+
+func (s *Postgres) UpdateGroup(ctx context.Context, tenant string, id int, group models.Group) (models.Group, error) {
+	var after models.Group
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		before, err := lockGroup(ctx, tx, tenant, id)
+		if err != nil {
+			return err
+		}
+		if err := checkVersion(before.Version, group.Version); err != nil {
+			return err
+		}
+
+		after = before
+		after.GroupKey = group.GroupKey
+		after.Description = group.Description
+		err = tx.QueryRowContext(ctx, "UPDATE groups SET group_key = $1, description = $2, updated_at = CURRENT_TIMESTAMP, version = version + 1 WHERE id = $3 AND tenant_id = $4 RETURNING updated_at, version", group.GroupKey, group.Description, id, tenant).
+			Scan(&after.UpdatedAt, &after.Version)
+		if err != nil {
+			return err
+		}
+
+		return audit.Record(ctx, tx, audit.NewEvent(ctx, audit.ActionUpdate, audit.EntityGroup, id, before, after))
+	})
+	return after, err
+}
+
+func (s *Postgres) DeleteGroup(ctx context.Context, tenant string, id int, version int) error {
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		before, err := lockGroup(ctx, tx, tenant, id)
+		if err != nil {
+			return err
+		}
+		if err := checkVersion(before.Version, version); err != nil {
+			return err
+		}
+
+		grants, err := lockGroupGrants(ctx, tx, tenant, id)
+		if err != nil {
+			return err
+		}
+		for _, grant := range grants {
+			if err := removeUserRole(ctx, tx, grant, ReasonGroupDeleted); err != nil {
+				return err
+			}
+		}
+
+		if _, err := tx.ExecContext(ctx, "UPDATE groups SET deleted_at = CURRENT_TIMESTAMP, version = version + 1 WHERE id = $1 AND tenant_id = $2", id, tenant); err != nil {
+			return err
+		}
+
+		return audit.Record(ctx, tx, audit.NewEvent(ctx, audit.ActionDelete, audit.EntityGroup, id, before, nil))
+	})
+}
+
+func (s *Postgres) ListGroupMembers(ctx context.Context, tenant string, groupID int) ([]models.GroupMember, error) {
+	if _, err := s.GetGroup(ctx, tenant, groupID); err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.QueryContext(ctx, "SELECT "+groupMemberColumns+" FROM group_members WHERE group_id = $1 AND tenant_id = $2 ORDER BY id", groupID, tenant)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	members := []models.GroupMember{}
+	for rows.Next() {
+		member, err := scanGroupMember(rows)
+		if err != nil {
+			return nil, err
+		}
+		members = append(members, member)
+	}
+	return members, rows.Err()
+}
+
+func (s *Postgres) AddGroupMember(ctx context.Context, tenant string, member models.GroupMember) (models.GroupMember, error) {
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		if _, err := lockGroup(ctx, tx, tenant, member.GroupID); err != nil {
+			return err
+		}
+		if member.MemberGroupID != nil {
+			if *member.MemberGroupID == member.GroupID {
+				return ErrGroupCycle
+			}
+			if err := lockHierarchy(ctx, tx, groupHierarchyLock, tenant); err != nil {
+				return err
+			}
+			if err := checkGroup(ctx, tx, tenant, member.MemberGroupID); err != nil {
+				return err
+			}
+
+			// The new member must not contain the group, however deeply.
+			var cycle bool
+			err := tx.QueryRowContext(ctx, `
+                WITH RECURSIVE contained(id) AS (
+                    SELECT member_group_id FROM group_members WHERE group_id = $1 AND tenant_id = $3 AND member_group_id IS NOT NULL
+                    UNION
+                    SELECT group_members.member_group_id FROM group_members JOIN contained ON group_members.group_id = contained.id
+                    WHERE group_members.member_group_id IS NOT NULL
+                )
+                SELECT EXISTS (SELECT 1 FROM contained WHERE id = $2)`, *member.MemberGroupID, member.GroupID, tenant).Scan(&cycle)
+			if err != nil {
+				return err
+			}
+			if cycle {
+				return ErrGroupCycle
+			}
+		}
+
+		err := tx.QueryRowContext(ctx, "INSERT INTO group_members (tenant_id, group_id, email, member_group_id) VALUES ($1, $2, $3, $4) RETURNING id, created_at",
+			tenant, member.GroupID, member.Email, member.MemberGroupID).Scan(&member.ID, &member.CreatedAt)
+		if err != nil {
+			return err
+		}
+
+		return audit.Record(ctx, tx, audit.NewEvent(ctx, audit.ActionCreate, audit.EntityGroupMember, member.ID, nil, member))
+	})
+	return member, err
+}
+
+func (s *Postgres) RemoveGroupMember(ctx context.Context, tenant string, groupID, memberID int) error {
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		if _, err := lockGroup(ctx, tx, tenant, groupID); err != nil {
+			return err
+		}
+		before, err := scanGroupMember(tx.QueryRowContext(ctx, "DELETE FROM group_members WHERE id = $1 AND group_id = $2 AND tenant_id = $3 RETURNING "+groupMemberColumns, memberID, groupID, tenant))
+		if err == sql.ErrNoRows {
+			return ErrNotFound
+		}
+		if err != nil {
+			return err
+		}
+
+		return audit.Record(ctx, tx, audit.NewEvent(ctx, audit.ActionDelete, audit.EntityGroupMember, memberID, before, nil))
+	})
+}
+
+// lockGroup reads a live group and locks it for the rest of tx.
+func lockGroup(ctx context.Context, tx *sql.Tx, tenant string, id int) (models.Group, error) {
+	group, err := scanGroup(tx.QueryRowContext(ctx, "SELECT "+groupColumns+" FROM groups WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL FOR UPDATE", id, tenant))
+	if err == sql.ErrNoRows {
+		return group, ErrNotFound
+	}
+	return group, err
+}
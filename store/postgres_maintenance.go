@@ -0,0 +1,131 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	models "main/Models"
+	"main/audit"
+	"main/utils"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+func (s *Postgres) SweepExpiredUserRoles(ctx context.Context) (int64, error) {
+	var expired []models.UserRole
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		var err error
+		expired, err = expireUserRoles(ctx, tx, "user_roles.deleted_at IS NULL AND user_roles.valid_until IS NOT NULL AND user_roles.valid_until <= CURRENT_TIMESTAMP")
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return int64(len(expired)), nil
+}
+
+// expireUserRoles soft-deletes the user roles matching condition with reason
+// expired, records an expire event for each, and returns them as they were.
+func expireUserRoles(ctx context.Context, tx *sql.Tx, condition string, args ...interface{}) ([]models.UserRole, error) {
+	expired, err := lockUserRoles(ctx, tx, condition, args...)
+	if err != nil || len(expired) == 0 {
+		return nil, err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+        UPDATE user_roles SET deleted_at = CURRENT_TIMESTAMP, deleted_reason = $1, updated_at = CURRENT_TIMESTAMP, version = version + 1
+        WHERE id = ANY($2)`, ReasonExpired, pq.Array(userRoleIDs(expired)))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, userRole := range expired {
+		event := audit.NewEvent(utils.WithTenant(ctx, userRole.TenantID), audit.ActionExpire, audit.EntityUserRole, userRole.ID, userRole, nil)
+		if err := audit.Record(ctx, tx, event); err != nil {
+			return nil, err
+		}
+	}
+	return expired, nil
+}
+
+func (s *Postgres) PurgeDeleted(ctx context.Context, retention time.Duration) (int64, error) {
+	var userRoles []models.UserRole
+	var roles []models.Role
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		var err error
+		userRoles, err = lockUserRoles(ctx, tx, "user_roles.deleted_at IS NOT NULL AND user_roles.deleted_at < CURRENT_TIMESTAMP - make_interval(secs => $1)", retention.Seconds())
+		if err != nil {
+			return err
+		}
+		if len(userRoles) > 0 {
+			if _, err := tx.ExecContext(ctx, "DELETE FROM user_roles WHERE id = ANY($1)", pq.Array(userRoleIDs(userRoles))); err != nil {
+				return err
+			}
+		}
+
+		rows, err := tx.QueryContext(ctx, `
+        SELECT id, tenant_id, role_key, description, created_at, updated_at, deleted_at
+        FROM roles
+        WHERE deleted_at IS NOT NULL AND deleted_at < CURRENT_TIMESTAMP - make_interval(secs => $1)
+            AND NOT EXISTS (SELECT 1 FROM user_roles WHERE user_roles.role_id = roles.id)
+        FOR UPDATE`, retention.Seconds())
+		if err != nil {
+			return err
+		}
+
+		roles = []models.Role{}
+		roleIDs := []int64{}
+		for rows.Next() {
+			var role models.Role
+			if err := rows.Scan(&role.ID, &role.TenantID, &role.RoleKey, &role.Description, &role.CreatedAt, &role.UpdatedAt, &role.DeletedAt); err != nil {
+				rows.Close()
+				return err
+			}
+			roles = append(roles, role)
+			roleIDs = append(roleIDs, int64(role.ID))
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		if len(roleIDs) > 0 {
+			if _, err := tx.ExecContext(ctx, "DELETE FROM role_parents WHERE role_id = ANY($1) OR parent_role_id = ANY($1)", pq.Array(roleIDs)); err != nil {
+				return err
+			}
+			if _, err := tx.ExecContext(ctx, "DELETE FROM role_permissions WHERE role_id = ANY($1)", pq.Array(roleIDs)); err != nil {
+				return err
+			}
+			if _, err := tx.ExecContext(ctx, "DELETE FROM roles WHERE id = ANY($1)", pq.Array(roleIDs)); err != nil {
+				return err
+			}
+		}
+
+		for _, userRole := range userRoles {
+			event := audit.NewEvent(utils.WithTenant(ctx, userRole.TenantID), audit.ActionPurge, audit.EntityUserRole, userRole.ID, userRole, nil)
+			if err := audit.Record(ctx, tx, event); err != nil {
+				return err
+			}
+		}
+		for _, role := range roles {
+			event := audit.NewEvent(utils.WithTenant(ctx, role.TenantID), audit.ActionPurge, audit.EntityRole, role.ID, role, nil)
+			if err := audit.Record(ctx, tx, event); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return int64(len(userRoles) + len(roles)), nil
+}
+
+// userRoleIDs returns the ids of userRoles for pq.Array.
+func userRoleIDs(userRoles []models.UserRole) []int64 {
+	ids := make([]int64, 0, len(userRoles))
+	for _, userRole := range userRoles {
+		ids = append(ids, int64(userRole.ID))
+	}
+	return ids
+}
@@ -0,0 +1,215 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	models "main/Models"
+	"main/audit"
+	"main/listing"
+)
+
+const permissionColumns = "id, tenant_id, permission_key, description, created_at, updated_at, deleted_at"
+
+const rolePermissionColumns = `role_permissions.id, role_permissions.role_id, role_permissions.permission_id,
+                permissions.permission_key, role_permissions.condition, role_permissions.effect, role_permissions.created_at`
+
+func scanPermission(row scanner) (models.Permission, error) {
+	var permission models.Permission
+	err := row.Scan(&permission.ID, &permission.TenantID, &permission.PermissionKey, &permission.Description,
+		&permission.CreatedAt, &permission.UpdatedAt, &permission.DeletedAt)
+	return permission, err
+}
+
+func scanRolePermission(row scanner) (models.RolePermission, error) {
+	var rolePermission models.RolePermission
+	err := row.Scan(&rolePermission.ID, &rolePermission.RoleID, &rolePermission.PermissionID,
+		&rolePermission.PermissionKey, &rolePermission.Condition, &rolePermission.Effect, &rolePermission.CreatedAt)
+	return rolePermission, err
+}
+
+func (s *Postgres) ListPermissions(ctx context.Context, tenant string, filter PermissionFilter, page listing.Params) ([]models.Permission, string, error) {
+	args := []interface{}{tenant}
+	query := "SELECT " + permissionColumns + " FROM permissions WHERE tenant_id = $1 AND deleted_at IS NULL"
+	if filter.PermissionKey != "" {
+		args = append(args, filter.PermissionKey)
+		query += fmt.Sprintf(" AND permission_key = $%d", len(args))
+	}
+	query, args = createdRange(query, args, "created_at", filter.CreatedAfter, filter.CreatedBefore)
+	query, args = page.SQL(query, args, map[string]string{"permission_key": "permission_key", "created_at": "created_at"}, "id")
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+
+	permissions := []models.Permission{}
+	for rows.Next() {
+		permission, err := scanPermission(rows)
+		if err != nil {
+			return nil, "", err
+		}
+		permissions = append(permissions, permission)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, "", err
+	}
+	permissions, next := listing.Page(permissions, page, permissionSortKey)
+	return permissions, next, nil
+}
+
+func (s *Postgres) GetPermission(ctx context.Context, tenant string, id int) (models.Permission, error) {
+	permission, err := scanPermission(s.db.QueryRowContext(ctx, "SELECT "+permissionColumns+" FROM permissions WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL", id, tenant))
+	if err == sql.ErrNoRows {
+		return permission, ErrNotFound
+	}
+	return permission, err
+}
+
+func (s *Postgres) CreatePermission(ctx context.Context, permission models.Permission) (models.Permission, error) {
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		err := tx.QueryRowContext(ctx, "INSERT INTO permissions (tenant_id, permission_key, description) VALUES ($1, $2, $3) RETURNING id, created_at, updated_at",
+			permission.TenantID, permission.PermissionKey, permission.Description).Scan(&permission.ID, &permission.CreatedAt, &permission.UpdatedAt)
+		if err != nil {
+			return err
+		}
+
+		return audit.Record(ctx, tx, audit.NewEvent(ctx, audit.ActionCreate, audit.EntityPermission, permission.ID, nil, permission))
+	})
+	return permission, err
+}
+
+func (s *Postgres) UpdatePermission(ctx context.Context, tenant string, id int, permission models.Permission) (models.Permission, error) {
+	var after models.Permission
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		before, err := lockPermission(ctx, tx, tenant, id)
+		if err != nil {
+			return err
+		}
+
+		after = before
+		after.PermissionKey = permission.PermissionKey
+		after.Description = permission.Description
+		err = tx.QueryRowContext(ctx, "UPDATE permissions SET permission_key = $1, description = $2, updated_at = CURRENT_TIMESTAMP WHERE id = $3 AND tenant_id = $4 AND deleted_at IS NULL RETURNING updated_at",
+			permission.PermissionKey, permission.Description, id, tenant).Scan(&after.UpdatedAt)
+		if err != nil {
+			return err
+		}
+
+		return audit.Record(ctx, tx, audit.NewEvent(ctx, audit.ActionUpdate, audit.EntityPermission, id, before, after))
+	})
+	return after, err
+}
+
+func (s *Postgres) DeletePermission(ctx context.Context, tenant string, id int) error {
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		before, err := lockPermission(ctx, tx, tenant, id)
+		if err != nil {
+			return err
+		}
+
+		if _, err := tx.ExecContext(ctx, "UPDATE permissions SET deleted_at = CURRENT_TIMESTAMP WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL", id, tenant); err != nil {
+			return err
+		}
+
+		return audit.Record(ctx, tx, audit.NewEvent(ctx, audit.ActionDelete, audit.EntityPermission, id, before, nil))
+	})
+}
+
+// lockPermission reads a live permission and locks it for the rest of tx.
+func lockPermission(ctx context.Context, tx *sql.Tx, tenant string, id int) (models.Permission, error) {
+	permission, err := scanPermission(tx.QueryRowContext(ctx, "SELECT "+permissionColumns+" FROM permissions WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL FOR UPDATE", id, tenant))
+	if err == sql.ErrNoRows {
+		return permission, ErrNotFound
+	}
+	return permission, err
+}
+
+func (s *Postgres) ListRolePermissions(ctx context.Context, tenant string, roleID int, effective bool) ([]models.RolePermission, error) {
+	query := `
+            SELECT ` + rolePermissionColumns + `
+            FROM role_permissions
+            JOIN permissions ON role_permissions.permission_id = permissions.id
+            WHERE role_permissions.role_id = $1 AND role_permissions.tenant_id = $2 AND permissions.deleted_at IS NULL`
+	if effective {
+		query = `
+            WITH RECURSIVE effective(id) AS (
+                SELECT id FROM roles WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL
+                UNION
+                SELECT role_parents.parent_role_id FROM role_parents
+                JOIN effective ON role_parents.role_id = effective.id
+                JOIN roles ON roles.id = role_parents.parent_role_id AND roles.deleted_at IS NULL
+            )
+            SELECT ` + rolePermissionColumns + `
+            FROM role_permissions
+            JOIN effective ON role_permissions.role_id = effective.id
+            JOIN permissions ON role_permissions.permission_id = permissions.id
+            WHERE permissions.deleted_at IS NULL`
+	}
+
+	rows, err := s.db.QueryContext(ctx, query, roleID, tenant)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rolePermissions := []models.RolePermission{}
+	for rows.Next() {
+		rolePermission, err := scanRolePermission(rows)
+		if err != nil {
+			return nil, err
+		}
+		rolePermissions = append(rolePermissions, rolePermission)
+	}
+	return rolePermissions, rows.Err()
+}
+
+func (s *Postgres) AddRolePermission(ctx context.Context, tenant string, rolePermission models.RolePermission) (models.RolePermission, error) {
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		var exists bool
+		err := tx.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM roles WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL)", rolePermission.RoleID, tenant).Scan(&exists)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return ErrNotFound
+		}
+
+		err = tx.QueryRowContext(ctx, "SELECT permission_key FROM permissions WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL", rolePermission.PermissionID, tenant).
+			Scan(&rolePermission.PermissionKey)
+		if err == sql.ErrNoRows {
+			return ErrPermissionNotFound
+		}
+		if err != nil {
+			return err
+		}
+
+		err = tx.QueryRowContext(ctx, "INSERT INTO role_permissions (tenant_id, role_id, permission_id, condition, effect) VALUES ($1, $2, $3, $4, $5) RETURNING id, created_at",
+			tenant, rolePermission.RoleID, rolePermission.PermissionID, rolePermission.Condition, rolePermission.Effect).Scan(&rolePermission.ID, &rolePermission.CreatedAt)
+		if err != nil {
+			return err
+		}
+
+		return audit.Record(ctx, tx, audit.NewEvent(ctx, audit.ActionCreate, audit.EntityRolePermission, rolePermission.ID, nil, rolePermission))
+	})
+	return rolePermission, err
+}
+
+func (s *Postgres) RemoveRolePermission(ctx context.Context, tenant string, roleID, permissionID int) error {
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		before, err := scanRolePermission(tx.QueryRowContext(ctx, `
+            DELETE FROM role_permissions USING permissions
+            WHERE role_permissions.role_id = $1 AND role_permissions.permission_id = $2 AND role_permissions.tenant_id = $3
+                AND permissions.id = role_permissions.permission_id
+            RETURNING `+rolePermissionColumns, roleID, permissionID, tenant))
+		if err == sql.ErrNoRows {
+			return ErrNotFound
+		}
+		if err != nil {
+			return err
+		}
+
+		return audit.Record(ctx, tx, audit.NewEvent(ctx, audit.ActionDelete, audit.EntityRolePermission, before.ID, before, nil))
+	})
+}
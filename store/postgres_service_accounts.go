@@ -0,0 +1,276 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	models "main/Models"
+	"main/audit"
+	"main/listing"
+	"time"
+)
+
+const serviceAccountColumns = "service_accounts.id, service_accounts.tenant_id, service_accounts.name, service_accounts.display_name, service_accounts.created_at, service_accounts.updated_at, service_accounts.deleted_at, service_accounts.version"
+
+const credentialColumns = "service_account_credentials.id, service_account_credentials.service_account_id, service_account_credentials.key_prefix, service_account_credentials.created_at, service_account_credentials.expires_at, service_account_credentials.revoked_at"
+
+func scanServiceAccount(row scanner) (models.ServiceAccount, error) {
+	var account models.ServiceAccount
+	err := row.Scan(&account.ID, &account.TenantID, &account.Name, &account.DisplayName, &account.CreatedAt, &account.UpdatedAt, &account.DeletedAt, &account.Version)
+	return account, err
+}
+
+func scanCredential(row scanner) (models.ServiceAccountCredential, error) {
+	var credential models.ServiceAccountCredential
+	err := row.Scan(&credential.ID, &credential.ServiceAccountID, &credential.KeyPrefix, &credential.CreatedAt, &credential.ExpiresAt, &credential.RevokedAt)
+	return credential, err
+}
+
+func (s *Postgres) ListServiceAccounts(ctx context.Context, tenant string, filter ServiceAccountFilter, page listing.Params) ([]models.ServiceAccount, string, error) {
+	args := []interface{}{tenant}
+	query := "SELECT " + serviceAccountColumns + " FROM service_accounts WHERE service_accounts.tenant_id = $1 AND service_accounts.deleted_at IS NULL"
+	if filter.Name != "" {
+		args = append(args, filter.Name)
+		query += fmt.Sprintf(" AND service_accounts.name = $%d", len(args))
+	}
+	query, args = page.SQL(query, args, map[string]string{"name": "service_accounts.name", "created_at": "service_accounts.created_at"}, "service_accounts.id")
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+
+	accounts := []models.ServiceAccount{}
+	for rows.Next() {
+		account, err := scanServiceAccount(rows)
+		if err != nil {
+			return nil, "", err
+		}
+		accounts = append(accounts, account)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, "", err
+	}
+	accounts, next := listing.Page(accounts, page, serviceAccountSortKey)
+	return accounts, next, nil
+}
+
+func (s *Postgres) GetServiceAccount(ctx context.Context, tenant string, id int) (models.ServiceAccount, error) {
+	account, err := scanServiceAccount(s.db.QueryRowContext(ctx, "SELECT "+serviceAccountColumns+" FROM service_accounts WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL", id, tenant))
+	if err == sql.ErrNoRows {
+		return account, ErrNotFound
+	}
+	return account, err
+}
+
+func (s *Postgres) CreateServiceAccount(ctx context.Context, account models.ServiceAccount) (models.ServiceAccount, error) {
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		err := tx.QueryRowContext(ctx, "INSERT INTO service_accounts (tenant_id, name, display_name) VALUES ($1, $2, $3) RETURNING id, created_at, updated_at, version", account.TenantID, account.Name, account.DisplayName).
+			Scan(&account.ID, &account.CreatedAt, &account.UpdatedAt, &account.Version)
+		if err != nil {
+			return err
+		}
+
+		return audit.Record(ctx, tx, audit.NewEvent(ctx, audit.ActionCreate, audit.EntityServiceAccount, account.ID, nil, account))
+	})
+	return account, err
+}
+
+func (s *Postgres) UpdateServiceAccount(ctx context.Context, tenant string, id int, account models.ServiceAccount) (models.ServiceAccount, error) {
+	var after models.ServiceAccount
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		before, err := lockServiceAccount(ctx, tx, tenant, id)
+		if err != nil {
+			return err
+		}
+		if err := checkVersion(before.Version, account.Version); err != nil {
+			return err
+		}
+
+		after = before
+		after.Name = account.Name
+		after.DisplayName = account.DisplayName
+		err = tx.QueryRowContext(ctx, "UPDATE service_accounts SET name = $1, display_name = $2, updated_at = CURRENT_TIMESTAMP, version = version + 1 WHERE id = $3 AND tenant_id = $4 RETURNING updated_at, version", account.Name, account.DisplayName, id, tenant).
+			Scan(&after.UpdatedAt, &after.Version)
+		if err != nil {
+			return err
+		}
+
+		return audit.Record(ctx, tx, audit.NewEvent(ctx, audit.ActionUpdate, audit.EntityServiceAccount, id, before, after))
+	})
+	return after, err
+}
+
+func (s *Postgres) DeleteServiceAccount(ctx context.Context, tenant string, id int, version int) error {
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		before, err := lockServiceAccount(ctx, tx, tenant, id)
+		if err != nil {
+			return err
+		}
+		if err := checkVersion(before.Version, version); err != nil {
+			return err
+		}
+
+		grants, err := lockServiceAccountGrants(ctx, tx, tenant, id)
+		if err != nil {
+			return err
+		}
+		for _, grant := range grants {
+			if err := removeUserRole(ctx, tx, grant, ReasonServiceAccountDeleted); err != nil {
+				return err
+			}
+		}
+
+		if _, err := tx.ExecContext(ctx, "UPDATE service_account_credentials SET revoked_at = CURRENT_TIMESTAMP WHERE service_account_id = $1 AND tenant_id = $2 AND revoked_at IS NULL", id, tenant); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, "UPDATE service_accounts SET deleted_at = CURRENT_TIMESTAMP, version = version + 1 WHERE id = $1 AND tenant_id = $2", id, tenant); err != nil {
+			return err
+		}
+
+		return audit.Record(ctx, tx, audit.NewEvent(ctx, audit.ActionDelete, audit.EntityServiceAccount, id, before, nil))
+	})
+}
+
+func (s *Postgres) ListCredentials(ctx context.Context, tenant string, serviceAccountID int) ([]models.ServiceAccountCredential, error) {
+	if _, err := s.GetServiceAccount(ctx, tenant, serviceAccountID); err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.QueryContext(ctx, "SELECT "+credentialColumns+" FROM service_account_credentials WHERE service_account_id = $1 AND tenant_id = $2 ORDER BY id", serviceAccountID, tenant)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	credentials := []models.ServiceAccountCredential{}
+	for rows.Next() {
+		credential, err := scanCredential(rows)
+		if err != nil {
+			return nil, err
+		}
+		credentials = append(credentials, credential)
+	}
+	return credentials, rows.Err()
+}
+
+func (s *Postgres) CreateCredential(ctx context.Context, tenant string, credential models.ServiceAccountCredential, retireOthersAfter *time.Duration) (models.ServiceAccountCredential, error) {
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		if _, err := lockServiceAccount(ctx, tx, tenant, credential.ServiceAccountID); err != nil {
+			return err
+		}
+
+		if retireOthersAfter != nil {
+			if err := retireCredentials(ctx, tx, tenant, credential.ServiceAccountID, time.Now().Add(*retireOthersAfter)); err != nil {
+				return err
+			}
+		}
+
+		err := tx.QueryRowContext(ctx, "INSERT INTO service_account_credentials (tenant_id, service_account_id, key_prefix, key_hash, expires_at) VALUES ($1, $2, $3, $4, $5) RETURNING id, created_at",
+			tenant, credential.ServiceAccountID, credential.KeyPrefix, credential.KeyHash, credential.ExpiresAt).Scan(&credential.ID, &credential.CreatedAt)
+		if err != nil {
+			return err
+		}
+
+		return audit.Record(ctx, tx, audit.NewEvent(ctx, audit.ActionCreate, audit.EntityCredential, credential.ID, nil, credential))
+	})
+	return credential, err
+}
+
+func (s *Postgres) RevokeCredential(ctx context.Context, tenant string, serviceAccountID, credentialID int) error {
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		if _, err := lockServiceAccount(ctx, tx, tenant, serviceAccountID); err != nil {
+			return err
+		}
+		before, err := scanCredential(tx.QueryRowContext(ctx, "UPDATE service_account_credentials SET revoked_at = CURRENT_TIMESTAMP WHERE id = $1 AND service_account_id = $2 AND tenant_id = $3 AND revoked_at IS NULL RETURNING "+credentialColumns,
+			credentialID, serviceAccountID, tenant))
+		if err == sql.ErrNoRows {
+			return ErrNotFound
+		}
+		if err != nil {
+			return err
+		}
+
+		before.RevokedAt = nil
+		return audit.Record(ctx, tx, audit.NewEvent(ctx, audit.ActionDelete, audit.EntityCredential, credentialID, before, nil))
+	})
+}
+
+func (s *Postgres) AuthenticateCredential(ctx context.Context, keyHash string) (models.ServiceAccount, error) {
+	account, err := scanServiceAccount(s.db.QueryRowContext(ctx, `
+        SELECT `+serviceAccountColumns+`
+        FROM service_account_credentials
+        JOIN service_accounts ON service_accounts.id = service_account_credentials.service_account_id
+        WHERE service_account_credentials.key_hash = $1 AND service_account_credentials.revoked_at IS NULL
+            AND (service_account_credentials.expires_at IS NULL OR service_account_credentials.expires_at > CURRENT_TIMESTAMP)
+            AND service_accounts.deleted_at IS NULL`, keyHash))
+	if err == sql.ErrNoRows {
+		return account, ErrNotFound
+	}
+	return account, err
+}
+
+// retireCredentials makes the active credentials of a service account expire
+// at retireAt, unless they expire sooner already.
+func retireCredentials(ctx context.Context, tx *sql.Tx, tenant string, serviceAccountID int, retireAt time.Time) error {
+	rows, err := tx.QueryContext(ctx, `
+        SELECT `+credentialColumns+`
+        FROM service_account_credentials
+        WHERE service_account_id = $1 AND tenant_id = $2 AND revoked_at IS NULL AND (expires_at IS NULL OR expires_at > $3)
+        ORDER BY id
+        FOR UPDATE`, serviceAccountID, tenant, retireAt)
+	if err != nil {
+		return err
+	}
+	active := []models.ServiceAccountCredential{}
+	for rows.Next() {
+		credential, err := scanCredential(rows)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		active = append(active, credential)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, before := range active {
+		if _, err := tx.ExecContext(ctx, "UPDATE service_account_credentials SET expires_at = $1 WHERE id = $2", retireAt, before.ID); err != nil {
+			return err
+		}
+		after := before
+		after.ExpiresAt = &retireAt
+		if err := audit.Record(ctx, tx, audit.NewEvent(ctx, audit.ActionUpdate, audit.EntityCredential, before.ID, before, after)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// lockServiceAccount reads a live service account and locks it for the rest
+// of tx.
+func lockServiceAccount(ctx context.Context, tx *sql.Tx, tenant string, id int) (models.ServiceAccount, error) {
+	account, err := scanServiceAccount(tx.QueryRowContext(ctx, "SELECT "+serviceAccountColumns+" FROM service_accounts WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL FOR UPDATE", id, tenant))
+	if err == sql.ErrNoRows {
+		return account, ErrNotFound
+	}
+	return account, err
+}
+
+// checkServiceAccount fails with ErrServiceAccountNotFound unless id is nil
+// or names a live service account, which it then locks against deletion for
+// the rest of tx.
+func checkServiceAccount(ctx context.Context, tx *sql.Tx, tenant string, id *int) error {
+	if id == nil {
+		return nil
+	}
+	var found int
+	err := tx.QueryRowContext(ctx, "SELECT id FROM service_accounts WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL FOR SHARE", *id, tenant).Scan(&found)
+	if err == sql.ErrNoRows {
+		return ErrServiceAccountNotFound
+	}
+	return err
+}
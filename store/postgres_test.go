@@ -0,0 +1,746 @@
+package store
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	models "main/Models"
+	"main/authz"
+	"main/utils"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var userRoleRowColumns = []string{"id", "tenant_id", "email", "role_id", "resource_type", "resource_id",
+	"valid_from", "valid_until", "created_at", "updated_at", "deleted_at", "deleted_reason", "version", "group_id", "service_account_id", "condition", "effect", "role_key"}
+
+// expectLockUserRole expects the transactional read of a live user role.
+func expectLockUserRole(mock sqlmock.Sqlmock, id int) {
+	mock.ExpectQuery(`FROM user_roles .* FOR UPDATE OF user_roles`).
+		WithArgs(id, "default").
+		WillReturnRows(sqlmock.NewRows(userRoleRowColumns).
+			AddRow(id, "default", "test@example.com", 2, "", "", nil, nil, time.Now(), time.Now(), nil, nil, 3, nil, nil, "", "allow", "admin"))
+}
+
+func expectRoleKey(mock sqlmock.Sqlmock, roleID int, roleKey string) {
+	rows := sqlmock.NewRows([]string{"role_key"})
+	if roleKey != "" {
+		rows.AddRow(roleKey)
+	}
+	mock.ExpectQuery(`SELECT role_key FROM roles WHERE id = \$1 AND tenant_id = \$2 AND deleted_at IS NULL`).
+		WithArgs(roleID, "default").
+		WillReturnRows(rows)
+}
+
+func TestPostgresCreateUserRole(t *testing.T) {
+	testCases := []struct {
+		name        string
+		mockQueries func(mock sqlmock.Sqlmock)
+		expectedErr error
+	}{
+		{
+			name: "success",
+			mockQueries: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				expectRoleKey(mock, 2, "admin")
+				mock.ExpectQuery(`INSERT INTO user_roles \(tenant_id, email, group_id, service_account_id, role_id, resource_type, resource_id, valid_from, valid_until, condition, effect\) VALUES \(\$1, \$2, \$3, \$4, \$5, \$6, \$7, \$8, \$9, \$10, \$11\) RETURNING id, created_at, updated_at, version`).
+					WithArgs("default", "test@example.com", nil, nil, 2, "", "", nil, nil, "", "allow").
+					WillReturnRows(sqlmock.NewRows([]string{"id", "created_at", "updated_at", "version"}).AddRow(1, time.Now(), time.Now(), 1))
+				mock.ExpectExec(`INSERT INTO audit_events`).
+					WithArgs("default", "anonymous", "create", "user_role", 1, nil, sqlmock.AnyArg(), "").
+					WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectCommit()
+			},
+		},
+		{
+			name: "role does not exist",
+			mockQueries: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				expectRoleKey(mock, 2, "")
+				mock.ExpectRollback()
+			},
+			expectedErr: ErrRoleNotFound,
+		},
+		{
+			name: "duplicate grant",
+			mockQueries: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				expectRoleKey(mock, 2, "admin")
+				mock.ExpectQuery(`INSERT INTO user_roles`).
+					WillReturnError(&pq.Error{Code: "23505", Constraint: "unique_email_role"})
+				mock.ExpectRollback()
+			},
+			expectedErr: &ConstraintError{Err: ErrConflict, Constraint: "unique_email_role"},
+		},
+		{
+			name: "database error on insert",
+			mockQueries: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				expectRoleKey(mock, 2, "admin")
+				mock.ExpectQuery(`INSERT INTO user_roles`).
+					WillReturnError(errors.New("insert error"))
+				mock.ExpectRollback()
+			},
+			expectedErr: errors.New("insert error"),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			assert.NoError(t, err)
+			defer db.Close()
+			tc.mockQueries(mock)
+
+			userRole, err := NewPostgres(db).CreateUserRole(context.Background(), models.UserRole{TenantID: "default", Email: "test@example.com", RoleID: 2})
+			assert.Equal(t, tc.expectedErr, err)
+			if tc.expectedErr == nil {
+				assert.Equal(t, 1, userRole.ID)
+				assert.Equal(t, "admin", userRole.RoleKey)
+			}
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestPostgresUpdateUserRole(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectBegin()
+	expectRoleKey(mock, 3, "viewer")
+	expectLockUserRole(mock, 1)
+	mock.ExpectQuery(`UPDATE user_roles SET email = \$1, group_id = \$2, service_account_id = \$3, role_id = \$4, resource_type = \$5, resource_id = \$6, valid_from = \$7, valid_until = \$8, condition = \$9, effect = \$10, updated_at = CURRENT_TIMESTAMP, version = version \+ 1 WHERE id = \$11 AND tenant_id = \$12 AND deleted_at IS NULL RETURNING updated_at, version`).
+		WithArgs("updated@example.com", nil, nil, 3, "", "", nil, nil, "", "allow", 1, "default").
+		WillReturnRows(sqlmock.NewRows([]string{"updated_at", "version"}).AddRow(time.Now(), 4))
+	mock.ExpectExec(`INSERT INTO audit_events`).
+		WithArgs("default", "anonymous", "update", "user_role", 1, sqlmock.AnyArg(), sqlmock.AnyArg(), "").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	userRole, err := NewPostgres(db).UpdateUserRole(context.Background(), "default", 1, models.UserRole{Email: "updated@example.com", RoleID: 3})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, userRole.ID)
+	assert.Equal(t, "viewer", userRole.RoleKey)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgresDeleteUserRole(t *testing.T) {
+	testCases := []struct {
+		name        string
+		id          int
+		version     int
+		mockQueries func(mock sqlmock.Sqlmock)
+		expectedErr error
+	}{
+		{
+			name:    "success",
+			id:      1,
+			version: 3,
+			mockQueries: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				expectLockUserRole(mock, 1)
+				mock.ExpectExec(`UPDATE user_roles SET deleted_at = CURRENT_TIMESTAMP, deleted_reason = \$1, updated_at = CURRENT_TIMESTAMP, version = version \+ 1\s+WHERE id = \$2 AND tenant_id = \$3`).
+					WithArgs(ReasonRevoked, 1, "default").
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectExec(`INSERT INTO audit_events`).
+					WithArgs("default", "anonymous", "delete", "user_role", 1, sqlmock.AnyArg(), nil, "").
+					WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectCommit()
+			},
+		},
+		{
+			name: "not found",
+			id:   99,
+			mockQueries: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(`FROM user_roles .* FOR UPDATE OF user_roles`).
+					WithArgs(99, "default").
+					WillReturnRows(sqlmock.NewRows(userRoleRowColumns))
+				mock.ExpectRollback()
+			},
+			expectedErr: ErrNotFound,
+		},
+		{
+			name:    "stale version",
+			id:      1,
+			version: 2,
+			mockQueries: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				expectLockUserRole(mock, 1)
+				mock.ExpectRollback()
+			},
+			expectedErr: ErrVersionMismatch,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			assert.NoError(t, err)
+			defer db.Close()
+			tc.mockQueries(mock)
+
+			err = NewPostgres(db).DeleteUserRole(context.Background(), "default", tc.id, tc.version)
+			assert.Equal(t, tc.expectedErr, err)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestPostgresRestoreUserRole(t *testing.T) {
+	deletedAt := time.Now().Add(-time.Hour)
+	expiredAt := time.Now().Add(-time.Minute)
+
+	testCases := []struct {
+		name        string
+		mockQueries func(mock sqlmock.Sqlmock)
+		expectedErr error
+	}{
+		{
+			name: "success",
+			mockQueries: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(`FROM user_roles .* FOR UPDATE OF user_roles`).
+					WithArgs(1, "default").
+					WillReturnRows(sqlmock.NewRows(userRoleRowColumns).
+						AddRow(1, "default", "test@example.com", 2, "", "", nil, nil, time.Now(), time.Now(), deletedAt, nil, 3, nil, nil, "", "allow", "admin"))
+				expectRoleKey(mock, 2, "admin")
+				mock.ExpectQuery(`UPDATE user_roles SET deleted_at = NULL, deleted_reason = NULL, updated_at = CURRENT_TIMESTAMP, version = version \+ 1 WHERE id = \$1 AND tenant_id = \$2 RETURNING updated_at, version`).
+					WithArgs(1, "default").
+					WillReturnRows(sqlmock.NewRows([]string{"updated_at", "version"}).AddRow(time.Now(), 4))
+				mock.ExpectExec(`INSERT INTO audit_events`).
+					WithArgs("default", "anonymous", "restore", "user_role", 1, sqlmock.AnyArg(), sqlmock.AnyArg(), "").
+					WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectCommit()
+			},
+		},
+		{
+			name: "not deleted",
+			mockQueries: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				expectLockUserRole(mock, 1)
+				mock.ExpectRollback()
+			},
+			expectedErr: ErrNotDeleted,
+		},
+		{
+			name: "expired",
+			mockQueries: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(`FROM user_roles .* FOR UPDATE OF user_roles`).
+					WithArgs(1, "default").
+					WillReturnRows(sqlmock.NewRows(userRoleRowColumns).
+						AddRow(1, "default", "test@example.com", 2, "", "", nil, expiredAt, time.Now(), time.Now(), deletedAt, "expired", 3, nil, nil, "", "allow", "admin"))
+				mock.ExpectRollback()
+			},
+			expectedErr: ErrUserRoleExpired,
+		},
+		{
+			name: "grant given again",
+			mockQueries: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(`FROM user_roles .* FOR UPDATE OF user_roles`).
+					WithArgs(1, "default").
+					WillReturnRows(sqlmock.NewRows(userRoleRowColumns).
+						AddRow(1, "default", "test@example.com", 2, "", "", nil, nil, time.Now(), time.Now(), deletedAt, nil, 3, nil, nil, "", "allow", "admin"))
+				expectRoleKey(mock, 2, "admin")
+				mock.ExpectQuery(`UPDATE user_roles SET deleted_at = NULL`).
+					WithArgs(1, "default").
+					WillReturnError(&pq.Error{Code: "23505", Constraint: "unique_email_role"})
+				mock.ExpectRollback()
+			},
+			expectedErr: &ConstraintError{Err: ErrConflict, Constraint: "unique_email_role"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			assert.NoError(t, err)
+			defer db.Close()
+			tc.mockQueries(mock)
+
+			userRole, err := NewPostgres(db).RestoreUserRole(context.Background(), "default", 1)
+			assert.Equal(t, tc.expectedErr, err)
+			if tc.expectedErr == nil {
+				assert.Nil(t, userRole.DeletedAt)
+			}
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestPostgresDeleteRole(t *testing.T) {
+	roleRowColumns := []string{"id", "tenant_id", "role_key", "description", "created_at", "updated_at", "deleted_at", "version"}
+	expectLockRole := func(mock sqlmock.Sqlmock) {
+		mock.ExpectQuery(`SELECT .* FROM roles WHERE id = \$1 AND tenant_id = \$2 AND deleted_at IS NULL FOR UPDATE`).
+			WithArgs(1, "default").
+			WillReturnRows(sqlmock.NewRows(roleRowColumns).AddRow(1, "default", "viewer", "Viewer", "2024-01-01T00:00:00Z", "2024-01-01T00:00:00Z", nil, 2))
+		mock.ExpectQuery(`FROM role_parents`).WillReturnRows(sqlmock.NewRows([]string{"role_id", "parent_role_id"}))
+	}
+	expectDelete := func(mock sqlmock.Sqlmock) {
+		mock.ExpectExec(`UPDATE roles SET deleted_at = CURRENT_TIMESTAMP`).
+			WithArgs(1, "default").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(`INSERT INTO audit_events`).
+			WithArgs("default", "anonymous", "delete", "role", 1, sqlmock.AnyArg(), nil, "").
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+	}
+
+	testCases := []struct {
+		name        string
+		options     DeleteRoleOptions
+		mockQueries func(mock sqlmock.Sqlmock)
+		expectedErr error
+	}{
+		{
+			name:    "restrict with grants",
+			options: DeleteRoleOptions{Assignments: AssignmentsRestrict},
+			mockQueries: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				expectLockRole(mock)
+				mock.ExpectQuery(`SELECT EXISTS \(SELECT 1 FROM user_roles WHERE role_id = \$1`).
+					WithArgs(1, "default").
+					WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+				mock.ExpectRollback()
+			},
+			expectedErr: ErrRoleHasAssignments,
+		},
+		{
+			name:    "restrict retires expired grants",
+			options: DeleteRoleOptions{Assignments: AssignmentsRestrict},
+			mockQueries: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				expectLockRole(mock)
+				mock.ExpectQuery(`SELECT EXISTS \(SELECT 1 FROM user_roles WHERE role_id = \$1`).
+					WithArgs(1, "default").
+					WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+				mock.ExpectQuery(`FROM user_roles .* WHERE user_roles.role_id = \$1 AND user_roles.tenant_id = \$2 AND user_roles.deleted_at IS NULL .* FOR UPDATE OF user_roles`).
+					WithArgs(1, "default").
+					WillReturnRows(sqlmock.NewRows(userRoleRowColumns).
+						AddRow(7, "default", "test@example.com", 1, "", "", nil, time.Now().Add(-time.Hour), time.Now(), time.Now(), nil, nil, 3, nil, nil, "", "allow", "viewer"))
+				mock.ExpectExec(`UPDATE user_roles SET deleted_at = CURRENT_TIMESTAMP, deleted_reason = \$1`).
+					WithArgs(ReasonExpired, sqlmock.AnyArg()).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectExec(`INSERT INTO audit_events`).
+					WithArgs("default", "anonymous", "expire", "user_role", 7, sqlmock.AnyArg(), nil, "").
+					WillReturnResult(sqlmock.NewResult(1, 1))
+				expectDelete(mock)
+			},
+		},
+		{
+			name:    "cascade",
+			options: DeleteRoleOptions{Assignments: AssignmentsCascade},
+			mockQueries: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				expectLockRole(mock)
+				mock.ExpectQuery(`FROM user_roles .* WHERE user_roles.role_id = \$1 .* FOR UPDATE OF user_roles`).
+					WithArgs(1, "default").
+					WillReturnRows(sqlmock.NewRows(userRoleRowColumns).
+						AddRow(7, "default", "test@example.com", 1, "", "", nil, nil, time.Now(), time.Now(), nil, nil, 3, nil, nil, "", "allow", "viewer"))
+				mock.ExpectExec(`UPDATE user_roles SET deleted_at = CURRENT_TIMESTAMP, deleted_reason = \$1`).
+					WithArgs(ReasonRoleDeleted, 1, "default").
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectExec(`INSERT INTO audit_events`).
+					WithArgs("default", "anonymous", "delete", "user_role", 7, sqlmock.AnyArg(), nil, "").
+					WillReturnResult(sqlmock.NewResult(1, 1))
+				expectDelete(mock)
+			},
+		},
+		{
+			name:    "reassign",
+			options: DeleteRoleOptions{Assignments: AssignmentsReassign, ReplacementRoleID: 2},
+			mockQueries: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				expectLockRole(mock)
+				expectRoleKey(mock, 2, "reader")
+				mock.ExpectQuery(`FROM user_roles .* WHERE user_roles.role_id = \$1 .* FOR UPDATE OF user_roles`).
+					WithArgs(1, "default").
+					WillReturnRows(sqlmock.NewRows(userRoleRowColumns).
+						AddRow(7, "default", "test@example.com", 1, "", "", nil, nil, time.Now(), time.Now(), nil, nil, 3, nil, nil, "", "allow", "viewer"))
+				mock.ExpectQuery(`UPDATE user_roles SET role_id = \$1, updated_at = CURRENT_TIMESTAMP, version = version \+ 1 WHERE id = \$2 AND tenant_id = \$3 RETURNING updated_at, version`).
+					WithArgs(2, 7, "default").
+					WillReturnRows(sqlmock.NewRows([]string{"updated_at", "version"}).AddRow(time.Now(), 4))
+				mock.ExpectExec(`INSERT INTO audit_events`).
+					WithArgs("default", "anonymous", "update", "user_role", 7, sqlmock.AnyArg(), sqlmock.AnyArg(), "").
+					WillReturnResult(sqlmock.NewResult(1, 1))
+				expectDelete(mock)
+			},
+		},
+		{
+			name:    "reassign to missing role",
+			options: DeleteRoleOptions{Assignments: AssignmentsReassign, ReplacementRoleID: 2},
+			mockQueries: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				expectLockRole(mock)
+				expectRoleKey(mock, 2, "")
+				mock.ExpectRollback()
+			},
+			expectedErr: ErrRoleNotFound,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			assert.NoError(t, err)
+			defer db.Close()
+			tc.mockQueries(mock)
+
+			err = NewPostgres(db).DeleteRole(context.Background(), "default", 1, tc.options)
+			assert.Equal(t, tc.expectedErr, err)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestPostgresSetParentRoles(t *testing.T) {
+	testCases := []struct {
+		name        string
+		cycle       bool
+		mockQueries func(mock sqlmock.Sqlmock)
+		expectedErr error
+	}{
+		{
+			name: "success",
+			mockQueries: func(mock sqlmock.Sqlmock) {
+				mock.ExpectExec(`DELETE FROM role_parents WHERE role_id = \$1 AND tenant_id = \$2`).
+					WithArgs(1, "default").
+					WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectExec(`INSERT INTO role_parents`).
+					WithArgs("default", 1, 2).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+			},
+		},
+		{
+			name:        "cycle",
+			cycle:       true,
+			mockQueries: func(mock sqlmock.Sqlmock) {},
+			expectedErr: ErrRoleCycle,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			assert.NoError(t, err)
+			defer db.Close()
+			mock.ExpectBegin()
+			// The hierarchy is locked before it is read for cycles.
+			mock.ExpectExec(`SELECT pg_advisory_xact_lock\(\$1, hashtext\(\$2\)\)`).
+				WithArgs(roleHierarchyLock, "default").
+				WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectQuery(`SELECT COUNT\(\*\) FROM roles WHERE id = ANY\(\$1\)`).
+				WithArgs(pq.Array([]int64{2}), "default").
+				WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+			mock.ExpectQuery(`WITH RECURSIVE ancestors`).
+				WithArgs(pq.Array([]int64{2}), 1, "default").
+				WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(tc.cycle))
+			tc.mockQueries(mock)
+
+			tx, err := db.Begin()
+			assert.NoError(t, err)
+			err = setParentRoles(context.Background(), tx, "default", 1, []int{2})
+			assert.Equal(t, tc.expectedErr, err)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestPostgresBatchUserRoles(t *testing.T) {
+	grants := []models.UserRole{
+		{Email: "a@example.com", RoleID: 2},
+		{Email: "b@example.com", RoleID: 2},
+	}
+	expectInsert := func(mock sqlmock.Sqlmock, email string) *sqlmock.ExpectedQuery {
+		return mock.ExpectQuery(`INSERT INTO user_roles`).
+			WithArgs("default", email, nil, nil, 2, "", "", nil, nil, "", "allow")
+	}
+	insertedRow := func(id int) *sqlmock.Rows {
+		return sqlmock.NewRows([]string{"id", "created_at", "updated_at", "version"}).AddRow(id, time.Now(), time.Now(), 1)
+	}
+
+	t.Run("not atomic", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		assert.NoError(t, err)
+		defer db.Close()
+
+		mock.ExpectBegin()
+		// The role is looked up once for both grants.
+		expectRoleKey(mock, 2, "admin")
+		mock.ExpectExec(`SAVEPOINT batch_item`).WillReturnResult(sqlmock.NewResult(0, 0))
+		expectInsert(mock, "a@example.com").WillReturnRows(insertedRow(1))
+		mock.ExpectExec(`INSERT INTO audit_events`).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec(`RELEASE SAVEPOINT batch_item`).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec(`SAVEPOINT batch_item`).WillReturnResult(sqlmock.NewResult(0, 0))
+		expectInsert(mock, "b@example.com").WillReturnError(&pq.Error{Code: "23505", Constraint: "unique_email_role"})
+		mock.ExpectExec(`ROLLBACK TO SAVEPOINT batch_item`).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectCommit()
+
+		results, err := NewPostgres(db).BatchUserRoles(context.Background(), "default", models.UserRoleBatch{Grants: grants})
+		assert.NoError(t, err)
+		assert.Len(t, results, 2)
+		assert.NoError(t, results[0].Err)
+		assert.Equal(t, 1, results[0].UserRole.ID)
+		assert.Equal(t, "admin", results[0].UserRole.RoleKey)
+		assert.Equal(t, &ConstraintError{Err: ErrConflict, Constraint: "unique_email_role"}, results[1].Err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("atomic", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		assert.NoError(t, err)
+		defer db.Close()
+
+		mock.ExpectBegin()
+		expectRoleKey(mock, 2, "admin")
+		expectInsert(mock, "a@example.com").WillReturnRows(insertedRow(1))
+		mock.ExpectExec(`INSERT INTO audit_events`).WillReturnResult(sqlmock.NewResult(1, 1))
+		expectInsert(mock, "b@example.com").WillReturnError(&pq.Error{Code: "23505", Constraint: "unique_email_role"})
+		mock.ExpectRollback()
+
+		results, err := NewPostgres(db).BatchUserRoles(context.Background(), "default", models.UserRoleBatch{Atomic: true, Grants: grants})
+		assert.Nil(t, results)
+		assert.Equal(t, &BatchItemError{Op: BatchGrant, Index: 1, Err: &ConstraintError{Err: ErrConflict, Constraint: "unique_email_role"}}, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestPostgresSyncRoleMembers(t *testing.T) {
+	expectGrants := func(mock sqlmock.Sqlmock) {
+		mock.ExpectQuery(`FROM user_roles .* WHERE user_roles.role_id = \$1 .* FOR UPDATE OF user_roles`).
+			WithArgs(2, "default").
+			WillReturnRows(sqlmock.NewRows(userRoleRowColumns).
+				AddRow(1, "default", "old@example.com", 2, "", "", nil, nil, time.Now(), time.Now(), nil, nil, 1, nil, nil, "", "allow", "admin").
+				AddRow(2, "default", "kept@example.com", 2, "", "", nil, nil, time.Now(), time.Now(), nil, nil, 1, nil, nil, "", "allow", "admin").
+				AddRow(3, "default", "new@example.com", 2, "project", "apollo", nil, nil, time.Now(), time.Now(), nil, nil, 1, nil, nil, "", "allow", "admin"))
+	}
+	emails := []string{"new@example.com", "kept@example.com"}
+	expectedDiff := models.RoleMembersDiff{RoleID: 2, Added: []string{"new@example.com"}, Removed: []string{"old@example.com"}, Unchanged: []string{"kept@example.com"}}
+
+	t.Run("sync", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		assert.NoError(t, err)
+		defer db.Close()
+
+		mock.ExpectBegin()
+		expectRoleKey(mock, 2, "admin")
+		expectGrants(mock)
+		mock.ExpectExec(`UPDATE user_roles SET deleted_at = CURRENT_TIMESTAMP, deleted_reason = \$1`).
+			WithArgs(ReasonMembersSynced, 1, "default").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(`INSERT INTO audit_events`).
+			WithArgs("default", "anonymous", "delete", "user_role", 1, sqlmock.AnyArg(), nil, "").
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectQuery(`INSERT INTO user_roles`).
+			WithArgs("default", "new@example.com", nil, nil, 2, "", "", nil, nil, "", "allow").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "created_at", "updated_at", "version"}).AddRow(4, time.Now(), time.Now(), 1))
+		mock.ExpectExec(`INSERT INTO audit_events`).
+			WithArgs("default", "anonymous", "create", "user_role", 4, nil, sqlmock.AnyArg(), "").
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+
+		diff, err := NewPostgres(db).SyncRoleMembers(context.Background(), "default", 2, emails, false)
+		assert.NoError(t, err)
+		assert.Equal(t, expectedDiff, diff)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("dry run", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		assert.NoError(t, err)
+		defer db.Close()
+
+		mock.ExpectBegin()
+		expectRoleKey(mock, 2, "admin")
+		expectGrants(mock)
+		mock.ExpectCommit()
+
+		diff, err := NewPostgres(db).SyncRoleMembers(context.Background(), "default", 2, emails, true)
+		assert.NoError(t, err)
+		expectedDiff.DryRun = true
+		assert.Equal(t, expectedDiff, diff)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("role not found", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		assert.NoError(t, err)
+		defer db.Close()
+
+		mock.ExpectBegin()
+		expectRoleKey(mock, 2, "")
+		mock.ExpectRollback()
+
+		_, err = NewPostgres(db).SyncRoleMembers(context.Background(), "default", 2, emails, false)
+		assert.Equal(t, ErrNotFound, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+// auditState matches the JSON state of an audit event that includes every
+// fragment.
+type auditState []string
+
+func (fragments auditState) Match(v driver.Value) bool {
+	state, ok := v.(string)
+	if !ok {
+		return false
+	}
+	for _, fragment := range fragments {
+		if !strings.Contains(state, fragment) {
+			return false
+		}
+	}
+	return true
+}
+
+func TestPostgresSweepExpiredUserRoles(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+	s := NewPostgres(db)
+	ctx := utils.WithActor(context.Background(), "system:expiry-sweeper")
+
+	// The swept grants keep their grantee, condition and effect, in the
+	// audit trail too.
+	mock.ExpectBegin()
+	mock.ExpectQuery(`FROM user_roles .* WHERE user_roles.deleted_at IS NULL AND user_roles.valid_until IS NOT NULL .* FOR UPDATE OF user_roles`).
+		WillReturnRows(sqlmock.NewRows(userRoleRowColumns).
+			AddRow(4, "default", "", 1, "", "", nil, time.Now(), time.Now(), time.Now(), nil, nil, 1, 5, nil, "", "allow", "viewer").
+			AddRow(9, "billing", "oncall@example.com", 7, "", "", nil, time.Now(), time.Now(), time.Now(), nil, nil, 2, nil, nil, `request.ip == "10.0.0.1"`, "deny", "admin").
+			AddRow(11, "default", "", 2, "", "", nil, time.Now(), time.Now(), time.Now(), nil, nil, 1, nil, 8, "", "allow", "deployer"))
+	mock.ExpectExec(`UPDATE user_roles SET deleted_at = CURRENT_TIMESTAMP, deleted_reason = \$1`).
+		WithArgs(ReasonExpired, pq.Array([]int64{4, 9, 11})).
+		WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectExec(`INSERT INTO audit_events`).
+		WithArgs("default", "system:expiry-sweeper", "expire", "user_role", 4, auditState{`"group_id":5`, `"source":"group"`}, nil, "").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(`INSERT INTO audit_events`).
+		WithArgs("billing", "system:expiry-sweeper", "expire", "user_role", 9, auditState{`"condition":"request.ip == \"10.0.0.1\""`, `"effect":"deny"`}, nil, "").
+		WillReturnResult(sqlmock.NewResult(2, 1))
+	mock.ExpectExec(`INSERT INTO audit_events`).
+		WithArgs("default", "system:expiry-sweeper", "expire", "user_role", 11, auditState{`"service_account_id":8`, `"principal":{"type":"service","id":"8"}`}, nil, "").
+		WillReturnResult(sqlmock.NewResult(3, 1))
+	mock.ExpectCommit()
+
+	swept, err := s.SweepExpiredUserRoles(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), swept)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`FROM user_roles`).
+		WillReturnError(errors.New("database error"))
+	mock.ExpectRollback()
+
+	_, err = s.SweepExpiredUserRoles(ctx)
+	assert.Error(t, err)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgresPurgeDeleted(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+	s := NewPostgres(db)
+	ctx := utils.WithActor(context.Background(), "system:purger")
+
+	retention := 30 * 24 * time.Hour
+	purgedRoleColumns := []string{"id", "tenant_id", "role_key", "description", "created_at", "updated_at", "deleted_at"}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`FROM user_roles .* WHERE user_roles.deleted_at IS NOT NULL .* FOR UPDATE OF user_roles`).
+		WithArgs(retention.Seconds()).
+		WillReturnRows(sqlmock.NewRows(userRoleRowColumns).
+			AddRow(4, "default", "", 3, "", "", nil, nil, time.Now(), time.Now(), time.Now(), ReasonRoleDeleted, 2, 5, nil, "", "deny", "legacy").
+			AddRow(6, "default", "", 3, "", "", nil, nil, time.Now(), time.Now(), time.Now(), ReasonServiceAccountDeleted, 2, nil, 8, "", "allow", "legacy"))
+	mock.ExpectExec(`DELETE FROM user_roles WHERE id = ANY\(\$1\)`).
+		WithArgs(pq.Array([]int64{4, 6})).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectQuery(`SELECT id, tenant_id, role_key, description, created_at, updated_at, deleted_at\s+FROM roles`).
+		WithArgs(retention.Seconds()).
+		WillReturnRows(sqlmock.NewRows(purgedRoleColumns).
+			AddRow(3, "default", "legacy", "Legacy role", "2024-01-01T00:00:00Z", "2024-01-01T00:00:00Z", "2024-02-01T00:00:00Z"))
+	mock.ExpectExec(`DELETE FROM role_parents WHERE role_id = ANY\(\$1\) OR parent_role_id = ANY\(\$1\)`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`DELETE FROM role_permissions WHERE role_id = ANY\(\$1\)`).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec(`DELETE FROM roles WHERE id = ANY\(\$1\)`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`INSERT INTO audit_events`).
+		WithArgs("default", "system:purger", "purge", "user_role", 4, auditState{`"group_id":5`, `"effect":"deny"`}, nil, "").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(`INSERT INTO audit_events`).
+		WithArgs("default", "system:purger", "purge", "user_role", 6, auditState{`"service_account_id":8`, `"deleted_reason":"service_account_deleted"`}, nil, "").
+		WillReturnResult(sqlmock.NewResult(2, 1))
+	mock.ExpectExec(`INSERT INTO audit_events`).
+		WithArgs("default", "system:purger", "purge", "role", 3, sqlmock.AnyArg(), nil, "").
+		WillReturnResult(sqlmock.NewResult(3, 1))
+	mock.ExpectCommit()
+
+	purged, err := s.PurgeDeleted(ctx, retention)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), purged)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`FROM user_roles`).
+		WithArgs(retention.Seconds()).
+		WillReturnRows(sqlmock.NewRows(userRoleRowColumns))
+	mock.ExpectQuery(`FROM roles`).
+		WithArgs(retention.Seconds()).
+		WillReturnError(errors.New("database error"))
+	mock.ExpectRollback()
+
+	_, err = s.PurgeDeleted(ctx, retention)
+	assert.Error(t, err)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgresExplainSweptGrant(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+	s := NewPostgres(db)
+	ctx := context.Background()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`FROM user_roles .* FOR UPDATE OF user_roles`).
+		WillReturnRows(sqlmock.NewRows(userRoleRowColumns).
+			AddRow(4, "default", "alice@example.com", 10, "", "", nil, time.Now(), time.Now(), time.Now(), nil, nil, 1, nil, nil, "", "allow", "approver"))
+	mock.ExpectExec(`UPDATE user_roles SET deleted_at = CURRENT_TIMESTAMP, deleted_reason = \$1`).
+		WithArgs(ReasonExpired, pq.Array([]int64{4})).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`INSERT INTO audit_events`).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	swept, err := s.SweepExpiredUserRoles(ctx)
+	require.NoError(t, err)
+	require.Equal(t, int64(1), swept)
+
+	// Explain still loads the swept grant, as the sweeper left it.
+	mock.ExpectQuery(`FROM user_roles .* user_roles.deleted_reason IN \('expired', 'role_deleted'\)`).
+		WithArgs("default", "alice@example.com").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "group_id", "id", "role_key", "resource_type", "resource_id", "condition", "effect", "inactive"}).
+			AddRow(4, nil, 10, "approver", "", "", "", "allow", ReasonExpired))
+
+	explanation, err := s.Explain(ctx, "default", models.AuthorizationRequest{Email: "alice@example.com", Permission: "refund:approve"})
+	assert.NoError(t, err)
+	assert.False(t, explanation.Result.Allowed)
+	require.Len(t, explanation.Bindings, 1)
+	assert.Equal(t, 4, explanation.Bindings[0].UserRoleID)
+	assert.Equal(t, authz.OutcomeSkipped, explanation.Bindings[0].Outcome)
+	assert.Equal(t, authz.SkipExpired, explanation.Bindings[0].Reason)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
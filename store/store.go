@@ -0,0 +1,546 @@
+// Package store persists roles, user roles, permissions and audit events
+// behind interfaces, so the HTTP handlers can run against Postgres or
+// entirely in memory. Every write also records its audit event, atomically
+// with the change where the backend supports it.
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	models "main/Models"
+	"main/listing"
+	"sort"
+	"strconv"
+	"time"
+)
+
+var (
+	// ErrNotFound is returned when the addressed row does not exist, is
+	// deleted, or belongs to another tenant.
+	ErrNotFound = errors.New("not found")
+	// ErrConflict is returned when a write would duplicate a unique key.
+	ErrConflict = errors.New("already exists")
+	// ErrInvalidReference is returned when a write references a row that
+	// does not exist.
+	ErrInvalidReference = errors.New("referenced row does not exist")
+	// ErrRoleNotFound is returned when a user role names a role that is
+	// deleted or does not exist.
+	ErrRoleNotFound       = errors.New("role is either deleted or does not exist")
+	ErrParentRoleNotFound = errors.New("parent role is either deleted or does not exist")
+	ErrRoleCycle          = errors.New("parent roles would create a cycle")
+	// ErrNotDeleted is returned when restoring a row that is not deleted.
+	ErrNotDeleted = errors.New("not deleted")
+	// ErrUserRoleExpired is returned when restoring a grant whose
+	// valid_until has passed, which the expiry sweeper would delete again.
+	ErrUserRoleExpired = errors.New("user role has expired; grant it again instead")
+	// ErrRoleHasAssignments is returned when deleting a role that is still
+	// granted, under AssignmentsRestrict.
+	ErrRoleHasAssignments = errors.New("role is still granted to user roles")
+	// ErrVersionMismatch is returned when a write names a version that is no
+	// longer current.
+	ErrVersionMismatch = errors.New("version does not match the current version")
+	// ErrGroupNotFound is returned when a user role or group member names a
+	// group that is deleted or does not exist.
+	ErrGroupNotFound = errors.New("group is either deleted or does not exist")
+	ErrGroupCycle    = errors.New("member group would create a cycle")
+	// ErrServiceAccountNotFound is returned when a user role names a service
+	// account that is deleted or does not exist.
+	ErrServiceAccountNotFound = errors.New("service account is either deleted or does not exist")
+	// ErrPermissionNotFound is returned when a role permission names a
+	// permission that is deleted or does not exist.
+	ErrPermissionNotFound = errors.New("permission is either deleted or does not exist")
+)
+
+// ConstraintError reports the database constraint behind ErrConflict or
+// ErrInvalidReference.
+type ConstraintError struct {
+	Err        error
+	Constraint string
+}
+
+func (e *ConstraintError) Error() string { return e.Err.Error() + ": " + e.Constraint }
+
+func (e *ConstraintError) Unwrap() error { return e.Err }
+
+// RoleStore manages roles and their parent roles within a tenant.
+type RoleStore interface {
+	// ListRoles returns one page of roles and the cursor of the next page.
+	ListRoles(ctx context.Context, tenant string, filter RoleFilter, page listing.Params) ([]models.Role, string, error)
+	GetRole(ctx context.Context, tenant string, id int) (models.Role, error)
+	// CreateRole stores role in role.TenantID with the parents in ParentIDs.
+	CreateRole(ctx context.Context, role models.Role) (models.Role, error)
+	// UpdateRole replaces the key and description of a role, and its parents
+	// when role.ParentIDs is not nil. A non-zero role.Version must be the
+	// current version.
+	UpdateRole(ctx context.Context, tenant string, id int, role models.Role) (models.Role, error)
+	// DeleteRole soft-deletes a role, handling its user roles as options
+	// says.
+	DeleteRole(ctx context.Context, tenant string, id int, options DeleteRoleOptions) error
+	// RestoreRole undeletes a soft-deleted role. Its key must not have been
+	// reused by a live role in the meantime.
+	RestoreRole(ctx context.Context, tenant string, id int) (models.Role, error)
+	// RoleAncestors lists every live role id inherits from, transitively.
+	RoleAncestors(ctx context.Context, tenant string, id int) ([]models.Role, error)
+	// RoleDescendants lists every live role inheriting from id, transitively.
+	RoleDescendants(ctx context.Context, tenant string, id int) ([]models.Role, error)
+}
+
+// AssignmentPolicy decides what deleting a role does to the user roles that
+// grant it.
+type AssignmentPolicy string
+
+const (
+	// AssignmentsRestrict refuses to delete a role with unexpired grants, and
+	// soft-deletes its expired ones with reason expired.
+	AssignmentsRestrict AssignmentPolicy = "restrict"
+	// AssignmentsCascade soft-deletes the grants along with the role.
+	AssignmentsCascade AssignmentPolicy = "cascade"
+	// AssignmentsReassign moves the grants to a replacement role.
+	AssignmentsReassign AssignmentPolicy = "reassign"
+)
+
+// Reasons recorded in user_roles.deleted_reason: ReasonRevoked for grants
+// removed by DeleteUserRole or a batch revocation, ReasonExpired for grants
+// removed by SweepExpiredUserRoles because their valid_until passed,
+// ReasonRoleDeleted for grants removed by AssignmentsCascade,
+// ReasonMembersSynced for grants removed by SyncRoleMembers, and
+// ReasonGroupDeleted and ReasonServiceAccountDeleted for the grants of a
+// deleted group or service account.
+const (
+	ReasonRevoked               = "revoked"
+	ReasonExpired               = "expired"
+	ReasonRoleDeleted           = "role_deleted"
+	ReasonMembersSynced         = "members_synced"
+	ReasonGroupDeleted          = "group_deleted"
+	ReasonServiceAccountDeleted = "service_account_deleted"
+)
+
+// Sources of a user role: granted to an email directly, or to a group.
+const (
+	SourceDirect = "direct"
+	SourceGroup  = "group"
+)
+
+// withEffect returns userRole with an empty effect defaulted to allow, as the
+// column is.
+func withEffect(userRole models.UserRole) models.UserRole {
+	if userRole.Effect == "" {
+		userRole.Effect = models.EffectAllow
+	}
+	return userRole
+}
+
+// describeUserRole sets the fields of userRole that follow from its grantee:
+// Principal and Source.
+func describeUserRole(userRole *models.UserRole) {
+	userRole.Source = SourceDirect
+	switch {
+	case userRole.GroupID != nil:
+		userRole.Principal = models.Principal{Type: models.PrincipalGroup, ID: strconv.Itoa(*userRole.GroupID)}
+		userRole.Source = SourceGroup
+	case userRole.ServiceAccountID != nil:
+		userRole.Principal = models.Principal{Type: models.PrincipalService, ID: strconv.Itoa(*userRole.ServiceAccountID)}
+	default:
+		userRole.Principal = models.Principal{Type: models.PrincipalUser, ID: userRole.Email}
+	}
+}
+
+// DeleteRoleOptions configures DeleteRole. The zero value restricts.
+type DeleteRoleOptions struct {
+	Assignments AssignmentPolicy
+	// ReplacementRoleID is the live role grants move to under
+	// AssignmentsReassign.
+	ReplacementRoleID int
+	// Version, when non-zero, must be the role's current version.
+	Version int
+}
+
+// Fields roles and user roles can be sorted on, besides id.
+var (
+	RoleSortFields           = []string{"role_key", "created_at"}
+	UserRoleSortFields       = []string{"email", "created_at"}
+	GroupSortFields          = []string{"group_key", "created_at"}
+	ServiceAccountSortFields = []string{"name", "created_at"}
+	PermissionSortFields     = []string{"permission_key", "created_at"}
+	AuditEventSortFields     = []string{"created_at"}
+)
+
+// RoleFilter narrows ListRoles. Empty fields match everything, and deleted
+// roles are only listed with IncludeDeleted.
+type RoleFilter struct {
+	RoleKey        string
+	CreatedAfter   *time.Time
+	CreatedBefore  *time.Time
+	IncludeDeleted bool
+}
+
+// UserRoleFilter narrows ListUserRoles. Empty fields match everything; a
+// resource matches every assignment whose scope covers it. Email resolves
+// the email's effective grants: its own, and those of every live group it
+// belongs to, directly or through nested groups. EmailDomain matches the part
+// after "@". IncludeDeleted also lists deleted and expired grants.
+type UserRoleFilter struct {
+	Email            string
+	EmailPrefix      string
+	EmailDomain      string
+	GroupID          int
+	ServiceAccountID int
+	RoleID           int
+	RoleKey          string
+	ResourceType     string
+	ResourceID       string
+	CreatedAfter     *time.Time
+	CreatedBefore    *time.Time
+	IncludeDeleted   bool
+}
+
+// UserRoleStore manages the roles granted to emails, groups and service
+// accounts within a tenant. Expired grants are only returned by
+// ListUserRoles with IncludeDeleted.
+type UserRoleStore interface {
+	// ListUserRoles returns one page of user roles and the cursor of the
+	// next page.
+	ListUserRoles(ctx context.Context, tenant string, filter UserRoleFilter, page listing.Params) ([]models.UserRole, string, error)
+	GetUserRole(ctx context.Context, tenant string, id int) (models.UserRole, error)
+	// CreateUserRole stores userRole in userRole.TenantID.
+	CreateUserRole(ctx context.Context, userRole models.UserRole) (models.UserRole, error)
+	// UpdateUserRole replaces a user role. A non-zero userRole.Version must
+	// be the current version.
+	UpdateUserRole(ctx context.Context, tenant string, id int, userRole models.UserRole) (models.UserRole, error)
+	// DeleteUserRole soft-deletes a user role. A non-zero version must be the
+	// current version.
+	DeleteUserRole(ctx context.Context, tenant string, id int, version int) error
+	// RestoreUserRole undeletes a soft-deleted user role, provided it has not
+	// expired, its role is live and the grant has not been given again since.
+	RestoreUserRole(ctx context.Context, tenant string, id int) (models.UserRole, error)
+	// BatchUserRoles applies batch's grants and then its revocations within
+	// tenant, returning one result per item in that order. Each distinct
+	// role granted is looked up once. An atomic batch stops at the first
+	// failing item, undoes the rest and returns a *BatchItemError.
+	BatchUserRoles(ctx context.Context, tenant string, batch models.UserRoleBatch) ([]BatchResult, error)
+	// SyncRoleMembers makes emails, which must be normalized and distinct,
+	// the exact members of a live role: the emails holding it through an
+	// unexpired, unscoped user role of their own. Missing members are granted
+	// the role and other members lose it; scoped grants, and grants to groups
+	// and service accounts, are left alone. A dry run
+	// only computes the diff.
+	SyncRoleMembers(ctx context.Context, tenant string, roleID int, emails []string, dryRun bool) (models.RoleMembersDiff, error)
+}
+
+// Operations of a user role batch.
+const (
+	BatchGrant  = "grant"
+	BatchRevoke = "revoke"
+)
+
+// BatchResult is the outcome of one batch item: the user role granted, or
+// revoked as it was before, or the error the item failed with.
+type BatchResult struct {
+	UserRole models.UserRole
+	Err      error
+}
+
+// BatchItemError reports the item an atomic batch failed on.
+type BatchItemError struct {
+	Op    string
+	Index int
+	Err   error
+}
+
+func (e *BatchItemError) Error() string { return fmt.Sprintf("%s %d: %v", e.Op, e.Index, e.Err) }
+
+func (e *BatchItemError) Unwrap() error { return e.Err }
+
+// GroupFilter narrows ListGroups. Empty fields match everything.
+type GroupFilter struct {
+	GroupKey string
+}
+
+// GroupStore manages groups and their members within a tenant. Roles are
+// granted to groups through UserRoleStore.
+type GroupStore interface {
+	// ListGroups returns one page of live groups and the cursor of the next
+	// page.
+	ListGroups(ctx context.Context, tenant string, filter GroupFilter, page listing.Params) ([]models.Group, string, error)
+	GetGroup(ctx context.Context, tenant string, id int) (models.Group, error)
+	// CreateGroup stores group in group.TenantID.
+	CreateGroup(ctx context.Context, group models.Group) (models.Group, error)
+	// UpdateGroup replaces the key and description of a group. A non-zero
+	// group.Version must be the current version.
+	UpdateGroup(ctx context.Context, tenant string, id int, group models.Group) (models.Group, error)
+	// DeleteGroup soft-deletes a group and the user roles granted to it. A
+	// non-zero version must be the current version.
+	DeleteGroup(ctx context.Context, tenant string, id int, version int) error
+	// ListGroupMembers lists the direct members of a live group.
+	ListGroupMembers(ctx context.Context, tenant string, groupID int) ([]models.GroupMember, error)
+	// AddGroupMember adds member to member.GroupID. A member group must be
+	// live and must not already contain the group, however deeply.
+	AddGroupMember(ctx context.Context, tenant string, member models.GroupMember) (models.GroupMember, error)
+	// RemoveGroupMember removes a member from a live group.
+	RemoveGroupMember(ctx context.Context, tenant string, groupID, memberID int) error
+}
+
+// ServiceAccountFilter narrows ListServiceAccounts. Empty fields match
+// everything.
+type ServiceAccountFilter struct {
+	Name string
+}
+
+// ServiceAccountStore manages service accounts and their API credentials
+// within a tenant. Roles are granted to service accounts through
+// UserRoleStore.
+type ServiceAccountStore interface {
+	// ListServiceAccounts returns one page of live service accounts and the
+	// cursor of the next page.
+	ListServiceAccounts(ctx context.Context, tenant string, filter ServiceAccountFilter, page listing.Params) ([]models.ServiceAccount, string, error)
+	GetServiceAccount(ctx context.Context, tenant string, id int) (models.ServiceAccount, error)
+	// CreateServiceAccount stores account in account.TenantID.
+	CreateServiceAccount(ctx context.Context, account models.ServiceAccount) (models.ServiceAccount, error)
+	// UpdateServiceAccount replaces the name and display name of a service
+	// account. A non-zero account.Version must be the current version.
+	UpdateServiceAccount(ctx context.Context, tenant string, id int, account models.ServiceAccount) (models.ServiceAccount, error)
+	// DeleteServiceAccount soft-deletes a service account and the user roles
+	// granted to it, and revokes its credentials. A non-zero version must be
+	// the current version.
+	DeleteServiceAccount(ctx context.Context, tenant string, id int, version int) error
+	// ListCredentials lists every credential of a live service account,
+	// revoked and expired ones included.
+	ListCredentials(ctx context.Context, tenant string, serviceAccountID int) ([]models.ServiceAccountCredential, error)
+	// CreateCredential stores credential, whose KeyPrefix and KeyHash are
+	// set, for credential.ServiceAccountID. With a non-nil retireOthersAfter,
+	// the account's other active credentials expire that long from now,
+	// unless they expire sooner already.
+	CreateCredential(ctx context.Context, tenant string, credential models.ServiceAccountCredential, retireOthersAfter *time.Duration) (models.ServiceAccountCredential, error)
+	// RevokeCredential revokes an active credential of a live service
+	// account.
+	RevokeCredential(ctx context.Context, tenant string, serviceAccountID, credentialID int) error
+	// AuthenticateCredential returns the live service account, in whichever
+	// tenant, that holds the active credential with keyHash, or ErrNotFound.
+	AuthenticateCredential(ctx context.Context, keyHash string) (models.ServiceAccount, error)
+}
+
+// PrincipalStore looks up every kind of principal and the roles granted to
+// them.
+type PrincipalStore interface {
+	UserRoleStore
+	GroupStore
+	ServiceAccountStore
+}
+
+// PermissionFilter narrows ListPermissions. Empty fields match everything.
+type PermissionFilter struct {
+	PermissionKey string
+	CreatedAfter  *time.Time
+	CreatedBefore *time.Time
+}
+
+// PermissionStore manages the permission keys of a tenant.
+type PermissionStore interface {
+	// ListPermissions returns one page of live permissions and the cursor of
+	// the next page.
+	ListPermissions(ctx context.Context, tenant string, filter PermissionFilter, page listing.Params) ([]models.Permission, string, error)
+	GetPermission(ctx context.Context, tenant string, id int) (models.Permission, error)
+	// CreatePermission stores permission in permission.TenantID.
+	CreatePermission(ctx context.Context, permission models.Permission) (models.Permission, error)
+	// UpdatePermission replaces the key and description of a permission.
+	UpdatePermission(ctx context.Context, tenant string, id int, permission models.Permission) (models.Permission, error)
+	// DeletePermission soft-deletes a permission, which unbinds it from
+	// every role as far as checks are concerned.
+	DeletePermission(ctx context.Context, tenant string, id int) error
+}
+
+// RolePermissionStore manages the permissions bound to roles.
+type RolePermissionStore interface {
+	// ListRolePermissions lists the bindings of live permissions to a role
+	// or, with effective, also to every live role it inherits from. Each
+	// binding keeps the id of the role that holds it.
+	ListRolePermissions(ctx context.Context, tenant string, roleID int, effective bool) ([]models.RolePermission, error)
+	// AddRolePermission binds a live permission to a live role. A missing
+	// role is ErrNotFound, a missing permission ErrPermissionNotFound.
+	AddRolePermission(ctx context.Context, tenant string, rolePermission models.RolePermission) (models.RolePermission, error)
+	// RemoveRolePermission unbinds a permission from a role.
+	RemoveRolePermission(ctx context.Context, tenant string, roleID, permissionID int) error
+}
+
+// AuditFilter narrows ListAuditEvents. Empty fields match everything.
+type AuditFilter struct {
+	Actor         string
+	Action        string
+	EntityType    string
+	EntityID      *int
+	RequestID     string
+	CreatedAfter  *time.Time
+	CreatedBefore *time.Time
+}
+
+// AuditStore reads the audit events every write records.
+type AuditStore interface {
+	// ListAuditEvents returns one page of audit events and the cursor of the
+	// next page.
+	ListAuditEvents(ctx context.Context, tenant string, filter AuditFilter, page listing.Params) ([]models.AuditEvent, string, error)
+}
+
+// Authorizer decides authorization requests within a tenant, evaluating the
+// stored grants with package authz.
+type Authorizer interface {
+	Check(ctx context.Context, tenant string, req models.AuthorizationRequest) (models.AuthorizationDecision, error)
+	// CheckBatch decides several requests, loading each distinct principal's
+	// grants only once.
+	CheckBatch(ctx context.Context, tenant string, reqs []models.AuthorizationRequest) ([]models.AuthorizationDecision, error)
+	// Explain decides req like Check and returns the trace of how.
+	Explain(ctx context.Context, tenant string, req models.AuthorizationRequest) (models.AuthorizationExplanation, error)
+}
+
+// PolicyStore is what the service's own route policies are enforced with:
+// checks of the caller, and the roles a request touches.
+type PolicyStore interface {
+	Authorizer
+	// RoleKeys returns the keys of the roles roleIDs and of the roles the
+	// user roles userRoleIDs grant, deleted ones included, and of every live
+	// role any of them inherits from, sorted and without duplicates. Ids that
+	// do not exist are ignored.
+	RoleKeys(ctx context.Context, tenant string, roleIDs, userRoleIDs []int) ([]string, error)
+	// HeldRoleKeys returns the keys of the live roles a group or service
+	// account is granted, and of every live role they inherit from, sorted.
+	// Grants that are not valid yet or have expired but not been swept still
+	// count. A group also holds the roles of the groups containing it. Other
+	// principals hold none.
+	HeldRoleKeys(ctx context.Context, tenant string, principal models.Principal) ([]string, error)
+}
+
+// MaintenanceStore is what the background jobs in package jobs run against.
+// Their audit events are attributed to the actor in ctx and to the tenant of
+// each row changed.
+type MaintenanceStore interface {
+	// SweepExpiredUserRoles soft-deletes every live user role whose validity
+	// window has ended, in every tenant, and returns how many it removed.
+	SweepExpiredUserRoles(ctx context.Context) (int64, error)
+	// PurgeDeleted permanently removes user roles and roles that were
+	// soft-deleted more than retention ago, and returns how many it removed.
+	// A deleted role is kept while any user role still references it, so it
+	// is purged once those grants have been purged too.
+	PurgeDeleted(ctx context.Context, retention time.Duration) (int64, error)
+}
+
+// Store is everything the API persists. Postgres and Memory implement it.
+type Store interface {
+	RoleStore
+	PrincipalStore
+	PermissionStore
+	RolePermissionStore
+	AuditStore
+	PolicyStore
+	MaintenanceStore
+}
+
+// roleSortKey returns the value of role's sort field, and its id.
+func roleSortKey(role models.Role, field string) (interface{}, int) {
+	switch field {
+	case "role_key":
+		return role.RoleKey, role.ID
+	case "created_at":
+		createdAt, _ := time.Parse(time.RFC3339Nano, role.CreatedAt)
+		return createdAt, role.ID
+	}
+	return role.ID, role.ID
+}
+
+// userRoleSortKey returns the value of userRole's sort field, and its id.
+func userRoleSortKey(userRole models.UserRole, field string) (interface{}, int) {
+	switch field {
+	case "email":
+		return userRole.Email, userRole.ID
+	case "created_at":
+		return userRole.CreatedAt, userRole.ID
+	}
+	return userRole.ID, userRole.ID
+}
+
+// groupSortKey returns the value of group's sort field, and its id.
+func groupSortKey(group models.Group, field string) (interface{}, int) {
+	switch field {
+	case "group_key":
+		return group.GroupKey, group.ID
+	case "created_at":
+		return group.CreatedAt, group.ID
+	}
+	return group.ID, group.ID
+}
+
+// serviceAccountSortKey returns the value of account's sort field, and its
+// id.
+func serviceAccountSortKey(account models.ServiceAccount, field string) (interface{}, int) {
+	switch field {
+	case "name":
+		return account.Name, account.ID
+	case "created_at":
+		return account.CreatedAt, account.ID
+	}
+	return account.ID, account.ID
+}
+
+// permissionSortKey returns the value of permission's sort field, and its
+// id.
+func permissionSortKey(permission models.Permission, field string) (interface{}, int) {
+	switch field {
+	case "permission_key":
+		return permission.PermissionKey, permission.ID
+	case "created_at":
+		return permission.CreatedAt, permission.ID
+	}
+	return permission.ID, permission.ID
+}
+
+// auditEventSortKey returns the value of event's sort field, and its id.
+func auditEventSortKey(event models.AuditEvent, field string) (interface{}, int) {
+	if field == "created_at" {
+		return event.CreatedAt, event.ID
+	}
+	return event.ID, event.ID
+}
+
+// checkVersion fails with ErrVersionMismatch unless expected is zero or the
+// current version.
+func checkVersion(current, expected int) error {
+	if expected != 0 && expected != current {
+		return ErrVersionMismatch
+	}
+	return nil
+}
+
+// diffMembers splits the desired emails into those to add and those already
+// members, and lists the current members to remove. All three are sorted.
+func diffMembers(current map[string]models.UserRole, emails []string, roleID int, dryRun bool) models.RoleMembersDiff {
+	diff := models.RoleMembersDiff{RoleID: roleID, DryRun: dryRun, Added: []string{}, Removed: []string{}, Unchanged: []string{}}
+	desired := map[string]bool{}
+	for _, email := range emails {
+		desired[email] = true
+		if _, ok := current[email]; ok {
+			diff.Unchanged = append(diff.Unchanged, email)
+		} else {
+			diff.Added = append(diff.Added, email)
+		}
+	}
+	for email := range current {
+		if !desired[email] {
+			diff.Removed = append(diff.Removed, email)
+		}
+	}
+	sort.Strings(diff.Added)
+	sort.Strings(diff.Removed)
+	sort.Strings(diff.Unchanged)
+	return diff
+}
+
+func distinctInts(values []int) []int {
+	seen := map[int]bool{}
+	distinct := []int{}
+	for _, v := range values {
+		if !seen[v] {
+			seen[v] = true
+			distinct = append(distinct, v)
+		}
+	}
+	return distinct
+}
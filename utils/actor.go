@@ -0,0 +1,21 @@
+package utils
+
+import "context"
+
+// AnonymousActor is recorded for changes made by unidentified callers.
+const AnonymousActor = "anonymous"
+
+type actorKey struct{}
+
+// WithActor returns a copy of ctx attributed to actor.
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// ActorFromContext returns who is acting in ctx, or AnonymousActor.
+func ActorFromContext(ctx context.Context) string {
+	if actor, ok := ctx.Value(actorKey{}).(string); ok && actor != "" {
+		return actor
+	}
+	return AnonymousActor
+}
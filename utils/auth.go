@@ -0,0 +1,156 @@
+package utils
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+const (
+	AuthMethodAPIKey         = "api_key"
+	AuthMethodJWT            = "jwt"
+	AuthMethodServiceAccount = "service_account"
+
+	// APIKeyHeader carries a static or service account API key.
+	APIKeyHeader = "X-API-Key"
+	// ServiceAccountKeyPrefix starts every service account API key, which
+	// tells them apart from static API keys.
+	ServiceAccountKeyPrefix = "sa_"
+)
+
+// ErrInvalidCredentials is returned when a request carries a credential that
+// an Authenticator understands but cannot verify.
+var ErrInvalidCredentials = errors.New("invalid credentials")
+
+// Identity is the authenticated caller of a request.
+type Identity struct {
+	// Subject names the caller: the API key name, or the token's email claim
+	// (falling back to sub) for JWTs.
+	Subject string
+	Method  string
+	// Tenant is set when the credential is bound to a tenant.
+	Tenant string
+	// ServiceAccountID is set when the caller is a service account, whose
+	// roles it then acts with. Other callers act with the roles of the user
+	// named by Subject.
+	ServiceAccountID int
+	Claims           map[string]interface{}
+}
+
+// Authenticator recognises one kind of credential. Authenticate returns a nil
+// Identity and nil error when the request carries no credential of its kind,
+// so several authenticators can be tried in turn.
+type Authenticator interface {
+	Authenticate(r *http.Request) (*Identity, error)
+}
+
+type identityKey struct{}
+
+// AuthMiddleware rejects requests that none of the authenticators accept and
+// stores the caller's Identity, and audit actor, in the request context.
+func AuthMiddleware(authenticators ...Authenticator) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			for _, authenticator := range authenticators {
+				identity, err := authenticator.Authenticate(r)
+				if err != nil {
+					unauthorized(w, r)
+					return
+				}
+				if identity != nil {
+					ctx := context.WithValue(r.Context(), identityKey{}, identity)
+					ctx = WithActor(ctx, identity.Subject)
+					next.ServeHTTP(w, r.WithContext(ctx))
+					return
+				}
+			}
+			unauthorized(w, r)
+		})
+	}
+}
+
+// IdentityFromContext returns the authenticated caller, or nil.
+func IdentityFromContext(ctx context.Context) *Identity {
+	identity, _ := ctx.Value(identityKey{}).(*Identity)
+	return identity
+}
+
+// WithIdentity returns a copy of ctx authenticated as identity.
+func WithIdentity(ctx context.Context, identity *Identity) context.Context {
+	return WithActor(context.WithValue(ctx, identityKey{}, identity), identity.Subject)
+}
+
+// APIKeyFromRequest returns the API key sent in X-API-Key or as
+// "Authorization: ApiKey <key>", or "".
+func APIKeyFromRequest(r *http.Request) string {
+	if key := r.Header.Get(APIKeyHeader); key != "" {
+		return key
+	}
+	scheme, credential, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "ApiKey") {
+		return ""
+	}
+	return strings.TrimSpace(credential)
+}
+
+// NewServiceAccountKey generates a random service account API key.
+func NewServiceAccountKey() (string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return ServiceAccountKeyPrefix + base64.RawURLEncoding.EncodeToString(secret), nil
+}
+
+// HashAPIKey returns the hex SHA-256 digest a service account API key is
+// stored and looked up by. The keys are random, so no salt is needed.
+func HashAPIKey(key string) string {
+	digest := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(digest[:])
+}
+
+func unauthorized(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("WWW-Authenticate", `Bearer, ApiKey`)
+	WriteError(w, r, http.StatusUnauthorized, CodeUnauthorized, "missing or invalid credentials", nil)
+}
+
+// APIKeyAuthenticator accepts static keys sent in X-API-Key or as
+// "Authorization: ApiKey <key>". Keys are compared by SHA-256 digest in
+// constant time. Service account keys are left to their own authenticator.
+type APIKeyAuthenticator struct {
+	keys map[[sha256.Size]byte]string
+}
+
+// NewAPIKeyAuthenticator builds an authenticator from a map of key name to key.
+func NewAPIKeyAuthenticator(keys map[string]string) *APIKeyAuthenticator {
+	a := &APIKeyAuthenticator{keys: map[[sha256.Size]byte]string{}}
+	for name, key := range keys {
+		a.keys[sha256.Sum256([]byte(key))] = name
+	}
+	return a
+}
+
+func (a *APIKeyAuthenticator) Authenticate(r *http.Request) (*Identity, error) {
+	key := APIKeyFromRequest(r)
+	if key == "" || strings.HasPrefix(key, ServiceAccountKeyPrefix) {
+		return nil, nil
+	}
+
+	digest := sha256.Sum256([]byte(key))
+	var name string
+	for candidate, candidateName := range a.keys {
+		if subtle.ConstantTimeCompare(digest[:], candidate[:]) == 1 {
+			name = candidateName
+		}
+	}
+	if name == "" {
+		return nil, ErrInvalidCredentials
+	}
+	return &Identity{Subject: name, Method: AuthMethodAPIKey}, nil
+}
@@ -0,0 +1,107 @@
+package utils
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func signToken(t *testing.T, alg, kid string, claims map[string]interface{}, key interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": alg, "typ": "JWT", "kid": kid})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+
+	var signature []byte
+	switch k := key.(type) {
+	case []byte:
+		mac := hmac.New(sha256.New, k)
+		mac.Write([]byte(signed))
+		signature = mac.Sum(nil)
+	case *rsa.PrivateKey:
+		digest := sha256.Sum256([]byte(signed))
+		var err error
+		signature, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest[:])
+		require.NoError(t, err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func TestAuthMiddleware(t *testing.T) {
+	secret := []byte("hmac-secret")
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	jwks := fmt.Sprintf(`{"keys":[{"kty":"oct","kid":"hs","k":%q},{"kty":"RSA","kid":"rs","n":%q,"e":%q}]}`,
+		base64.RawURLEncoding.EncodeToString(secret),
+		base64.RawURLEncoding.EncodeToString(rsaKey.N.Bytes()),
+		base64.RawURLEncoding.EncodeToString(big.NewInt(int64(rsaKey.E)).Bytes()))
+	keys, err := ParseKeySet([]byte(jwks))
+	require.NoError(t, err)
+
+	jwtAuth := NewJWTAuthenticator(keys, "issuer", "permissions")
+	middleware := AuthMiddleware(NewAPIKeyAuthenticator(map[string]string{"ci-bot": "s3cret"}), jwtAuth)
+
+	valid := map[string]interface{}{
+		"sub": "u-1", "email": "alice@example.com", "iss": "issuer", "aud": "permissions",
+		"tenant": "billing", "exp": time.Now().Add(time.Hour).Unix(),
+	}
+	expired := map[string]interface{}{"sub": "u-1", "iss": "issuer", "aud": "permissions", "exp": time.Now().Add(-time.Hour).Unix()}
+	wrongAudience := map[string]interface{}{"sub": "u-1", "iss": "issuer", "aud": "other", "exp": time.Now().Add(time.Hour).Unix()}
+
+	testCases := []struct {
+		name           string
+		headers        map[string]string
+		expectedCode   int
+		expectedActor  string
+		expectedTenant string
+	}{
+		{name: "no credentials", expectedCode: http.StatusUnauthorized},
+		{name: "api key header", headers: map[string]string{APIKeyHeader: "s3cret"}, expectedCode: http.StatusOK, expectedActor: "ci-bot", expectedTenant: DefaultTenant},
+		{name: "api key authorization", headers: map[string]string{"Authorization": "ApiKey s3cret"}, expectedCode: http.StatusOK, expectedActor: "ci-bot", expectedTenant: DefaultTenant},
+		{name: "unknown api key", headers: map[string]string{APIKeyHeader: "guess"}, expectedCode: http.StatusUnauthorized},
+		{name: "service account key is left to its authenticator", headers: map[string]string{APIKeyHeader: ServiceAccountKeyPrefix + "s3cret"}, expectedCode: http.StatusUnauthorized},
+		{name: "hs256 token", headers: map[string]string{"Authorization": "Bearer " + signToken(t, "HS256", "hs", valid, secret)}, expectedCode: http.StatusOK, expectedActor: "alice@example.com", expectedTenant: "billing"},
+		{name: "rs256 token", headers: map[string]string{"Authorization": "Bearer " + signToken(t, "RS256", "rs", valid, rsaKey)}, expectedCode: http.StatusOK, expectedActor: "alice@example.com", expectedTenant: "billing"},
+		{name: "hs256 token signed with wrong secret", headers: map[string]string{"Authorization": "Bearer " + signToken(t, "HS256", "hs", valid, []byte("nope"))}, expectedCode: http.StatusUnauthorized},
+		{name: "alg does not match key type", headers: map[string]string{"Authorization": "Bearer " + signToken(t, "HS256", "rs", valid, secret)}, expectedCode: http.StatusUnauthorized},
+		{name: "unsigned token", headers: map[string]string{"Authorization": "Bearer " + signToken(t, "none", "hs", valid, nil)}, expectedCode: http.StatusUnauthorized},
+		{name: "expired token", headers: map[string]string{"Authorization": "Bearer " + signToken(t, "HS256", "hs", expired, secret)}, expectedCode: http.StatusUnauthorized},
+		{name: "wrong audience", headers: map[string]string{"Authorization": "Bearer " + signToken(t, "HS256", "hs", wrongAudience, secret)}, expectedCode: http.StatusUnauthorized},
+		{name: "token tenant matches header", headers: map[string]string{"Authorization": "Bearer " + signToken(t, "HS256", "hs", valid, secret), TenantHeader: "billing"}, expectedCode: http.StatusOK, expectedActor: "alice@example.com", expectedTenant: "billing"},
+		{name: "token tenant conflicts with header", headers: map[string]string{"Authorization": "Bearer " + signToken(t, "HS256", "hs", valid, secret), TenantHeader: "other"}, expectedCode: http.StatusForbidden},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var actor, tenant string
+			handler := middleware(TenantMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				actor = ActorFromContext(r.Context())
+				tenant = TenantFromContext(r.Context())
+			})))
+
+			req := httptest.NewRequest("POST", "/user-roles", nil)
+			for k, v := range tc.headers {
+				req.Header.Set(k, v)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			assert.Equal(t, tc.expectedCode, w.Code)
+			assert.Equal(t, tc.expectedActor, actor)
+			assert.Equal(t, tc.expectedTenant, tenant)
+		})
+	}
+}
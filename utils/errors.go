@@ -0,0 +1,33 @@
+package utils
+
+import (
+	"encoding/json"
+	models "main/Models"
+	"net/http"
+)
+
+// Error codes carried in ErrorResponse.Code.
+const (
+	CodeInvalidRequest       = "invalid_request"
+	CodeUnauthorized         = "unauthorized"
+	CodeForbidden            = "forbidden"
+	CodeNotFound             = "not_found"
+	CodeConflict             = "conflict"
+	CodeUnprocessable        = "unprocessable_entity"
+	CodeValidation           = "validation_failed"
+	CodePrecondition         = "precondition_failed"
+	CodeUnsupportedMediaType = "unsupported_media_type"
+	CodeInternal             = "internal_error"
+)
+
+// WriteError writes the JSON error envelope, tagged with the request ID.
+func WriteError(w http.ResponseWriter, r *http.Request, status int, code, message string, details interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(models.ErrorResponse{
+		Code:      code,
+		Message:   message,
+		Details:   details,
+		RequestID: RequestIDFromContext(r.Context()),
+	})
+}
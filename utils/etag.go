@@ -0,0 +1,66 @@
+package utils
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ErrInvalidIfMatch is returned for an If-Match header that does not name a
+// single version ETag.
+var ErrInvalidIfMatch = errors.New("If-Match must be a single ETag or *")
+
+// VersionETag is the strong ETag of a row at version.
+func VersionETag(version int) string {
+	return `"` + strconv.Itoa(version) + `"`
+}
+
+// ContentETag is a weak ETag for a response body without a version of its
+// own, such as a list page.
+func ContentETag(body []byte) string {
+	sum := sha256.Sum256(body)
+	return `W/"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// IfMatchVersion returns the version required by the request's If-Match
+// header, or 0 when any version will do. A weak or unparseable ETag can
+// never match, so it yields -1 and the write fails its precondition.
+func IfMatchVersion(r *http.Request) (int, error) {
+	header := strings.TrimSpace(r.Header.Get("If-Match"))
+	if header == "" || header == "*" {
+		return 0, nil
+	}
+	if strings.Contains(header, ",") {
+		return 0, ErrInvalidIfMatch
+	}
+	if !strings.HasPrefix(header, `"`) || !strings.HasSuffix(header, `"`) || len(header) < 2 {
+		return -1, nil
+	}
+	version, err := strconv.Atoi(header[1 : len(header)-1])
+	if err != nil || version < 1 {
+		return -1, nil
+	}
+	return version, nil
+}
+
+// NotModified sets the ETag header and, when the request's If-None-Match
+// already names etag, writes 304 Not Modified and returns true. Tags are
+// compared weakly, as RFC 9110 requires for If-None-Match.
+func NotModified(w http.ResponseWriter, r *http.Request, etag string) bool {
+	w.Header().Set("ETag", etag)
+	header := r.Header.Get("If-None-Match")
+	if header == "" {
+		return false
+	}
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			w.WriteHeader(http.StatusNotModified)
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,66 @@
+package utils
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIfMatchVersion(t *testing.T) {
+	testCases := []struct {
+		header          string
+		expectedVersion int
+		expectedErr     error
+	}{
+		{header: "", expectedVersion: 0},
+		{header: "*", expectedVersion: 0},
+		{header: `"4"`, expectedVersion: 4},
+		{header: ` "4" `, expectedVersion: 4},
+		{header: `W/"4"`, expectedVersion: -1},
+		{header: `"abc"`, expectedVersion: -1},
+		{header: `4`, expectedVersion: -1},
+		{header: `"4", "5"`, expectedErr: ErrInvalidIfMatch},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.header, func(t *testing.T) {
+			req := httptest.NewRequest("PUT", "/roles/1", nil)
+			req.Header.Set("If-Match", tc.header)
+
+			version, err := IfMatchVersion(req)
+			assert.Equal(t, tc.expectedErr, err)
+			assert.Equal(t, tc.expectedVersion, version)
+		})
+	}
+}
+
+func TestNotModified(t *testing.T) {
+	testCases := []struct {
+		ifNoneMatch string
+		etag        string
+		expected    bool
+	}{
+		{ifNoneMatch: "", etag: `"2"`, expected: false},
+		{ifNoneMatch: `"2"`, etag: `"2"`, expected: true},
+		{ifNoneMatch: `W/"2"`, etag: `"2"`, expected: true},
+		{ifNoneMatch: `"1", "2"`, etag: `"2"`, expected: true},
+		{ifNoneMatch: `"1"`, etag: `"2"`, expected: false},
+		{ifNoneMatch: "*", etag: `W/"abc"`, expected: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.ifNoneMatch, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/roles/1", nil)
+			req.Header.Set("If-None-Match", tc.ifNoneMatch)
+			w := httptest.NewRecorder()
+
+			assert.Equal(t, tc.expected, NotModified(w, req, tc.etag))
+			assert.Equal(t, tc.etag, w.Header().Get("ETag"))
+			if tc.expected {
+				assert.Equal(t, http.StatusNotModified, w.Code)
+			}
+		})
+	}
+}
@@ -0,0 +1,232 @@
+package utils
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// jwtLeeway tolerates small clock differences when checking exp and nbf.
+const jwtLeeway = 30 * time.Second
+
+// KeySet holds the keys JWTs are verified against, loaded from a local JWKS
+// document. HS256 tokens need an "oct" key and RS256 tokens an "RSA" key, so
+// a token can never pick the algorithm a key is used with.
+type KeySet struct {
+	hmacKeys map[string][]byte
+	rsaKeys  map[string]*rsa.PublicKey
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	K   string `json:"k"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+// LoadKeySet reads a JWKS file.
+func LoadKeySet(path string) (*KeySet, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseKeySet(data)
+}
+
+// ParseKeySet parses a JWKS document with "oct" and "RSA" keys.
+func ParseKeySet(data []byte) (*KeySet, error) {
+	var doc struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+
+	ks := &KeySet{hmacKeys: map[string][]byte{}, rsaKeys: map[string]*rsa.PublicKey{}}
+	for _, key := range doc.Keys {
+		switch key.Kty {
+		case "oct":
+			secret, err := base64.RawURLEncoding.DecodeString(key.K)
+			if err != nil || len(secret) == 0 {
+				return nil, fmt.Errorf("jwks: invalid oct key %q", key.Kid)
+			}
+			ks.hmacKeys[key.Kid] = secret
+		case "RSA":
+			n, errN := base64.RawURLEncoding.DecodeString(key.N)
+			e, errE := base64.RawURLEncoding.DecodeString(key.E)
+			if errN != nil || errE != nil || len(n) == 0 || len(e) == 0 {
+				return nil, fmt.Errorf("jwks: invalid RSA key %q", key.Kid)
+			}
+			ks.rsaKeys[key.Kid] = &rsa.PublicKey{
+				N: new(big.Int).SetBytes(n),
+				E: int(new(big.Int).SetBytes(e).Int64()),
+			}
+		default:
+			return nil, fmt.Errorf("jwks: unsupported key type %q", key.Kty)
+		}
+	}
+	return ks, nil
+}
+
+// JWTAuthenticator accepts "Authorization: Bearer <jwt>" tokens signed with
+// HS256 or RS256 by a key in its KeySet.
+type JWTAuthenticator struct {
+	Keys *KeySet
+	// Issuer and Audience are checked against iss and aud when set.
+	Issuer   string
+	Audience string
+	// TenantClaim names the claim that binds a token to a tenant.
+	TenantClaim string
+	now         func() time.Time
+}
+
+// NewJWTAuthenticator builds a JWT authenticator for keys.
+func NewJWTAuthenticator(keys *KeySet, issuer, audience string) *JWTAuthenticator {
+	return &JWTAuthenticator{Keys: keys, Issuer: issuer, Audience: audience, TenantClaim: "tenant", now: time.Now}
+}
+
+func (a *JWTAuthenticator) Authenticate(r *http.Request) (*Identity, error) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return nil, nil
+	}
+
+	claims, err := a.verify(strings.TrimSpace(token))
+	if err != nil {
+		return nil, ErrInvalidCredentials
+	}
+
+	identity := &Identity{Method: AuthMethodJWT, Claims: claims}
+	if email, ok := claims["email"].(string); ok && email != "" {
+		identity.Subject = email
+	} else if sub, ok := claims["sub"].(string); ok {
+		identity.Subject = sub
+	}
+	if identity.Subject == "" {
+		return nil, ErrInvalidCredentials
+	}
+	if tenant, ok := claims[a.TenantClaim].(string); ok {
+		identity.Tenant = tenant
+	}
+	return identity, nil
+}
+
+func (a *JWTAuthenticator) verify(token string) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("jwt: malformed token")
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, err
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, err
+	}
+	signed := []byte(parts[0] + "." + parts[1])
+	digest := sha256.Sum256(signed)
+
+	switch header.Alg {
+	case "HS256":
+		secret, ok := lookupKey(a.Keys.hmacKeys, header.Kid)
+		if !ok {
+			return nil, errors.New("jwt: unknown key")
+		}
+		mac := hmac.New(sha256.New, secret)
+		mac.Write(signed)
+		if !hmac.Equal(signature, mac.Sum(nil)) {
+			return nil, errors.New("jwt: bad signature")
+		}
+	case "RS256":
+		key, ok := lookupKey(a.Keys.rsaKeys, header.Kid)
+		if !ok {
+			return nil, errors.New("jwt: unknown key")
+		}
+		if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("jwt: unsupported alg %q", header.Alg)
+	}
+
+	var claims map[string]interface{}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, err
+	}
+	if err := a.validateClaims(claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+func (a *JWTAuthenticator) validateClaims(claims map[string]interface{}) error {
+	now := a.now()
+
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return errors.New("jwt: missing exp")
+	}
+	if now.After(time.Unix(int64(exp), 0).Add(jwtLeeway)) {
+		return errors.New("jwt: token expired")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(jwtLeeway).Before(time.Unix(int64(nbf), 0)) {
+		return errors.New("jwt: token not yet valid")
+	}
+	if a.Issuer != "" && claims["iss"] != a.Issuer {
+		return errors.New("jwt: unexpected issuer")
+	}
+	if a.Audience != "" && !hasAudience(claims["aud"], a.Audience) {
+		return errors.New("jwt: unexpected audience")
+	}
+	return nil
+}
+
+// lookupKey finds the key named kid; a token without kid may only use a key
+// set with exactly one key of the required type.
+func lookupKey[K any](keys map[string]K, kid string) (K, bool) {
+	if kid == "" && len(keys) == 1 {
+		for _, key := range keys {
+			return key, true
+		}
+	}
+	key, ok := keys[kid]
+	return key, ok
+}
+
+func hasAudience(aud interface{}, audience string) bool {
+	switch v := aud.(type) {
+	case string:
+		return v == audience
+	case []interface{}:
+		for _, a := range v {
+			if a == audience {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
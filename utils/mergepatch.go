@@ -0,0 +1,27 @@
+package utils
+
+// MergePatchContentType is the media type of RFC 7396 merge patches.
+const MergePatchContentType = "application/merge-patch+json"
+
+// MergePatch applies an RFC 7396 JSON merge patch to target, both decoded
+// from JSON. Objects merge key by key, a null removes the key, and any other
+// value replaces the target outright. target is modified in place where it
+// is an object.
+func MergePatch(target, patch interface{}) interface{} {
+	patchObject, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	targetObject, ok := target.(map[string]interface{})
+	if !ok {
+		targetObject = map[string]interface{}{}
+	}
+	for key, value := range patchObject {
+		if value == nil {
+			delete(targetObject, key)
+			continue
+		}
+		targetObject[key] = MergePatch(targetObject[key], value)
+	}
+	return targetObject
+}
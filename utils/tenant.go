@@ -0,0 +1,55 @@
+package utils
+
+import (
+	"context"
+	"net/http"
+	"regexp"
+)
+
+const (
+	// TenantHeader names the request header that selects the tenant.
+	TenantHeader = "X-Tenant-ID"
+	// DefaultTenant is used when a request does not name a tenant.
+	DefaultTenant = "default"
+)
+
+type tenantKey struct{}
+
+var tenantPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,62}$`)
+
+// TenantMiddleware resolves the tenant of every request and stores it in the
+// request context, so handlers never take a tenant from the request body. A
+// caller whose credential is bound to a tenant cannot select another one.
+func TenantMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tenant := r.Header.Get(TenantHeader)
+		if identity := IdentityFromContext(r.Context()); identity != nil && identity.Tenant != "" {
+			if tenant != "" && tenant != identity.Tenant {
+				WriteError(w, r, http.StatusForbidden, CodeForbidden, "tenant not permitted for caller", nil)
+				return
+			}
+			tenant = identity.Tenant
+		}
+		if tenant == "" {
+			tenant = DefaultTenant
+		}
+		if !tenantPattern.MatchString(tenant) {
+			WriteError(w, r, http.StatusBadRequest, CodeInvalidRequest, "invalid tenant", nil)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(WithTenant(r.Context(), tenant)))
+	})
+}
+
+// WithTenant returns a copy of ctx bound to tenant.
+func WithTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenant)
+}
+
+// TenantFromContext returns the tenant bound to ctx, or DefaultTenant.
+func TenantFromContext(ctx context.Context) string {
+	if tenant, ok := ctx.Value(tenantKey{}).(string); ok && tenant != "" {
+		return tenant
+	}
+	return DefaultTenant
+}
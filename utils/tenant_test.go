@@ -0,0 +1,41 @@
+package utils
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTenantMiddleware(t *testing.T) {
+	testCases := []struct {
+		name           string
+		header         string
+		expectedCode   int
+		expectedTenant string
+	}{
+		{name: "default tenant", header: "", expectedCode: http.StatusOK, expectedTenant: DefaultTenant},
+		{name: "tenant from header", header: "billing", expectedCode: http.StatusOK, expectedTenant: "billing"},
+		{name: "invalid tenant", header: "Billing Team", expectedCode: http.StatusBadRequest},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var tenant string
+			handler := TenantMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				tenant = TenantFromContext(r.Context())
+			}))
+
+			req := httptest.NewRequest("GET", "/roles", nil)
+			if tc.header != "" {
+				req.Header.Set(TenantHeader, tc.header)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			assert.Equal(t, tc.expectedCode, w.Code)
+			assert.Equal(t, tc.expectedTenant, tenant)
+		})
+	}
+}
@@ -0,0 +1,278 @@
+// Package validation checks and normalizes the fields clients write, so every
+// handler applies the same rules and reports problems per field.
+package validation
+
+import (
+	"errors"
+	"fmt"
+	models "main/Models"
+	"main/authz"
+	"main/condition"
+	"net/mail"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	MinRoleKeyLength       = 2
+	MaxRoleKeyLength       = 64
+	MaxPermissionKeyLength = 128
+	MaxDescriptionLength   = 500
+	MaxEmailLength         = 254
+)
+
+// ReservedRoleKeys cannot be used as role keys: they read as keywords in
+// grants and audit trails, or collide with the service's own actors.
+var ReservedRoleKeys = map[string]bool{
+	"all":       true,
+	"any":       true,
+	"none":      true,
+	"self":      true,
+	"me":        true,
+	"system":    true,
+	"anonymous": true,
+}
+
+var (
+	roleKeyPattern       = regexp.MustCompile(`^[a-z][a-z0-9]*([-_][a-z0-9]+)*$`)
+	permissionKeyPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_.*-]*(:[a-z0-9_.*-]+)*$`)
+)
+
+// Errors maps each invalid field to what is wrong with it.
+type Errors map[string]string
+
+func (e Errors) Error() string {
+	fields := make([]string, 0, len(e))
+	for field := range e {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	problems := make([]string, 0, len(fields))
+	for _, field := range fields {
+		problems = append(problems, field+": "+e[field])
+	}
+	return "invalid fields: " + strings.Join(problems, "; ")
+}
+
+// check records problem against field unless it is empty.
+func (e Errors) check(field, problem string) {
+	if problem != "" {
+		e[field] = problem
+	}
+}
+
+// err returns e as an error, or nil when no field is invalid.
+func (e Errors) err() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return e
+}
+
+// RoleKey returns what is wrong with a role key: it must be a lowercase slug
+// of letters and digits joined by single hyphens or underscores, starting
+// with a letter, and not reserved.
+func RoleKey(key string) string {
+	switch {
+	case key == "":
+		return "is required"
+	case len(key) < MinRoleKeyLength || len(key) > MaxRoleKeyLength:
+		return fmt.Sprintf("must be %d to %d characters", MinRoleKeyLength, MaxRoleKeyLength)
+	case !roleKeyPattern.MatchString(key):
+		return "must be a lowercase slug such as billing-admin"
+	case ReservedRoleKeys[key]:
+		return "is reserved"
+	}
+	return ""
+}
+
+// PermissionKey returns what is wrong with a permission key: lowercase
+// segments separated by colons, such as invoice:read.
+func PermissionKey(key string) string {
+	switch {
+	case key == "":
+		return "is required"
+	case len(key) > MaxPermissionKeyLength:
+		return fmt.Sprintf("must be at most %d characters", MaxPermissionKeyLength)
+	case !permissionKeyPattern.MatchString(key):
+		return "must be lowercase segments separated by colons, such as invoice:read"
+	}
+	return ""
+}
+
+// Description returns what is wrong with a description.
+func Description(description string) string {
+	if utf8.RuneCountInString(description) > MaxDescriptionLength {
+		return fmt.Sprintf("must be at most %d characters", MaxDescriptionLength)
+	}
+	return ""
+}
+
+// NormalizeEmail returns the canonical form of a bare RFC 5322 address:
+// trimmed and lowercased. Display names and angle brackets are rejected.
+func NormalizeEmail(email string) (string, error) {
+	email = strings.TrimSpace(email)
+	if email == "" {
+		return "", errors.New("is required")
+	}
+	if len(email) > MaxEmailLength {
+		return "", fmt.Errorf("must be at most %d characters", MaxEmailLength)
+	}
+	address, err := mail.ParseAddress(email)
+	if err != nil || address.Name != "" || address.Address != email {
+		return "", errors.New("must be an email address such as jane@example.com")
+	}
+	return strings.ToLower(email), nil
+}
+
+// Role checks the fields a client sets on a role.
+func Role(role models.Role) error {
+	errs := Errors{}
+	errs.check("role_key", RoleKey(role.RoleKey))
+	errs.check("description", Description(role.Description))
+	return errs.err()
+}
+
+// RoleChange checks the fields a client sets when replacing current with
+// role. The role_key is only checked when it changes, so a role whose key
+// predates the current rules can still be updated.
+func RoleChange(current, role models.Role) error {
+	errs := Errors{}
+	if role.RoleKey != current.RoleKey {
+		errs.check("role_key", RoleKey(role.RoleKey))
+	}
+	errs.check("description", Description(role.Description))
+	return errs.err()
+}
+
+// Permission checks the fields a client sets on a permission.
+func Permission(permission models.Permission) error {
+	errs := Errors{}
+	errs.check("permission_key", PermissionKey(permission.PermissionKey))
+	errs.check("description", Description(permission.Description))
+	return errs.err()
+}
+
+// UserRole checks the fields a client sets on a user role, and replaces its
+// email with the canonical form. A user role names exactly one of an email, a
+// group_id and a service_account_id. An empty effect means allow.
+func UserRole(userRole *models.UserRole) error {
+	errs := Errors{}
+	switch {
+	case userRole.GroupID != nil && (userRole.Email != "" || userRole.ServiceAccountID != nil):
+		errs.check("group_id", "cannot be set together with email or service_account_id")
+	case userRole.ServiceAccountID != nil && userRole.Email != "":
+		errs.check("service_account_id", "cannot be set together with email")
+	case userRole.GroupID != nil && *userRole.GroupID <= 0:
+		errs.check("group_id", "must be a group id")
+	case userRole.ServiceAccountID != nil && *userRole.ServiceAccountID <= 0:
+		errs.check("service_account_id", "must be a service account id")
+	case userRole.GroupID == nil && userRole.ServiceAccountID == nil:
+		email, err := NormalizeEmail(userRole.Email)
+		if err != nil {
+			errs.check("email", err.Error())
+		} else {
+			userRole.Email = email
+		}
+	}
+	if userRole.RoleID <= 0 {
+		errs.check("role_id", "is required")
+	}
+	if err := authz.ValidateScope(userRole.ResourceType, userRole.ResourceID); err != nil {
+		errs.check("resource_type", err.Error())
+	}
+	errs.check("valid_until", validity(userRole.ValidFrom, userRole.ValidUntil))
+	errs.check("condition", Condition(userRole.Condition))
+	errs.check("effect", Effect(&userRole.Effect))
+	return errs.err()
+}
+
+// RolePermission checks the fields a client sets on a role permission. An
+// empty effect means allow.
+func RolePermission(rolePermission *models.RolePermission) error {
+	errs := Errors{}
+	errs.check("condition", Condition(rolePermission.Condition))
+	errs.check("effect", Effect(&rolePermission.Effect))
+	return errs.err()
+}
+
+// Effect returns what is wrong with a binding's effect, defaulting an empty
+// one to allow.
+func Effect(effect *string) string {
+	switch *effect {
+	case "":
+		*effect = models.EffectAllow
+	case models.EffectAllow, models.EffectDeny:
+	default:
+		return fmt.Sprintf("must be %s or %s", models.EffectAllow, models.EffectDeny)
+	}
+	return ""
+}
+
+// Condition returns what is wrong with a binding's condition: it must parse
+// and type-check as a bool expression. An empty condition always holds.
+func Condition(source string) string {
+	if source == "" {
+		return ""
+	}
+	if _, err := condition.Parse(source); err != nil {
+		return err.Error()
+	}
+	return ""
+}
+
+// Group checks the fields a client sets on a group. Group keys follow the
+// rules of role keys.
+func Group(group models.Group) error {
+	errs := Errors{}
+	errs.check("group_key", RoleKey(group.GroupKey))
+	errs.check("description", Description(group.Description))
+	return errs.err()
+}
+
+// ServiceAccount checks the fields a client sets on a service account.
+// Names follow the rules of role keys; display names those of descriptions.
+func ServiceAccount(account models.ServiceAccount) error {
+	errs := Errors{}
+	errs.check("name", RoleKey(account.Name))
+	errs.check("display_name", Description(account.DisplayName))
+	return errs.err()
+}
+
+// GroupMember checks a new group member, which names an email or a
+// member_group_id, and replaces its email with the canonical form.
+func GroupMember(member *models.GroupMember) error {
+	errs := Errors{}
+	switch {
+	case member.MemberGroupID != nil && member.Email != "":
+		errs.check("member_group_id", "cannot be set together with email")
+	case member.MemberGroupID != nil && *member.MemberGroupID <= 0:
+		errs.check("member_group_id", "must be a group id")
+	case member.MemberGroupID == nil:
+		email, err := NormalizeEmail(member.Email)
+		if err != nil {
+			errs.check("email", err.Error())
+		} else {
+			member.Email = email
+		}
+	}
+	return errs.err()
+}
+
+// validity rejects a grant window that is empty or already over.
+func validity(validFrom, validUntil *time.Time) string {
+	if validUntil == nil {
+		return ""
+	}
+	if validFrom != nil && !validUntil.After(*validFrom) {
+		return "must be after valid_from"
+	}
+	if !validUntil.After(time.Now()) {
+		return "must be in the future"
+	}
+	return ""
+}
@@ -0,0 +1,157 @@
+package validation
+
+import (
+	models "main/Models"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRoleKey(t *testing.T) {
+	testCases := []struct {
+		key   string
+		valid bool
+	}{
+		{key: "admin", valid: true},
+		{key: "billing-admin", valid: true},
+		{key: "org_viewer2", valid: true},
+		{key: "", valid: false},
+		{key: "a", valid: false},
+		{key: strings.Repeat("a", MaxRoleKeyLength+1), valid: false},
+		{key: "Admin", valid: false},
+		{key: "2fa", valid: false},
+		{key: "billing--admin", valid: false},
+		{key: "admin-", valid: false},
+		{key: "billing admin", valid: false},
+		{key: "system", valid: false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.key, func(t *testing.T) {
+			assert.Equal(t, tc.valid, RoleKey(tc.key) == "", RoleKey(tc.key))
+		})
+	}
+}
+
+func TestPermissionKey(t *testing.T) {
+	assert.Empty(t, PermissionKey("invoice:read"))
+	assert.Empty(t, PermissionKey("users.admin:*"))
+	assert.NotEmpty(t, PermissionKey(""))
+	assert.NotEmpty(t, PermissionKey("Invoice:Read"))
+	assert.NotEmpty(t, PermissionKey("invoice::read"))
+}
+
+func TestDescription(t *testing.T) {
+	assert.Empty(t, Description(""))
+	assert.Empty(t, Description(strings.Repeat("é", MaxDescriptionLength)))
+	assert.NotEmpty(t, Description(strings.Repeat("a", MaxDescriptionLength+1)))
+}
+
+func TestNormalizeEmail(t *testing.T) {
+	testCases := []struct {
+		email    string
+		expected string
+	}{
+		{email: "jane@example.com", expected: "jane@example.com"},
+		{email: "  Jane.Doe@Example.COM ", expected: "jane.doe@example.com"},
+		{email: "jane+roles@example.com", expected: "jane+roles@example.com"},
+		{email: ""},
+		{email: "jane"},
+		{email: "jane@"},
+		{email: "Jane <jane@example.com>"},
+		{email: "<jane@example.com>"},
+		{email: "jane@example.com, joe@example.com"},
+		{email: strings.Repeat("a", MaxEmailLength) + "@example.com"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.email, func(t *testing.T) {
+			email, err := NormalizeEmail(tc.email)
+			if tc.expected == "" {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, email)
+		})
+	}
+}
+
+func TestUserRole(t *testing.T) {
+	userRole := models.UserRole{Email: "Jane@Example.com", RoleID: 1}
+	require.NoError(t, UserRole(&userRole))
+	assert.Equal(t, "jane@example.com", userRole.Email)
+
+	past := time.Now().Add(-time.Hour)
+	err := UserRole(&models.UserRole{Email: "jane", ResourceType: "project", ValidUntil: &past})
+	var errs Errors
+	require.ErrorAs(t, err, &errs)
+	assert.Len(t, errs, 4)
+	for _, field := range []string{"email", "resource_type", "role_id", "valid_until"} {
+		assert.Contains(t, errs, field)
+	}
+}
+
+func TestUserRoleGroup(t *testing.T) {
+	groupID := 3
+	require.NoError(t, UserRole(&models.UserRole{GroupID: &groupID, RoleID: 1}))
+
+	err := UserRole(&models.UserRole{Email: "jane@example.com", GroupID: &groupID, RoleID: 1})
+	var errs Errors
+	require.ErrorAs(t, err, &errs)
+	assert.Contains(t, errs, "group_id")
+}
+
+func TestUserRoleServiceAccount(t *testing.T) {
+	serviceAccountID := 4
+	require.NoError(t, UserRole(&models.UserRole{ServiceAccountID: &serviceAccountID, RoleID: 1}))
+
+	err := UserRole(&models.UserRole{Email: "jane@example.com", ServiceAccountID: &serviceAccountID, RoleID: 1})
+	var errs Errors
+	require.ErrorAs(t, err, &errs)
+	assert.Contains(t, errs, "service_account_id")
+
+	require.NoError(t, ServiceAccount(models.ServiceAccount{Name: "ci-bot", DisplayName: "CI bot"}))
+	assert.Error(t, ServiceAccount(models.ServiceAccount{Name: "CI Bot"}))
+}
+
+func TestCondition(t *testing.T) {
+	assert.Empty(t, Condition(""))
+	assert.Empty(t, Condition(`amount < 1000 && in_cidr(ip, "10.0.0.0/8")`))
+	assert.Equal(t, "at offset 0: a condition must be a bool, not a number", Condition("42"))
+
+	err := RolePermission(&models.RolePermission{Condition: "amount <"})
+	var errs Errors
+	require.ErrorAs(t, err, &errs)
+	assert.Contains(t, errs, "condition")
+}
+
+func TestEffect(t *testing.T) {
+	rolePermission := models.RolePermission{}
+	require.NoError(t, RolePermission(&rolePermission))
+	assert.Equal(t, models.EffectAllow, rolePermission.Effect)
+
+	userRole := models.UserRole{Email: "jane@example.com", RoleID: 1, Effect: models.EffectDeny}
+	require.NoError(t, UserRole(&userRole))
+	assert.Equal(t, models.EffectDeny, userRole.Effect)
+
+	err := UserRole(&models.UserRole{Email: "jane@example.com", RoleID: 1, Effect: "block"})
+	var errs Errors
+	require.ErrorAs(t, err, &errs)
+	assert.Equal(t, "must be allow or deny", errs["effect"])
+}
+
+func TestGroupMember(t *testing.T) {
+	member := models.GroupMember{Email: " Jane@Example.com"}
+	require.NoError(t, GroupMember(&member))
+	assert.Equal(t, "jane@example.com", member.Email)
+
+	groupID := 0
+	for _, member := range []models.GroupMember{{}, {Email: "jane"}, {Email: "jane@example.com", MemberGroupID: &groupID}, {MemberGroupID: &groupID}} {
+		assert.Error(t, GroupMember(&member))
+	}
+	assert.Error(t, Group(models.Group{GroupKey: "Platform Team"}))
+}